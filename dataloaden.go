@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -8,10 +9,41 @@ import (
 )
 
 func main() {
-	if len(os.Args) != 4 {
-		fmt.Println("usage: name keyType valueType")
+	var opts generator.Options
+	flag.BoolVar(&opts.Spill, "spill", false, "also generate a cache that spills cold entries to a bbolt file")
+	flag.BoolVar(&opts.Redis, "redis", false, "also generate a cache kept in redis, so replicas share their cache")
+	flag.BoolVar(&opts.Prometheus, "prometheus", false, "also generate metrics that export to prometheus")
+	flag.BoolVar(&opts.OTel, "otel", false, "also generate a tracer that traces batches with OpenTelemetry")
+	flag.BoolVar(&opts.View, "view", false, "also generate a generic view that projects loaded values (go1.18+)")
+	flag.BoolVar(&opts.Iter, "iter", false, "also generate iterator based loads (go1.23+)")
+	flag.BoolVar(&opts.Split, "split", false, "write the internals of the loader to a separate <name>_impl_gen.go")
+	flag.StringVar(&opts.InternalPrefix, "internal-prefix", "", "prefix the unexported fields and methods of the loader, so they don't collide with code added beside it")
+	flag.BoolVar(&opts.Schema, "schema", false, "also write a JSON Schema of the key and value types to <name>_schema_gen.json")
+	flag.BoolVar(&opts.Generic, "generic", false, "generate aliases over the generic runtime loader instead of a whole loader (go1.18+)")
+	flag.StringVar(&opts.Fetcher, "fetcher", "", "an Interface.Method to fetch with, adds a constructor that accepts the interface")
+	bundle := flag.Bool("bundle", false, "also generate a Loaders struct holding one of each loader, with request middleware")
+	manifest := flag.String("config", "", "generate every loader listed in a dataloaden.yml manifest instead")
+	flag.Parse()
+
+	if *manifest != "" {
+		if flag.NArg() != 0 {
+			fmt.Fprintln(os.Stderr, "-config can't be combined with a name, keyType and valueType")
+			os.Exit(1)
+		}
+		if err := generator.GenerateManifest(*manifest); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
+		return
+	}
+
+	if flag.NArg() == 0 || flag.NArg()%3 != 0 {
+		fmt.Println("usage: [flags] name keyType valueType [name keyType valueType...]")
+		fmt.Println("       -config dataloaden.yml")
 		fmt.Println(" example:")
 		fmt.Println(" dataloaden 'UserLoader int []*github.com/my/package.User'")
+		fmt.Println(" flags:")
+		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
@@ -21,8 +53,21 @@ func main() {
 		os.Exit(2)
 	}
 
-	if err := generator.Generate(os.Args[1], os.Args[2], os.Args[3], wd); err != nil {
-		fmt.Fprintln(os.Stderr, err.Error())
-		os.Exit(2)
+	// every loader gets the same flags, use -config when they differ
+	args := flag.Args()
+	var names []string
+	for i := 0; i < len(args); i += 3 {
+		if err := generator.GenerateWithOptions(args[i], args[i+1], args[i+2], wd, opts); err != nil {
+			fmt.Fprintln(os.Stderr, args[i]+": "+err.Error())
+			os.Exit(2)
+		}
+		names = append(names, args[i])
+	}
+
+	if *bundle {
+		if err := generator.GenerateBundle(wd, names); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(2)
+		}
 	}
 }
@@ -0,0 +1,5034 @@
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package aggregate
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	crand "crypto/rand"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// CommentCountLoaderCache can be used to cache results. A default map based
+// implementation is used by default. Any implementation can be passed in the config, eg. an LRU, a
+// shared redis or CommentCountLoaderNoCache.
+type CommentCountLoaderCache interface {
+	Get(key int) (int, bool)
+	Set(key int, value int)
+	ClearKey(key int)
+}
+
+// CommentCountLoaderClearableCache is implemented by caches that can drop every entry at once, it is used by ClearAll.
+// Other caches have the keys the loader knows about cleared one at a time.
+type CommentCountLoaderClearableCache interface {
+	Clear()
+}
+
+// CommentCountLoaderEvictingCache is implemented by caches that drop entries by themselves, eg. to stay within a size
+// limit. The loader registers a callback with OnEvict to forget what it knows about the evicted keys, the cache
+// must not call it while it is locked.
+type CommentCountLoaderEvictingCache interface {
+	OnEvict(evicted func(key int))
+}
+
+// CommentCountLoaderNoCache is a CommentCountLoaderCache that never caches anything, so every load is fetched. Loads of the
+// same key are still batched together.
+type CommentCountLoaderNoCache struct{}
+
+func (CommentCountLoaderNoCache) Get(key int) (int, bool) {
+	var zero int
+	return zero, false
+}
+
+func (CommentCountLoaderNoCache) Set(key int, value int) {}
+
+func (CommentCountLoaderNoCache) ClearKey(key int) {}
+
+// CommentCountLoaderTTLCache is implemented by caches that can expire individual entries, it is used when
+// a value is primed with CommentCountLoaderWithTTL.
+type CommentCountLoaderTTLCache interface {
+	SetWithTTL(key int, value int, ttl time.Duration)
+}
+
+// Cache implementation for github.com/patrickmn/go-cache
+// !!! Works for string keys only !!!
+
+type CommentCountLoaderGoCache struct {
+	cache *gocache.Cache
+}
+
+type CommentCountLoaderGoCacheConfig struct {
+	DefaultExpiration time.Duration
+	CleanupInterval   time.Duration
+}
+
+func NewCommentCountLoaderGoCache(conf CommentCountLoaderGoCacheConfig) *CommentCountLoaderGoCache {
+	return &CommentCountLoaderGoCache{
+		cache: gocache.New(conf.DefaultExpiration, conf.CleanupInterval),
+	}
+}
+
+func (c *CommentCountLoaderGoCache) Get(key string) (int, bool) {
+	var zero int
+
+	i, exists := c.cache.Get(key)
+	if !exists {
+		return zero, false
+	}
+
+	v, ok := i.(int)
+	return v, ok
+}
+
+func (c *CommentCountLoaderGoCache) Set(key string, value int) {
+	c.cache.Set(key, value, 0)
+}
+
+func (c *CommentCountLoaderGoCache) SetWithTTL(key string, value int, ttl time.Duration) {
+	c.cache.Set(key, value, ttl)
+}
+
+func (c *CommentCountLoaderGoCache) ClearKey(key string) {
+	c.cache.Delete(key)
+}
+
+func (c *CommentCountLoaderGoCache) Clear() {
+	c.cache.Flush()
+}
+
+// Cache implementation for Golang Map
+
+type CommentCountLoaderMapCache struct {
+	data    map[int]int
+	expires map[int]time.Time
+	mu      *sync.Mutex
+}
+
+func NewCommentCountLoaderMapCache() *CommentCountLoaderMapCache {
+	return &CommentCountLoaderMapCache{
+		data:    map[int]int{},
+		expires: map[int]time.Time{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+func (c *CommentCountLoaderMapCache) Get(key int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if expires, ok := c.expires[key]; ok && time.Now().After(expires) {
+		delete(c.data, key)
+		delete(c.expires, key)
+	}
+
+	r, ok := c.data[key]
+	return r, ok
+}
+
+func (c *CommentCountLoaderMapCache) Set(key int, value int) {
+	c.mu.Lock()
+	c.data[key] = value
+	delete(c.expires, key)
+	c.mu.Unlock()
+}
+
+// SetWithTTL stores a value that Get will stop returning once ttl has passed
+func (c *CommentCountLoaderMapCache) SetWithTTL(key int, value int, ttl time.Duration) {
+	c.mu.Lock()
+	c.data[key] = value
+	c.expires[key] = time.Now().Add(ttl)
+	c.mu.Unlock()
+}
+
+func (c *CommentCountLoaderMapCache) ClearKey(key int) {
+	c.mu.Lock()
+	delete(c.data, key)
+	delete(c.expires, key)
+	c.mu.Unlock()
+}
+
+func (c *CommentCountLoaderMapCache) Clear() {
+	c.mu.Lock()
+	c.data = map[int]int{}
+	c.expires = map[int]time.Time{}
+	c.mu.Unlock()
+}
+
+// CommentCountLoaderLRUCache is a CommentCountLoaderCache that holds at most maxEntries values, evicting the least recently used
+// one to make room. It is safe to share between goroutines.
+type CommentCountLoaderLRUCache struct {
+	maxEntries int
+	recent     *list.List
+	entries    map[int]*list.Element
+	onEvict    []func(key int)
+	mu         sync.Mutex
+}
+
+type commentCountLoaderLRUEntry struct {
+	key   int
+	value int
+}
+
+// NewCommentCountLoaderLRUCache creates an empty CommentCountLoaderLRUCache that holds up to maxEntries values, 0 = no limit
+func NewCommentCountLoaderLRUCache(maxEntries int) *CommentCountLoaderLRUCache {
+	return &CommentCountLoaderLRUCache{
+		maxEntries: maxEntries,
+		recent:     list.New(),
+		entries:    map[int]*list.Element{},
+	}
+}
+
+func (c *CommentCountLoaderLRUCache) Get(key int) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		var zero int
+		return zero, false
+	}
+	c.recent.MoveToFront(el)
+	return el.Value.(*commentCountLoaderLRUEntry).value, true
+}
+
+func (c *CommentCountLoaderLRUCache) Set(key int, value int) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*commentCountLoaderLRUEntry).value = value
+		c.recent.MoveToFront(el)
+		c.mu.Unlock()
+		return
+	}
+
+	c.entries[key] = c.recent.PushFront(&commentCountLoaderLRUEntry{key: key, value: value})
+	var evicted []int
+	for c.maxEntries > 0 && c.recent.Len() > c.maxEntries {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*commentCountLoaderLRUEntry).key)
+		evicted = append(evicted, oldest.Value.(*commentCountLoaderLRUEntry).key)
+	}
+	onEvict := c.onEvict
+	c.mu.Unlock()
+
+	for _, key := range evicted {
+		for _, f := range onEvict {
+			f(key)
+		}
+	}
+}
+
+// OnEvict calls evicted with each key Set drops to stay within maxEntries
+func (c *CommentCountLoaderLRUCache) OnEvict(evicted func(key int)) {
+	c.mu.Lock()
+	c.onEvict = append(c.onEvict, evicted)
+	c.mu.Unlock()
+}
+
+func (c *CommentCountLoaderLRUCache) ClearKey(key int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.recent.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *CommentCountLoaderLRUCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recent.Init()
+	c.entries = map[int]*list.Element{}
+}
+
+// Len is how many values are cached
+func (c *CommentCountLoaderLRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+// CommentCountLoaderConfig captures the config to create a new CommentCountLoader
+type CommentCountLoaderConfig struct {
+	// Fetch is a method that provides the data for the loader
+	Fetch func(keys []int) ([]int, []error)
+
+	// FetchContext is used instead of Fetch when set. Its ctx is cancelled once every caller waiting on the batch
+	// has given up (see LoadContext), so a batch nobody wants anymore can abort its round trip. Callers that
+	// can't be cancelled, like Load, keep it alive.
+	FetchContext func(ctx context.Context, keys []int) ([]int, []error)
+
+	// FetchConn is used instead of Fetch when set. Every batch is fetched on a single connection or transaction
+	// that Acquire checks out for it, so its queries can take part in the request's transaction. Its ctx behaves
+	// like the one of FetchContext.
+	FetchConn func(ctx context.Context, conn CommentCountLoaderConn, keys []int) ([]int, []error)
+
+	// Acquire checks out the connection of a batch, passes it to fetch and releases it once fetch returns. An
+	// error fails every key of the batch. See CommentCountLoaderDBConn and CommentCountLoaderTxConn.
+	Acquire func(ctx context.Context, fetch func(conn CommentCountLoaderConn)) error
+
+	// Wait is how long wait before sending a batch
+	Wait time.Duration
+
+	// TargetBatch adapts the wait of every batch to the rate keys arrive at. A batch waits about as long as it
+	// takes TargetBatch keys to arrive, but at least MinWait and at most Wait, so busy loaders send their batches
+	// sooner while quiet ones keep waiting long enough to batch what little arrives. 0 = every batch waits Wait.
+	TargetBatch int
+
+	// MinWait is the shortest wait of a batch with TargetBatch
+	MinWait time.Duration
+
+	// MaxBatch will limit the maximum number of keys to send in one batch, 0 = not limit
+	MaxBatch int
+
+	// MaxBatchOverflow lets a batch grow up to MaxBatch+MaxBatchOverflow keys when that fits the rest of a LoadAll
+	// call, instead of splitting off a tiny trailing batch
+	MaxBatchOverflow int
+
+	// Cache is the datastructure used to cache fetched data. The loader never calls it while it is locked, so a
+	// cache doing network I/O only slows down the loads that use it
+	Cache CommentCountLoaderCache
+
+	// TTL expires fetched and primed values after this long, so they are fetched again on their next load.
+	// Values primed with CommentCountLoaderWithTTL keep their own TTL. 0 = values are kept until they are cleared.
+	// TTLs are measured with the monotonic clock, so jumps of the wall clock don't expire values early or late.
+	TTL time.Duration
+
+	// SortKeys, when set, puts the keys of each batch in a deterministic order before they are sent to Fetch
+	SortKeys func(keys []int)
+
+	// ClassifyError puts each failed key into an error class for ErrorCounts, nil = every error is CommentCountLoaderErrorOther
+	ClassifyError func(key int, err error) CommentCountLoaderErrorClass
+
+	// KeyPattern groups keys for PatternStats, eg. into "users:*" and "orgs:*" when one loader serves both kinds.
+	// CommentCountLoaderKeyPatterns builds one from regular expressions. nil = loads aren't broken down by pattern.
+	KeyPattern func(key int) string
+
+	// OnDuplicateFetch is a development aid for finding resolvers that defeat batching. When set, it is called for
+	// every key that is fetched again after an earlier batch already returned it successfully, with the number of
+	// times it has now been fetched. Clearing a key resets its count.
+	OnDuplicateFetch func(key int, fetches int)
+
+	// Strict checks that Fetch returned a value for every key, even the failed ones, and either no errors, a single
+	// error or an error for every key. Any other result fails the whole batch with a CommentCountLoaderResultLengthError.
+	// Without it a batch only fails like that when there are more values than keys, more than one error but not one
+	// for every key, or a key got neither a value nor an error and the MissingPolicy is CommentCountLoaderMissingAsFetched.
+	// Other MissingPolicies apply to the keys a short result left out.
+	Strict bool
+
+	// OnResultLengthError is called with the offending keys whenever a batch fails with a CommentCountLoaderResultLengthError
+	OnResultLengthError func(keys []int, err *CommentCountLoaderResultLengthError)
+
+	// IsDeleted identifies soft deleted values, they are returned as ErrCommentCountLoaderNotFound instead of being cached
+	IsDeleted func(value int) bool
+
+	// Version returns the version of a value, eg. a row version or updated at timestamp. PrimeIfNewer uses it to
+	// only replace cached values with newer ones, and ForcePrime to never replace them with older ones.
+	Version func(value int) int64
+
+	// Transform is applied once to every fetched value before it is cached or checked, eg. to redact fields or
+	// normalize time zones, so every consumer sees the transformed value
+	Transform func(key int, value int) int
+
+	// ValidateValue checks every fetched value before it is cached, keys whose value fails get the error instead
+	// and aren't cached, so one corrupt row doesn't stick around
+	ValidateValue func(key int, value int) error
+
+	// MaxValueBytes stops fetched values larger than this, as measured by ValueSize, from being cached. They are
+	// still returned, but a pathological row can't evict swathes of normal entries from a size bounded cache.
+	MaxValueBytes int
+
+	// ValueSize estimates the size of a value in bytes for MaxValueBytes
+	ValueSize func(value int) int
+
+	// IndexBy returns the terms a cached value is indexed under, eg. "org:42" for a user in org 42, so ClearIndexed
+	// can clear every value with a term without scanning the cache
+	IndexBy func(value int) []string
+
+	// CacheDeleted remembers keys that IsDeleted matched, so they aren't fetched again until they are cleared
+	CacheDeleted bool
+
+	// ErrorTTL caches the errors CacheError accepts for this long, so hot keys that don't exist aren't fetched on
+	// every load. It is independent of TTL, values usually live much longer. 0 = errors aren't cached.
+	ErrorTTL time.Duration
+
+	// CacheError decides which errors ErrorTTL caches, nil = the ones wrapping ErrCommentCountLoaderNotFound
+	CacheError func(key int, err error) bool
+
+	// LoadAllNoCache stops LoadAll and LoadAllThunk from caching what they fetch, eg. for bulk listings that are
+	// never read again. They still read from the cache, and Load keeps caching.
+	LoadAllNoCache bool
+
+	// CollapseLoadAll makes a LoadAll of the exact same keys as one that is still running wait for it and share
+	// its result instead of loading again, eg. for polling clients. Stats counts them as Collapsed. When the
+	// LoadAll they wait for panics they get a CommentCountLoaderPanicError for every key.
+	CollapseLoadAll bool
+
+	// LogSampleRate is the fraction of loads, from 0 to 1, that are passed to LogSample
+	LogSampleRate float64
+
+	// LogSample is called for the sampled loads once they return, eg. to log them
+	LogSample func(sample CommentCountLoaderLoadSample)
+
+	// KeyLocker protects a cache shared between processes from stampedes. Only the process holding a key's lock
+	// fetches it, the others wait up to KeyLockWait for it to show up in the cache before fetching it themselves.
+	KeyLocker CommentCountLoaderKeyLocker
+
+	// KeyLockWait is how long to wait for another process to fill the cache, 0 = CommentCountLoaderDefaultKeyLockWait
+	KeyLockWait time.Duration
+
+	// Hedge sends a second fetch for the same keys when a batch takes longer than the p99 of recent fetches, and
+	// uses whichever returns first. The ctx of the slower FetchContext or FetchConn is cancelled, with Fetch its
+	// result is dropped. Fetch must be safe to call twice for the same keys.
+	Hedge bool
+
+	// MaxRetries fetches keys that failed with an error Retryable accepts again, up to this many times, so
+	// transient backend failures don't reach every caller of the batch. Only the failed keys are fetched again.
+	MaxRetries int
+
+	// Backoff returns how long to wait before retry attempt, counting from 1, eg. CommentCountLoaderExponentialBackoff
+	// with some jitter. nil = CommentCountLoaderDefaultBackoff.
+	Backoff func(attempt int) time.Duration
+
+	// Retryable decides which errors are retried, nil = every error but the ones wrapping ErrCommentCountLoaderNotFound
+	// or ErrCommentCountLoaderCircuitOpen
+	Retryable func(err error) bool
+
+	// Breaker short-circuits fetches while the backend is failing, batches it doesn't allow fail right away with
+	// ErrCommentCountLoaderCircuitOpen instead of calling Fetch. CommentCountLoaderCircuitBreaker trips on the rate of failed fetches.
+	Breaker CommentCountLoaderBreaker
+
+	// BatchKey partitions keys into separate batches, eg. by tenant or locale when their fetches differ. Keys are
+	// still batched within each partition. By default all keys share one batch.
+	BatchKey func(key int) string
+
+	// MissingPolicy decides what loads of missing keys return, by default they return whatever Fetch returned
+	MissingPolicy CommentCountLoaderMissingPolicy
+
+	// Owner is the context of the request a per request loader belongs to. Loads after it is done panic with
+	// ErrCommentCountLoaderOwnerDone, catching loaders captured by a background goroutine that outlives the request. It is
+	// meant for development, leave it unset in production.
+	Owner context.Context
+
+	// DetectDeadlocks makes loads from within Fetch that don't pass on the ctx of FetchContext fail with
+	// ErrCommentCountLoaderDeadlock, instead of hanging once they wait on their own batch or on a Pool worker or
+	// MaxConcurrentBatches slot the fetch is holding. It tells goroutines apart by their stack traces, so it is
+	// meant for development, leave it unset in production.
+	DetectDeadlocks bool
+
+	// DetectMutations catches values that were changed in place after they were cached, eg. by a caller writing
+	// through a pointer it shares with the cache. Values are checksummed, as encoding/json encodes them, when they
+	// are cached and again whenever a load or CheckMutations reads them. It is slow, so it is meant for
+	// development, leave it unset in production.
+	DetectMutations bool
+
+	// OnMutation is called with the keys DetectMutations caught, nil = loads of them panic with ErrCommentCountLoaderMutated
+	OnMutation func(key int, value int)
+
+	// ClosedPolicy decides what happens to loads once the loader is closed, by default they fail with ErrCommentCountLoaderClosed
+	ClosedPolicy CommentCountLoaderClosedPolicy
+
+	// Pool runs the fetches of this loader, so many loaders can share a bounded number of goroutines. By default every
+	// batch is fetched on a goroutine of its own.
+	Pool CommentCountLoaderPool
+
+	// MaxConcurrentBatches limits how many batches of this loader are fetched at once, later batches queue until
+	// one returns. 0 = no limit.
+	MaxConcurrentBatches int
+
+	// MaxPendingKeys caps how many keys may wait in batches that haven't returned yet, across all of them. Loads
+	// that would add more fail right away with a CommentCountLoaderOverloadError, so batches backing up during an outage
+	// can't exhaust memory. Loads of keys already waiting in the batch they would join are let through. 0 = no limit.
+	MaxPendingKeys int
+
+	// OnBackpressure is called with QueueDepth whenever a batch has to queue for MaxConcurrentBatches, so upstream
+	// layers can shed load, eg. by failing requests early while the queue is deep
+	OnBackpressure func(queued int)
+
+	// Tracer is told about every batch and the callers waiting on it, eg. CommentCountLoaderOTel to trace them. Only callers
+	// loading with a context, eg. with LoadContext, are passed on.
+	Tracer CommentCountLoaderTracer
+
+	// PropagateContext copies values, eg. the tenant, locale or trace ID, from the ctx of the first caller that
+	// loaded a key of a batch with a context into the ctx FetchContext gets, see CommentCountLoaderContextKeys. Without it
+	// the ctx of a fetch carries none of the callers' values.
+	PropagateContext func(from, to context.Context) context.Context
+
+	// Metrics is told about every batch and cache lookup, eg. CommentCountLoaderPrometheus to see how well Wait and
+	// MaxBatch are tuned
+	Metrics CommentCountLoaderMetrics
+
+	// StatsWindow is the longest period WindowStats can report on, 0 = no window stats are kept
+	StatsWindow time.Duration
+
+	// HotKeys is how many of the most loaded keys are tracked for HotKeys, 0 = hot keys aren't tracked
+	HotKeys int
+}
+
+// CommentCountLoaderPerRequest returns a config for loaders created for every request: a short wait, batches of up to 100
+// keys and the default map cache, which lives exactly as long as the loader.
+func CommentCountLoaderPerRequest(fetch func(keys []int) ([]int, []error)) CommentCountLoaderConfig {
+	return CommentCountLoaderConfig{
+		Fetch:    fetch,
+		Wait:     2 * time.Millisecond,
+		MaxBatch: 100,
+	}
+}
+
+// CommentCountLoaderLongLivedLRU returns a config for loaders shared between requests that must not grow without bound,
+// the maxEntries most recently used values are kept in a CommentCountLoaderLRUCache.
+func CommentCountLoaderLongLivedLRU(fetch func(keys []int) ([]int, []error), maxEntries int) CommentCountLoaderConfig {
+	return CommentCountLoaderConfig{
+		Fetch:    fetch,
+		Wait:     2 * time.Millisecond,
+		MaxBatch: 100,
+		Cache:    NewCommentCountLoaderLRUCache(maxEntries),
+	}
+}
+
+// CommentCountLoaderDefaultWait is the Wait NewCommentCountLoaderValidated uses when none is configured
+const CommentCountLoaderDefaultWait = time.Millisecond
+
+// Validate reports the first setting that would make the loader misbehave
+func (c CommentCountLoaderConfig) Validate() error {
+	switch {
+	case c.Fetch == nil && c.FetchContext == nil && c.FetchConn == nil:
+		return fmt.Errorf("CommentCountLoader: Fetch, FetchContext or FetchConn is required")
+	case c.FetchConn != nil && c.Acquire == nil:
+		return fmt.Errorf("CommentCountLoader: FetchConn needs an Acquire to get connections from")
+	case c.Wait < 0:
+		return fmt.Errorf("CommentCountLoader: Wait must not be negative, got %s", c.Wait)
+	case c.TargetBatch < 0:
+		return fmt.Errorf("CommentCountLoader: TargetBatch must not be negative, got %d (use 0 to always wait Wait)", c.TargetBatch)
+	case c.MinWait < 0 || (c.TargetBatch > 0 && c.MinWait > c.Wait):
+		return fmt.Errorf("CommentCountLoader: MinWait must be between 0 and Wait (%s), got %s", c.Wait, c.MinWait)
+	case c.MaxBatch < 0:
+		return fmt.Errorf("CommentCountLoader: MaxBatch must not be negative, got %d (use 0 for no limit)", c.MaxBatch)
+	case c.MaxConcurrentBatches < 0:
+		return fmt.Errorf("CommentCountLoader: MaxConcurrentBatches must not be negative, got %d (use 0 for no limit)", c.MaxConcurrentBatches)
+	case c.MaxBatchOverflow < 0:
+		return fmt.Errorf("CommentCountLoader: MaxBatchOverflow must not be negative, got %d", c.MaxBatchOverflow)
+	case c.MaxBatchOverflow > 0 && c.MaxBatch == 0:
+		return fmt.Errorf("CommentCountLoader: MaxBatchOverflow needs a MaxBatch to overflow")
+	case c.StatsWindow < 0:
+		return fmt.Errorf("CommentCountLoader: StatsWindow must not be negative, got %s", c.StatsWindow)
+	case c.HotKeys < 0:
+		return fmt.Errorf("CommentCountLoader: HotKeys must not be negative, got %d", c.HotKeys)
+	case c.MaxPendingKeys < 0:
+		return fmt.Errorf("CommentCountLoader: MaxPendingKeys must not be negative, got %d (use 0 for no limit)", c.MaxPendingKeys)
+	case c.MaxRetries < 0:
+		return fmt.Errorf("CommentCountLoader: MaxRetries must not be negative, got %d", c.MaxRetries)
+	case c.TTL < 0:
+		return fmt.Errorf("CommentCountLoader: TTL must not be negative, got %s", c.TTL)
+	case c.ErrorTTL < 0:
+		return fmt.Errorf("CommentCountLoader: ErrorTTL must not be negative, got %s", c.ErrorTTL)
+	case c.LogSampleRate < 0 || c.LogSampleRate > 1:
+		return fmt.Errorf("CommentCountLoader: LogSampleRate must be between 0 and 1, got %g", c.LogSampleRate)
+	case c.MaxValueBytes < 0:
+		return fmt.Errorf("CommentCountLoader: MaxValueBytes must not be negative, got %d", c.MaxValueBytes)
+	case c.MaxValueBytes > 0 && c.ValueSize == nil:
+		return fmt.Errorf("CommentCountLoader: MaxValueBytes needs a ValueSize to measure values")
+	}
+	return nil
+}
+
+// NewCommentCountLoaderValidated creates a new CommentCountLoader like NewCommentCountLoader, but fills in CommentCountLoaderDefaultWait when Wait is
+// zero and returns an error for an invalid config instead of a loader that fails under load.
+func NewCommentCountLoaderValidated(config CommentCountLoaderConfig) (*CommentCountLoader, error) {
+	if config.Wait == 0 {
+		config.Wait = CommentCountLoaderDefaultWait
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return NewCommentCountLoader(config), nil
+}
+
+// NewCommentCountLoader creates a new CommentCountLoader given a fetch, wait, and maxBatch
+func NewCommentCountLoader(config CommentCountLoaderConfig) *CommentCountLoader {
+	dl := CommentCountLoader{
+		cache: NewCommentCountLoaderMapCache(),
+		meta:  map[int]*CommentCountLoaderEntryMeta{},
+	}
+	dl.configure(config)
+
+	if config.Cache != nil {
+		dl.cache = config.Cache
+	}
+	if cache, ok := dl.cache.(CommentCountLoaderEvictingCache); ok {
+		cache.OnEvict(dl.evicted)
+	}
+
+	if config.StatsWindow > 0 {
+		dl.window = newcommentCountLoaderStatsWindow(config.StatsWindow)
+	}
+
+	if config.HotKeys > 0 {
+		dl.hotKeys = newcommentCountLoaderHotKeys(config.HotKeys)
+	}
+
+	return &dl
+}
+
+// NewCommentCountLoaderSecondary creates a loader for a secondary key of primary's values, eg. a slug next to an ID. The
+// Fetch of config resolves secondary keys and keyOf returns the primary key of a value. Values loaded either way
+// are cached once, in primary, so the two loaders never hold diverging copies. The Cache of config is ignored.
+func NewCommentCountLoaderSecondary(primary *CommentCountLoader, config CommentCountLoaderConfig, keyOf func(value int) int) *CommentCountLoader {
+	config.Cache = &commentCountLoaderSecondaryCache{
+		primary: primary,
+		keyOf:   keyOf,
+		keys:    map[int]int{},
+	}
+	return NewCommentCountLoader(config)
+}
+
+// commentCountLoaderSecondaryCache remembers the primary key of each secondary key and keeps the values in the
+// primary loader
+type commentCountLoaderSecondaryCache struct {
+	primary *CommentCountLoader
+	keyOf   func(value int) int
+	mu      sync.Mutex
+	keys    map[int]int
+}
+
+func (c *commentCountLoaderSecondaryCache) Get(key int) (int, bool) {
+	c.mu.Lock()
+	primaryKey, ok := c.keys[key]
+	c.mu.Unlock()
+
+	if !ok {
+		var zero int
+		return zero, false
+	}
+	return c.primary.cache.Get(primaryKey)
+}
+
+func (c *commentCountLoaderSecondaryCache) Set(key int, value int) {
+	primaryKey := c.keyOf(value)
+
+	c.mu.Lock()
+	c.keys[key] = primaryKey
+	c.mu.Unlock()
+
+	c.primary.mu.Lock()
+	c.primary.unsafeSet(primaryKey, value, 0)
+	c.primary.mu.Unlock()
+	c.primary.flushWrites()
+}
+
+// ClearKey forgets the secondary key, the value stays cached under its primary key
+func (c *commentCountLoaderSecondaryCache) ClearKey(key int) {
+	c.mu.Lock()
+	delete(c.keys, key)
+	c.mu.Unlock()
+}
+
+// Clear forgets every secondary key
+func (c *commentCountLoaderSecondaryCache) Clear() {
+	c.mu.Lock()
+	c.keys = map[int]int{}
+	c.mu.Unlock()
+}
+
+// CommentCountLoaderOption changes the config of a live loader, see Apply
+type CommentCountLoaderOption func(config *CommentCountLoaderConfig)
+
+// Apply changes the config of a live loader, eg. to swap hooks from a dynamic config system. All options are
+// applied at once under the loader's lock, so a batch sees either the old or the new config, never a mix.
+// Cache, StatsWindow and HotKeys are fixed when the loader is created, changes to them are ignored.
+func (l *CommentCountLoader) Apply(opts ...CommentCountLoaderOption) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	config := l.config()
+	for _, opt := range opts {
+		opt(&config)
+	}
+	l.configure(config)
+}
+
+// SetFetch swaps the fetch function of a live loader, eg. to move to a read replica during an incident, keeping
+// its cache. Batches whose fetch already started finish with the old function, all others use fetch. It replaces
+// FetchContext and FetchConn as well, a loader configured with either fetches with fetch from then on.
+func (l *CommentCountLoader) SetFetch(fetch func(keys []int) ([]int, []error)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fetch = fetch
+	l.fetchContext = nil
+	l.fetchConn = nil
+}
+
+// config returns the current config of the loader, it must be called with the loader locked
+func (l *CommentCountLoader) config() CommentCountLoaderConfig {
+	return CommentCountLoaderConfig{
+		Fetch:                l.fetch,
+		FetchContext:         l.fetchContext,
+		FetchConn:            l.fetchConn,
+		Acquire:              l.acquire,
+		Wait:                 l.wait,
+		TargetBatch:          l.targetBatch,
+		MinWait:              l.minWait,
+		MaxBatch:             l.maxBatch,
+		MaxBatchOverflow:     l.maxBatchOverflow,
+		Cache:                l.cache,
+		TTL:                  l.ttl,
+		SortKeys:             l.sortKeys,
+		ClassifyError:        l.classifyError,
+		KeyPattern:           l.keyPattern,
+		OnDuplicateFetch:     l.onDuplicateFetch,
+		Strict:               l.strict,
+		OnResultLengthError:  l.onResultLengthError,
+		IsDeleted:            l.isDeleted,
+		Version:              l.version,
+		Transform:            l.transform,
+		ValidateValue:        l.validateValue,
+		MaxValueBytes:        l.maxValueBytes,
+		ValueSize:            l.valueSize,
+		IndexBy:              l.indexBy,
+		CacheDeleted:         l.cacheDeleted,
+		ErrorTTL:             l.errorTTL,
+		CacheError:           l.cacheError,
+		LoadAllNoCache:       l.loadAllNoCache,
+		CollapseLoadAll:      l.collapseLoadAll,
+		LogSampleRate:        l.logSampleRate,
+		LogSample:            l.logSample,
+		KeyLocker:            l.keyLocker,
+		KeyLockWait:          l.keyLockWait,
+		Hedge:                l.hedge,
+		MaxRetries:           l.maxRetries,
+		Backoff:              l.backoff,
+		Retryable:            l.retryable,
+		Breaker:              l.breaker,
+		BatchKey:             l.batchKey,
+		MissingPolicy:        l.missingPolicy,
+		Owner:                l.owner,
+		DetectDeadlocks:      l.detectDeadlocks,
+		DetectMutations:      l.detectMutations,
+		OnMutation:           l.onMutation,
+		ClosedPolicy:         l.closedPolicy,
+		Pool:                 l.pool,
+		MaxConcurrentBatches: l.maxConcurrentBatches,
+		MaxPendingKeys:       l.maxPendingKeys,
+		OnBackpressure:       l.onBackpressure,
+		Metrics:              l.metrics,
+		Tracer:               l.tracer,
+		PropagateContext:     l.propagateContext,
+	}
+}
+
+// configure applies the parts of config that can change at runtime, it must be called with the loader locked
+func (l *CommentCountLoader) configure(config CommentCountLoaderConfig) {
+	l.fetch = config.Fetch
+	l.fetchContext = config.FetchContext
+	l.fetchConn = config.FetchConn
+	l.acquire = config.Acquire
+	l.wait = config.Wait
+	l.targetBatch = config.TargetBatch
+	l.minWait = config.MinWait
+	l.maxBatch = config.MaxBatch
+	l.maxBatchOverflow = config.MaxBatchOverflow
+	l.ttl = config.TTL
+	l.sortKeys = config.SortKeys
+	l.classifyError = config.ClassifyError
+	l.keyPattern = config.KeyPattern
+	l.onDuplicateFetch = config.OnDuplicateFetch
+	l.strict = config.Strict
+	l.onResultLengthError = config.OnResultLengthError
+	l.isDeleted = config.IsDeleted
+	l.version = config.Version
+	l.transform = config.Transform
+	l.validateValue = config.ValidateValue
+	l.maxValueBytes = config.MaxValueBytes
+	l.valueSize = config.ValueSize
+	l.indexBy = config.IndexBy
+	l.cacheDeleted = config.CacheDeleted
+	l.errorTTL = config.ErrorTTL
+	l.cacheError = config.CacheError
+	l.loadAllNoCache = config.LoadAllNoCache
+	l.collapseLoadAll = config.CollapseLoadAll
+	l.logSampleRate = config.LogSampleRate
+	l.logSample = config.LogSample
+	l.keyLocker = config.KeyLocker
+	l.keyLockWait = config.KeyLockWait
+	l.hedge = config.Hedge
+	l.maxRetries = config.MaxRetries
+	l.backoff = config.Backoff
+	l.retryable = config.Retryable
+	l.breaker = config.Breaker
+	l.batchKey = config.BatchKey
+	l.missingPolicy = config.MissingPolicy
+	l.owner = config.Owner
+	l.detectDeadlocks = config.DetectDeadlocks
+	l.detectMutations = config.DetectMutations
+	l.onMutation = config.OnMutation
+	l.closedPolicy = config.ClosedPolicy
+	l.pool = config.Pool
+	l.maxConcurrentBatches = config.MaxConcurrentBatches
+	l.maxPendingKeys = config.MaxPendingKeys
+	l.onBackpressure = config.OnBackpressure
+	l.metrics = config.Metrics
+	l.tracer = config.Tracer
+	l.propagateContext = config.PropagateContext
+}
+
+// CommentCountLoader batches and caches requests
+type CommentCountLoader struct {
+	// this method provides the data for the loader
+	fetch func(keys []int) ([]int, []error)
+
+	// this replaces fetch when set
+	fetchContext func(ctx context.Context, keys []int) ([]int, []error)
+
+	// when set, batches are fetched on a connection acquire checks out
+	fetchConn func(ctx context.Context, conn CommentCountLoaderConn, keys []int) ([]int, []error)
+	acquire   func(ctx context.Context, fetch func(conn CommentCountLoaderConn)) error
+
+	// how long to done before sending a batch
+	wait time.Duration
+
+	// how many keys a batch should wait for and how short its wait may get, 0 = batches always wait for wait
+	targetBatch int
+	minWait     time.Duration
+
+	// the average time between new keys, only tracked when targetBatch is set
+	arrivals commentCountLoaderArrivals
+
+	// this will limit the maximum number of keys to send in one batch, 0 = no limit
+	maxBatch int
+
+	// how far past maxBatch a batch may grow to fit a whole LoadAll
+	maxBatchOverflow int
+
+	// this orders the keys of a batch before fetching, nil = keys are sent in the order they were requested
+	sortKeys func(keys []int)
+
+	// this decides which error class a failed key is counted in
+	classifyError func(key int, err error) CommentCountLoaderErrorClass
+
+	// this groups keys for patternStats
+	keyPattern func(key int) string
+
+	// this is told about keys that are fetched more than once
+	onDuplicateFetch func(key int, fetches int)
+
+	// when set, fetch results of the wrong length fail the batch
+	strict bool
+
+	// this is told about batches failed by strict
+	onResultLengthError func(keys []int, err *CommentCountLoaderResultLengthError)
+
+	// this identifies soft deleted values
+	isDeleted func(value int) bool
+
+	// this orders values for PrimeIfNewer
+	version func(value int) int64
+
+	// this is applied to fetched values before they are cached
+	transform func(key int, value int) int
+
+	// this checks fetched values before they are cached
+	validateValue func(key int, value int) error
+
+	// values larger than this aren't cached, 0 = no limit
+	maxValueBytes int
+
+	// this measures values for maxValueBytes
+	valueSize func(value int) int
+
+	// this returns the index terms of a value
+	indexBy func(value int) []string
+
+	// when set, keys of soft deleted values are remembered
+	cacheDeleted bool
+
+	// how long errors are cached, 0 = they aren't
+	errorTTL time.Duration
+
+	// this picks the errors to cache, nil = not found errors
+	cacheError func(key int, err error) bool
+
+	// when set, LoadAll doesn't cache what it fetches
+	loadAllNoCache bool
+
+	// when set, identical LoadAlls that overlap share one result
+	collapseLoadAll bool
+
+	// the fraction of loads passed to logSample
+	logSampleRate float64
+
+	// this is told about sampled loads
+	logSample func(sample CommentCountLoaderLoadSample)
+
+	// this keeps processes sharing a cache from fetching the same keys
+	keyLocker CommentCountLoaderKeyLocker
+
+	// how long to wait for another process holding a key's lock
+	keyLockWait time.Duration
+
+	// when set, slow fetches are hedged
+	hedge bool
+
+	// how often failed keys are fetched again, how long to wait in between, and which errors are worth it
+	maxRetries int
+	backoff    func(attempt int) time.Duration
+	retryable  func(err error) bool
+
+	// this short-circuits fetches while the backend is failing
+	breaker CommentCountLoaderBreaker
+
+	// this partitions keys into batches, nil = one batch for all keys
+	batchKey func(key int) string
+
+	// what to return for missing keys
+	missingPolicy CommentCountLoaderMissingPolicy
+
+	// loads after this is done panic, nil = no check
+	owner context.Context
+
+	// when set, loads from within fetch without its ctx fail
+	detectDeadlocks bool
+
+	// when set, cached values are checksummed to catch changes made in place
+	detectMutations bool
+	onMutation      func(key int, value int)
+
+	// what to do with loads after close
+	closedPolicy CommentCountLoaderClosedPolicy
+
+	// this runs fetches, nil = a new goroutine per batch
+	pool CommentCountLoaderPool
+
+	// this limits the batches fetching at once, 0 = no limit
+	maxConcurrentBatches int
+
+	// the most keys that may be pending at once, 0 = no limit
+	maxPendingKeys int
+
+	// this is called when a batch queues for maxConcurrentBatches
+	onBackpressure func(queued int)
+
+	// this is told about batches and cache lookups
+	metrics CommentCountLoaderMetrics
+
+	// this traces batches
+	tracer CommentCountLoaderTracer
+
+	// copies values of the first caller's ctx into the ctx of the fetch
+	propagateContext func(from, to context.Context) context.Context
+
+	// how long values stay cached, 0 = until they are cleared
+	ttl time.Duration
+
+	// INTERNAL
+
+	cache CommentCountLoaderCache
+
+	// cache writes made with the loader locked, flushWrites applies them once it is unlocked
+	writes []commentCountLoaderCacheWrite
+
+	// keeps flushWrites applying queued writes in the order they were made
+	writeMu sync.Mutex
+
+	// what the loader knows about each key it wrote to the cache
+	meta map[int]*CommentCountLoaderEntryMeta
+
+	// rolling hit/miss/batch counters, nil when StatsWindow is not set
+	window *commentCountLoaderStatsWindow
+
+	// approximate load counts of the hottest keys, nil when HotKeys is not set
+	hotKeys *commentCountLoaderHotKeys
+
+	// number of failed keys per error class
+	errorCounts map[CommentCountLoaderErrorClass]int
+
+	// the counters of each key pattern, only kept when keyPattern is set
+	patternStats map[string]*CommentCountLoaderPatternStats
+
+	// keys that are known to be absent, ie. soft deleted ones when cacheDeleted is set and the ones
+	// LoadOptional didn't find, with when they expire like a value cached with ttl would. Zero = never.
+	deleted map[int]time.Time
+
+	// the errors cached for errorTTL
+	errored map[int]commentCountLoaderCachedError
+
+	// the primes PrimePending staged, until they are committed or rolled back
+	staged map[int]*commentCountLoaderStaged
+
+	// the LoadAlls that are running, by their keys, only tracked when collapseLoadAll is set
+	collapsing map[string]*commentCountLoaderCollapsed
+
+	// batches that haven't returned yet, so close can wait for them
+	inflight map[*commentCountLoaderBatch]struct{}
+
+	// one token per batch that is fetching, sized to maxConcurrentBatches
+	slots chan struct{}
+
+	// number of closed batches that haven't started fetching yet
+	queued int
+
+	// the batches being fetched by goroutine id, only tracked when detectDeadlocks is set
+	fetchers map[uint64]*commentCountLoaderBatch
+
+	// set once the loader is closed
+	closed bool
+
+	// number of batches each key is waiting on, and their sum
+	pending     map[int]int
+	pendingKeys int
+
+	// the keys cached under each index term and the terms of each key, only tracked when indexBy is set
+	index map[string]map[int]struct{}
+	terms map[int][]string
+
+	// lifetime counters, the derived fields are filled in by Stats
+	stats CommentCountLoaderStats
+
+	// durations of recent fetches
+	latencies commentCountLoaderLatencies
+
+	// how many times each key was fetched successfully, only tracked when onDuplicateFetch is set
+	fetchCounts map[int]int
+
+	// the current batch of each partition. keys will continue to be collected until timeout is hit,
+	// then everything will be sent to the fetch method and out to the listeners
+	batches map[string]*commentCountLoaderBatch
+
+	// mutex to prevent races
+	mu sync.Mutex
+}
+
+type commentCountLoaderBatch struct {
+	partition string
+	keys      []int
+	claims    []int
+	data      []int
+	error     []error
+	oversized map[int]bool
+	index     map[int]int
+	closing   bool
+	done      chan struct{}
+
+	// whether the fetch has started, and the keys cleared since then. Values of stale keys aren't cached, they may
+	// have been read before whatever write the clear was for.
+	fetching bool
+	stale    map[int]bool
+	staleAll bool
+
+	// how many waiters could still cancel, and whether any can't. Once every waiter has cancelled the batch is
+	// abandoned, it isn't fetched at all when that happens before its fetch starts and the ctx of FetchContext is
+	// cancelled when it happens after.
+	contexts  int
+	detached  bool
+	abandoned bool
+	cancel    context.CancelFunc
+
+	// the contexts of the callers waiting on the batch, only kept when the loader has a tracer
+	callers []context.Context
+
+	// the ctx of the first caller that loaded with one, only kept when the loader propagates context values
+	origin context.Context
+
+	// the fetch this batch was loaded from, when a FetchContext loads more keys. Such batches skip the Pool
+	// and MaxConcurrentBatches, the fetch waiting on them holds a slot already.
+	parent *commentCountLoaderFetch
+
+	// identifies the batch to FetchContext, it is set once the batch is fetched
+	id string
+}
+
+// Load a int by key, batching and caching will be applied automatically
+func (l *CommentCountLoader) Load(key int) (int, error) {
+	return l.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a int.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *CommentCountLoader) LoadThunk(key int) func() (int, error) {
+	thunk, _ := l.LoadThunkWithRelease(key)
+	return thunk
+}
+
+// LoadContext loads a int by key like Load, but gives up with ctx.Err() once ctx is done. The ctx
+// also counts towards the context FetchContext gets.
+func (l *CommentCountLoader) LoadContext(ctx context.Context, key int) (int, error) {
+	return l.LoadThunkContext(ctx, key)()
+}
+
+// LoadThunkContext works like LoadThunk, but the thunk gives up with ctx.Err() once ctx is done
+func (l *CommentCountLoader) LoadThunkContext(ctx context.Context, key int) func() (int, error) {
+	thunk, release, done := l.load(ctx, key, 1, false)
+	return func() (int, error) {
+		select {
+		case <-done:
+		default:
+			select {
+			case <-done:
+			case <-ctx.Done():
+				release()
+				var zero int
+				return zero, ctx.Err()
+			}
+		}
+		return thunk()
+	}
+}
+
+// LoadThunkWithRelease works like LoadThunk, but also returns a release func. Calling release tells the loader
+// that the thunk will never be called, so the batch stops holding on to its result. A released thunk can still
+// be called, it will load the key again.
+func (l *CommentCountLoader) LoadThunkWithRelease(key int) (func() (int, error), func()) {
+	return l.loadThunk(key, 1, false)
+}
+
+// loadThunk adds key to the current batch, remaining is the number of keys the caller is adding to the batch
+// including this one and bulk is set for LoadAll
+func (l *CommentCountLoader) loadThunk(key int, remaining int, bulk bool) (func() (int, error), func()) {
+	thunk, release, _ := l.load(context.Background(), key, remaining, bulk)
+	return thunk, release
+}
+
+// load is loadThunk for a caller waiting with ctx, done is closed once the thunk won't block anymore
+func (l *CommentCountLoader) load(ctx context.Context, key int, remaining int, bulk bool) (thunk func() (int, error), release func(), done <-chan struct{}) {
+	start := time.Now()
+	l.hotKeys.record(key)
+	if it, ok := l.cache.Get(key); ok && !l.expire(key) {
+		l.window.record(1, 0, 0)
+		l.mu.Lock()
+		l.checkOwner(key)
+		if l.closed {
+			config := l.config()
+			l.mu.Unlock()
+			thunk, release := l.closedThunk(config, key)
+			return thunk, release, commentCountLoaderReady
+		}
+		var mutated bool
+		if meta, ok := l.meta[key]; ok {
+			meta.Hits++
+			mutated = l.detectMutations && meta.mutated(it)
+		}
+		l.stats.Hits++
+		if stats := l.patternOf(key); stats != nil {
+			stats.Loads++
+			stats.Hits++
+		}
+		logSample := l.sampleLog()
+		metrics := l.metrics
+		onMutation := l.onMutation
+		l.mu.Unlock()
+		if mutated {
+			if onMutation == nil {
+				panic(fmt.Errorf("%w: loading %s", ErrCommentCountLoaderMutated, commentCountLoaderKeyString(key)))
+			}
+			onMutation(key, it)
+		}
+		if metrics != nil {
+			metrics.Hit()
+		}
+		if logSample != nil {
+			logSample(CommentCountLoaderLoadSample{Key: key, Hit: true, Latency: time.Since(start)})
+		}
+		return func() (int, error) {
+			return it, nil
+		}, func() {}, commentCountLoaderReady
+	}
+	l.window.record(0, 1, 0)
+	l.mu.Lock()
+	l.checkOwner(key)
+	if l.closed {
+		config := l.config()
+		l.mu.Unlock()
+		thunk, release := l.closedThunk(config, key)
+		return thunk, release, commentCountLoaderReady
+	}
+	l.stats.Misses++
+	patternStats := l.patternOf(key)
+	if patternStats != nil {
+		patternStats.Loads++
+		patternStats.Misses++
+	}
+	metrics := l.metrics
+	if l.unsafeIsAbsent(key) {
+		missingPolicy := l.missingPolicy
+		l.mu.Unlock()
+		if metrics != nil {
+			metrics.Miss()
+		}
+		return func() (int, error) {
+			var zero int
+			if missingPolicy == CommentCountLoaderMissingZero {
+				return zero, nil
+			}
+			return zero, ErrCommentCountLoaderNotFound
+		}, func() {}, commentCountLoaderReady
+	}
+	if cached, ok := l.errored[key]; ok {
+		if time.Now().Before(cached.expires) {
+			l.mu.Unlock()
+			if metrics != nil {
+				metrics.Miss()
+			}
+			return func() (int, error) {
+				var zero int
+				return zero, cached.err
+			}, func() {}, commentCountLoaderReady
+		}
+		delete(l.errored, key)
+	}
+	parent, _ := ctx.Value(commentCountLoaderFetchKey{}).(*commentCountLoaderFetch)
+	if parent.has(l, key) {
+		l.mu.Unlock()
+		return func() (int, error) {
+			var zero int
+			return zero, ErrCommentCountLoaderReentrant
+		}, func() {}, commentCountLoaderReady
+	}
+	if l.detectDeadlocks && parent == nil {
+		if fetching := l.fetchers[commentCountLoaderGoroutineID()]; fetching != nil {
+			err := fmt.Errorf("%w: loaded %s from within the fetch of %d keys, pass the ctx of FetchContext to LoadContext instead",
+				ErrCommentCountLoaderDeadlock, commentCountLoaderKeyString(key), len(fetching.keys))
+			l.mu.Unlock()
+			return func() (int, error) {
+				var zero int
+				return zero, err
+			}, func() {}, commentCountLoaderReady
+		}
+	}
+	var partition string
+	if l.batchKey != nil {
+		partition = l.batchKey(key)
+	}
+	if l.maxPendingKeys > 0 && l.pendingKeys >= l.maxPendingKeys {
+		if b := l.batches[partition]; b == nil || !b.has(key) {
+			err := &CommentCountLoaderOverloadError{Pending: l.pendingKeys, MaxPendingKeys: l.maxPendingKeys}
+			l.stats.Overloaded++
+			l.mu.Unlock()
+			return func() (int, error) {
+				var zero int
+				return zero, err
+			}, func() {}, commentCountLoaderReady
+		}
+	}
+	batch := l.batches[partition]
+	if batch == nil {
+		batch = &commentCountLoaderBatch{partition: partition, done: make(chan struct{})}
+		if l.batches == nil {
+			l.batches = map[string]*commentCountLoaderBatch{}
+		}
+		l.batches[partition] = batch
+		if l.inflight == nil {
+			l.inflight = map[*commentCountLoaderBatch]struct{}{}
+		}
+		l.inflight[batch] = struct{}{}
+	}
+	if patternStats != nil {
+		if _, ok := batch.position(key); ok {
+			patternStats.Coalesced++
+		}
+	}
+	pos, full := batch.keyIndex(l, key, l.batchLimit(batch, remaining))
+	batch.claims[pos]++
+	if parent != nil && batch.parent == nil {
+		batch.parent = parent
+	}
+	pool := l.pool
+	if batch.parent != nil {
+		pool = nil
+	}
+	store := !bulk || !l.loadAllNoCache
+	logSample := l.sampleLog()
+	if ctx.Done() == nil {
+		batch.detached = true
+	} else {
+		batch.contexts++
+		go batch.watch(l, ctx)
+	}
+	// the batch is still collecting keys, so it is wanted again even if every earlier waiter gave up
+	batch.abandoned = false
+	if l.tracer != nil && ctx != context.Background() {
+		batch.callers = append(batch.callers, ctx)
+	}
+	if l.propagateContext != nil && ctx != context.Background() && batch.origin == nil {
+		batch.origin = ctx
+	}
+	l.mu.Unlock()
+	if metrics != nil {
+		metrics.Miss()
+	}
+
+	if full {
+		if pool != nil {
+			pool.Go(func() { batch.end(l) })
+		} else {
+			go batch.end(l)
+		}
+	}
+
+	var once sync.Once
+	var released bool
+	var data int
+	var err error
+
+	release = func() {
+		once.Do(func() {
+			released = true
+			batch.unclaim(l, pos)
+			batch = nil
+		})
+	}
+
+	thunk = func() (int, error) {
+		once.Do(func() {
+			<-batch.done
+
+			if pos < len(batch.data) {
+				data = batch.data[pos]
+			}
+
+			err = batch.errorAt(pos)
+			cache := store && !batch.oversized[pos]
+			fetched := batch
+
+			batch.unclaim(l, pos)
+			batch = nil
+
+			if err == nil && cache {
+				l.mu.Lock()
+				if !fetched.staleAll && !fetched.stale[pos] {
+					l.unsafeSet(key, data, 0)
+				}
+				l.mu.Unlock()
+				l.flushWrites()
+			}
+
+			if logSample != nil {
+				logSample(CommentCountLoaderLoadSample{Key: key, Latency: time.Since(start), Err: err, BatchID: fetched.id})
+			}
+		})
+
+		if released {
+			// load again for the same caller, one whose ctx is done gets its error without queueing the key
+			if err := ctx.Err(); err != nil {
+				var zero int
+				return zero, err
+			}
+			return l.LoadContext(ctx, key)
+		}
+		return data, err
+	}
+
+	return thunk, release, batch.done
+}
+
+// commentCountLoaderReady is the done channel of thunks that don't wait on a batch
+var commentCountLoaderReady = func() chan struct{} {
+	ready := make(chan struct{})
+	close(ready)
+	return ready
+}()
+
+// IsPending reports whether key is part of a batch that hasn't returned yet, either because it is still
+// collecting keys or because its fetch is in flight. It never triggers a fetch.
+func (l *CommentCountLoader) IsPending(key int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pending[key] > 0
+}
+
+// Flush fetches the batches that are still collecting keys right away instead of waiting out Wait, eg. once a
+// resolver knows it has queued every key it needs. It doesn't wait for the fetches to return.
+func (l *CommentCountLoader) Flush() {
+	l.mu.Lock()
+	var batches []*commentCountLoaderBatch
+	for partition, b := range l.batches {
+		b.closing = true
+		delete(l.batches, partition)
+		l.queued++
+		batches = append(batches, b)
+	}
+	pool := l.pool
+	l.mu.Unlock()
+
+	for _, b := range batches {
+		b := b
+		if pool != nil && b.parent == nil {
+			pool.Go(func() { b.end(l) })
+		} else {
+			go b.end(l)
+		}
+	}
+}
+
+// LoadFresh loads a int by key like Load, but only accepts a cached value if it was cached within
+// maxAge. Older (or untracked) values are cleared and fetched again.
+func (l *CommentCountLoader) LoadFresh(key int, maxAge time.Duration) (int, error) {
+	l.mu.Lock()
+	meta, ok := l.meta[key]
+	fresh := ok && time.Since(meta.CachedAt) <= maxAge
+	l.mu.Unlock()
+
+	if !fresh {
+		l.Clear(key)
+	}
+	return l.Load(key)
+}
+
+// CommentCountLoaderSession gives read-your-writes on top of a shared loader for the length of a request. Keys marked with
+// Wrote are fetched again on every load through the session instead of being served from the cache. What those
+// fetches return stays in the session, the shared loader and its cache are left as they are.
+type CommentCountLoaderSession struct {
+	loader  *CommentCountLoader
+	mu      sync.Mutex
+	written map[int]struct{}
+
+	// fetches written keys with the config of loader but without caching, nil until a written key is loaded
+	fresh *CommentCountLoader
+}
+
+// Session starts a read-your-writes session, it is meant to be created per request
+func (l *CommentCountLoader) Session() *CommentCountLoaderSession {
+	return &CommentCountLoaderSession{loader: l, written: map[int]struct{}{}}
+}
+
+// Wrote records that keys were mutated during the session
+func (s *CommentCountLoaderSession) Wrote(keys ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.written[key] = struct{}{}
+	}
+}
+
+// Load a int by key, bypassing the cache for keys written during the session
+func (s *CommentCountLoaderSession) Load(key int) (int, error) {
+	return s.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a int, keys written during the
+// session are fetched again rather than served from the cache
+func (s *CommentCountLoaderSession) LoadThunk(key int) func() (int, error) {
+	s.mu.Lock()
+	_, written := s.written[key]
+	if written && s.fresh == nil {
+		s.loader.mu.Lock()
+		config := s.loader.config()
+		s.loader.mu.Unlock()
+		config.Cache = CommentCountLoaderNoCache{}
+		s.fresh = NewCommentCountLoader(config)
+	}
+	fresh := s.fresh
+	s.mu.Unlock()
+
+	if written {
+		return fresh.LoadThunk(key)
+	}
+	return s.loader.LoadThunk(key)
+}
+
+// LoadAll loads many keys at once, bypassing the cache for keys written during the session
+func (s *CommentCountLoaderSession) LoadAll(keys []int) ([]int, []error) {
+	thunks := make([]func() (int, error), len(keys))
+	for i, key := range keys {
+		thunks[i] = s.LoadThunk(key)
+	}
+
+	ints := make([]int, len(keys))
+	errors := make([]error, len(keys))
+	for i, thunk := range thunks {
+		ints[i], errors[i] = thunk()
+	}
+	return ints, errors
+}
+
+// CommentCountLoaderTx binds a loader to a database transaction. What is loaded or primed through the tx is cached in the
+// tx only, Commit copies it into the loader and Rollback discards it, so uncommitted data never reaches a shared
+// cache. After Commit or Rollback the tx loads through the loader itself.
+type CommentCountLoaderTx struct {
+	loader *CommentCountLoader
+
+	// caches what was loaded or primed through the tx, nil once the tx is done
+	tx      *CommentCountLoader
+	mu      sync.Mutex
+	primed  map[int]struct{}
+	cleared map[int]struct{}
+}
+
+// Tx starts binding l to a transaction, fetch reads through the transaction so it sees its uncommitted writes.
+// Batches are collected with the wait and max batch of l.
+func (l *CommentCountLoader) Tx(fetch func(keys []int) ([]int, []error)) *CommentCountLoaderTx {
+	l.mu.Lock()
+	config := CommentCountLoaderConfig{Fetch: fetch, Wait: l.wait, MaxBatch: l.maxBatch}
+	l.mu.Unlock()
+
+	return &CommentCountLoaderTx{
+		loader:  l,
+		tx:      NewCommentCountLoader(config),
+		primed:  map[int]struct{}{},
+		cleared: map[int]struct{}{},
+	}
+}
+
+// Load a int by key through the transaction
+func (t *CommentCountLoaderTx) Load(key int) (int, error) {
+	return t.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a int loaded through the
+// transaction
+func (t *CommentCountLoaderTx) LoadThunk(key int) func() (int, error) {
+	return t.current().LoadThunk(key)
+}
+
+// LoadAll loads many keys at once through the transaction
+func (t *CommentCountLoaderTx) LoadAll(keys []int) ([]int, []error) {
+	return t.current().LoadAll(keys)
+}
+
+// Prime caches a value written in the transaction, it replaces whatever the tx cached for key before. The
+// loader's cache only gets it on Commit.
+func (t *CommentCountLoaderTx) Prime(key int, value int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil {
+		t.loader.Clear(key)
+		t.loader.Prime(key, value)
+		return
+	}
+	t.tx.Clear(key)
+	t.tx.Prime(key, value)
+	t.primed[key] = struct{}{}
+	delete(t.cleared, key)
+}
+
+// Clear the value at key, eg. after deleting it in the transaction. The loader's cache is cleared on Commit.
+func (t *CommentCountLoaderTx) Clear(key int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil {
+		t.loader.Clear(key)
+		return
+	}
+	t.tx.Clear(key)
+	t.cleared[key] = struct{}{}
+	delete(t.primed, key)
+}
+
+// Commit copies what the tx cached into the loader, call it once the transaction committed. Primed values
+// replace the ones the loader has, loaded ones only fill in keys it doesn't.
+func (t *CommentCountLoaderTx) Commit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tx == nil {
+		return
+	}
+
+	for key := range t.cleared {
+		t.loader.Clear(key)
+	}
+
+	t.tx.mu.Lock()
+	keys := make([]int, 0, len(t.tx.meta))
+	for key := range t.tx.meta {
+		keys = append(keys, key)
+	}
+	t.tx.mu.Unlock()
+
+	for _, key := range keys {
+		value, ok := t.tx.cache.Get(key)
+		if !ok {
+			continue
+		}
+		if _, primed := t.primed[key]; primed {
+			t.loader.Clear(key)
+		}
+		t.loader.Prime(key, value)
+	}
+	t.tx = nil
+}
+
+// Rollback discards everything the tx cached, call it once the transaction rolled back
+func (t *CommentCountLoaderTx) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tx = nil
+}
+
+// current is the loader loads go through, the tx one until the tx is done
+func (t *CommentCountLoaderTx) current() *CommentCountLoader {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tx == nil {
+		return t.loader
+	}
+	return t.tx
+}
+
+// CommentCountLoaderEntryMeta describes a cached value
+type CommentCountLoaderEntryMeta struct {
+	// CachedAt is when the value was written to the cache
+	CachedAt time.Time
+
+	// Expires is when the value expires because of TTL or CommentCountLoaderWithTTL, zero when the cache decides
+	Expires time.Time
+
+	// Hits is how many loads have been served from the cache since
+	Hits int
+
+	// the results of LoadThen, they go together with the value
+	derived map[*CommentCountLoaderDerivation]interface{}
+
+	// the checksum of the value when it was cached, only kept when detectMutations is set
+	checksum uint64
+}
+
+// Entry returns the cached value for key, without fetching it, along with what the loader knows about the entry.
+// The meta is zero for values this loader didn't write itself, eg. ones that were put in a shared cache by others.
+func (l *CommentCountLoader) Entry(key int) (int, CommentCountLoaderEntryMeta, bool) {
+	value, ok := l.cache.Get(key)
+	if !ok {
+		return value, CommentCountLoaderEntryMeta{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var meta CommentCountLoaderEntryMeta
+	if m, ok := l.meta[key]; ok {
+		meta = *m
+	}
+	return value, meta, true
+}
+
+// CommentCountLoaderDerivation is a computation derived from a int, eg. an expensive transform of it. Loads
+// through LoadThen remember its result alongside the cached value.
+type CommentCountLoaderDerivation struct {
+	derive func(value int) (interface{}, error)
+}
+
+// NewCommentCountLoaderDerivation creates a derivation, it is meant to be created once and reused for every load
+func NewCommentCountLoaderDerivation(derive func(value int) (interface{}, error)) *CommentCountLoaderDerivation {
+	return &CommentCountLoaderDerivation{derive: derive}
+}
+
+// LoadThen loads a int by key and returns what derivation derives from it. The result is cached
+// alongside the value, so it is derived once per cached value rather than on every load, and is dropped together
+// with it when the key is cleared, expires or is primed. Failed derivations are not cached.
+func (l *CommentCountLoader) LoadThen(key int, derivation *CommentCountLoaderDerivation) (interface{}, error) {
+	value, err := l.Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	meta := l.meta[key]
+	if meta != nil {
+		if result, ok := meta.derived[derivation]; ok {
+			l.mu.Unlock()
+			return result, nil
+		}
+	}
+	l.mu.Unlock()
+
+	result, err := derivation.derive(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// the value may have been replaced while deriving, the result only belongs with the one it was derived from
+	l.mu.Lock()
+	if meta != nil && l.meta[key] == meta {
+		if meta.derived == nil {
+			meta.derived = map[*CommentCountLoaderDerivation]interface{}{}
+		}
+		meta.derived[derivation] = result
+	}
+	l.mu.Unlock()
+	return result, nil
+}
+
+// InvalidateAndReload clears key, fetches it again and caches the fresh value, which it returns. It is meant to be
+// called after a mutation to get read-your-writes behaviour.
+func (l *CommentCountLoader) InvalidateAndReload(key int) (int, error) {
+	l.Clear(key)
+
+	value, err := l.Load(key)
+	if err != nil {
+		return value, err
+	}
+
+	// a batch that was already in flight before the clear may have cached an older value in the meantime
+	l.mu.Lock()
+	l.unsafeSet(key, value, 0)
+	l.mu.Unlock()
+	l.flushWrites()
+
+	return value, nil
+}
+
+// LoadAll fetches many keys at once. It will be broken into appropriate sized
+// sub batches depending on how the loader is configured
+func (l *CommentCountLoader) LoadAll(keys []int) ([]int, []error) {
+	l.mu.Lock()
+	collapse := l.collapseLoadAll
+	l.mu.Unlock()
+	if collapse {
+		return l.collapsedLoadAll(keys)
+	}
+	return l.loadAll(keys)
+}
+
+func (l *CommentCountLoader) loadAll(keys []int) ([]int, []error) {
+	distinct, index := l.distinct(keys)
+	results := make([]func() (int, error), len(distinct))
+
+	for i, key := range distinct {
+		results[i], _ = l.loadThunk(key, len(distinct)-i, true)
+	}
+
+	ints := make([]int, len(keys))
+	errors := make([]error, len(keys))
+	for i := range keys {
+		ints[i], errors[i] = results[index[i]]()
+	}
+	return ints, errors
+}
+
+// distinct returns keys without its duplicates, in the order they first appear, and the position in it of every key.
+// Duplicates would otherwise take up room in a batch, or end up fetched again in the next one. They still count as
+// loads for HotKeys.
+func (l *CommentCountLoader) distinct(keys []int) ([]int, []int) {
+	distinct := make([]int, 0, len(keys))
+	index := make([]int, len(keys))
+	seen := make(map[int]int, len(keys))
+	for i, key := range keys {
+		pos, ok := seen[key]
+		if !ok {
+			pos = len(distinct)
+			seen[key] = pos
+			distinct = append(distinct, key)
+		} else {
+			l.hotKeys.record(key)
+		}
+		index[i] = pos
+	}
+	return distinct, index
+}
+
+type commentCountLoaderCollapsed struct {
+	done   chan struct{}
+	values []int
+	errors []error
+}
+
+// collapsedLoadAll shares the result of a LoadAll of the same keys that is still running, or runs one itself
+func (l *CommentCountLoader) collapsedLoadAll(keys []int) ([]int, []error) {
+	id := fmt.Sprintf("%#v", keys)
+
+	l.mu.Lock()
+	if c, ok := l.collapsing[id]; ok {
+		l.stats.Collapsed++
+		l.mu.Unlock()
+
+		<-c.done
+		values := make([]int, len(c.values))
+		copy(values, c.values)
+		errors := make([]error, len(c.errors))
+		copy(errors, c.errors)
+		return values, errors
+	}
+	c := &commentCountLoaderCollapsed{done: make(chan struct{})}
+	if l.collapsing == nil {
+		l.collapsing = map[string]*commentCountLoaderCollapsed{}
+	}
+	l.collapsing[id] = c
+	l.mu.Unlock()
+
+	defer func() {
+		// the waiters get a panic of the LoadAll they share, eg. in LogSample, as an error instead of waiting forever
+		r := recover()
+		if r != nil {
+			err := &CommentCountLoaderPanicError{Value: r, Stack: debug.Stack()}
+			c.values = make([]int, len(keys))
+			c.errors = make([]error, len(keys))
+			for i := range c.errors {
+				c.errors[i] = err
+			}
+		}
+
+		l.mu.Lock()
+		delete(l.collapsing, id)
+		l.mu.Unlock()
+		close(c.done)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	values, errors := l.loadAll(keys)
+	c.values = make([]int, len(values))
+	copy(c.values, values)
+	c.errors = make([]error, len(errors))
+	copy(c.errors, errors)
+	return values, errors
+}
+
+// LoadAllThunk returns a function that when called will block waiting for a ints.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *CommentCountLoader) LoadAllThunk(keys []int) func() ([]int, []error) {
+	distinct, index := l.distinct(keys)
+	results := make([]func() (int, error), len(distinct))
+	for i, key := range distinct {
+		results[i], _ = l.loadThunk(key, len(distinct)-i, true)
+	}
+	return func() ([]int, []error) {
+		ints := make([]int, len(keys))
+		errors := make([]error, len(keys))
+		for i := range keys {
+			ints[i], errors[i] = results[index[i]]()
+		}
+		return ints, errors
+	}
+}
+
+// LoadAllPartial loads many keys like LoadAll, but only waits until ctx is done. Keys that haven't returned by
+// then get ctx.Err() (eg. context.DeadlineExceeded) as their error and everything else is returned as it was loaded,
+// for best effort fan outs that would rather have some results in time than all of them late.
+func (l *CommentCountLoader) LoadAllPartial(ctx context.Context, keys []int) ([]int, []error) {
+	distinct, index := l.distinct(keys)
+	thunks := make([]func() (int, error), len(distinct))
+	releases := make([]func(), len(distinct))
+	dones := make([]<-chan struct{}, len(distinct))
+	for i, key := range distinct {
+		thunks[i], releases[i], dones[i] = l.load(ctx, key, len(distinct)-i, true)
+	}
+
+	ints := make([]int, len(keys))
+	errors := make([]error, len(keys))
+	for i := range keys {
+		d := index[i]
+		select {
+		case <-dones[d]:
+		default:
+			select {
+			case <-dones[d]:
+			case <-ctx.Done():
+				releases[d]()
+				errors[i] = ctx.Err()
+				continue
+			}
+		}
+		ints[i], errors[i] = thunks[d]()
+	}
+	return ints, errors
+}
+
+// LoadAllParallel loads many keys like LoadAll, but fails fast like an errgroup: the first key to fail cancels the
+// loads still waiting and its error is returned, wrapped with the key. The thunks are resolved on up to parallel
+// goroutines, 0 = one per key. It returns ctx.Err() if ctx is done before every key has loaded.
+func (l *CommentCountLoader) LoadAllParallel(ctx context.Context, keys []int, parallel int) ([]int, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	distinct, index := l.distinct(keys)
+	thunks := make([]func() (int, error), len(distinct))
+	for i, key := range distinct {
+		thunks[i] = l.LoadThunkContext(ctx, key)
+	}
+
+	// repeated keys share a thunk, so there is never more to resolve than there are distinct keys
+	if parallel <= 0 || parallel > len(distinct) {
+		parallel = len(distinct)
+	}
+	values := make([]int, len(distinct))
+	var failed sync.Once
+	var firstErr error
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(parallel)
+	for w := 0; w < parallel; w++ {
+		go func() {
+			defer wg.Done()
+			for d := range next {
+				value, err := thunks[d]()
+				if err != nil {
+					failed.Do(func() {
+						firstErr = fmt.Errorf("CommentCountLoader: loading %s: %w", commentCountLoaderKeyString(distinct[d]), err)
+						cancel()
+					})
+					continue
+				}
+				values[d] = value
+			}
+		}()
+	}
+
+feed:
+	for d := range distinct {
+		select {
+		case next <- d:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ints := make([]int, len(keys))
+	for i, d := range index {
+		ints[i] = values[d]
+	}
+	return ints, nil
+}
+
+// CommentCountLoaderLoadSample describes a load picked by LogSampleRate
+type CommentCountLoaderLoadSample struct {
+	Key int
+
+	// Hit is set when the value came from the cache
+	Hit bool
+
+	// Latency is the time from the load until its value was available
+	Latency time.Duration
+	Err     error
+
+	// BatchID is the ID of the batch the value was fetched in, see CommentCountLoaderBatchID
+	BatchID string
+}
+
+// sampleLog returns logSample when this load should be sampled, it must be called with the loader locked
+func (l *CommentCountLoader) sampleLog() func(sample CommentCountLoaderLoadSample) {
+	if l.logSample == nil || l.logSampleRate <= 0 || rand.Float64() >= l.logSampleRate {
+		return nil
+	}
+	return l.logSample
+}
+
+// CommentCountLoaderResult is the result of loading one of the keys passed to LoadAllStream
+type CommentCountLoaderResult struct {
+	// Index is the position of Key in the keys passed to LoadAllStream
+	Index int
+	Key   int
+	Value int
+	Err   error
+}
+
+// LoadAllStream loads many keys like LoadAll, but sends the results on the returned channel as soon as the batch
+// they are in returns, so a caller can start on them before the last batch is done. Cached keys are sent right away, the
+// channel is closed after the last result.
+func (l *CommentCountLoader) LoadAllStream(keys []int) <-chan CommentCountLoaderResult {
+	results := make(chan CommentCountLoaderResult, len(keys))
+	distinct, index := l.distinct(keys)
+	thunks := make([]func() (int, error), len(distinct))
+	dones := make([]<-chan struct{}, len(distinct))
+	for i, key := range distinct {
+		thunks[i], _, dones[i] = l.load(context.Background(), key, len(distinct)-i, true)
+	}
+
+	// group the keys by the batch they wait on, so there is one goroutine per batch rather than per key
+	var batches []<-chan struct{}
+	waiting := map[<-chan struct{}][]int{}
+	for i := range keys {
+		done := dones[index[i]]
+		if _, ok := waiting[done]; !ok {
+			batches = append(batches, done)
+		}
+		waiting[done] = append(waiting[done], i)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(batches))
+	for _, done := range batches {
+		go func(done <-chan struct{}, indexes []int) {
+			defer wg.Done()
+			<-done
+			for _, i := range indexes {
+				value, err := thunks[index[i]]()
+				results <- CommentCountLoaderResult{Index: i, Key: keys[i], Value: value, Err: err}
+			}
+		}(done, waiting[done])
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Prime the cache with the provided key and value. If the key already exists, no change is made
+// and false is returned. The cache is checked before the loader is locked, so a value cached by a load
+// in between may be replaced.
+// (To forcefully prime the cache, use ForcePrime.)
+func (l *CommentCountLoader) Prime(key int, value int, opts ...CommentCountLoaderPrimeOption) bool {
+	var o commentCountLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if _, found := l.cache.Get(key); found {
+		return false
+	}
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unsafePrime(key, value, o.ttl)
+	return true
+}
+
+// PrimeMany primes the cache with each of keys and the value at the same position in values, eg. the results of
+// a list query, in one go. Like Prime keys that are already cached are left alone. It returns how many values were
+// cached and panics if there aren't as many values as keys.
+func (l *CommentCountLoader) PrimeMany(keys []int, values []int, opts ...CommentCountLoaderPrimeOption) int {
+	if len(keys) != len(values) {
+		panic(fmt.Sprintf("CommentCountLoader: PrimeMany got %d keys and %d values", len(keys), len(values)))
+	}
+
+	var o commentCountLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	missing := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if _, found := l.cache.Get(key); !found {
+			missing = append(missing, i)
+		}
+	}
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, i := range missing {
+		l.unsafePrime(keys[i], values[i], o.ttl)
+	}
+	return len(missing)
+}
+
+// PrimeMap primes the cache with every key and value in values like PrimeMany
+func (l *CommentCountLoader) PrimeMap(values map[int]int, opts ...CommentCountLoaderPrimeOption) int {
+	var o commentCountLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	missing := make([]int, 0, len(values))
+	for key := range values {
+		if _, found := l.cache.Get(key); !found {
+			missing = append(missing, key)
+		}
+	}
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range missing {
+		l.unsafePrime(key, values[key], o.ttl)
+	}
+	return len(missing)
+}
+
+// PrimeIfNewer primes the cache like Prime, but also replaces a cached value when value has a higher Version,
+// so an out of order response can't regress the cache. It returns whether value was cached. Without a Version
+// configured it only primes missing keys.
+func (l *CommentCountLoader) PrimeIfNewer(key int, value int, opts ...CommentCountLoaderPrimeOption) bool {
+	var o commentCountLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	defer l.flushWrites()
+	cached, found := l.lockCached(key)
+	defer l.mu.Unlock()
+
+	if found && (l.version == nil || l.version(value) <= l.version(cached)) {
+		return false
+	}
+	l.unsafePrime(key, value, o.ttl)
+	return true
+}
+
+// lockCached reads the cached value of key and locks the loader, making sure nothing wrote key in between so a
+// check of the value is atomic with the write that follows. The cache itself is read with the loader unlocked.
+func (l *CommentCountLoader) lockCached(key int) (int, bool) {
+	for {
+		l.mu.Lock()
+		meta, queued := l.meta[key], l.unsafeQueued(key)
+		l.mu.Unlock()
+		if queued {
+			l.flushWrites()
+			continue
+		}
+
+		cached, found := l.cache.Get(key)
+		l.mu.Lock()
+		if l.meta[key] == meta && !l.unsafeQueued(key) {
+			return cached, found
+		}
+		l.mu.Unlock()
+	}
+}
+
+// unsafeQueued reports whether a write to key is waiting for flushWrites, it must be called with the loader locked
+func (l *CommentCountLoader) unsafeQueued(key int) bool {
+	for _, w := range l.writes {
+		if w.all || w.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ForcePrime replaces the cached value at key with value, eg. to write through after a mutation. Unlike a Clear
+// followed by a Prime no load can cache another value in between, and batches whose fetch is in flight won't
+// overwrite it. With a Version configured a value older than the cached one is ignored, so an out of order response
+// can't regress the cache. It returns whether value was cached.
+func (l *CommentCountLoader) ForcePrime(key int, value int, opts ...CommentCountLoaderPrimeOption) bool {
+	var o commentCountLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	defer l.flushWrites()
+	cached, found := l.lockCached(key)
+	defer l.mu.Unlock()
+
+	if found && l.version != nil && l.version(value) < l.version(cached) {
+		return false
+	}
+	l.unsafeMarkStale(key)
+	l.unsafePrime(key, value, o.ttl)
+	return true
+}
+
+type commentCountLoaderStaged struct {
+	// what was cached before the first staged prime, if anything
+	previous int
+	had      bool
+
+	// the entry of the latest staged prime, it is only rolled back while it is still cached
+	meta *CommentCountLoaderEntryMeta
+}
+
+// PrimePending stages value for key, eg. for an optimistic mutation. Loads see it right away, like with ForcePrime,
+// and CommitPrime or RollbackPrime keep it or restore what was cached before once the write succeeds or fails.
+func (l *CommentCountLoader) PrimePending(key int, value int) {
+	previous, had := l.cache.Get(key)
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	staged := l.staged[key]
+	if staged == nil {
+		staged = &commentCountLoaderStaged{previous: previous, had: had}
+		if l.staged == nil {
+			l.staged = map[int]*commentCountLoaderStaged{}
+		}
+		l.staged[key] = staged
+	}
+	l.unsafeMarkStale(key)
+	l.unsafePrime(key, value, 0)
+	staged.meta = l.meta[key]
+}
+
+// CommitPrime keeps the value PrimePending staged for key, it returns false if nothing was staged
+func (l *CommentCountLoader) CommitPrime(key int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.staged[key]
+	delete(l.staged, key)
+	return ok
+}
+
+// RollbackPrime restores what was cached for key before PrimePending staged a value, it returns false if nothing
+// was staged. Keys that were cleared or primed again since are left alone, they no longer hold the staged value.
+func (l *CommentCountLoader) RollbackPrime(key int) bool {
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	staged, ok := l.staged[key]
+	if !ok {
+		return false
+	}
+	delete(l.staged, key)
+	if meta := l.meta[key]; meta == nil || meta != staged.meta {
+		return true
+	}
+
+	l.unsafeMarkStale(key)
+	if staged.had {
+		l.unsafeSet(key, staged.previous, 0)
+	} else {
+		delete(l.meta, key)
+		l.unindex(key)
+		l.writes = append(l.writes, commentCountLoaderCacheWrite{key: key, clear: true})
+	}
+	return true
+}
+
+// unsafePrime caches a copy of value, it must be called with the loader locked. The key is no longer
+// known to be absent once it has a value.
+func (l *CommentCountLoader) unsafePrime(key int, value int, ttl time.Duration) {
+	delete(l.deleted, key)
+	l.unsafeSet(key, value, ttl)
+}
+
+// CommentCountLoaderPrimeOption changes how a single Prime call stores its value
+type CommentCountLoaderPrimeOption func(*commentCountLoaderPrimeOptions)
+
+type commentCountLoaderPrimeOptions struct {
+	ttl time.Duration
+}
+
+// CommentCountLoaderWithTTL expires the primed value after ttl, regardless of how long the cache keeps other values.
+// Caches that don't implement CommentCountLoaderTTLCache have the value expired by the loader on its next load.
+func CommentCountLoaderWithTTL(ttl time.Duration) CommentCountLoaderPrimeOption {
+	return func(o *commentCountLoaderPrimeOptions) {
+		o.ttl = ttl
+	}
+}
+
+// Clear the value at key from the cache, if it exists
+func (l *CommentCountLoader) Clear(key int) {
+	l.ClearMany(key)
+}
+
+// ClearMany clears the values at keys from the cache, eg. after a bulk write. Batches whose fetch is in flight
+// still return the keys to their callers, but don't cache them, they may have been read before the write.
+func (l *CommentCountLoader) ClearMany(keys ...int) {
+	l.mu.Lock()
+	for _, key := range keys {
+		delete(l.meta, key)
+		delete(l.fetchCounts, key)
+		delete(l.deleted, key)
+		delete(l.errored, key)
+		l.unindex(key)
+		l.writes = append(l.writes, commentCountLoaderCacheWrite{key: key, clear: true})
+	}
+	l.unsafeMarkStale(keys...)
+	l.mu.Unlock()
+	l.flushWrites()
+}
+
+// unsafeMarkStale keeps batches whose fetch is in flight from caching keys, it must be called with the loader locked
+func (l *CommentCountLoader) unsafeMarkStale(keys ...int) {
+	for b := range l.inflight {
+		if !b.fetching {
+			continue
+		}
+		for _, key := range keys {
+			if pos, ok := b.position(key); ok {
+				if b.stale == nil {
+					b.stale = map[int]bool{}
+				}
+				b.stale[pos] = true
+			}
+		}
+	}
+}
+
+// ClearAll clears every value from the cache. Caches that don't implement CommentCountLoaderClearableCache only have
+// the keys this loader cached cleared. Like ClearMany, batches whose fetch is in flight don't cache their keys.
+func (l *CommentCountLoader) ClearAll() {
+	l.mu.Lock()
+	if _, ok := l.cache.(CommentCountLoaderClearableCache); ok {
+		l.writes = append(l.writes, commentCountLoaderCacheWrite{clear: true, all: true})
+	} else {
+		for key := range l.meta {
+			l.writes = append(l.writes, commentCountLoaderCacheWrite{key: key, clear: true})
+		}
+	}
+	l.meta = nil
+	l.fetchCounts = nil
+	l.deleted = nil
+	l.errored = nil
+	l.index = nil
+	l.terms = nil
+	for b := range l.inflight {
+		if b.fetching {
+			b.staleAll = true
+		}
+	}
+	l.mu.Unlock()
+	l.flushWrites()
+}
+
+// ClearIndexed clears every value cached under term by IndexBy, returning how many were cleared
+func (l *CommentCountLoader) ClearIndexed(term string) int {
+	l.mu.Lock()
+	keys := make([]int, 0, len(l.index[term]))
+	for key := range l.index[term] {
+		keys = append(keys, key)
+	}
+	l.mu.Unlock()
+
+	l.ClearMany(keys...)
+	return len(keys)
+}
+
+// unindex removes key from the index, it must be called with the loader locked
+func (l *CommentCountLoader) unindex(key int) {
+	for _, term := range l.terms[key] {
+		delete(l.index[term], key)
+		if len(l.index[term]) == 0 {
+			delete(l.index, term)
+		}
+	}
+	delete(l.terms, key)
+}
+
+// ClearFunc clears every key this loader has cached that match returns true for, eg. everything for a tenant.
+// Keys written to a shared cache by other loaders aren't known to this one and are left alone.
+func (l *CommentCountLoader) ClearFunc(match func(key int) bool) {
+	l.mu.Lock()
+	keys := make([]int, 0, len(l.meta))
+	for key := range l.meta {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	l.mu.Unlock()
+
+	l.ClearMany(keys...)
+}
+
+func (l *CommentCountLoader) unsafeSet(key int, value int, ttl time.Duration) {
+	if l.cache == nil {
+		l.cache = NewCommentCountLoaderMapCache()
+	}
+	if l.meta == nil {
+		l.meta = map[int]*CommentCountLoaderEntryMeta{}
+	}
+
+	delete(l.errored, key)
+	if ttl == 0 {
+		ttl = l.ttl
+	}
+	l.writes = append(l.writes, commentCountLoaderCacheWrite{key: key, value: value, ttl: ttl})
+	if l.indexBy != nil {
+		l.unindex(key)
+		terms := l.indexBy(value)
+		if len(terms) > 0 {
+			if l.index == nil {
+				l.index = map[string]map[int]struct{}{}
+				l.terms = map[int][]string{}
+			}
+			for _, term := range terms {
+				if l.index[term] == nil {
+					l.index[term] = map[int]struct{}{}
+				}
+				l.index[term][key] = struct{}{}
+			}
+			l.terms[key] = terms
+		}
+	}
+	meta := &CommentCountLoaderEntryMeta{CachedAt: time.Now()}
+	if ttl > 0 {
+		meta.Expires = meta.CachedAt.Add(ttl)
+	}
+	if l.detectMutations {
+		meta.checksum = commentCountLoaderChecksum(value)
+	}
+	l.meta[key] = meta
+}
+
+// commentCountLoaderCacheWrite is a write to the cache made with the loader locked, see flushWrites
+type commentCountLoaderCacheWrite struct {
+	key   int
+	value int
+	ttl   time.Duration
+
+	// clear the key instead of setting it, or every key when all is set
+	clear bool
+	all   bool
+}
+
+// flushWrites applies the cache writes queued with the loader locked, in the order they were made. Keeping the
+// cache calls out of the lock means a cache doing network I/O, eg. redis, only blocks the caller that wrote to it.
+// It must be called with the loader unlocked.
+func (l *CommentCountLoader) flushWrites() {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	l.mu.Lock()
+	writes := l.writes
+	l.writes = nil
+	cache := l.cache
+	l.mu.Unlock()
+
+	for _, w := range writes {
+		switch {
+		case w.all:
+			cache.(CommentCountLoaderClearableCache).Clear()
+		case w.clear:
+			cache.ClearKey(w.key)
+		default:
+			if ttlCache, ok := cache.(CommentCountLoaderTTLCache); ok && w.ttl > 0 {
+				ttlCache.SetWithTTL(w.key, w.value, w.ttl)
+			} else {
+				cache.Set(w.key, w.value)
+			}
+		}
+	}
+}
+
+// evicted forgets what the loader knows about a key the cache dropped by itself
+func (l *CommentCountLoader) evicted(key int) {
+	l.mu.Lock()
+	delete(l.meta, key)
+	delete(l.fetchCounts, key)
+	delete(l.deleted, key)
+	l.unindex(key)
+	l.mu.Unlock()
+}
+
+// expire clears key once its TTL has passed when the cache can't expire it by itself, reporting whether it did
+func (l *CommentCountLoader) expire(key int) bool {
+	if _, ok := l.cache.(CommentCountLoaderTTLCache); ok {
+		return false
+	}
+
+	l.mu.Lock()
+	meta, ok := l.meta[key]
+	expired := ok && !meta.Expires.IsZero() && time.Now().After(meta.Expires)
+	if expired {
+		delete(l.meta, key)
+		l.writes = append(l.writes, commentCountLoaderCacheWrite{key: key, clear: true})
+	}
+	l.mu.Unlock()
+
+	if expired {
+		l.flushWrites()
+	}
+	return expired
+}
+
+// batchLimit returns the number of keys at which batch will be sent, it must be called with the loader locked
+func (l *CommentCountLoader) batchLimit(batch *commentCountLoaderBatch, remaining int) int {
+	if l.maxBatch == 0 || l.maxBatchOverflow == 0 {
+		return l.maxBatch
+	}
+	if len(batch.keys)+remaining <= l.maxBatch+l.maxBatchOverflow {
+		return l.maxBatch + l.maxBatchOverflow
+	}
+	return l.maxBatch
+}
+
+// keyIndex will return the location of the key in the batch, if its not found
+// it will add the key to the batch. Once the batch holds limit keys it is detached from the loader
+// and full is returned, the caller must then end it after unlocking the loader.
+func (b *commentCountLoaderBatch) keyIndex(l *CommentCountLoader, key int, limit int) (pos int, full bool) {
+	if i, ok := b.position(key); ok {
+		return i, false
+	}
+
+	pos = len(b.keys)
+	b.keys = append(b.keys, key)
+	b.claims = append(b.claims, 0)
+	// scanning is faster for small batches, large ones switch to a map so adding keys doesn't go quadratic
+	if b.index != nil {
+		b.index[key] = pos
+	} else if len(b.keys) > commentCountLoaderIndexAfter {
+		b.index = make(map[int]int, 2*len(b.keys))
+		for i, k := range b.keys {
+			b.index[k] = i
+		}
+	}
+	if l.pending == nil {
+		l.pending = map[int]int{}
+	}
+	l.pending[key]++
+	l.pendingKeys++
+	if l.targetBatch > 0 {
+		l.arrivals.record(l.wait)
+	}
+	if pos == 0 {
+		go b.startTimer(l, l.batchWait())
+	}
+
+	if limit != 0 && pos >= limit-1 {
+		if !b.closing {
+			b.closing = true
+			delete(l.batches, b.partition)
+			l.queued++
+			full = true
+		}
+	}
+
+	return pos, full
+}
+
+// has reports whether key is in the batch
+func (b *commentCountLoaderBatch) has(key int) bool {
+	_, ok := b.position(key)
+	return ok
+}
+
+// position returns the location of the key in the batch, if it is in it
+func (b *commentCountLoaderBatch) position(key int) (int, bool) {
+	if b.index != nil {
+		i, ok := b.index[key]
+		return i, ok
+	}
+	for i, existingKey := range b.keys {
+		if key == existingKey {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// commentCountLoaderIndexAfter is how many keys a batch holds before it is indexed with a map
+const commentCountLoaderIndexAfter = 32
+
+// watch gives up the batch for a waiter once ctx is done, abandoning it when that was the last waiter
+func (b *commentCountLoaderBatch) watch(l *CommentCountLoader, ctx context.Context) {
+	select {
+	case <-b.done:
+		return
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b.contexts--
+	if b.contexts == 0 && !b.detached && !b.abandoned {
+		b.abandoned = true
+		if b.cancel != nil {
+			b.cancel()
+		}
+	}
+}
+
+// skipAbandoned finishes the batch without fetching it when every waiter has given up on it, reporting whether
+// it did. The slot the batch holds, if any, is given back.
+func (b *commentCountLoaderBatch) skipAbandoned(l *CommentCountLoader, slots chan struct{}) bool {
+	l.mu.Lock()
+	if !b.abandoned {
+		l.mu.Unlock()
+		return false
+	}
+	l.queued--
+	b.error = []error{context.Canceled}
+	for _, key := range b.keys {
+		if l.pending[key]--; l.pending[key] <= 0 {
+			delete(l.pending, key)
+		}
+	}
+	l.pendingKeys -= len(b.keys)
+	l.stats.Abandoned++
+	delete(l.inflight, b)
+	l.mu.Unlock()
+
+	if slots != nil {
+		<-slots
+	}
+	close(b.done)
+	return true
+}
+
+// commentCountLoaderArrivals keeps a moving average of the time between keys
+type commentCountLoaderArrivals struct {
+	last     time.Time
+	interval time.Duration
+}
+
+// record a key arriving now, gaps longer than max count as max so a loader that was idle adapts right away
+func (a *commentCountLoaderArrivals) record(max time.Duration) {
+	now := time.Now()
+	if a.last.IsZero() {
+		a.last, a.interval = now, max
+		return
+	}
+	gap := now.Sub(a.last)
+	if gap > max {
+		gap = max
+	}
+	a.last = now
+	a.interval += (gap - a.interval) / 5
+}
+
+// batchWait is how long a new batch waits, it must be called with the loader locked
+func (l *CommentCountLoader) batchWait() time.Duration {
+	if l.targetBatch == 0 || l.arrivals.last.IsZero() {
+		return l.wait
+	}
+	wait := l.arrivals.interval * time.Duration(l.targetBatch)
+	if wait < l.minWait {
+		wait = l.minWait
+	}
+	if wait > l.wait {
+		wait = l.wait
+	}
+	return wait
+}
+
+// CurrentWait is how long a batch started now would wait, with TargetBatch it follows the rate keys arrive at
+func (l *CommentCountLoader) CurrentWait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.batchWait()
+}
+
+func (b *commentCountLoaderBatch) startTimer(l *CommentCountLoader, wait time.Duration) {
+	time.Sleep(wait)
+	l.mu.Lock()
+
+	// we must have hit a batch limit and are already finalizing this batch
+	if b.closing {
+		l.mu.Unlock()
+		return
+	}
+
+	b.closing = true
+	delete(l.batches, b.partition)
+	l.queued++
+	pool := l.pool
+	if b.parent != nil {
+		pool = nil
+	}
+	l.mu.Unlock()
+
+	if pool != nil {
+		pool.Go(func() { b.end(l) })
+	} else {
+		b.end(l)
+	}
+}
+
+func (b *commentCountLoaderBatch) end(l *CommentCountLoader) {
+	if b.skipAbandoned(l, nil) {
+		return
+	}
+
+	l.mu.Lock()
+	config := l.config()
+	callers, origin := b.callers, b.origin
+	var slots chan struct{}
+	if b.parent == nil {
+		slots = l.concurrencySlots()
+	}
+	l.mu.Unlock()
+
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if config.OnBackpressure != nil {
+				config.OnBackpressure(l.QueueDepth())
+			}
+			slots <- struct{}{}
+		}
+		// waiting for the slot may have taken long enough for every waiter to give up
+		if b.skipAbandoned(l, slots) {
+			return
+		}
+	}
+
+	b.id = commentCountLoaderNewBatchID()
+	ctx := context.WithValue(context.Background(), commentCountLoaderFetchKey{}, &commentCountLoaderFetch{loader: l, batch: b, parent: b.parent})
+	if config.PropagateContext != nil && origin != nil {
+		ctx = config.PropagateContext(origin, ctx)
+	}
+	var endTrace func(errors int)
+	if config.Tracer != nil {
+		ctx, endTrace = config.Tracer.StartBatch(ctx, callers, len(b.keys))
+	}
+
+	l.mu.Lock()
+	l.queued--
+	b.fetching = true
+	fetchContext := config.contextFetch()
+	if fetchContext != nil {
+		ctx, b.cancel = context.WithCancel(ctx)
+		defer b.cancel()
+		if b.abandoned {
+			b.cancel()
+		}
+	}
+	l.mu.Unlock()
+
+	var locks *commentCountLoaderKeyLocks
+	if config.KeyLocker != nil {
+		locks = &commentCountLoaderKeyLocks{locker: config.KeyLocker}
+		defer locks.release()
+	}
+	fetch := config.Fetch
+	fetchWith := func(ctx context.Context) func(keys []int) ([]int, []error) {
+		fetch := fetch
+		if fetchContext != nil {
+			fetch = func(keys []int) ([]int, []error) {
+				return fetchContext(ctx, keys)
+			}
+		}
+		// a hedged fetch runs on goroutines of its own, so panics are recovered right where fetch is called
+		fetch = commentCountLoaderRecovered(fetch)
+		if locks != nil {
+			fetch = l.lockedFetch(config, locks, fetch)
+		}
+		return fetch
+	}
+	if config.Hedge {
+		config.Fetch = l.hedgedFetch(ctx, fetchWith)
+	} else {
+		config.Fetch = fetchWith(ctx)
+	}
+	if config.Breaker != nil {
+		config.Fetch = commentCountLoaderBrokenFetch(config.Breaker, config.Fetch)
+	}
+	if config.MaxRetries > 0 {
+		config.Fetch = l.retriedFetch(ctx, config, config.Fetch)
+	}
+	var untrack func()
+	if config.DetectDeadlocks {
+		untrack = l.trackFetch(b)
+	}
+	start := time.Now()
+	data, errs := b.fetch(config)
+	latency := time.Since(start)
+	if untrack != nil {
+		untrack()
+	}
+	if slots != nil {
+		<-slots
+	}
+	b.transform(config, data, errs)
+	data, errs, deleted := b.markDeleted(config, data, errs)
+	errs = b.validate(config, data, errs)
+	errs = b.markMissing(config, data, errs)
+	oversized := b.measure(config, data, errs)
+
+	l.mu.Lock()
+	b.data, b.error, b.oversized = data, errs, oversized
+	if locks != nil && !l.loadAllNoCache {
+		// other processes wait for the cache to be filled, so the keys are cached before they are unlocked
+		for pos, key := range b.keys {
+			if b.errorAt(pos) == nil && !oversized[pos] && !b.staleAll && !b.stale[pos] {
+				l.unsafeSet(key, data[pos], 0)
+			}
+		}
+	}
+	if config.CacheDeleted {
+		for _, pos := range deleted {
+			l.unsafeAbsent(b.keys[pos])
+		}
+	}
+	if config.ErrorTTL > 0 {
+		l.cacheErrors(config, b)
+	}
+	for pos, claims := range b.claims {
+		if claims == 0 {
+			b.release(pos)
+		}
+	}
+	for _, key := range b.keys {
+		if l.pending[key]--; l.pending[key] <= 0 {
+			delete(l.pending, key)
+		}
+	}
+	l.pendingKeys -= len(b.keys)
+	if b.abandoned && b.cancel != nil {
+		// the fetch was cancelled once every waiter gave up, it says nothing about how long the backend takes
+		l.stats.Abandoned++
+	} else {
+		l.latencies.record(latency)
+		l.stats.Batches++
+		l.stats.Keys += len(b.keys)
+		l.window.record(0, 0, 1)
+	}
+	l.countPatterns(b)
+	failed := l.countErrors(b)
+	duplicates := l.countFetches(b)
+	delete(l.inflight, b)
+	l.mu.Unlock()
+
+	if locks != nil {
+		l.flushWrites()
+		locks.release()
+	}
+	close(b.done)
+
+	if endTrace != nil {
+		endTrace(failed)
+	}
+	if config.Metrics != nil {
+		traceID := ""
+		if tracer, ok := config.Tracer.(CommentCountLoaderTraceIDs); ok {
+			traceID = tracer.TraceID(ctx)
+		}
+		if metrics, ok := config.Metrics.(CommentCountLoaderExemplarMetrics); ok && traceID != "" {
+			metrics.BatchExemplar(len(b.keys), latency, failed, traceID)
+		} else {
+			config.Metrics.Batch(len(b.keys), latency, failed)
+		}
+	}
+
+	for _, key := range duplicates {
+		config.OnDuplicateFetch(key, l.fetchCount(key))
+	}
+}
+
+// ErrCommentCountLoaderReentrant is returned by loads from within a fetch of keys that fetch is already loading, instead of
+// waiting for the fetch to return and so for themselves. Only loads passed the ctx of FetchContext are checked.
+var ErrCommentCountLoaderReentrant = errors.New("CommentCountLoader: loaded a key from within its own fetch")
+
+// CommentCountLoaderPanicError is returned for every key of a batch whose fetch panicked. The loader stays usable, the keys
+// are fetched again by their next load.
+type CommentCountLoaderPanicError struct {
+	// Value is what fetch panicked with
+	Value interface{}
+
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (e *CommentCountLoaderPanicError) Error() string {
+	return fmt.Sprintf("CommentCountLoader: fetch panicked: %v", e.Value)
+}
+
+// commentCountLoaderRecovered wraps fetch so a panic fails the batch with a CommentCountLoaderPanicError instead of crashing
+// the process
+func commentCountLoaderRecovered(fetch func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	return func(keys []int) (data []int, errs []error) {
+		defer func() {
+			if r := recover(); r != nil {
+				data, errs = nil, []error{&CommentCountLoaderPanicError{Value: r, Stack: debug.Stack()}}
+			}
+		}()
+		return fetch(keys)
+	}
+}
+
+// ErrCommentCountLoaderDeadlock is returned by loads from within Fetch when DetectDeadlocks is set, see there
+var ErrCommentCountLoaderDeadlock = errors.New("CommentCountLoader: load from within fetch could deadlock")
+
+// trackFetch remembers that the calling goroutine fetches b, until the returned func is called
+func (l *CommentCountLoader) trackFetch(b *commentCountLoaderBatch) func() {
+	id := commentCountLoaderGoroutineID()
+	l.mu.Lock()
+	if l.fetchers == nil {
+		l.fetchers = map[uint64]*commentCountLoaderBatch{}
+	}
+	l.fetchers[id] = b
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		delete(l.fetchers, id)
+		l.mu.Unlock()
+	}
+}
+
+// commentCountLoaderGoroutineID parses the id of the calling goroutine out of its stack trace. Go doesn't expose it
+// otherwise, which is why DetectDeadlocks is for development only.
+func commentCountLoaderGoroutineID() uint64 {
+	var buf [64]byte
+	trace := buf[:runtime.Stack(buf[:], false)]
+	trace = bytes.TrimPrefix(trace, []byte("goroutine "))
+	if i := bytes.IndexByte(trace, ' '); i >= 0 {
+		trace = trace[:i]
+	}
+	id, _ := strconv.ParseUint(string(trace), 10, 64)
+	return id
+}
+
+// commentCountLoaderFetchKey is the context key of the commentCountLoaderFetch a FetchContext runs in
+type commentCountLoaderFetchKey struct{}
+
+// commentCountLoaderFetch is a batch that is being fetched, linked to the fetch that loaded it if there is one
+type commentCountLoaderFetch struct {
+	loader *CommentCountLoader
+	batch  *commentCountLoaderBatch
+	parent *commentCountLoaderFetch
+}
+
+// CommentCountLoaderContextKeys returns a PropagateContext that copies the values of keys, eg. the context keys of a tenant
+// middleware, into the ctx of the fetch
+func CommentCountLoaderContextKeys(keys ...interface{}) func(from, to context.Context) context.Context {
+	return func(from, to context.Context) context.Context {
+		for _, key := range keys {
+			if value := from.Value(key); value != nil {
+				to = context.WithValue(to, key, value)
+			}
+		}
+		return to
+	}
+}
+
+// CommentCountLoaderBatchID returns the ID of the batch whose FetchContext or Tracer was passed ctx, or "" for any other
+// ctx. Every batch gets a random ID that stays the same across its retries and hedged fetches, so backends can use
+// it as an idempotency key and logs can correlate the events of a batch.
+func CommentCountLoaderBatchID(ctx context.Context) string {
+	if f, ok := ctx.Value(commentCountLoaderFetchKey{}).(*commentCountLoaderFetch); ok {
+		return f.batch.id
+	}
+	return ""
+}
+
+// commentCountLoaderNewBatchID returns 16 random bytes in hex, like a trace ID
+func commentCountLoaderNewBatchID() string {
+	var id [16]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		// not secure, but still unique enough to correlate logs
+		rand.Read(id[:])
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// has reports whether f or a fetch it was loaded from is fetching key for l. It must be called with the loader
+// locked.
+func (f *commentCountLoaderFetch) has(l *CommentCountLoader, key int) bool {
+	for ; f != nil; f = f.parent {
+		if f.loader != l {
+			continue
+		}
+		for _, k := range f.batch.keys {
+			if k == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// concurrencySlots returns the channel limiting the batches fetching at once, nil when there is no limit. It must
+// be called with the loader locked.
+func (l *CommentCountLoader) concurrencySlots() chan struct{} {
+	if l.maxConcurrentBatches == 0 {
+		return nil
+	}
+	// batches that are already fetching give their token back to the old channel
+	if cap(l.slots) != l.maxConcurrentBatches {
+		l.slots = make(chan struct{}, l.maxConcurrentBatches)
+	}
+	return l.slots
+}
+
+// QueueDepth is how many batches are waiting to be fetched, because MaxConcurrentBatches batches are already
+// fetching or the Pool has no free worker. A growing queue means fetches can't keep up with the loads.
+func (l *CommentCountLoader) QueueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// errorAt returns the error for the key at pos
+func (b *commentCountLoaderBatch) errorAt(pos int) error {
+	// its convenient to be able to return a single error for everything
+	if len(b.error) == 1 {
+		return b.error[0]
+	} else if pos < len(b.error) {
+		return b.error[pos]
+	}
+	return nil
+}
+
+func (b *commentCountLoaderBatch) fetch(config CommentCountLoaderConfig) ([]int, []error) {
+	if config.SortKeys == nil {
+		return b.checkedFetch(config, b.keys)
+	}
+
+	sorted := make([]int, len(b.keys))
+	copy(sorted, b.keys)
+	config.SortKeys(sorted)
+
+	data, errs := b.checkedFetch(config, sorted)
+	return b.unsort(sorted, data, errs)
+}
+
+// checkedFetch calls fetch and replaces results that don't line up with the keys with an error
+func (b *commentCountLoaderBatch) checkedFetch(config CommentCountLoaderConfig, keys []int) ([]int, []error) {
+	data, errs := config.Fetch(keys)
+	if config.Strict {
+		validErrs := len(errs) == 0 || len(errs) == 1 || len(errs) == len(keys)
+		// a single error fails the whole batch, so there doesn't need to be any data alongside it
+		validData := len(data) == len(keys) || (len(errs) == 1 && len(data) == 0)
+		if validErrs && validData {
+			return data, errs
+		}
+	} else if commentCountLoaderComplete(len(keys), data, errs, config.MissingPolicy != CommentCountLoaderMissingAsFetched) {
+		return data, errs
+	}
+
+	err := &CommentCountLoaderResultLengthError{Keys: len(keys), Values: len(data), Errors: len(errs)}
+	if config.OnResultLengthError != nil {
+		config.OnResultLengthError(keys, err)
+	}
+	return nil, []error{err}
+}
+
+// commentCountLoaderComplete reports whether every key got a value or an error, or only whether the results line up
+// with the keys when missing is set and the MissingPolicy says what keys without one return. The loader can't
+// tell what a missing result was meant to be otherwise, caching a zero value for it would hide the bug in fetch.
+func commentCountLoaderComplete(keys int, data []int, errs []error, missing bool) bool {
+	if len(errs) == 1 && errs[0] != nil {
+		return true
+	}
+	if len(data) > keys || (len(errs) > 1 && len(errs) != keys) {
+		return false
+	}
+	if missing {
+		return true
+	}
+	for pos := len(data); pos < keys; pos++ {
+		if pos >= len(errs) || errs[pos] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// markDeleted replaces soft deleted values with ErrCommentCountLoaderNotFound, returning the positions it replaced
+func (b *commentCountLoaderBatch) markDeleted(config CommentCountLoaderConfig, data []int, errs []error) ([]int, []error, []int) {
+	// a single error fails every key anyway
+	if config.IsDeleted == nil || (len(errs) == 1 && errs[0] != nil) {
+		return data, errs, nil
+	}
+
+	var deleted []int
+	for pos := range data {
+		if pos < len(errs) && errs[pos] != nil {
+			continue
+		}
+		if !config.IsDeleted(data[pos]) {
+			continue
+		}
+
+		if len(errs) < len(data) {
+			expanded := make([]error, len(data))
+			copy(expanded, errs)
+			errs = expanded
+		}
+		var zero int
+		data[pos] = zero
+		errs[pos] = ErrCommentCountLoaderNotFound
+		deleted = append(deleted, pos)
+	}
+	return data, errs, deleted
+}
+
+// transform applies Transform to the values that were fetched without an error
+func (b *commentCountLoaderBatch) transform(config CommentCountLoaderConfig, data []int, errs []error) {
+	// a single error fails every key anyway
+	if config.Transform == nil || (len(errs) == 1 && errs[0] != nil) {
+		return
+	}
+
+	for pos := range data {
+		if pos >= len(b.keys) || (pos < len(errs) && errs[pos] != nil) {
+			continue
+		}
+		data[pos] = config.Transform(b.keys[pos], data[pos])
+	}
+}
+
+// validate replaces the errors of values that fail ValidateValue
+func (b *commentCountLoaderBatch) validate(config CommentCountLoaderConfig, data []int, errs []error) []error {
+	// a single error fails every key anyway
+	if config.ValidateValue == nil || (len(errs) == 1 && errs[0] != nil) {
+		return errs
+	}
+
+	for pos := range data {
+		if pos >= len(b.keys) || (pos < len(errs) && errs[pos] != nil) {
+			continue
+		}
+		err := config.ValidateValue(b.keys[pos], data[pos])
+		if err == nil {
+			continue
+		}
+
+		if len(errs) < len(b.keys) {
+			expanded := make([]error, len(b.keys))
+			copy(expanded, errs)
+			errs = expanded
+		}
+		var zero int
+		data[pos] = zero
+		errs[pos] = err
+	}
+	return errs
+}
+
+// measure returns the positions of values too large to cache under MaxValueBytes
+func (b *commentCountLoaderBatch) measure(config CommentCountLoaderConfig, data []int, errs []error) map[int]bool {
+	if config.MaxValueBytes == 0 || config.ValueSize == nil || (len(errs) == 1 && errs[0] != nil) {
+		return nil
+	}
+
+	var oversized map[int]bool
+	for pos := range data {
+		if pos < len(errs) && errs[pos] != nil {
+			continue
+		}
+		if config.ValueSize(data[pos]) <= config.MaxValueBytes {
+			continue
+		}
+		if oversized == nil {
+			oversized = map[int]bool{}
+		}
+		oversized[pos] = true
+	}
+	return oversized
+}
+
+// markMissing applies the MissingPolicy to the keys Fetch didn't find
+func (b *commentCountLoaderBatch) markMissing(config CommentCountLoaderConfig, data []int, errs []error) []error {
+	switch config.MissingPolicy {
+	case CommentCountLoaderMissingZero:
+		for pos, err := range errs {
+			if errors.Is(err, ErrCommentCountLoaderNotFound) {
+				errs[pos] = nil
+			}
+		}
+
+	case CommentCountLoaderMissingError:
+		// a single error fails every key anyway
+		if len(errs) == 1 && errs[0] != nil {
+			return errs
+		}
+		for pos := range b.keys {
+			if pos < len(errs) && errs[pos] != nil {
+				continue
+			}
+			if pos < len(data) {
+				continue
+			}
+
+			if len(errs) < len(b.keys) {
+				expanded := make([]error, len(b.keys))
+				copy(expanded, errs)
+				errs = expanded
+			}
+			errs[pos] = ErrCommentCountLoaderNotFound
+		}
+	}
+	return errs
+}
+
+// unclaim is called once a thunk has read its result or was released, when no thunks are left waiting on pos
+// the result is dropped so it can be garbage collected even if other thunks keep the batch alive.
+func (b *commentCountLoaderBatch) unclaim(l *CommentCountLoader, pos int) {
+	l.mu.Lock()
+	b.claims[pos]--
+	if b.claims[pos] == 0 {
+		b.release(pos)
+	}
+	l.mu.Unlock()
+}
+
+func (b *commentCountLoaderBatch) release(pos int) {
+	if pos < len(b.data) {
+		var zero int
+		b.data[pos] = zero
+	}
+}
+
+// unsort maps results fetched for the sorted keys back to the positions the thunks are waiting on
+func (b *commentCountLoaderBatch) unsort(sorted []int, data []int, errs []error) ([]int, []error) {
+	index := make(map[int]int, len(sorted))
+	for i, key := range sorted {
+		index[key] = i
+	}
+
+	unsortedData := make([]int, len(b.keys))
+	var unsortedErrs []error
+	if len(errs) > 1 {
+		unsortedErrs = make([]error, len(b.keys))
+	} else {
+		unsortedErrs = errs
+	}
+
+	for i, key := range b.keys {
+		pos, ok := index[key]
+		if !ok {
+			continue
+		}
+		if pos < len(data) {
+			unsortedData[i] = data[pos]
+		}
+		if len(errs) > 1 && pos < len(errs) {
+			unsortedErrs[i] = errs[pos]
+		}
+	}
+
+	return unsortedData, unsortedErrs
+}
+
+// CommentCountLoaderTracer traces the batches of a loader, see CommentCountLoaderOTel
+type CommentCountLoaderTracer interface {
+	// StartBatch is called before a batch is fetched with the contexts of the callers waiting on it. The ctx it
+	// returns is passed to FetchContext, and end is called with the number of failed keys once the fetch returned.
+	StartBatch(ctx context.Context, callers []context.Context, keys int) (batchCtx context.Context, end func(errors int))
+}
+
+// CommentCountLoaderTraceIDs is implemented by Tracers that can tell the ID of the trace the batchCtx StartBatch returned
+// belongs to, eg. CommentCountLoaderOTel. It is passed to CommentCountLoaderExemplarMetrics.
+type CommentCountLoaderTraceIDs interface {
+	// TraceID returns the ID of the trace of batchCtx, or "" if there is none
+	TraceID(batchCtx context.Context) string
+}
+
+// CommentCountLoaderExemplarMetrics is implemented by Metrics that can link a batch to its trace, eg. CommentCountLoaderPrometheus
+// with an exemplar. BatchExemplar is called instead of Batch when the Tracer implements CommentCountLoaderTraceIDs.
+type CommentCountLoaderExemplarMetrics interface {
+	BatchExemplar(size int, latency time.Duration, errors int, traceID string)
+}
+
+// CommentCountLoaderMetrics is told what the loader does, eg. to export it to a metrics system. It is called outside of
+// the loader's lock.
+type CommentCountLoaderMetrics interface {
+	// Batch is called after every fetch with the number of keys it was sent, how long it took and how many keys
+	// failed
+	Batch(size int, latency time.Duration, errors int)
+
+	// Hit and Miss are called for every load that is served from the cache or not
+	Hit()
+	Miss()
+}
+
+// CommentCountLoaderAggregator is a CommentCountLoaderMetrics that adds everything up in memory, for services that don't use a
+// metrics library. Unlike Stats it can be shared by every loader, eg. the ones created for each request, and read
+// with Snapshot.
+type CommentCountLoaderAggregator struct {
+	mu       sync.Mutex
+	snapshot CommentCountLoaderMetricsSnapshot
+}
+
+// CommentCountLoaderMetricsSnapshot is what a CommentCountLoaderAggregator added up
+type CommentCountLoaderMetricsSnapshot struct {
+	// Batches is the number of fetches, Keys the number of keys they were sent and Errors the number that failed
+	Batches int64
+	Keys    int64
+	Errors  int64
+
+	Hits   int64
+	Misses int64
+
+	// BatchSize has the number of keys of each batch, and FetchLatency how long each fetch took in nanoseconds
+	BatchSize    CommentCountLoaderHistogram
+	FetchLatency CommentCountLoaderHistogram
+}
+
+// Batch implements CommentCountLoaderMetrics
+func (a *CommentCountLoaderAggregator) Batch(size int, latency time.Duration, errors int) {
+	a.mu.Lock()
+	a.snapshot.Batches++
+	a.snapshot.Keys += int64(size)
+	a.snapshot.Errors += int64(errors)
+	a.snapshot.BatchSize.Record(int64(size))
+	a.snapshot.FetchLatency.Record(int64(latency))
+	a.mu.Unlock()
+}
+
+// Hit implements CommentCountLoaderMetrics
+func (a *CommentCountLoaderAggregator) Hit() {
+	a.mu.Lock()
+	a.snapshot.Hits++
+	a.mu.Unlock()
+}
+
+// Miss implements CommentCountLoaderMetrics
+func (a *CommentCountLoaderAggregator) Miss() {
+	a.mu.Lock()
+	a.snapshot.Misses++
+	a.mu.Unlock()
+}
+
+// Snapshot returns a copy of everything added up so far
+func (a *CommentCountLoaderAggregator) Snapshot() CommentCountLoaderMetricsSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshot
+}
+
+// commentCountLoaderSubBuckets is how many buckets each power of two is split into, values are recorded to within
+// 1/commentCountLoaderSubBuckets of their size
+const commentCountLoaderSubBuckets = 8
+
+// CommentCountLoaderHistogram counts non negative values into log-linear buckets, like an HDR histogram with a precision
+// of 12.5%. Its zero value is empty and ready to use, it isn't safe for concurrent use.
+type CommentCountLoaderHistogram struct {
+	Count int64
+	Sum   int64
+	Min   int64
+	Max   int64
+
+	buckets [64 * commentCountLoaderSubBuckets]int64
+}
+
+// Record adds v, negative values are recorded as 0
+func (h *CommentCountLoaderHistogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if h.Count == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Count++
+	h.Sum += v
+	h.buckets[commentCountLoaderBucketOf(v)]++
+}
+
+// Mean is the average of the recorded values
+func (h *CommentCountLoaderHistogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Quantile estimates the value that the fraction q, from 0 to 1, of the recorded values is at or below, eg. 0.99
+// for the p99
+func (h *CommentCountLoaderHistogram) Quantile(q float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.Count)))
+	// the extremes are known exactly
+	if rank <= 1 {
+		return h.Min
+	} else if rank >= h.Count {
+		return h.Max
+	}
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if n > 0 && seen >= rank {
+			lower, upper := commentCountLoaderBucketBounds(i)
+			v := lower + (upper-lower)/2
+			if v < h.Min {
+				v = h.Min
+			}
+			if v > h.Max {
+				v = h.Max
+			}
+			return v
+		}
+	}
+	return h.Max
+}
+
+// commentCountLoaderBucketOf returns the bucket v is counted in: values below commentCountLoaderSubBuckets get one
+// each, larger ones share a bucket with the values that agree in their top 4 bits
+func commentCountLoaderBucketOf(v int64) int {
+	if v < commentCountLoaderSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - 1
+	sub := int(v>>uint(exp-3)) - commentCountLoaderSubBuckets
+	return (exp-2)*commentCountLoaderSubBuckets + sub
+}
+
+// commentCountLoaderBucketBounds is the range of values counted in bucket i, upper is exclusive
+func commentCountLoaderBucketBounds(i int) (lower int64, upper int64) {
+	if i < commentCountLoaderSubBuckets {
+		return int64(i), int64(i) + 1
+	}
+	exp := i/commentCountLoaderSubBuckets + 2
+	sub := int64(i%commentCountLoaderSubBuckets + commentCountLoaderSubBuckets)
+	return sub << uint(exp-3), (sub + 1) << uint(exp-3)
+}
+
+// CommentCountLoaderStats is a snapshot of what a loader has done since it was created
+type CommentCountLoaderStats struct {
+	// Batches is the number of fetches and Keys the number of keys they were sent
+	Batches int
+	Keys    int
+
+	Hits   int
+	Misses int
+
+	// Errors is the number of keys that failed, see ErrorCounts for a breakdown
+	Errors int
+
+	// Collapsed is the number of LoadAlls that shared the result of an identical one, see CollapseLoadAll
+	Collapsed int
+
+	// Abandoned is the number of batches that weren't fetched, or had the ctx of FetchContext cancelled, because
+	// every caller waiting on them gave up first. They don't count as Batches.
+	Abandoned int
+
+	// Retries is the number of times failed keys were fetched again, see MaxRetries
+	Retries int
+
+	// Overloaded is the number of loads that failed with a CommentCountLoaderOverloadError, see MaxPendingKeys
+	Overloaded int
+
+	AvgBatchSize float64
+
+	// FetchP50 and FetchP99 are taken over the most recent fetches
+	FetchP50 time.Duration
+	FetchP99 time.Duration
+}
+
+// Stats returns a snapshot of the loader's counters, eg. to expose as metrics
+func (l *CommentCountLoader) Stats() CommentCountLoaderStats {
+	l.mu.Lock()
+	stats := l.stats
+	for _, count := range l.errorCounts {
+		stats.Errors += count
+	}
+	l.mu.Unlock()
+
+	if stats.Batches > 0 {
+		stats.AvgBatchSize = float64(stats.Keys) / float64(stats.Batches)
+	}
+	stats.FetchP50, _ = l.latencies.percentile(50, 1)
+	stats.FetchP99, _ = l.latencies.percentile(99, 1)
+	return stats
+}
+
+// CommentCountLoaderWindowStats holds the counters observed over a rolling window
+type CommentCountLoaderWindowStats struct {
+	Hits    int
+	Misses  int
+	Batches int
+}
+
+// WindowStats returns the hits, misses and batches seen over the last window (eg. 1m or 5m),
+// rounded to the second. The window is capped at the StatsWindow the loader was configured with.
+func (l *CommentCountLoader) WindowStats(window time.Duration) CommentCountLoaderWindowStats {
+	if l.window == nil {
+		return CommentCountLoaderWindowStats{}
+	}
+	return l.window.sum(window)
+}
+
+// commentCountLoaderStatsWindow is a ring of one second buckets
+type commentCountLoaderStatsWindow struct {
+	mu      sync.Mutex
+	buckets []commentCountLoaderStatsBucket
+}
+
+type commentCountLoaderStatsBucket struct {
+	second int64
+	stats  CommentCountLoaderWindowStats
+}
+
+func newcommentCountLoaderStatsWindow(size time.Duration) *commentCountLoaderStatsWindow {
+	seconds := int(size / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &commentCountLoaderStatsWindow{buckets: make([]commentCountLoaderStatsBucket, seconds)}
+}
+
+func (w *commentCountLoaderStatsWindow) record(hits, misses, batches int) {
+	if w == nil {
+		return
+	}
+	now := time.Now().Unix()
+
+	w.mu.Lock()
+	b := &w.buckets[now%int64(len(w.buckets))]
+	if b.second != now {
+		*b = commentCountLoaderStatsBucket{second: now}
+	}
+	b.stats.Hits += hits
+	b.stats.Misses += misses
+	b.stats.Batches += batches
+	w.mu.Unlock()
+}
+
+func (w *commentCountLoaderStatsWindow) sum(window time.Duration) CommentCountLoaderWindowStats {
+	seconds := int64(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if seconds > int64(len(w.buckets)) {
+		seconds = int64(len(w.buckets))
+	}
+	now := time.Now().Unix()
+
+	var total CommentCountLoaderWindowStats
+	w.mu.Lock()
+	for _, b := range w.buckets {
+		if b.second > now-seconds && b.second <= now {
+			total.Hits += b.stats.Hits
+			total.Misses += b.stats.Misses
+			total.Batches += b.stats.Batches
+		}
+	}
+	w.mu.Unlock()
+	return total
+}
+
+// CommentCountLoaderErrorClass is the category a failed key is counted in by ErrorCounts
+type CommentCountLoaderErrorClass string
+
+const (
+	CommentCountLoaderErrorNotFound CommentCountLoaderErrorClass = "not_found"
+	CommentCountLoaderErrorTimeout  CommentCountLoaderErrorClass = "timeout"
+	CommentCountLoaderErrorBackend  CommentCountLoaderErrorClass = "backend"
+	CommentCountLoaderErrorOther    CommentCountLoaderErrorClass = "other"
+)
+
+// ErrorCounts returns how many fetched keys have failed so far, by error class
+func (l *CommentCountLoader) ErrorCounts() map[CommentCountLoaderErrorClass]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[CommentCountLoaderErrorClass]int, len(l.errorCounts))
+	for class, count := range l.errorCounts {
+		counts[class] = count
+	}
+	return counts
+}
+
+// CommentCountLoaderPatternStats holds the counters of the keys of one KeyPattern
+type CommentCountLoaderPatternStats struct {
+	Loads  int
+	Hits   int
+	Misses int
+
+	// Coalesced is the number of loads that joined a key already waiting in a batch, rather than adding it
+	Coalesced int
+
+	// Batches is the number of fetches with keys of the pattern and Keys the number of them they were sent
+	Batches int
+	Keys    int
+}
+
+// PatternStats returns a snapshot of the counters of every KeyPattern seen so far
+func (l *CommentCountLoader) PatternStats() map[string]CommentCountLoaderPatternStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make(map[string]CommentCountLoaderPatternStats, len(l.patternStats))
+	for pattern, counters := range l.patternStats {
+		stats[pattern] = *counters
+	}
+	return stats
+}
+
+// patternOf returns the counters of the pattern of key, or nil when there is no KeyPattern. It must be called with
+// the loader locked.
+func (l *CommentCountLoader) patternOf(key int) *CommentCountLoaderPatternStats {
+	if l.keyPattern == nil {
+		return nil
+	}
+	pattern := l.keyPattern(key)
+	stats := l.patternStats[pattern]
+	if stats == nil {
+		if l.patternStats == nil {
+			l.patternStats = map[string]*CommentCountLoaderPatternStats{}
+		}
+		stats = &CommentCountLoaderPatternStats{}
+		l.patternStats[pattern] = stats
+	}
+	return stats
+}
+
+// countPatterns must be called with the loader locked
+func (l *CommentCountLoader) countPatterns(b *commentCountLoaderBatch) {
+	if l.keyPattern == nil {
+		return
+	}
+
+	batched := map[*CommentCountLoaderPatternStats]bool{}
+	for _, key := range b.keys {
+		stats := l.patternOf(key)
+		stats.Keys++
+		if !batched[stats] {
+			batched[stats] = true
+			stats.Batches++
+		}
+	}
+}
+
+// CommentCountLoaderKeyPattern names the keys Regexp matches, for CommentCountLoaderKeyPatterns
+type CommentCountLoaderKeyPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// CommentCountLoaderKeyPatterns returns a KeyPattern that groups keys under the name of the first pattern matching them,
+// keys that match none are grouped as "other"
+func CommentCountLoaderKeyPatterns(patterns ...CommentCountLoaderKeyPattern) func(key int) string {
+	return func(key int) string {
+		text := commentCountLoaderKeyString(key)
+		for _, pattern := range patterns {
+			if pattern.Regexp.MatchString(text) {
+				return pattern.Name
+			}
+		}
+		return "other"
+	}
+}
+
+// countErrors must be called with the loader locked, it returns how many keys of the batch failed
+func (l *CommentCountLoader) countErrors(b *commentCountLoaderBatch) int {
+	if len(b.error) == 0 {
+		return 0
+	}
+
+	var failed int
+	for pos, key := range b.keys {
+		err := b.errorAt(pos)
+		if err == nil {
+			continue
+		}
+		failed++
+
+		class := CommentCountLoaderErrorOther
+		if l.classifyError != nil {
+			class = l.classifyError(key, err)
+		}
+
+		if l.errorCounts == nil {
+			l.errorCounts = map[CommentCountLoaderErrorClass]int{}
+		}
+		l.errorCounts[class]++
+	}
+	return failed
+}
+
+// countFetches must be called with the loader locked, it returns the keys that have been fetched before
+func (l *CommentCountLoader) countFetches(b *commentCountLoaderBatch) []int {
+	if l.onDuplicateFetch == nil {
+		return nil
+	}
+	if l.fetchCounts == nil {
+		l.fetchCounts = map[int]int{}
+	}
+
+	var duplicates []int
+	for pos, key := range b.keys {
+		if b.errorAt(pos) != nil {
+			continue
+		}
+		l.fetchCounts[key]++
+		if l.fetchCounts[key] > 1 {
+			duplicates = append(duplicates, key)
+		}
+	}
+	return duplicates
+}
+
+func (l *CommentCountLoader) fetchCount(key int) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fetchCounts[key]
+}
+
+// CommentCountLoaderKeyLocker hands out a lock per key that is shared by every process using the same cache, eg. with
+// SET NX in redis
+type CommentCountLoaderKeyLocker interface {
+	// TryLock takes the lock for key without waiting, ok is false when another process holds it
+	TryLock(key int) (unlock func(), ok bool)
+}
+
+// CommentCountLoaderDefaultKeyLockWait is how long a load waits for another process to fill the cache when KeyLockWait is 0
+const CommentCountLoaderDefaultKeyLockWait = 100 * time.Millisecond
+
+// commentCountLoaderKeyLocks holds the locks lockedFetch took for a batch, until end has cached its values
+type commentCountLoaderKeyLocks struct {
+	locker CommentCountLoaderKeyLocker
+	mu     sync.Mutex
+	held   map[int]func()
+}
+
+// lock takes the lock for key, a key that is already held, eg. by an earlier attempt of a retried fetch, is
+// locked again right away
+func (k *commentCountLoaderKeyLocks) lock(key int) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.held[key]; ok {
+		return true
+	}
+	unlock, ok := k.locker.TryLock(key)
+	if !ok {
+		return false
+	}
+	if k.held == nil {
+		k.held = map[int]func(){}
+	}
+	k.held[key] = unlock
+	return true
+}
+
+// release unlocks every key that is held, it is safe to call more than once
+func (k *commentCountLoaderKeyLocks) release() {
+	k.mu.Lock()
+	held := k.held
+	k.held = nil
+	k.mu.Unlock()
+
+	for _, unlock := range held {
+		unlock()
+	}
+}
+
+// lockedFetch wraps fetch so it only fetches the keys this process could lock in locks, and waits for the cache
+// to be filled with the rest
+func (l *CommentCountLoader) lockedFetch(config CommentCountLoaderConfig, locks *commentCountLoaderKeyLocks, fetch func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	wait := config.KeyLockWait
+	if wait == 0 {
+		wait = CommentCountLoaderDefaultKeyLockWait
+	}
+
+	return func(keys []int) ([]int, []error) {
+		data := make([]int, len(keys))
+		errs := make([]error, len(keys))
+		fetchInto := func(positions []int) {
+			batch := make([]int, len(positions))
+			for i, pos := range positions {
+				batch[i] = keys[pos]
+			}
+			values, valueErrs := fetch(batch)
+			for i, pos := range positions {
+				if i < len(values) {
+					data[pos] = values[i]
+				}
+				if len(valueErrs) == 1 {
+					errs[pos] = valueErrs[0]
+				} else if i < len(valueErrs) {
+					errs[pos] = valueErrs[i]
+				}
+			}
+		}
+
+		var mine, theirs []int
+		for pos, key := range keys {
+			if locks.lock(key) {
+				mine = append(mine, pos)
+			} else {
+				theirs = append(theirs, pos)
+			}
+		}
+		if len(mine) > 0 {
+			fetchInto(mine)
+		}
+
+		deadline := time.Now().Add(wait)
+		for len(theirs) > 0 && time.Now().Before(deadline) {
+			time.Sleep(wait / 10)
+			waiting := theirs[:0]
+			for _, pos := range theirs {
+				if value, ok := config.Cache.Get(keys[pos]); ok {
+					data[pos] = value
+				} else {
+					waiting = append(waiting, pos)
+				}
+			}
+			theirs = waiting
+		}
+		// the other process didn't come through in time
+		if len(theirs) > 0 {
+			fetchInto(theirs)
+		}
+		return data, errs
+	}
+}
+
+// hedgedFetch returns a fetch that gets a second call when it is slower than the p99 of recent fetches, the first
+// to return wins. Both calls fetch with a child of ctx from fetchWith, it is cancelled once the winner returns.
+func (l *CommentCountLoader) hedgedFetch(ctx context.Context, fetchWith func(ctx context.Context) func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	type result struct {
+		data []int
+		errs []error
+	}
+
+	return func(keys []int) ([]int, []error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		fetch := fetchWith(ctx)
+
+		results := make(chan result, 2)
+		call := func() {
+			data, errs := fetch(keys)
+			results <- result{data, errs}
+		}
+
+		delay, ok := l.latencies.percentile(99, 20)
+		if !ok {
+			call()
+			r := <-results
+			return r.data, r.errs
+		}
+
+		go call()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case r := <-results:
+			return r.data, r.errs
+		case <-timer.C:
+		}
+
+		go call()
+		r := <-results
+		return r.data, r.errs
+	}
+}
+
+// CommentCountLoaderDefaultBackoff waits 10ms before the first retry, doubling with every attempt up to a second
+func CommentCountLoaderDefaultBackoff(attempt int) time.Duration {
+	return CommentCountLoaderExponentialBackoff(10*time.Millisecond, time.Second, 0)(attempt)
+}
+
+// CommentCountLoaderExponentialBackoff returns a Backoff that waits initial before the first retry, doubling with every
+// attempt up to max. Every wait is shortened by a random fraction of up to jitter, from 0 to 1, so clients that
+// failed together don't retry in lockstep.
+func CommentCountLoaderExponentialBackoff(initial, max time.Duration, jitter float64) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		wait := initial
+		for i := 1; i < attempt && wait < max; i++ {
+			wait *= 2
+		}
+		if wait > max {
+			wait = max
+		}
+		if jitter > 0 {
+			wait -= time.Duration(rand.Float64() * jitter * float64(wait))
+		}
+		return wait
+	}
+}
+
+// CommentCountLoaderRetry calls op until it succeeds, fails with an error retryable doesn't accept or has been retried
+// maxRetries times, waiting backoff(attempt) before every retry, and returns its last error. It is the retry loop
+// MaxRetries uses, for Fetch implementations that retry calls of their own. A nil backoff is
+// CommentCountLoaderDefaultBackoff and a nil retryable retries the same errors as a nil Retryable. Once ctx is done it
+// stops waiting and returns ctx.Err().
+func CommentCountLoaderRetry(ctx context.Context, maxRetries int, backoff func(attempt int) time.Duration, retryable func(err error) bool, op func() error) error {
+	if backoff == nil {
+		backoff = CommentCountLoaderDefaultBackoff
+	}
+
+	err := op()
+	for attempt := 1; attempt <= maxRetries && commentCountLoaderRetryable(retryable, err); attempt++ {
+		if !commentCountLoaderSleep(ctx, backoff(attempt)) {
+			return ctx.Err()
+		}
+		err = op()
+	}
+	return err
+}
+
+// commentCountLoaderRetryable reports whether err is worth retrying, retryable nil = every error but the ones
+// wrapping ErrCommentCountLoaderNotFound or ErrCommentCountLoaderCircuitOpen
+func commentCountLoaderRetryable(retryable func(err error) bool, err error) bool {
+	if err == nil {
+		return false
+	}
+	if retryable != nil {
+		return retryable(err)
+	}
+	return !errors.Is(err, ErrCommentCountLoaderNotFound) && !errors.Is(err, ErrCommentCountLoaderCircuitOpen)
+}
+
+// commentCountLoaderSleep waits for d, it returns false when ctx was done first
+func commentCountLoaderSleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retriedFetch fetches the keys that failed with a retryable error again, up to config.MaxRetries times. It gives up
+// early once ctx is done, ie. every caller of a FetchContext batch gave up.
+func (l *CommentCountLoader) retriedFetch(ctx context.Context, config CommentCountLoaderConfig, fetch func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	backoff := config.Backoff
+	if backoff == nil {
+		backoff = CommentCountLoaderDefaultBackoff
+	}
+
+	return func(keys []int) ([]int, []error) {
+		data, errs := fetch(keys)
+		for attempt := 1; attempt <= config.MaxRetries; attempt++ {
+			retry := commentCountLoaderRetries(config, keys, data, errs)
+			if len(retry) == 0 {
+				break
+			}
+
+			if !commentCountLoaderSleep(ctx, backoff(attempt)) {
+				return data, errs
+			}
+
+			l.mu.Lock()
+			l.stats.Retries++
+			l.mu.Unlock()
+
+			if len(retry) == len(keys) {
+				data, errs = fetch(keys)
+				continue
+			}
+
+			retryKeys := make([]int, len(retry))
+			for i, pos := range retry {
+				retryKeys[i] = keys[pos]
+			}
+			retried, retriedErrs := fetch(retryKeys)
+			// results that don't line up with the keys can't be merged, the keys keep their earlier errors
+			if len(retried) > len(retryKeys) || (len(retriedErrs) > 1 && len(retriedErrs) != len(retryKeys)) {
+				continue
+			}
+
+			if len(data) < len(keys) {
+				data = append(data, make([]int, len(keys)-len(data))...)
+			}
+			for i, pos := range retry {
+				var err error
+				if len(retriedErrs) == 1 {
+					err = retriedErrs[0]
+				} else if i < len(retriedErrs) {
+					err = retriedErrs[i]
+				}
+				if i < len(retried) {
+					data[pos] = retried[i]
+				}
+				errs[pos] = err
+			}
+		}
+		return data, errs
+	}
+}
+
+// commentCountLoaderRetries returns the positions of the keys that failed with a retryable error. A single error
+// fails every key, and results that don't line up with the keys are left for checkedFetch to reject.
+func commentCountLoaderRetries(config CommentCountLoaderConfig, keys []int, data []int, errs []error) []int {
+	retryable := func(err error) bool {
+		return commentCountLoaderRetryable(config.Retryable, err)
+	}
+
+	if len(errs) == 1 && len(keys) != 1 {
+		if !retryable(errs[0]) {
+			return nil
+		}
+		retry := make([]int, len(keys))
+		for pos := range retry {
+			retry[pos] = pos
+		}
+		return retry
+	}
+	if len(errs) != len(keys) || len(data) > len(keys) {
+		return nil
+	}
+
+	var retry []int
+	for pos, err := range errs {
+		if retryable(err) {
+			retry = append(retry, pos)
+		}
+	}
+	return retry
+}
+
+// commentCountLoaderLatencies keeps the durations of the most recent fetches
+type commentCountLoaderLatencies struct {
+	mu      sync.Mutex
+	samples [128]time.Duration
+	n       int
+}
+
+func (la *commentCountLoaderLatencies) record(d time.Duration) {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	la.samples[la.n%len(la.samples)] = d
+	la.n++
+}
+
+// percentile returns the pth percentile of the recent fetches, ok is false until there are at least minSamples
+func (la *commentCountLoaderLatencies) percentile(p int, minSamples int) (d time.Duration, ok bool) {
+	la.mu.Lock()
+	n := la.n
+	if n > len(la.samples) {
+		n = len(la.samples)
+	}
+	if n == 0 || n < minSamples {
+		la.mu.Unlock()
+		return 0, false
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, la.samples[:n])
+	la.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[n*p/100], true
+}
+
+// CommentCountLoaderResultLengthError is returned for every key of a batch when Fetch returns a number of values or errors
+// that doesn't line up with the keys it was given, see Strict
+type CommentCountLoaderResultLengthError struct {
+	Keys   int
+	Values int
+	Errors int
+}
+
+func (e *CommentCountLoaderResultLengthError) Error() string {
+	return fmt.Sprintf("CommentCountLoader: fetch returned %d values and %d errors for %d keys", e.Values, e.Errors, e.Keys)
+}
+
+// CommentCountLoaderOverloadError is returned for loads that would take the loader over MaxPendingKeys, errors.Is matches
+// it with ErrCommentCountLoaderOverloaded
+type CommentCountLoaderOverloadError struct {
+	Pending        int
+	MaxPendingKeys int
+}
+
+func (e *CommentCountLoaderOverloadError) Error() string {
+	return fmt.Sprintf("CommentCountLoader: overloaded, %d keys are pending (MaxPendingKeys is %d)", e.Pending, e.MaxPendingKeys)
+}
+
+func (e *CommentCountLoaderOverloadError) Is(target error) bool {
+	return target == ErrCommentCountLoaderOverloaded
+}
+
+// ErrCommentCountLoaderOverloaded matches every CommentCountLoaderOverloadError, for callers that only need to know the load was shed
+var ErrCommentCountLoaderOverloaded = errors.New("CommentCountLoader: overloaded")
+
+// CommentCountLoaderKeyCount is an approximate number of times a key was loaded
+type CommentCountLoaderKeyCount struct {
+	Key   int
+	Count uint64
+}
+
+// HotKeys returns up to n of the most loaded keys, hottest first. Counts come from a count-min sketch so they
+// can overestimate, but never underestimate, how often a key was loaded. This is intended to be exposed on
+// debug or admin endpoints to find entities worth dedicated caching.
+func (l *CommentCountLoader) HotKeys(n int) []CommentCountLoaderKeyCount {
+	if l.hotKeys == nil {
+		return nil
+	}
+	return l.hotKeys.top(n)
+}
+
+const (
+	commentCountLoaderSketchDepth = 4
+	commentCountLoaderSketchWidth = 2048
+)
+
+type commentCountLoaderHotKeys struct {
+	mu     sync.Mutex
+	size   int
+	sketch [commentCountLoaderSketchDepth][commentCountLoaderSketchWidth]uint64
+	counts map[int]uint64
+}
+
+func newcommentCountLoaderHotKeys(size int) *commentCountLoaderHotKeys {
+	return &commentCountLoaderHotKeys{
+		size:   size,
+		counts: make(map[int]uint64, size),
+	}
+}
+
+func (h *commentCountLoaderHotKeys) record(key int) {
+	if h == nil {
+		return
+	}
+	sum := commentCountLoaderMix(uint64(key))
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	estimate := ^uint64(0)
+	for i := range h.sketch {
+		cell := &h.sketch[i][(h1+uint32(i)*h2)%commentCountLoaderSketchWidth]
+		*cell++
+		if *cell < estimate {
+			estimate = *cell
+		}
+	}
+
+	if _, ok := h.counts[key]; ok || len(h.counts) < h.size {
+		h.counts[key] = estimate
+		return
+	}
+
+	var coldest int
+	coldestCount := ^uint64(0)
+	for k, count := range h.counts {
+		if count < coldestCount {
+			coldest, coldestCount = k, count
+		}
+	}
+	if estimate > coldestCount {
+		delete(h.counts, coldest)
+		h.counts[key] = estimate
+	}
+}
+
+// commentCountLoaderMix is the splitmix64 finalizer, integer keys are hashed with it instead of formatting them
+func commentCountLoaderMix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+func (h *commentCountLoaderHotKeys) top(n int) []CommentCountLoaderKeyCount {
+	h.mu.Lock()
+	keys := make([]CommentCountLoaderKeyCount, 0, len(h.counts))
+	for key, count := range h.counts {
+		keys = append(keys, CommentCountLoaderKeyCount{Key: key, Count: count})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Count > keys[j].Count
+	})
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// ErrCommentCountLoaderNotFound is returned for keys whose value is missing, eg. because IsDeleted matched it
+var ErrCommentCountLoaderNotFound = errors.New("CommentCountLoader: not found")
+
+// CommentCountLoaderMissingPolicy decides what loads of keys that Fetch didn't find return
+type CommentCountLoaderMissingPolicy int
+
+const (
+	// CommentCountLoaderMissingAsFetched returns whatever Fetch returned for the key
+	CommentCountLoaderMissingAsFetched CommentCountLoaderMissingPolicy = iota
+
+	// CommentCountLoaderMissingZero returns the zero value without an error, eg. for nullable graphql fields. Fetch
+	// errors wrapping ErrCommentCountLoaderNotFound are dropped and the zero value is cached.
+	CommentCountLoaderMissingZero
+
+	// CommentCountLoaderMissingError fails keys that Fetch returned no value for with ErrCommentCountLoaderNotFound
+	CommentCountLoaderMissingError
+)
+
+// CommentCountLoaderRows is the part of *sql.Rows that CommentCountLoaderGroups reads from
+type CommentCountLoaderRows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+}
+
+// CommentCountLoaderGroups turns the rows of an aggregate query into a Fetch result, eg. for
+//
+//	SELECT post_id, COUNT(*) FROM comments WHERE post_id IN (...) GROUP BY post_id
+//
+// Every row must hold a key followed by its aggregate, keys without a row are given 0.
+func CommentCountLoaderGroups(keys []int, rows CommentCountLoaderRows) ([]int, []error) {
+	groups := make(map[int]int, len(keys))
+	for rows.Next() {
+		var key int
+		var value int
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, []error{err}
+		}
+		groups[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, []error{err}
+	}
+
+	values := make([]int, len(keys))
+	for i, key := range keys {
+		values[i] = groups[key]
+	}
+	return values, nil
+}
+
+// Sum loads the aggregate of every key and adds them up, the first error is returned
+func (l *CommentCountLoader) Sum(keys []int) (int, error) {
+	values, errs := l.LoadAll(keys)
+
+	var sum int
+	for i, value := range values {
+		if errs[i] != nil {
+			return 0, errs[i]
+		}
+		sum += value
+	}
+	return sum, nil
+}
+
+// CommentCountLoaderClosedPolicy decides what happens to loads after Close
+type CommentCountLoaderClosedPolicy int
+
+const (
+	// CommentCountLoaderClosedError fails loads with ErrCommentCountLoaderClosed
+	CommentCountLoaderClosedError CommentCountLoaderClosedPolicy = iota
+
+	// CommentCountLoaderClosedPanic panics on load, to catch loaders that are used after shutdown during development
+	CommentCountLoaderClosedPanic
+
+	// CommentCountLoaderClosedFetch calls Fetch for every load on its own, without batching or caching
+	CommentCountLoaderClosedFetch
+)
+
+// ErrCommentCountLoaderOwnerDone is what loads panic with when they happen after the Owner of the loader is done
+var ErrCommentCountLoaderOwnerDone = errors.New("CommentCountLoader: used after the request that owns it finished")
+
+// checkOwner panics once the owner is done, it must be called with the loader locked and unlocks it before panicking
+func (l *CommentCountLoader) checkOwner(key int) {
+	if l.owner == nil || l.owner.Err() == nil {
+		return
+	}
+	l.mu.Unlock()
+	panic(fmt.Errorf("%w: loading %s", ErrCommentCountLoaderOwnerDone, commentCountLoaderKeyString(key)))
+}
+
+// ErrCommentCountLoaderMutated is what loads panic with when DetectMutations catches a cached value that was changed in place
+var ErrCommentCountLoaderMutated = errors.New("CommentCountLoader: cached value was changed in place")
+
+// CheckMutations returns the keys whose cached values were changed in place since they were cached or last
+// checked, without calling OnMutation, eg. to check every value at the end of a test. DetectMutations must be set.
+func (l *CommentCountLoader) CheckMutations() []int {
+	l.mu.Lock()
+	metas := make(map[int]*CommentCountLoaderEntryMeta, len(l.meta))
+	if l.detectMutations {
+		for key, meta := range l.meta {
+			metas[key] = meta
+		}
+	}
+	l.mu.Unlock()
+
+	values := make(map[int]int, len(metas))
+	for key := range metas {
+		if value, ok := l.cache.Get(key); ok {
+			values[key] = value
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var mutated []int
+	for key, value := range values {
+		if meta := metas[key]; l.meta[key] == meta && meta.mutated(value) {
+			mutated = append(mutated, key)
+		}
+	}
+	return mutated
+}
+
+// mutated reports whether value no longer matches its checksum, updating it so a change is only reported once
+func (m *CommentCountLoaderEntryMeta) mutated(value int) bool {
+	checksum := commentCountLoaderChecksum(value)
+	if checksum == m.checksum {
+		return false
+	}
+	m.checksum = checksum
+	return true
+}
+
+// commentCountLoaderChecksum hashes value as encoding/json encodes it, values json can't encode all hash the same
+func commentCountLoaderChecksum(value int) uint64 {
+	h := fnv.New64a()
+	if err := json.NewEncoder(h).Encode(value); err != nil {
+		return 0
+	}
+	return h.Sum64()
+}
+
+// ErrCommentCountLoaderClosed is returned for loads after Close when the ClosedPolicy is CommentCountLoaderClosedError
+var ErrCommentCountLoaderClosed = errors.New("CommentCountLoader: loader is closed")
+
+// Close stops the loader from batching any new loads, from now on they are handled according to the ClosedPolicy.
+// Batches still collecting keys are fetched right away, like with Flush, and Close waits for every pending batch
+// to return, or for ctx to be done.
+func (l *CommentCountLoader) Close(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+
+	l.Flush()
+	return l.WaitForPending(ctx)
+}
+
+// WaitForPending blocks until every batch that is currently scheduled, either still collecting keys or already
+// fetching, has returned, or until ctx is done. Batches started in the meantime aren't waited for. Frameworks can use
+// this as a barrier between execution phases, or before serializing a response.
+func (l *CommentCountLoader) WaitForPending(ctx context.Context) error {
+	l.mu.Lock()
+	pending := make([]chan struct{}, 0, len(l.inflight))
+	for b := range l.inflight {
+		pending = append(pending, b.done)
+	}
+	l.mu.Unlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *CommentCountLoader) closedThunk(config CommentCountLoaderConfig, key int) (func() (int, error), func()) {
+	switch config.ClosedPolicy {
+	case CommentCountLoaderClosedPanic:
+		panic(ErrCommentCountLoaderClosed)
+
+	case CommentCountLoaderClosedFetch:
+		if fetchContext := config.contextFetch(); fetchContext != nil {
+			config.Fetch = func(keys []int) ([]int, []error) {
+				return fetchContext(context.Background(), keys)
+			}
+		}
+		config.Fetch = commentCountLoaderRecovered(config.Fetch)
+		return func() (int, error) {
+			b := &commentCountLoaderBatch{keys: []int{key}}
+			b.data, b.error = b.checkedFetch(config, b.keys)
+
+			var data int
+			if len(b.data) > 0 {
+				data = b.data[0]
+			}
+			return data, b.errorAt(0)
+		}, func() {}
+
+	default:
+		return func() (int, error) {
+			var zero int
+			return zero, ErrCommentCountLoaderClosed
+		}, func() {}
+	}
+}
+
+// CommentCountLoaderPool runs fetches, it is satisfied by CommentCountLoaderWorkerPool or any other goroutine pool
+type CommentCountLoaderPool interface {
+	// Go runs task, it may block until there is capacity to do so
+	Go(task func())
+}
+
+// CommentCountLoaderWorkerPool runs tasks on a fixed number of goroutines. It can be shared by many loaders, even of
+// different types, to bound the number of fetches running at once. A Fetch must not wait on another loader using
+// the same pool, or it can end up waiting for itself.
+type CommentCountLoaderWorkerPool struct {
+	tasks chan func()
+}
+
+// NewCommentCountLoaderWorkerPool starts a pool of workers goroutines
+func NewCommentCountLoaderWorkerPool(workers int) *CommentCountLoaderWorkerPool {
+	p := &CommentCountLoaderWorkerPool{tasks: make(chan func())}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Go blocks until a worker is free to run task
+func (p *CommentCountLoaderWorkerPool) Go(task func()) {
+	p.tasks <- task
+}
+
+// Stop the workers once they are done with their current task, Go must not be called afterwards
+func (p *CommentCountLoaderWorkerPool) Stop() {
+	close(p.tasks)
+}
+
+func (p *CommentCountLoaderWorkerPool) work() {
+	for task := range p.tasks {
+		task()
+	}
+}
+
+// CommentCountLoaderBreaker is a circuit breaker guarding Fetch, eg. CommentCountLoaderCircuitBreaker or an adapter to another library
+type CommentCountLoaderBreaker interface {
+	// Allow reports whether a batch may be fetched now
+	Allow() bool
+
+	// Done is told whether a fetch that Allow let through failed
+	Done(failed bool)
+}
+
+// ErrCommentCountLoaderCircuitOpen is returned for the keys of batches the Breaker didn't allow to be fetched
+var ErrCommentCountLoaderCircuitOpen = errors.New("CommentCountLoader: circuit open")
+
+// commentCountLoaderBrokenFetch wraps fetch so it is only called when breaker allows it. A fetch failed when it
+// failed the whole batch, either with a single error or with an error for every key. Keys that weren't found,
+// including the ones the MissingPolicy fails, say nothing about the health of the backend and don't count.
+func commentCountLoaderBrokenFetch(breaker CommentCountLoaderBreaker, fetch func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	return func(keys []int) ([]int, []error) {
+		if !breaker.Allow() {
+			return nil, []error{ErrCommentCountLoaderCircuitOpen}
+		}
+
+		data, errs := fetch(keys)
+		failed := len(errs) == 1 && errs[0] != nil && !errors.Is(errs[0], ErrCommentCountLoaderNotFound)
+		if len(errs) == len(keys) && len(keys) > 0 {
+			failed = true
+			for _, err := range errs {
+				if err == nil || errors.Is(err, ErrCommentCountLoaderNotFound) {
+					failed = false
+					break
+				}
+			}
+		}
+		breaker.Done(failed)
+		return data, errs
+	}
+}
+
+// CommentCountLoaderCircuitBreakerConfig tunes a CommentCountLoaderCircuitBreaker, zero fields take the defaults
+type CommentCountLoaderCircuitBreakerConfig struct {
+	// FailureRatio is the fraction of failed fetches in a window that opens the circuit, 0 = 0.5
+	FailureRatio float64
+
+	// MinFetches is how many fetches a window needs before it can open the circuit, 0 = 10
+	MinFetches int
+
+	// Window is how long fetches are counted for before the counts start over, 0 = 10 seconds
+	Window time.Duration
+
+	// Cooldown is how long the circuit stays open before a single fetch is let through to probe the backend.
+	// The circuit closes again when it succeeds, and stays open for another Cooldown when it fails. 0 = 5 seconds.
+	Cooldown time.Duration
+}
+
+// CommentCountLoaderCircuitBreaker opens once too many fetches of a window fail, so a failing backend isn't kept busy with
+// requests that are bound to fail. It can be shared by loaders fetching from the same backend.
+type CommentCountLoaderCircuitBreaker struct {
+	config CommentCountLoaderCircuitBreakerConfig
+
+	mu       sync.Mutex
+	started  time.Time
+	fetches  int
+	failures int
+	open     bool
+	opened   time.Time
+	probing  bool
+}
+
+// NewCommentCountLoaderCircuitBreaker creates a closed circuit breaker
+func NewCommentCountLoaderCircuitBreaker(config CommentCountLoaderCircuitBreakerConfig) *CommentCountLoaderCircuitBreaker {
+	if config.FailureRatio == 0 {
+		config.FailureRatio = 0.5
+	}
+	if config.MinFetches == 0 {
+		config.MinFetches = 10
+	}
+	if config.Window == 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = 5 * time.Second
+	}
+	return &CommentCountLoaderCircuitBreaker{config: config, started: time.Now()}
+}
+
+// Allow lets every fetch through while the circuit is closed, and a single probe once an open one cooled down
+func (c *CommentCountLoaderCircuitBreaker) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.open {
+		if c.probing || now.Sub(c.opened) < c.config.Cooldown {
+			return false
+		}
+		c.probing = true
+		return true
+	}
+	if now.Sub(c.started) > c.config.Window {
+		c.started, c.fetches, c.failures = now, 0, 0
+	}
+	return true
+}
+
+// Done counts the outcome of a fetch
+func (c *CommentCountLoaderCircuitBreaker) Done(failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.open {
+		// fetches that were let through before the circuit opened don't count, only the probe does
+		if !c.probing {
+			return
+		}
+		c.probing = false
+		if failed {
+			c.opened = time.Now()
+		} else {
+			c.open = false
+			c.started, c.fetches, c.failures = time.Now(), 0, 0
+		}
+		return
+	}
+
+	c.fetches++
+	if failed {
+		c.failures++
+	}
+	if c.fetches >= c.config.MinFetches && float64(c.failures) >= c.config.FailureRatio*float64(c.fetches) {
+		c.open = true
+		c.opened = time.Now()
+	}
+}
+
+// Open reports whether the circuit is open, ie. fetches are short-circuited
+func (c *CommentCountLoaderCircuitBreaker) Open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// CommentCountLoaderChunk splits keys into chunks of up to n keys, eg. for a backend that caps the size of an IN clause
+// below MaxBatch, and calls fetch for every chunk at once. The results are merged back in the order of keys: a
+// single error for a chunk fails each of its keys, keys a chunk returned no value for get the zero value, and a
+// chunk with more results than keys fails with a CommentCountLoaderResultLengthError. A panic in fetch fails its chunk with
+// a CommentCountLoaderPanicError. With n <= 0 all keys are fetched as a single chunk, merged the same way.
+func CommentCountLoaderChunk(keys []int, n int, fetch func(keys []int) ([]int, []error)) ([]int, []error) {
+	if n <= 0 || n > len(keys) {
+		n = len(keys)
+	}
+
+	fetch = commentCountLoaderRecovered(fetch)
+	data := make([]int, len(keys))
+	errs := make([]error, len(keys))
+	var failed int32
+	var wg sync.WaitGroup
+	for start := 0; start < len(keys); start += n {
+		end := start + n
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			chunk := keys[start:end]
+			chunkData, chunkErrs := fetch(chunk)
+			if len(chunkData) > len(chunk) || (len(chunkErrs) > 1 && len(chunkErrs) != len(chunk)) {
+				chunkData, chunkErrs = nil, []error{&CommentCountLoaderResultLengthError{Keys: len(chunk), Values: len(chunkData), Errors: len(chunkErrs)}}
+			}
+
+			copy(data[start:end], chunkData)
+			for i := range chunk {
+				var err error
+				if len(chunkErrs) == 1 {
+					err = chunkErrs[0]
+				} else if i < len(chunkErrs) {
+					err = chunkErrs[i]
+				}
+				if err != nil {
+					errs[start+i] = err
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if failed == 0 {
+		return data, nil
+	}
+	return data, errs
+}
+
+// commentCountLoaderRecording is one recorded batch, errors are kept as their messages along with the sentinel
+// they wrapped, if any
+type commentCountLoaderRecording struct {
+	Keys      []int    `json:"keys"`
+	Values    []int    `json:"values"`
+	Errors    []string `json:"errors,omitempty"`
+	Sentinels []string `json:"sentinels,omitempty"`
+}
+
+// commentCountLoaderSentinels are the errors Record tags recorded errors with, so errors.Is still matches them
+// once they are replayed
+var commentCountLoaderSentinels = []struct {
+	tag string
+	err error
+}{
+	{"not_found", ErrCommentCountLoaderNotFound},
+	{"circuit_open", ErrCommentCountLoaderCircuitOpen},
+	{"injected", ErrCommentCountLoaderInjected},
+	{"overloaded", ErrCommentCountLoaderOverloaded},
+	{"closed", ErrCommentCountLoaderClosed},
+}
+
+// commentCountLoaderReplayedError is a recorded error that wrapped one of commentCountLoaderSentinels
+type commentCountLoaderReplayedError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *commentCountLoaderReplayedError) Error() string {
+	return e.msg
+}
+
+func (e *commentCountLoaderReplayedError) Unwrap() error {
+	return e.sentinel
+}
+
+// commentCountLoaderReplayed turns a recorded error back into an error, unknown errors only keep their message
+func commentCountLoaderReplayed(msg, tag string) error {
+	for _, sentinel := range commentCountLoaderSentinels {
+		if sentinel.tag != tag {
+			continue
+		}
+		if msg == sentinel.err.Error() {
+			return sentinel.err
+		}
+		return &commentCountLoaderReplayedError{msg: msg, sentinel: sentinel.err}
+	}
+	return errors.New(msg)
+}
+
+// CommentCountLoaderRecord wraps fetch so every batch and its results are written to w as a line of json, eg. to a golden
+// file that CommentCountLoaderReplay serves in tests later. Values must survive a round trip through encoding/json.
+func CommentCountLoaderRecord(w io.Writer, fetch func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return func(keys []int) ([]int, []error) {
+		data, errs := fetch(keys)
+
+		rec := commentCountLoaderRecording{Keys: keys, Values: data}
+		tagged := false
+		for _, err := range errs {
+			msg, tag := "", ""
+			if err != nil {
+				msg = err.Error()
+				for _, sentinel := range commentCountLoaderSentinels {
+					if errors.Is(err, sentinel.err) {
+						tag, tagged = sentinel.tag, true
+						break
+					}
+				}
+			}
+			rec.Errors = append(rec.Errors, msg)
+			rec.Sentinels = append(rec.Sentinels, tag)
+		}
+		if !tagged {
+			rec.Sentinels = nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(rec); err != nil {
+			return nil, []error{fmt.Errorf("CommentCountLoader: recording batch: %w", err)}
+		}
+		return data, errs
+	}
+}
+
+// ErrCommentCountLoaderNotRecorded is returned by a replayed fetch for keys that weren't recorded
+var ErrCommentCountLoaderNotRecorded = errors.New("CommentCountLoader: key wasn't recorded")
+
+// CommentCountLoaderReplay reads batches written by CommentCountLoaderRecord and returns a fetch that serves them. Results are looked
+// up per key, so batches don't have to come together the same way they did while recording.
+func CommentCountLoaderReplay(r io.Reader) (func(keys []int) ([]int, []error), error) {
+	type result struct {
+		value int
+		err   error
+	}
+	results := map[int]result{}
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec commentCountLoaderRecording
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("CommentCountLoader: reading recording: %w", err)
+		}
+
+		for pos, key := range rec.Keys {
+			var res result
+			if pos < len(rec.Values) {
+				res.value = rec.Values[pos]
+			}
+			// a single error was returned for the whole batch
+			at := pos
+			if len(rec.Errors) == 1 {
+				at = 0
+			}
+			if at < len(rec.Errors) && rec.Errors[at] != "" {
+				tag := ""
+				if at < len(rec.Sentinels) {
+					tag = rec.Sentinels[at]
+				}
+				res.err = commentCountLoaderReplayed(rec.Errors[at], tag)
+			}
+			results[key] = res
+		}
+	}
+
+	return func(keys []int) ([]int, []error) {
+		data := make([]int, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			res, ok := results[key]
+			if !ok {
+				errs[i] = fmt.Errorf("%w: %s", ErrCommentCountLoaderNotRecorded, commentCountLoaderKeyString(key))
+				continue
+			}
+			data[i], errs[i] = res.value, res.err
+		}
+		return data, errs
+	}, nil
+}
+
+// ErrCommentCountLoaderInjected is the error of keys and batches failed by a CommentCountLoaderChaos
+var ErrCommentCountLoaderInjected = errors.New("CommentCountLoader: injected failure")
+
+// CommentCountLoaderChaosConfig is the degradation a CommentCountLoaderChaos injects, the zero value injects nothing
+type CommentCountLoaderChaosConfig struct {
+	// Latency is added to every batch
+	Latency time.Duration
+
+	// KeyErrorRate is the fraction of keys, from 0 to 1, that fail with ErrCommentCountLoaderInjected
+	KeyErrorRate float64
+
+	// BatchErrorRate is the fraction of batches, from 0 to 1, that fail as a whole without calling fetch
+	BatchErrorRate float64
+}
+
+// CommentCountLoaderChaos injects latency and failures into a fetch, to see how resolvers cope with a degraded loader
+// (eg. in staging). Its config can be changed at any time, so it can be switched on and off at runtime.
+type CommentCountLoaderChaos struct {
+	mu     sync.Mutex
+	config CommentCountLoaderChaosConfig
+}
+
+// Set replaces the degradation that is injected from the next batch on
+func (c *CommentCountLoaderChaos) Set(config CommentCountLoaderChaosConfig) {
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+}
+
+// Wrap returns fetch with the degradation of c injected
+func (c *CommentCountLoaderChaos) Wrap(fetch func(keys []int) ([]int, []error)) func(keys []int) ([]int, []error) {
+	return func(keys []int) ([]int, []error) {
+		c.mu.Lock()
+		config := c.config
+		c.mu.Unlock()
+
+		if config.Latency > 0 {
+			time.Sleep(config.Latency)
+		}
+		if config.BatchErrorRate > 0 && rand.Float64() < config.BatchErrorRate {
+			return nil, []error{ErrCommentCountLoaderInjected}
+		}
+
+		data, errs := fetch(keys)
+		if config.KeyErrorRate <= 0 || (len(errs) == 1 && errs[0] != nil) {
+			return data, errs
+		}
+
+		injected := make([]error, len(keys))
+		copy(injected, errs)
+		for pos := range keys {
+			if rand.Float64() < config.KeyErrorRate {
+				injected[pos] = ErrCommentCountLoaderInjected
+			}
+		}
+		return data, injected
+	}
+}
+
+// commentCountLoaderExport is one cache entry as written by Export
+type commentCountLoaderExport struct {
+	Key     int       `json:"key"`
+	Value   int       `json:"value"`
+	Expires time.Time `json:"expires,omitempty"`
+
+	// TTL is what was left of the TTL, Import uses it rather than Expires so clocks of the two instances
+	// needn't agree
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// Export writes the entries this loader has cached to w as lines of json, eg. to warm up the instance replacing
+// this one in a blue/green deploy with Import. It returns how many entries were written.
+func (l *CommentCountLoader) Export(w io.Writer) (int, error) {
+	l.mu.Lock()
+	entries := make([]commentCountLoaderExport, 0, len(l.meta))
+	for key, meta := range l.meta {
+		entry := commentCountLoaderExport{Key: key, Expires: meta.Expires}
+		if !meta.Expires.IsZero() {
+			if entry.TTL = time.Until(meta.Expires); entry.TTL <= 0 {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	l.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	written := 0
+	for _, entry := range entries {
+		value, ok := l.cache.Get(entry.Key)
+		if !ok {
+			continue
+		}
+		entry.Value = value
+		if err := enc.Encode(entry); err != nil {
+			return written, fmt.Errorf("CommentCountLoader: exporting %s: %w", commentCountLoaderKeyString(entry.Key), err)
+		}
+		written++
+	}
+	return written, nil
+}
+
+// Import primes the cache with the entries written by Export, keeping what is left of their TTL. Keys that are
+// already cached are left alone. It returns how many entries were cached.
+func (l *CommentCountLoader) Import(r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	imported := 0
+	for {
+		var entry commentCountLoaderExport
+		if err := dec.Decode(&entry); err == io.EOF {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("CommentCountLoader: importing: %w", err)
+		}
+
+		var opts []CommentCountLoaderPrimeOption
+		if !entry.Expires.IsZero() {
+			ttl := entry.TTL
+			if ttl == 0 {
+				// written before exports had a ttl
+				ttl = time.Until(entry.Expires)
+			}
+			if ttl <= 0 {
+				continue
+			}
+			opts = append(opts, CommentCountLoaderWithTTL(ttl))
+		}
+		if l.Prime(entry.Key, entry.Value, opts...) {
+			imported++
+		}
+	}
+}
+
+// CommentCountLoaderOptional is a value that may legitimately be absent. Absent keys have Found false and no error,
+// so "not found" is told apart from both a failed fetch and a found zero value.
+type CommentCountLoaderOptional struct {
+	Value int
+	Found bool
+}
+
+// LoadOptional loads key like Load, but reports keys that are not found, ie. that fail with
+// ErrCommentCountLoaderNotFound, as absent instead of failing. Absence is cached like a value: until the key is
+// cleared or evicted, or the TTL of the loader passed.
+// With CommentCountLoaderMissingZero missing keys load as found zero values, so return ErrCommentCountLoaderNotFound from Fetch
+// or use CommentCountLoaderMissingError to see them as absent.
+func (l *CommentCountLoader) LoadOptional(key int) (CommentCountLoaderOptional, error) {
+	value, err := l.Load(key)
+	return l.optional(key, value, err)
+}
+
+// LoadAllOptional loads many keys like LoadAll, reporting the ones that are not found as absent
+func (l *CommentCountLoader) LoadAllOptional(keys []int) ([]CommentCountLoaderOptional, []error) {
+	values, errs := l.LoadAll(keys)
+	optionals := make([]CommentCountLoaderOptional, len(keys))
+	for i, key := range keys {
+		optionals[i], errs[i] = l.optional(key, values[i], errs[i])
+	}
+	return optionals, errs
+}
+
+// PrimeOptional primes the cache with value, or with its absence when it isn't found. If the key is already
+// cached or known to be absent no change is made and false is returned.
+func (l *CommentCountLoader) PrimeOptional(key int, value CommentCountLoaderOptional) bool {
+	if value.Found {
+		return l.Prime(key, value.Value)
+	}
+
+	if _, found := l.cache.Get(key); found {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unsafeIsAbsent(key) {
+		return false
+	}
+	l.unsafeAbsent(key)
+	return true
+}
+
+func (l *CommentCountLoader) optional(key int, value int, err error) (CommentCountLoaderOptional, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if errors.Is(err, ErrCommentCountLoaderNotFound) {
+		l.unsafeAbsent(key)
+		return CommentCountLoaderOptional{}, nil
+	}
+	if err != nil {
+		return CommentCountLoaderOptional{}, err
+	}
+	// with CommentCountLoaderMissingZero keys known to be absent load as zero values
+	if l.unsafeIsAbsent(key) {
+		return CommentCountLoaderOptional{}, nil
+	}
+	return CommentCountLoaderOptional{Value: value, Found: true}, nil
+}
+
+type commentCountLoaderCachedError struct {
+	err     error
+	expires time.Time
+}
+
+// cacheErrors caches the errors of a batch that config.CacheError accepts for config.ErrorTTL, keys cleared while
+// the batch was fetched aren't cached. It must be called with the loader locked.
+func (l *CommentCountLoader) cacheErrors(config CommentCountLoaderConfig, b *commentCountLoaderBatch) {
+	expires := time.Now().Add(config.ErrorTTL)
+	for pos, key := range b.keys {
+		err := b.errorAt(pos)
+		if err == nil || b.staleAll || b.stale[pos] {
+			continue
+		}
+		if config.CacheError != nil && !config.CacheError(key, err) {
+			continue
+		}
+		if config.CacheError == nil && !errors.Is(err, ErrCommentCountLoaderNotFound) {
+			continue
+		}
+		if l.errored == nil {
+			l.errored = map[int]commentCountLoaderCachedError{}
+		}
+		l.errored[key] = commentCountLoaderCachedError{err: err, expires: expires}
+	}
+}
+
+// unsafeAbsent remembers that key has no value, loads of it fail with ErrCommentCountLoaderNotFound until it is cleared
+// or the TTL of the loader passed. It must be called with the loader locked.
+func (l *CommentCountLoader) unsafeAbsent(key int) {
+	if l.deleted == nil {
+		l.deleted = map[int]time.Time{}
+	}
+	var expires time.Time
+	if l.ttl > 0 {
+		expires = time.Now().Add(l.ttl)
+	}
+	l.deleted[key] = expires
+}
+
+// unsafeIsAbsent reports whether key is known to be absent, forgetting it once it expired. It must be called with
+// the loader locked.
+func (l *CommentCountLoader) unsafeIsAbsent(key int) bool {
+	expires, ok := l.deleted[key]
+	if ok && !expires.IsZero() && time.Now().After(expires) {
+		delete(l.deleted, key)
+		return false
+	}
+	return ok
+}
+
+// CommentCountLoaderName names the loader, eg. in metrics labels and logs. Use it instead of a string literal so a
+// typo can't split a dashboard in two.
+const CommentCountLoaderName = "CommentCountLoader"
+
+type commentCountLoaderContextKey struct{}
+
+// WithCommentCountLoader returns a copy of ctx that carries l, eg. for a middleware that creates loaders per request
+func WithCommentCountLoader(ctx context.Context, l *CommentCountLoader) context.Context {
+	return context.WithValue(ctx, commentCountLoaderContextKey{}, l)
+}
+
+// CommentCountLoaderFromContext returns the loader WithCommentCountLoader put in ctx, or nil if there is none
+func CommentCountLoaderFromContext(ctx context.Context) *CommentCountLoader {
+	l, _ := ctx.Value(commentCountLoaderContextKey{}).(*CommentCountLoader)
+	return l
+}
+
+// CommentCountLoaderConn is what FetchConn queries through, *sql.DB, *sql.Conn and *sql.Tx all implement it
+type CommentCountLoaderConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// CommentCountLoaderDBConn is an Acquire that checks out a connection from db for every batch
+func CommentCountLoaderDBConn(db *sql.DB) func(ctx context.Context, fetch func(conn CommentCountLoaderConn)) error {
+	return func(ctx context.Context, fetch func(conn CommentCountLoaderConn)) error {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		fetch(conn)
+		return nil
+	}
+}
+
+// CommentCountLoaderTxConn is an Acquire that runs every batch in tx, eg. for a loader created for a request inside the
+// request's transaction. Batches running at the same time share tx, so its driver has to allow that.
+func CommentCountLoaderTxConn(tx *sql.Tx) func(ctx context.Context, fetch func(conn CommentCountLoaderConn)) error {
+	return func(ctx context.Context, fetch func(conn CommentCountLoaderConn)) error {
+		fetch(tx)
+		return nil
+	}
+}
+
+// contextFetch is the fetch taking a ctx that is configured, FetchConn wrapped in Acquire or FetchContext. It
+// is nil when only Fetch is.
+func (c CommentCountLoaderConfig) contextFetch() func(ctx context.Context, keys []int) ([]int, []error) {
+	if c.FetchConn == nil {
+		return c.FetchContext
+	}
+	fetchConn, acquire := c.FetchConn, c.Acquire
+	return func(ctx context.Context, keys []int) ([]int, []error) {
+		var data []int
+		var errs []error
+		err := acquire(ctx, func(conn CommentCountLoaderConn) {
+			data, errs = fetchConn(ctx, conn, keys)
+		})
+		if err != nil {
+			return nil, []error{err}
+		}
+		return data, errs
+	}
+}
+
+// commentCountLoaderKeyString is how keys are written out, eg. in external caches and errors
+func commentCountLoaderKeyString(key int) string {
+	return fmt.Sprint(key)
+}
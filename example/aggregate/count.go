@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden CommentCountLoader int int
+
+package aggregate
@@ -0,0 +1,78 @@
+package aggregate_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example/aggregate"
+)
+
+// rows pretends to be the *sql.Rows of a GROUP BY query
+type rows struct {
+	groups [][2]int
+	pos    int
+}
+
+func (r *rows) Next() bool {
+	r.pos++
+	return r.pos <= len(r.groups)
+}
+
+func (r *rows) Scan(dest ...interface{}) error {
+	*dest[0].(*int) = r.groups[r.pos-1][0]
+	*dest[1].(*int) = r.groups[r.pos-1][1]
+	return nil
+}
+
+func (r *rows) Err() error {
+	return nil
+}
+
+func TestCommentCountLoader(t *testing.T) {
+	dl := aggregate.NewCommentCountLoader(aggregate.CommentCountLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []int) ([]int, []error) {
+			// SELECT post_id, COUNT(*) FROM comments WHERE post_id IN (...) GROUP BY post_id
+			return aggregate.CommentCountLoaderGroups(keys, &rows{groups: [][2]int{{1, 3}, {3, 5}}})
+		},
+	})
+
+	count, err := dl.Load(1)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	count, err = dl.Load(2)
+	require.NoError(t, err)
+	require.Equal(t, 0, count)
+
+	sum, err := dl.Sum([]int{1, 2, 3})
+	require.NoError(t, err)
+	require.Equal(t, 8, sum)
+}
+
+func TestCommentCountLoaderLargeBatch(t *testing.T) {
+	var batches [][]int
+	dl := aggregate.NewCommentCountLoader(aggregate.CommentCountLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []int) ([]int, []error) {
+			batches = append(batches, keys)
+			return keys, nil
+		},
+		HotKeys: 2,
+	})
+
+	keys := make([]int, 0, 300)
+	for i := 0; i < 100; i++ {
+		keys = append(keys, i, i, 7)
+	}
+	counts, errs := dl.LoadAll(keys)
+	for i, key := range keys {
+		require.NoError(t, errs[i])
+		require.Equal(t, key, counts[i])
+	}
+
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 100, "duplicate keys share a slot once the batch is indexed")
+	require.Equal(t, 7, dl.HotKeys(1)[0].Key)
+}
@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -bundle UserLoader string *github.com/tribunadigital/dataloaden/example.User UserSliceLoader int []*github.com/tribunadigital/dataloaden/example.User
+
+package bundle
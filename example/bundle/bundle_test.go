@@ -0,0 +1,62 @@
+package bundle_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/bundle"
+)
+
+func TestMiddleware(t *testing.T) {
+	config := bundle.LoadersConfig{
+		User: bundle.UserLoaderConfig{Wait: time.Millisecond},
+		UserSlice: bundle.UserSliceLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []int) ([][]*example.User, []error) {
+				return make([][]*example.User, len(keys)), nil
+			},
+		},
+		Prepare: func(r *http.Request, config *bundle.LoadersConfig) {
+			tenant := r.Header.Get("Tenant")
+			config.User.Fetch = func(keys []string) ([]*example.User, []error) {
+				users := make([]*example.User, len(keys))
+				for i, key := range keys {
+					users[i] = &example.User{ID: key, Name: tenant}
+				}
+				return users, nil
+			}
+		},
+	}
+
+	var seen []*bundle.Loaders
+	handler := config.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loaders := bundle.For(r.Context())
+		seen = append(seen, loaders)
+
+		user, err := loaders.User.Load("U1")
+		require.NoError(t, err)
+		_, _ = w.Write([]byte(user.Name))
+	}))
+
+	for _, tenant := range []string{"a", "b"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Tenant", tenant)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, tenant, rec.Body.String())
+	}
+
+	require.Len(t, seen, 2)
+	require.True(t, seen[0] != seen[1], "every request should get loaders of its own")
+
+	require.Panics(t, func() { bundle.For(context.Background()) })
+
+	loaders := bundle.NewLoaders(config)
+	require.True(t, bundle.For(bundle.WithLoaders(context.Background(), loaders)) == loaders)
+}
@@ -3,22 +3,77 @@
 package cache
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tribunadigital/dataloaden/example"
 
+	crand "crypto/rand"
+
 	gocache "github.com/patrickmn/go-cache"
 )
 
 // UserLoaderCache can be used to cache results. A default map based
-// implementation is used by default.
+// implementation is used by default. Any implementation can be passed in the config, eg. an LRU, a
+// shared redis or UserLoaderNoCache.
 type UserLoaderCache interface {
 	Get(key string) (*example.User, bool)
 	Set(key string, value *example.User)
 	ClearKey(key string)
 }
 
+// UserLoaderClearableCache is implemented by caches that can drop every entry at once, it is used by ClearAll.
+// Other caches have the keys the loader knows about cleared one at a time.
+type UserLoaderClearableCache interface {
+	Clear()
+}
+
+// UserLoaderEvictingCache is implemented by caches that drop entries by themselves, eg. to stay within a size
+// limit. The loader registers a callback with OnEvict to forget what it knows about the evicted keys, the cache
+// must not call it while it is locked.
+type UserLoaderEvictingCache interface {
+	OnEvict(evicted func(key string))
+}
+
+// UserLoaderNoCache is a UserLoaderCache that never caches anything, so every load is fetched. Loads of the
+// same key are still batched together.
+type UserLoaderNoCache struct{}
+
+func (UserLoaderNoCache) Get(key string) (*example.User, bool) {
+	var zero *example.User
+	return zero, false
+}
+
+func (UserLoaderNoCache) Set(key string, value *example.User) {}
+
+func (UserLoaderNoCache) ClearKey(key string) {}
+
+// UserLoaderTTLCache is implemented by caches that can expire individual entries, it is used when
+// a value is primed with UserLoaderWithTTL.
+type UserLoaderTTLCache interface {
+	SetWithTTL(key string, value *example.User, ttl time.Duration)
+}
+
 // Cache implementation for github.com/patrickmn/go-cache
 // !!! Works for string keys only !!!
 
@@ -53,257 +108,4912 @@ func (c *UserLoaderGoCache) Set(key string, value *example.User) {
 	c.cache.Set(key, value, 0)
 }
 
+func (c *UserLoaderGoCache) SetWithTTL(key string, value *example.User, ttl time.Duration) {
+	c.cache.Set(key, value, ttl)
+}
+
 func (c *UserLoaderGoCache) ClearKey(key string) {
 	c.cache.Delete(key)
 }
 
+func (c *UserLoaderGoCache) Clear() {
+	c.cache.Flush()
+}
+
 // Cache implementation for Golang Map
 
 type UserLoaderMapCache struct {
-	data map[string]*example.User
-	mu   *sync.Mutex
+	data    map[string]*example.User
+	expires map[string]time.Time
+	mu      *sync.Mutex
 }
 
 func NewUserLoaderMapCache() *UserLoaderMapCache {
 	return &UserLoaderMapCache{
-		data: map[string]*example.User{},
-		mu:   &sync.Mutex{},
+		data:    map[string]*example.User{},
+		expires: map[string]time.Time{},
+		mu:      &sync.Mutex{},
 	}
 }
 
 func (c *UserLoaderMapCache) Get(key string) (*example.User, bool) {
 	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if expires, ok := c.expires[key]; ok && time.Now().After(expires) {
+		delete(c.data, key)
+		delete(c.expires, key)
+	}
+
 	r, ok := c.data[key]
-	c.mu.Unlock()
 	return r, ok
 }
 
 func (c *UserLoaderMapCache) Set(key string, value *example.User) {
 	c.mu.Lock()
 	c.data[key] = value
+	delete(c.expires, key)
+	c.mu.Unlock()
+}
+
+// SetWithTTL stores a value that Get will stop returning once ttl has passed
+func (c *UserLoaderMapCache) SetWithTTL(key string, value *example.User, ttl time.Duration) {
+	c.mu.Lock()
+	c.data[key] = value
+	c.expires[key] = time.Now().Add(ttl)
 	c.mu.Unlock()
 }
 
 func (c *UserLoaderMapCache) ClearKey(key string) {
 	c.mu.Lock()
 	delete(c.data, key)
+	delete(c.expires, key)
+	c.mu.Unlock()
+}
+
+func (c *UserLoaderMapCache) Clear() {
+	c.mu.Lock()
+	c.data = map[string]*example.User{}
+	c.expires = map[string]time.Time{}
+	c.mu.Unlock()
+}
+
+// UserLoaderLRUCache is a UserLoaderCache that holds at most maxEntries values, evicting the least recently used
+// one to make room. It is safe to share between goroutines.
+type UserLoaderLRUCache struct {
+	maxEntries int
+	recent     *list.List
+	entries    map[string]*list.Element
+	onEvict    []func(key string)
+	mu         sync.Mutex
+}
+
+type userLoaderLRUEntry struct {
+	key   string
+	value *example.User
+}
+
+// NewUserLoaderLRUCache creates an empty UserLoaderLRUCache that holds up to maxEntries values, 0 = no limit
+func NewUserLoaderLRUCache(maxEntries int) *UserLoaderLRUCache {
+	return &UserLoaderLRUCache{
+		maxEntries: maxEntries,
+		recent:     list.New(),
+		entries:    map[string]*list.Element{},
+	}
+}
+
+func (c *UserLoaderLRUCache) Get(key string) (*example.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		var zero *example.User
+		return zero, false
+	}
+	c.recent.MoveToFront(el)
+	return el.Value.(*userLoaderLRUEntry).value, true
+}
+
+func (c *UserLoaderLRUCache) Set(key string, value *example.User) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		el.Value.(*userLoaderLRUEntry).value = value
+		c.recent.MoveToFront(el)
+		c.mu.Unlock()
+		return
+	}
+
+	c.entries[key] = c.recent.PushFront(&userLoaderLRUEntry{key: key, value: value})
+	var evicted []string
+	for c.maxEntries > 0 && c.recent.Len() > c.maxEntries {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*userLoaderLRUEntry).key)
+		evicted = append(evicted, oldest.Value.(*userLoaderLRUEntry).key)
+	}
+	onEvict := c.onEvict
+	c.mu.Unlock()
+
+	for _, key := range evicted {
+		for _, f := range onEvict {
+			f(key)
+		}
+	}
+}
+
+// OnEvict calls evicted with each key Set drops to stay within maxEntries
+func (c *UserLoaderLRUCache) OnEvict(evicted func(key string)) {
+	c.mu.Lock()
+	c.onEvict = append(c.onEvict, evicted)
 	c.mu.Unlock()
 }
 
+func (c *UserLoaderLRUCache) ClearKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.recent.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+func (c *UserLoaderLRUCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recent.Init()
+	c.entries = map[string]*list.Element{}
+}
+
+// Len is how many values are cached
+func (c *UserLoaderLRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
 // UserLoaderConfig captures the config to create a new UserLoader
 type UserLoaderConfig struct {
 	// Fetch is a method that provides the data for the loader
 	Fetch func(keys []string) ([]*example.User, []error)
 
+	// FetchContext is used instead of Fetch when set. Its ctx is cancelled once every caller waiting on the batch
+	// has given up (see LoadContext), so a batch nobody wants anymore can abort its round trip. Callers that
+	// can't be cancelled, like Load, keep it alive.
+	FetchContext func(ctx context.Context, keys []string) ([]*example.User, []error)
+
+	// FetchConn is used instead of Fetch when set. Every batch is fetched on a single connection or transaction
+	// that Acquire checks out for it, so its queries can take part in the request's transaction. Its ctx behaves
+	// like the one of FetchContext.
+	FetchConn func(ctx context.Context, conn UserLoaderConn, keys []string) ([]*example.User, []error)
+
+	// Acquire checks out the connection of a batch, passes it to fetch and releases it once fetch returns. An
+	// error fails every key of the batch. See UserLoaderDBConn and UserLoaderTxConn.
+	Acquire func(ctx context.Context, fetch func(conn UserLoaderConn)) error
+
 	// Wait is how long wait before sending a batch
 	Wait time.Duration
 
+	// TargetBatch adapts the wait of every batch to the rate keys arrive at. A batch waits about as long as it
+	// takes TargetBatch keys to arrive, but at least MinWait and at most Wait, so busy loaders send their batches
+	// sooner while quiet ones keep waiting long enough to batch what little arrives. 0 = every batch waits Wait.
+	TargetBatch int
+
+	// MinWait is the shortest wait of a batch with TargetBatch
+	MinWait time.Duration
+
 	// MaxBatch will limit the maximum number of keys to send in one batch, 0 = not limit
 	MaxBatch int
 
-	// Cache is the datastructure used to cache fetched data
+	// MaxBatchOverflow lets a batch grow up to MaxBatch+MaxBatchOverflow keys when that fits the rest of a LoadAll
+	// call, instead of splitting off a tiny trailing batch
+	MaxBatchOverflow int
+
+	// Cache is the datastructure used to cache fetched data. The loader never calls it while it is locked, so a
+	// cache doing network I/O only slows down the loads that use it
 	Cache UserLoaderCache
-}
 
-// NewUserLoader creates a new UserLoader given a fetch, wait, and maxBatch
-func NewUserLoader(config UserLoaderConfig) *UserLoader {
-	dl := UserLoader{
-		fetch:    config.Fetch,
-		wait:     config.Wait,
-		maxBatch: config.MaxBatch,
-		cache:    NewUserLoaderMapCache(),
-	}
+	// TTL expires fetched and primed values after this long, so they are fetched again on their next load.
+	// Values primed with UserLoaderWithTTL keep their own TTL. 0 = values are kept until they are cleared.
+	// TTLs are measured with the monotonic clock, so jumps of the wall clock don't expire values early or late.
+	TTL time.Duration
 
-	if config.Cache != nil {
-		dl.cache = config.Cache
-	}
+	// SortKeys, when set, puts the keys of each batch in a deterministic order before they are sent to Fetch
+	SortKeys func(keys []string)
 
-	return &dl
-}
+	// ClassifyError puts each failed key into an error class for ErrorCounts, nil = every error is UserLoaderErrorOther
+	ClassifyError func(key string, err error) UserLoaderErrorClass
 
-// UserLoader batches and caches requests
-type UserLoader struct {
-	// this method provides the data for the loader
-	fetch func(keys []string) ([]*example.User, []error)
+	// KeyPattern groups keys for PatternStats, eg. into "users:*" and "orgs:*" when one loader serves both kinds.
+	// UserLoaderKeyPatterns builds one from regular expressions. nil = loads aren't broken down by pattern.
+	KeyPattern func(key string) string
 
-	// how long to done before sending a batch
-	wait time.Duration
+	// OnDuplicateFetch is a development aid for finding resolvers that defeat batching. When set, it is called for
+	// every key that is fetched again after an earlier batch already returned it successfully, with the number of
+	// times it has now been fetched. Clearing a key resets its count.
+	OnDuplicateFetch func(key string, fetches int)
 
-	// this will limit the maximum number of keys to send in one batch, 0 = no limit
-	maxBatch int
+	// Strict checks that Fetch returned a value for every key, even the failed ones, and either no errors, a single
+	// error or an error for every key. Any other result fails the whole batch with a UserLoaderResultLengthError.
+	// Without it a batch only fails like that when there are more values than keys, more than one error but not one
+	// for every key, or a key got neither a value nor an error and the MissingPolicy is UserLoaderMissingAsFetched.
+	// Other MissingPolicies apply to the keys a short result left out.
+	Strict bool
 
-	// INTERNAL
+	// OnResultLengthError is called with the offending keys whenever a batch fails with a UserLoaderResultLengthError
+	OnResultLengthError func(keys []string, err *UserLoaderResultLengthError)
 
-	cache UserLoaderCache
+	// IsDeleted identifies soft deleted values, they are returned as ErrUserLoaderNotFound instead of being cached
+	IsDeleted func(value *example.User) bool
 
-	// the current batch. keys will continue to be collected until timeout is hit,
-	// then everything will be sent to the fetch method and out to the listeners
-	batch *userLoaderBatch
+	// Version returns the version of a value, eg. a row version or updated at timestamp. PrimeIfNewer uses it to
+	// only replace cached values with newer ones, and ForcePrime to never replace them with older ones.
+	Version func(value *example.User) int64
 
-	// mutex to prevent races
-	mu sync.Mutex
-}
+	// Transform is applied once to every fetched value before it is cached or checked, eg. to redact fields or
+	// normalize time zones, so every consumer sees the transformed value
+	Transform func(key string, value *example.User) *example.User
 
-type userLoaderBatch struct {
-	keys    []string
-	data    []*example.User
-	error   []error
-	closing bool
-	done    chan struct{}
-}
+	// ValidateValue checks every fetched value before it is cached, keys whose value fails get the error instead
+	// and aren't cached, so one corrupt row doesn't stick around
+	ValidateValue func(key string, value *example.User) error
 
-// Load a User by key, batching and caching will be applied automatically
-func (l *UserLoader) Load(key string) (*example.User, error) {
-	return l.LoadThunk(key)()
-}
+	// MaxValueBytes stops fetched values larger than this, as measured by ValueSize, from being cached. They are
+	// still returned, but a pathological row can't evict swathes of normal entries from a size bounded cache.
+	MaxValueBytes int
 
-// LoadThunk returns a function that when called will block waiting for a User.
-// This method should be used if you want one goroutine to make requests to many
-// different data loaders without blocking until the thunk is called.
-func (l *UserLoader) LoadThunk(key string) func() (*example.User, error) {
-	if it, ok := l.cache.Get(key); ok {
-		return func() (*example.User, error) {
-			return it, nil
-		}
-	}
-	l.mu.Lock()
-	if l.batch == nil {
-		l.batch = &userLoaderBatch{done: make(chan struct{})}
-	}
-	batch := l.batch
-	pos := batch.keyIndex(l, key)
-	l.mu.Unlock()
+	// ValueSize estimates the size of a value in bytes for MaxValueBytes
+	ValueSize func(value *example.User) int
 
-	return func() (*example.User, error) {
-		<-batch.done
+	// IndexBy returns the terms a cached value is indexed under, eg. "org:42" for a user in org 42, so ClearIndexed
+	// can clear every value with a term without scanning the cache
+	IndexBy func(value *example.User) []string
 
-		var data *example.User
-		if pos < len(batch.data) {
-			data = batch.data[pos]
-		}
+	// CacheDeleted remembers keys that IsDeleted matched, so they aren't fetched again until they are cleared
+	CacheDeleted bool
 
-		var err error
-		// its convenient to be able to return a single error for everything
-		if len(batch.error) == 1 {
-			err = batch.error[0]
-		} else if batch.error != nil {
-			err = batch.error[pos]
-		}
+	// ErrorTTL caches the errors CacheError accepts for this long, so hot keys that don't exist aren't fetched on
+	// every load. It is independent of TTL, values usually live much longer. 0 = errors aren't cached.
+	ErrorTTL time.Duration
 
-		if err == nil {
-			l.mu.Lock()
-			l.unsafeSet(key, data)
-			l.mu.Unlock()
-		}
+	// CacheError decides which errors ErrorTTL caches, nil = the ones wrapping ErrUserLoaderNotFound
+	CacheError func(key string, err error) bool
 
-		return data, err
+	// LoadAllNoCache stops LoadAll and LoadAllThunk from caching what they fetch, eg. for bulk listings that are
+	// never read again. They still read from the cache, and Load keeps caching.
+	LoadAllNoCache bool
+
+	// CollapseLoadAll makes a LoadAll of the exact same keys as one that is still running wait for it and share
+	// its result instead of loading again, eg. for polling clients. Stats counts them as Collapsed. When the
+	// LoadAll they wait for panics they get a UserLoaderPanicError for every key.
+	CollapseLoadAll bool
+
+	// LogSampleRate is the fraction of loads, from 0 to 1, that are passed to LogSample
+	LogSampleRate float64
+
+	// LogSample is called for the sampled loads once they return, eg. to log them
+	LogSample func(sample UserLoaderLoadSample)
+
+	// KeyLocker protects a cache shared between processes from stampedes. Only the process holding a key's lock
+	// fetches it, the others wait up to KeyLockWait for it to show up in the cache before fetching it themselves.
+	KeyLocker UserLoaderKeyLocker
+
+	// KeyLockWait is how long to wait for another process to fill the cache, 0 = UserLoaderDefaultKeyLockWait
+	KeyLockWait time.Duration
+
+	// Hedge sends a second fetch for the same keys when a batch takes longer than the p99 of recent fetches, and
+	// uses whichever returns first. The ctx of the slower FetchContext or FetchConn is cancelled, with Fetch its
+	// result is dropped. Fetch must be safe to call twice for the same keys.
+	Hedge bool
+
+	// MaxRetries fetches keys that failed with an error Retryable accepts again, up to this many times, so
+	// transient backend failures don't reach every caller of the batch. Only the failed keys are fetched again.
+	MaxRetries int
+
+	// Backoff returns how long to wait before retry attempt, counting from 1, eg. UserLoaderExponentialBackoff
+	// with some jitter. nil = UserLoaderDefaultBackoff.
+	Backoff func(attempt int) time.Duration
+
+	// Retryable decides which errors are retried, nil = every error but the ones wrapping ErrUserLoaderNotFound
+	// or ErrUserLoaderCircuitOpen
+	Retryable func(err error) bool
+
+	// Breaker short-circuits fetches while the backend is failing, batches it doesn't allow fail right away with
+	// ErrUserLoaderCircuitOpen instead of calling Fetch. UserLoaderCircuitBreaker trips on the rate of failed fetches.
+	Breaker UserLoaderBreaker
+
+	// BatchKey partitions keys into separate batches, eg. by tenant or locale when their fetches differ. Keys are
+	// still batched within each partition. By default all keys share one batch.
+	BatchKey func(key string) string
+
+	// MissingPolicy decides what loads of missing keys return, by default they return whatever Fetch returned
+	MissingPolicy UserLoaderMissingPolicy
+
+	// Owner is the context of the request a per request loader belongs to. Loads after it is done panic with
+	// ErrUserLoaderOwnerDone, catching loaders captured by a background goroutine that outlives the request. It is
+	// meant for development, leave it unset in production.
+	Owner context.Context
+
+	// DetectDeadlocks makes loads from within Fetch that don't pass on the ctx of FetchContext fail with
+	// ErrUserLoaderDeadlock, instead of hanging once they wait on their own batch or on a Pool worker or
+	// MaxConcurrentBatches slot the fetch is holding. It tells goroutines apart by their stack traces, so it is
+	// meant for development, leave it unset in production.
+	DetectDeadlocks bool
+
+	// DetectMutations catches values that were changed in place after they were cached, eg. by a caller writing
+	// through a pointer it shares with the cache. Values are checksummed, as encoding/json encodes them, when they
+	// are cached and again whenever a load or CheckMutations reads them. It is slow, so it is meant for
+	// development, leave it unset in production.
+	DetectMutations bool
+
+	// OnMutation is called with the keys DetectMutations caught, nil = loads of them panic with ErrUserLoaderMutated
+	OnMutation func(key string, value *example.User)
+
+	// ClosedPolicy decides what happens to loads once the loader is closed, by default they fail with ErrUserLoaderClosed
+	ClosedPolicy UserLoaderClosedPolicy
+
+	// Pool runs the fetches of this loader, so many loaders can share a bounded number of goroutines. By default every
+	// batch is fetched on a goroutine of its own.
+	Pool UserLoaderPool
+
+	// MaxConcurrentBatches limits how many batches of this loader are fetched at once, later batches queue until
+	// one returns. 0 = no limit.
+	MaxConcurrentBatches int
+
+	// MaxPendingKeys caps how many keys may wait in batches that haven't returned yet, across all of them. Loads
+	// that would add more fail right away with a UserLoaderOverloadError, so batches backing up during an outage
+	// can't exhaust memory. Loads of keys already waiting in the batch they would join are let through. 0 = no limit.
+	MaxPendingKeys int
+
+	// OnBackpressure is called with QueueDepth whenever a batch has to queue for MaxConcurrentBatches, so upstream
+	// layers can shed load, eg. by failing requests early while the queue is deep
+	OnBackpressure func(queued int)
+
+	// Tracer is told about every batch and the callers waiting on it, eg. UserLoaderOTel to trace them. Only callers
+	// loading with a context, eg. with LoadContext, are passed on.
+	Tracer UserLoaderTracer
+
+	// PropagateContext copies values, eg. the tenant, locale or trace ID, from the ctx of the first caller that
+	// loaded a key of a batch with a context into the ctx FetchContext gets, see UserLoaderContextKeys. Without it
+	// the ctx of a fetch carries none of the callers' values.
+	PropagateContext func(from, to context.Context) context.Context
+
+	// Metrics is told about every batch and cache lookup, eg. UserLoaderPrometheus to see how well Wait and
+	// MaxBatch are tuned
+	Metrics UserLoaderMetrics
+
+	// StatsWindow is the longest period WindowStats can report on, 0 = no window stats are kept
+	StatsWindow time.Duration
+
+	// HotKeys is how many of the most loaded keys are tracked for HotKeys, 0 = hot keys aren't tracked
+	HotKeys int
+}
+
+// UserLoaderPerRequest returns a config for loaders created for every request: a short wait, batches of up to 100
+// keys and the default map cache, which lives exactly as long as the loader.
+func UserLoaderPerRequest(fetch func(keys []string) ([]*example.User, []error)) UserLoaderConfig {
+	return UserLoaderConfig{
+		Fetch:    fetch,
+		Wait:     2 * time.Millisecond,
+		MaxBatch: 100,
 	}
 }
 
-// LoadAll fetches many keys at once. It will be broken into appropriate sized
-// sub batches depending on how the loader is configured
-func (l *UserLoader) LoadAll(keys []string) ([]*example.User, []error) {
-	results := make([]func() (*example.User, error), len(keys))
+// UserLoaderLongLived returns a config for loaders shared between requests, values are cached in go-cache and
+// expire after 5 minutes so changes made elsewhere are eventually picked up.
+func UserLoaderLongLived(fetch func(keys []string) ([]*example.User, []error)) UserLoaderConfig {
+	return UserLoaderConfig{
+		Fetch:    fetch,
+		Wait:     2 * time.Millisecond,
+		MaxBatch: 100,
+		Cache: NewUserLoaderGoCache(UserLoaderGoCacheConfig{
+			DefaultExpiration: 5 * time.Minute,
+			CleanupInterval:   10 * time.Minute,
+		}),
+	}
+}
 
-	for i, key := range keys {
-		results[i] = l.LoadThunk(key)
+// UserLoaderLongLivedLRU returns a config for loaders shared between requests that must not grow without bound,
+// the maxEntries most recently used values are kept in a UserLoaderLRUCache.
+func UserLoaderLongLivedLRU(fetch func(keys []string) ([]*example.User, []error), maxEntries int) UserLoaderConfig {
+	return UserLoaderConfig{
+		Fetch:    fetch,
+		Wait:     2 * time.Millisecond,
+		MaxBatch: 100,
+		Cache:    NewUserLoaderLRUCache(maxEntries),
 	}
+}
 
-	users := make([]*example.User, len(keys))
-	errors := make([]error, len(keys))
-	for i, thunk := range results {
-		users[i], errors[i] = thunk()
+// UserLoaderDefaultWait is the Wait NewUserLoaderValidated uses when none is configured
+const UserLoaderDefaultWait = time.Millisecond
+
+// Validate reports the first setting that would make the loader misbehave
+func (c UserLoaderConfig) Validate() error {
+	switch {
+	case c.Fetch == nil && c.FetchContext == nil && c.FetchConn == nil:
+		return fmt.Errorf("UserLoader: Fetch, FetchContext or FetchConn is required")
+	case c.FetchConn != nil && c.Acquire == nil:
+		return fmt.Errorf("UserLoader: FetchConn needs an Acquire to get connections from")
+	case c.Wait < 0:
+		return fmt.Errorf("UserLoader: Wait must not be negative, got %s", c.Wait)
+	case c.TargetBatch < 0:
+		return fmt.Errorf("UserLoader: TargetBatch must not be negative, got %d (use 0 to always wait Wait)", c.TargetBatch)
+	case c.MinWait < 0 || (c.TargetBatch > 0 && c.MinWait > c.Wait):
+		return fmt.Errorf("UserLoader: MinWait must be between 0 and Wait (%s), got %s", c.Wait, c.MinWait)
+	case c.MaxBatch < 0:
+		return fmt.Errorf("UserLoader: MaxBatch must not be negative, got %d (use 0 for no limit)", c.MaxBatch)
+	case c.MaxConcurrentBatches < 0:
+		return fmt.Errorf("UserLoader: MaxConcurrentBatches must not be negative, got %d (use 0 for no limit)", c.MaxConcurrentBatches)
+	case c.MaxBatchOverflow < 0:
+		return fmt.Errorf("UserLoader: MaxBatchOverflow must not be negative, got %d", c.MaxBatchOverflow)
+	case c.MaxBatchOverflow > 0 && c.MaxBatch == 0:
+		return fmt.Errorf("UserLoader: MaxBatchOverflow needs a MaxBatch to overflow")
+	case c.StatsWindow < 0:
+		return fmt.Errorf("UserLoader: StatsWindow must not be negative, got %s", c.StatsWindow)
+	case c.HotKeys < 0:
+		return fmt.Errorf("UserLoader: HotKeys must not be negative, got %d", c.HotKeys)
+	case c.MaxPendingKeys < 0:
+		return fmt.Errorf("UserLoader: MaxPendingKeys must not be negative, got %d (use 0 for no limit)", c.MaxPendingKeys)
+	case c.MaxRetries < 0:
+		return fmt.Errorf("UserLoader: MaxRetries must not be negative, got %d", c.MaxRetries)
+	case c.TTL < 0:
+		return fmt.Errorf("UserLoader: TTL must not be negative, got %s", c.TTL)
+	case c.ErrorTTL < 0:
+		return fmt.Errorf("UserLoader: ErrorTTL must not be negative, got %s", c.ErrorTTL)
+	case c.LogSampleRate < 0 || c.LogSampleRate > 1:
+		return fmt.Errorf("UserLoader: LogSampleRate must be between 0 and 1, got %g", c.LogSampleRate)
+	case c.MaxValueBytes < 0:
+		return fmt.Errorf("UserLoader: MaxValueBytes must not be negative, got %d", c.MaxValueBytes)
+	case c.MaxValueBytes > 0 && c.ValueSize == nil:
+		return fmt.Errorf("UserLoader: MaxValueBytes needs a ValueSize to measure values")
 	}
-	return users, errors
+	return nil
 }
 
-// LoadAllThunk returns a function that when called will block waiting for a Users.
-// This method should be used if you want one goroutine to make requests to many
-// different data loaders without blocking until the thunk is called.
-func (l *UserLoader) LoadAllThunk(keys []string) func() ([]*example.User, []error) {
-	results := make([]func() (*example.User, error), len(keys))
-	for i, key := range keys {
-		results[i] = l.LoadThunk(key)
+// NewUserLoaderValidated creates a new UserLoader like NewUserLoader, but fills in UserLoaderDefaultWait when Wait is
+// zero and returns an error for an invalid config instead of a loader that fails under load.
+func NewUserLoaderValidated(config UserLoaderConfig) (*UserLoader, error) {
+	if config.Wait == 0 {
+		config.Wait = UserLoaderDefaultWait
 	}
-	return func() ([]*example.User, []error) {
-		users := make([]*example.User, len(keys))
-		errors := make([]error, len(keys))
-		for i, thunk := range results {
-			users[i], errors[i] = thunk()
-		}
-		return users, errors
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
+	return NewUserLoader(config), nil
 }
 
-// Prime the cache with the provided key and value. If the key already exists, no change is made
-// and false is returned.
-// (To forcefully prime the cache, clear the key first with loader.clear(key).prime(key, value).)
-func (l *UserLoader) Prime(key string, value *example.User) bool {
-	var found bool
-	if _, found = l.cache.Get(key); !found {
-		// make a copy when writing to the cache, its easy to pass a pointer in from a loop var
-		// and end up with the whole cache pointing to the same value.
-		cpy := *value
-		l.unsafeSet(key, &cpy)
+// NewUserLoader creates a new UserLoader given a fetch, wait, and maxBatch
+func NewUserLoader(config UserLoaderConfig) *UserLoader {
+	dl := UserLoader{
+		cache: NewUserLoaderMapCache(),
+		meta:  map[string]*UserLoaderEntryMeta{},
 	}
-	return !found
-}
+	dl.configure(config)
 
-// Clear the value at key from the cache, if it exists
-func (l *UserLoader) Clear(key string) {
-	l.cache.ClearKey(key)
-}
+	if config.Cache != nil {
+		dl.cache = config.Cache
+	}
+	if cache, ok := dl.cache.(UserLoaderEvictingCache); ok {
+		cache.OnEvict(dl.evicted)
+	}
 
-func (l *UserLoader) unsafeSet(key string, value *example.User) {
-	if l.cache == nil {
-		l.cache = NewUserLoaderMapCache()
+	if config.StatsWindow > 0 {
+		dl.window = newuserLoaderStatsWindow(config.StatsWindow)
 	}
-	l.cache.Set(key, value)
-}
 
-// keyIndex will return the location of the key in the batch, if its not found
-// it will add the key to the batch
-func (b *userLoaderBatch) keyIndex(l *UserLoader, key string) int {
-	for i, existingKey := range b.keys {
-		if key == existingKey {
-			return i
-		}
+	if config.HotKeys > 0 {
+		dl.hotKeys = newuserLoaderHotKeys(config.HotKeys)
 	}
 
-	pos := len(b.keys)
-	b.keys = append(b.keys, key)
-	if pos == 0 {
-		go b.startTimer(l)
+	return &dl
+}
+
+// NewUserLoaderSecondary creates a loader for a secondary key of primary's values, eg. a slug next to an ID. The
+// Fetch of config resolves secondary keys and keyOf returns the primary key of a value. Values loaded either way
+// are cached once, in primary, so the two loaders never hold diverging copies. The Cache of config is ignored.
+func NewUserLoaderSecondary(primary *UserLoader, config UserLoaderConfig, keyOf func(value *example.User) string) *UserLoader {
+	config.Cache = &userLoaderSecondaryCache{
+		primary: primary,
+		keyOf:   keyOf,
+		keys:    map[string]string{},
 	}
+	return NewUserLoader(config)
+}
 
-	if l.maxBatch != 0 && pos >= l.maxBatch-1 {
-		if !b.closing {
-			b.closing = true
-			l.batch = nil
-			go b.end(l)
-		}
+// userLoaderSecondaryCache remembers the primary key of each secondary key and keeps the values in the
+// primary loader
+type userLoaderSecondaryCache struct {
+	primary *UserLoader
+	keyOf   func(value *example.User) string
+	mu      sync.Mutex
+	keys    map[string]string
+}
+
+func (c *userLoaderSecondaryCache) Get(key string) (*example.User, bool) {
+	c.mu.Lock()
+	primaryKey, ok := c.keys[key]
+	c.mu.Unlock()
+
+	if !ok {
+		var zero *example.User
+		return zero, false
 	}
+	return c.primary.cache.Get(primaryKey)
+}
+
+func (c *userLoaderSecondaryCache) Set(key string, value *example.User) {
+	primaryKey := c.keyOf(value)
+
+	c.mu.Lock()
+	c.keys[key] = primaryKey
+	c.mu.Unlock()
+
+	c.primary.mu.Lock()
+	c.primary.unsafeSet(primaryKey, value, 0)
+	c.primary.mu.Unlock()
+	c.primary.flushWrites()
+}
+
+// ClearKey forgets the secondary key, the value stays cached under its primary key
+func (c *userLoaderSecondaryCache) ClearKey(key string) {
+	c.mu.Lock()
+	delete(c.keys, key)
+	c.mu.Unlock()
+}
 
-	return pos
+// Clear forgets every secondary key
+func (c *userLoaderSecondaryCache) Clear() {
+	c.mu.Lock()
+	c.keys = map[string]string{}
+	c.mu.Unlock()
 }
 
-func (b *userLoaderBatch) startTimer(l *UserLoader) {
-	time.Sleep(l.wait)
+// UserLoaderOption changes the config of a live loader, see Apply
+type UserLoaderOption func(config *UserLoaderConfig)
+
+// Apply changes the config of a live loader, eg. to swap hooks from a dynamic config system. All options are
+// applied at once under the loader's lock, so a batch sees either the old or the new config, never a mix.
+// Cache, StatsWindow and HotKeys are fixed when the loader is created, changes to them are ignored.
+func (l *UserLoader) Apply(opts ...UserLoaderOption) {
 	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	// we must have hit a batch limit and are already finalizing this batch
-	if b.closing {
-		l.mu.Unlock()
-		return
+	config := l.config()
+	for _, opt := range opts {
+		opt(&config)
 	}
+	l.configure(config)
+}
 
-	l.batch = nil
-	l.mu.Unlock()
+// SetFetch swaps the fetch function of a live loader, eg. to move to a read replica during an incident, keeping
+// its cache. Batches whose fetch already started finish with the old function, all others use fetch. It replaces
+// FetchContext and FetchConn as well, a loader configured with either fetches with fetch from then on.
+func (l *UserLoader) SetFetch(fetch func(keys []string) ([]*example.User, []error)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-	b.end(l)
+	l.fetch = fetch
+	l.fetchContext = nil
+	l.fetchConn = nil
 }
 
-func (b *userLoaderBatch) end(l *UserLoader) {
-	b.data, b.error = l.fetch(b.keys)
-	close(b.done)
+// config returns the current config of the loader, it must be called with the loader locked
+func (l *UserLoader) config() UserLoaderConfig {
+	return UserLoaderConfig{
+		Fetch:                l.fetch,
+		FetchContext:         l.fetchContext,
+		FetchConn:            l.fetchConn,
+		Acquire:              l.acquire,
+		Wait:                 l.wait,
+		TargetBatch:          l.targetBatch,
+		MinWait:              l.minWait,
+		MaxBatch:             l.maxBatch,
+		MaxBatchOverflow:     l.maxBatchOverflow,
+		Cache:                l.cache,
+		TTL:                  l.ttl,
+		SortKeys:             l.sortKeys,
+		ClassifyError:        l.classifyError,
+		KeyPattern:           l.keyPattern,
+		OnDuplicateFetch:     l.onDuplicateFetch,
+		Strict:               l.strict,
+		OnResultLengthError:  l.onResultLengthError,
+		IsDeleted:            l.isDeleted,
+		Version:              l.version,
+		Transform:            l.transform,
+		ValidateValue:        l.validateValue,
+		MaxValueBytes:        l.maxValueBytes,
+		ValueSize:            l.valueSize,
+		IndexBy:              l.indexBy,
+		CacheDeleted:         l.cacheDeleted,
+		ErrorTTL:             l.errorTTL,
+		CacheError:           l.cacheError,
+		LoadAllNoCache:       l.loadAllNoCache,
+		CollapseLoadAll:      l.collapseLoadAll,
+		LogSampleRate:        l.logSampleRate,
+		LogSample:            l.logSample,
+		KeyLocker:            l.keyLocker,
+		KeyLockWait:          l.keyLockWait,
+		Hedge:                l.hedge,
+		MaxRetries:           l.maxRetries,
+		Backoff:              l.backoff,
+		Retryable:            l.retryable,
+		Breaker:              l.breaker,
+		BatchKey:             l.batchKey,
+		MissingPolicy:        l.missingPolicy,
+		Owner:                l.owner,
+		DetectDeadlocks:      l.detectDeadlocks,
+		DetectMutations:      l.detectMutations,
+		OnMutation:           l.onMutation,
+		ClosedPolicy:         l.closedPolicy,
+		Pool:                 l.pool,
+		MaxConcurrentBatches: l.maxConcurrentBatches,
+		MaxPendingKeys:       l.maxPendingKeys,
+		OnBackpressure:       l.onBackpressure,
+		Metrics:              l.metrics,
+		Tracer:               l.tracer,
+		PropagateContext:     l.propagateContext,
+	}
+}
+
+// configure applies the parts of config that can change at runtime, it must be called with the loader locked
+func (l *UserLoader) configure(config UserLoaderConfig) {
+	l.fetch = config.Fetch
+	l.fetchContext = config.FetchContext
+	l.fetchConn = config.FetchConn
+	l.acquire = config.Acquire
+	l.wait = config.Wait
+	l.targetBatch = config.TargetBatch
+	l.minWait = config.MinWait
+	l.maxBatch = config.MaxBatch
+	l.maxBatchOverflow = config.MaxBatchOverflow
+	l.ttl = config.TTL
+	l.sortKeys = config.SortKeys
+	l.classifyError = config.ClassifyError
+	l.keyPattern = config.KeyPattern
+	l.onDuplicateFetch = config.OnDuplicateFetch
+	l.strict = config.Strict
+	l.onResultLengthError = config.OnResultLengthError
+	l.isDeleted = config.IsDeleted
+	l.version = config.Version
+	l.transform = config.Transform
+	l.validateValue = config.ValidateValue
+	l.maxValueBytes = config.MaxValueBytes
+	l.valueSize = config.ValueSize
+	l.indexBy = config.IndexBy
+	l.cacheDeleted = config.CacheDeleted
+	l.errorTTL = config.ErrorTTL
+	l.cacheError = config.CacheError
+	l.loadAllNoCache = config.LoadAllNoCache
+	l.collapseLoadAll = config.CollapseLoadAll
+	l.logSampleRate = config.LogSampleRate
+	l.logSample = config.LogSample
+	l.keyLocker = config.KeyLocker
+	l.keyLockWait = config.KeyLockWait
+	l.hedge = config.Hedge
+	l.maxRetries = config.MaxRetries
+	l.backoff = config.Backoff
+	l.retryable = config.Retryable
+	l.breaker = config.Breaker
+	l.batchKey = config.BatchKey
+	l.missingPolicy = config.MissingPolicy
+	l.owner = config.Owner
+	l.detectDeadlocks = config.DetectDeadlocks
+	l.detectMutations = config.DetectMutations
+	l.onMutation = config.OnMutation
+	l.closedPolicy = config.ClosedPolicy
+	l.pool = config.Pool
+	l.maxConcurrentBatches = config.MaxConcurrentBatches
+	l.maxPendingKeys = config.MaxPendingKeys
+	l.onBackpressure = config.OnBackpressure
+	l.metrics = config.Metrics
+	l.tracer = config.Tracer
+	l.propagateContext = config.PropagateContext
+}
+
+// UserLoader batches and caches requests
+type UserLoader struct {
+	// this method provides the data for the loader
+	fetch func(keys []string) ([]*example.User, []error)
+
+	// this replaces fetch when set
+	fetchContext func(ctx context.Context, keys []string) ([]*example.User, []error)
+
+	// when set, batches are fetched on a connection acquire checks out
+	fetchConn func(ctx context.Context, conn UserLoaderConn, keys []string) ([]*example.User, []error)
+	acquire   func(ctx context.Context, fetch func(conn UserLoaderConn)) error
+
+	// how long to done before sending a batch
+	wait time.Duration
+
+	// how many keys a batch should wait for and how short its wait may get, 0 = batches always wait for wait
+	targetBatch int
+	minWait     time.Duration
+
+	// the average time between new keys, only tracked when targetBatch is set
+	arrivals userLoaderArrivals
+
+	// this will limit the maximum number of keys to send in one batch, 0 = no limit
+	maxBatch int
+
+	// how far past maxBatch a batch may grow to fit a whole LoadAll
+	maxBatchOverflow int
+
+	// this orders the keys of a batch before fetching, nil = keys are sent in the order they were requested
+	sortKeys func(keys []string)
+
+	// this decides which error class a failed key is counted in
+	classifyError func(key string, err error) UserLoaderErrorClass
+
+	// this groups keys for patternStats
+	keyPattern func(key string) string
+
+	// this is told about keys that are fetched more than once
+	onDuplicateFetch func(key string, fetches int)
+
+	// when set, fetch results of the wrong length fail the batch
+	strict bool
+
+	// this is told about batches failed by strict
+	onResultLengthError func(keys []string, err *UserLoaderResultLengthError)
+
+	// this identifies soft deleted values
+	isDeleted func(value *example.User) bool
+
+	// this orders values for PrimeIfNewer
+	version func(value *example.User) int64
+
+	// this is applied to fetched values before they are cached
+	transform func(key string, value *example.User) *example.User
+
+	// this checks fetched values before they are cached
+	validateValue func(key string, value *example.User) error
+
+	// values larger than this aren't cached, 0 = no limit
+	maxValueBytes int
+
+	// this measures values for maxValueBytes
+	valueSize func(value *example.User) int
+
+	// this returns the index terms of a value
+	indexBy func(value *example.User) []string
+
+	// when set, keys of soft deleted values are remembered
+	cacheDeleted bool
+
+	// how long errors are cached, 0 = they aren't
+	errorTTL time.Duration
+
+	// this picks the errors to cache, nil = not found errors
+	cacheError func(key string, err error) bool
+
+	// when set, LoadAll doesn't cache what it fetches
+	loadAllNoCache bool
+
+	// when set, identical LoadAlls that overlap share one result
+	collapseLoadAll bool
+
+	// the fraction of loads passed to logSample
+	logSampleRate float64
+
+	// this is told about sampled loads
+	logSample func(sample UserLoaderLoadSample)
+
+	// this keeps processes sharing a cache from fetching the same keys
+	keyLocker UserLoaderKeyLocker
+
+	// how long to wait for another process holding a key's lock
+	keyLockWait time.Duration
+
+	// when set, slow fetches are hedged
+	hedge bool
+
+	// how often failed keys are fetched again, how long to wait in between, and which errors are worth it
+	maxRetries int
+	backoff    func(attempt int) time.Duration
+	retryable  func(err error) bool
+
+	// this short-circuits fetches while the backend is failing
+	breaker UserLoaderBreaker
+
+	// this partitions keys into batches, nil = one batch for all keys
+	batchKey func(key string) string
+
+	// what to return for missing keys
+	missingPolicy UserLoaderMissingPolicy
+
+	// loads after this is done panic, nil = no check
+	owner context.Context
+
+	// when set, loads from within fetch without its ctx fail
+	detectDeadlocks bool
+
+	// when set, cached values are checksummed to catch changes made in place
+	detectMutations bool
+	onMutation      func(key string, value *example.User)
+
+	// what to do with loads after close
+	closedPolicy UserLoaderClosedPolicy
+
+	// this runs fetches, nil = a new goroutine per batch
+	pool UserLoaderPool
+
+	// this limits the batches fetching at once, 0 = no limit
+	maxConcurrentBatches int
+
+	// the most keys that may be pending at once, 0 = no limit
+	maxPendingKeys int
+
+	// this is called when a batch queues for maxConcurrentBatches
+	onBackpressure func(queued int)
+
+	// this is told about batches and cache lookups
+	metrics UserLoaderMetrics
+
+	// this traces batches
+	tracer UserLoaderTracer
+
+	// copies values of the first caller's ctx into the ctx of the fetch
+	propagateContext func(from, to context.Context) context.Context
+
+	// how long values stay cached, 0 = until they are cleared
+	ttl time.Duration
+
+	// INTERNAL
+
+	cache UserLoaderCache
+
+	// cache writes made with the loader locked, flushWrites applies them once it is unlocked
+	writes []userLoaderCacheWrite
+
+	// keeps flushWrites applying queued writes in the order they were made
+	writeMu sync.Mutex
+
+	// what the loader knows about each key it wrote to the cache
+	meta map[string]*UserLoaderEntryMeta
+
+	// rolling hit/miss/batch counters, nil when StatsWindow is not set
+	window *userLoaderStatsWindow
+
+	// approximate load counts of the hottest keys, nil when HotKeys is not set
+	hotKeys *userLoaderHotKeys
+
+	// number of failed keys per error class
+	errorCounts map[UserLoaderErrorClass]int
+
+	// the counters of each key pattern, only kept when keyPattern is set
+	patternStats map[string]*UserLoaderPatternStats
+
+	// keys that are known to be absent, ie. soft deleted ones when cacheDeleted is set and the ones
+	// LoadOptional didn't find, with when they expire like a value cached with ttl would. Zero = never.
+	deleted map[string]time.Time
+
+	// the errors cached for errorTTL
+	errored map[string]userLoaderCachedError
+
+	// the primes PrimePending staged, until they are committed or rolled back
+	staged map[string]*userLoaderStaged
+
+	// the LoadAlls that are running, by their keys, only tracked when collapseLoadAll is set
+	collapsing map[string]*userLoaderCollapsed
+
+	// batches that haven't returned yet, so close can wait for them
+	inflight map[*userLoaderBatch]struct{}
+
+	// one token per batch that is fetching, sized to maxConcurrentBatches
+	slots chan struct{}
+
+	// number of closed batches that haven't started fetching yet
+	queued int
+
+	// the batches being fetched by goroutine id, only tracked when detectDeadlocks is set
+	fetchers map[uint64]*userLoaderBatch
+
+	// set once the loader is closed
+	closed bool
+
+	// number of batches each key is waiting on, and their sum
+	pending     map[string]int
+	pendingKeys int
+
+	// the keys cached under each index term and the terms of each key, only tracked when indexBy is set
+	index map[string]map[string]struct{}
+	terms map[string][]string
+
+	// lifetime counters, the derived fields are filled in by Stats
+	stats UserLoaderStats
+
+	// durations of recent fetches
+	latencies userLoaderLatencies
+
+	// how many times each key was fetched successfully, only tracked when onDuplicateFetch is set
+	fetchCounts map[string]int
+
+	// the current batch of each partition. keys will continue to be collected until timeout is hit,
+	// then everything will be sent to the fetch method and out to the listeners
+	batches map[string]*userLoaderBatch
+
+	// mutex to prevent races
+	mu sync.Mutex
+}
+
+type userLoaderBatch struct {
+	partition string
+	keys      []string
+	claims    []int
+	data      []*example.User
+	error     []error
+	oversized map[int]bool
+	index     map[string]int
+	closing   bool
+	done      chan struct{}
+
+	// whether the fetch has started, and the keys cleared since then. Values of stale keys aren't cached, they may
+	// have been read before whatever write the clear was for.
+	fetching bool
+	stale    map[int]bool
+	staleAll bool
+
+	// how many waiters could still cancel, and whether any can't. Once every waiter has cancelled the batch is
+	// abandoned, it isn't fetched at all when that happens before its fetch starts and the ctx of FetchContext is
+	// cancelled when it happens after.
+	contexts  int
+	detached  bool
+	abandoned bool
+	cancel    context.CancelFunc
+
+	// the contexts of the callers waiting on the batch, only kept when the loader has a tracer
+	callers []context.Context
+
+	// the ctx of the first caller that loaded with one, only kept when the loader propagates context values
+	origin context.Context
+
+	// the fetch this batch was loaded from, when a FetchContext loads more keys. Such batches skip the Pool
+	// and MaxConcurrentBatches, the fetch waiting on them holds a slot already.
+	parent *userLoaderFetch
+
+	// identifies the batch to FetchContext, it is set once the batch is fetched
+	id string
+}
+
+// Load a User by key, batching and caching will be applied automatically
+func (l *UserLoader) Load(key string) (*example.User, error) {
+	return l.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a User.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *UserLoader) LoadThunk(key string) func() (*example.User, error) {
+	thunk, _ := l.LoadThunkWithRelease(key)
+	return thunk
+}
+
+// LoadContext loads a User by key like Load, but gives up with ctx.Err() once ctx is done. The ctx
+// also counts towards the context FetchContext gets.
+func (l *UserLoader) LoadContext(ctx context.Context, key string) (*example.User, error) {
+	return l.LoadThunkContext(ctx, key)()
+}
+
+// LoadThunkContext works like LoadThunk, but the thunk gives up with ctx.Err() once ctx is done
+func (l *UserLoader) LoadThunkContext(ctx context.Context, key string) func() (*example.User, error) {
+	thunk, release, done := l.load(ctx, key, 1, false)
+	return func() (*example.User, error) {
+		select {
+		case <-done:
+		default:
+			select {
+			case <-done:
+			case <-ctx.Done():
+				release()
+				var zero *example.User
+				return zero, ctx.Err()
+			}
+		}
+		return thunk()
+	}
+}
+
+// LoadThunkWithRelease works like LoadThunk, but also returns a release func. Calling release tells the loader
+// that the thunk will never be called, so the batch stops holding on to its result. A released thunk can still
+// be called, it will load the key again.
+func (l *UserLoader) LoadThunkWithRelease(key string) (func() (*example.User, error), func()) {
+	return l.loadThunk(key, 1, false)
+}
+
+// loadThunk adds key to the current batch, remaining is the number of keys the caller is adding to the batch
+// including this one and bulk is set for LoadAll
+func (l *UserLoader) loadThunk(key string, remaining int, bulk bool) (func() (*example.User, error), func()) {
+	thunk, release, _ := l.load(context.Background(), key, remaining, bulk)
+	return thunk, release
+}
+
+// load is loadThunk for a caller waiting with ctx, done is closed once the thunk won't block anymore
+func (l *UserLoader) load(ctx context.Context, key string, remaining int, bulk bool) (thunk func() (*example.User, error), release func(), done <-chan struct{}) {
+	start := time.Now()
+	l.hotKeys.record(key)
+	if it, ok := l.cache.Get(key); ok && !l.expire(key) {
+		l.window.record(1, 0, 0)
+		l.mu.Lock()
+		l.checkOwner(key)
+		if l.closed {
+			config := l.config()
+			l.mu.Unlock()
+			thunk, release := l.closedThunk(config, key)
+			return thunk, release, userLoaderReady
+		}
+		var mutated bool
+		if meta, ok := l.meta[key]; ok {
+			meta.Hits++
+			mutated = l.detectMutations && meta.mutated(it)
+		}
+		l.stats.Hits++
+		if stats := l.patternOf(key); stats != nil {
+			stats.Loads++
+			stats.Hits++
+		}
+		logSample := l.sampleLog()
+		metrics := l.metrics
+		onMutation := l.onMutation
+		l.mu.Unlock()
+		if mutated {
+			if onMutation == nil {
+				panic(fmt.Errorf("%w: loading %s", ErrUserLoaderMutated, userLoaderKeyString(key)))
+			}
+			onMutation(key, it)
+		}
+		if metrics != nil {
+			metrics.Hit()
+		}
+		if logSample != nil {
+			logSample(UserLoaderLoadSample{Key: key, Hit: true, Latency: time.Since(start)})
+		}
+		return func() (*example.User, error) {
+			return it, nil
+		}, func() {}, userLoaderReady
+	}
+	l.window.record(0, 1, 0)
+	l.mu.Lock()
+	l.checkOwner(key)
+	if l.closed {
+		config := l.config()
+		l.mu.Unlock()
+		thunk, release := l.closedThunk(config, key)
+		return thunk, release, userLoaderReady
+	}
+	l.stats.Misses++
+	patternStats := l.patternOf(key)
+	if patternStats != nil {
+		patternStats.Loads++
+		patternStats.Misses++
+	}
+	metrics := l.metrics
+	if l.unsafeIsAbsent(key) {
+		missingPolicy := l.missingPolicy
+		l.mu.Unlock()
+		if metrics != nil {
+			metrics.Miss()
+		}
+		return func() (*example.User, error) {
+			var zero *example.User
+			if missingPolicy == UserLoaderMissingZero {
+				return zero, nil
+			}
+			return zero, ErrUserLoaderNotFound
+		}, func() {}, userLoaderReady
+	}
+	if cached, ok := l.errored[key]; ok {
+		if time.Now().Before(cached.expires) {
+			l.mu.Unlock()
+			if metrics != nil {
+				metrics.Miss()
+			}
+			return func() (*example.User, error) {
+				var zero *example.User
+				return zero, cached.err
+			}, func() {}, userLoaderReady
+		}
+		delete(l.errored, key)
+	}
+	parent, _ := ctx.Value(userLoaderFetchKey{}).(*userLoaderFetch)
+	if parent.has(l, key) {
+		l.mu.Unlock()
+		return func() (*example.User, error) {
+			var zero *example.User
+			return zero, ErrUserLoaderReentrant
+		}, func() {}, userLoaderReady
+	}
+	if l.detectDeadlocks && parent == nil {
+		if fetching := l.fetchers[userLoaderGoroutineID()]; fetching != nil {
+			err := fmt.Errorf("%w: loaded %s from within the fetch of %d keys, pass the ctx of FetchContext to LoadContext instead",
+				ErrUserLoaderDeadlock, userLoaderKeyString(key), len(fetching.keys))
+			l.mu.Unlock()
+			return func() (*example.User, error) {
+				var zero *example.User
+				return zero, err
+			}, func() {}, userLoaderReady
+		}
+	}
+	var partition string
+	if l.batchKey != nil {
+		partition = l.batchKey(key)
+	}
+	if l.maxPendingKeys > 0 && l.pendingKeys >= l.maxPendingKeys {
+		if b := l.batches[partition]; b == nil || !b.has(key) {
+			err := &UserLoaderOverloadError{Pending: l.pendingKeys, MaxPendingKeys: l.maxPendingKeys}
+			l.stats.Overloaded++
+			l.mu.Unlock()
+			return func() (*example.User, error) {
+				var zero *example.User
+				return zero, err
+			}, func() {}, userLoaderReady
+		}
+	}
+	batch := l.batches[partition]
+	if batch == nil {
+		batch = &userLoaderBatch{partition: partition, done: make(chan struct{})}
+		if l.batches == nil {
+			l.batches = map[string]*userLoaderBatch{}
+		}
+		l.batches[partition] = batch
+		if l.inflight == nil {
+			l.inflight = map[*userLoaderBatch]struct{}{}
+		}
+		l.inflight[batch] = struct{}{}
+	}
+	if patternStats != nil {
+		if _, ok := batch.position(key); ok {
+			patternStats.Coalesced++
+		}
+	}
+	pos, full := batch.keyIndex(l, key, l.batchLimit(batch, remaining))
+	batch.claims[pos]++
+	if parent != nil && batch.parent == nil {
+		batch.parent = parent
+	}
+	pool := l.pool
+	if batch.parent != nil {
+		pool = nil
+	}
+	store := !bulk || !l.loadAllNoCache
+	logSample := l.sampleLog()
+	if ctx.Done() == nil {
+		batch.detached = true
+	} else {
+		batch.contexts++
+		go batch.watch(l, ctx)
+	}
+	// the batch is still collecting keys, so it is wanted again even if every earlier waiter gave up
+	batch.abandoned = false
+	if l.tracer != nil && ctx != context.Background() {
+		batch.callers = append(batch.callers, ctx)
+	}
+	if l.propagateContext != nil && ctx != context.Background() && batch.origin == nil {
+		batch.origin = ctx
+	}
+	l.mu.Unlock()
+	if metrics != nil {
+		metrics.Miss()
+	}
+
+	if full {
+		if pool != nil {
+			pool.Go(func() { batch.end(l) })
+		} else {
+			go batch.end(l)
+		}
+	}
+
+	var once sync.Once
+	var released bool
+	var data *example.User
+	var err error
+
+	release = func() {
+		once.Do(func() {
+			released = true
+			batch.unclaim(l, pos)
+			batch = nil
+		})
+	}
+
+	thunk = func() (*example.User, error) {
+		once.Do(func() {
+			<-batch.done
+
+			if pos < len(batch.data) {
+				data = batch.data[pos]
+			}
+
+			err = batch.errorAt(pos)
+			cache := store && !batch.oversized[pos]
+			fetched := batch
+
+			batch.unclaim(l, pos)
+			batch = nil
+
+			if err == nil && cache {
+				l.mu.Lock()
+				if !fetched.staleAll && !fetched.stale[pos] {
+					l.unsafeSet(key, data, 0)
+				}
+				l.mu.Unlock()
+				l.flushWrites()
+			}
+
+			if logSample != nil {
+				logSample(UserLoaderLoadSample{Key: key, Latency: time.Since(start), Err: err, BatchID: fetched.id})
+			}
+		})
+
+		if released {
+			// load again for the same caller, one whose ctx is done gets its error without queueing the key
+			if err := ctx.Err(); err != nil {
+				var zero *example.User
+				return zero, err
+			}
+			return l.LoadContext(ctx, key)
+		}
+		return data, err
+	}
+
+	return thunk, release, batch.done
+}
+
+// userLoaderReady is the done channel of thunks that don't wait on a batch
+var userLoaderReady = func() chan struct{} {
+	ready := make(chan struct{})
+	close(ready)
+	return ready
+}()
+
+// IsPending reports whether key is part of a batch that hasn't returned yet, either because it is still
+// collecting keys or because its fetch is in flight. It never triggers a fetch.
+func (l *UserLoader) IsPending(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.pending[key] > 0
+}
+
+// Flush fetches the batches that are still collecting keys right away instead of waiting out Wait, eg. once a
+// resolver knows it has queued every key it needs. It doesn't wait for the fetches to return.
+func (l *UserLoader) Flush() {
+	l.mu.Lock()
+	var batches []*userLoaderBatch
+	for partition, b := range l.batches {
+		b.closing = true
+		delete(l.batches, partition)
+		l.queued++
+		batches = append(batches, b)
+	}
+	pool := l.pool
+	l.mu.Unlock()
+
+	for _, b := range batches {
+		b := b
+		if pool != nil && b.parent == nil {
+			pool.Go(func() { b.end(l) })
+		} else {
+			go b.end(l)
+		}
+	}
+}
+
+// LoadFresh loads a User by key like Load, but only accepts a cached value if it was cached within
+// maxAge. Older (or untracked) values are cleared and fetched again.
+func (l *UserLoader) LoadFresh(key string, maxAge time.Duration) (*example.User, error) {
+	l.mu.Lock()
+	meta, ok := l.meta[key]
+	fresh := ok && time.Since(meta.CachedAt) <= maxAge
+	l.mu.Unlock()
+
+	if !fresh {
+		l.Clear(key)
+	}
+	return l.Load(key)
+}
+
+// UserLoaderSession gives read-your-writes on top of a shared loader for the length of a request. Keys marked with
+// Wrote are fetched again on every load through the session instead of being served from the cache. What those
+// fetches return stays in the session, the shared loader and its cache are left as they are.
+type UserLoaderSession struct {
+	loader  *UserLoader
+	mu      sync.Mutex
+	written map[string]struct{}
+
+	// fetches written keys with the config of loader but without caching, nil until a written key is loaded
+	fresh *UserLoader
+}
+
+// Session starts a read-your-writes session, it is meant to be created per request
+func (l *UserLoader) Session() *UserLoaderSession {
+	return &UserLoaderSession{loader: l, written: map[string]struct{}{}}
+}
+
+// Wrote records that keys were mutated during the session
+func (s *UserLoaderSession) Wrote(keys ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.written[key] = struct{}{}
+	}
+}
+
+// Load a User by key, bypassing the cache for keys written during the session
+func (s *UserLoaderSession) Load(key string) (*example.User, error) {
+	return s.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a User, keys written during the
+// session are fetched again rather than served from the cache
+func (s *UserLoaderSession) LoadThunk(key string) func() (*example.User, error) {
+	s.mu.Lock()
+	_, written := s.written[key]
+	if written && s.fresh == nil {
+		s.loader.mu.Lock()
+		config := s.loader.config()
+		s.loader.mu.Unlock()
+		config.Cache = UserLoaderNoCache{}
+		s.fresh = NewUserLoader(config)
+	}
+	fresh := s.fresh
+	s.mu.Unlock()
+
+	if written {
+		return fresh.LoadThunk(key)
+	}
+	return s.loader.LoadThunk(key)
+}
+
+// LoadAll loads many keys at once, bypassing the cache for keys written during the session
+func (s *UserLoaderSession) LoadAll(keys []string) ([]*example.User, []error) {
+	thunks := make([]func() (*example.User, error), len(keys))
+	for i, key := range keys {
+		thunks[i] = s.LoadThunk(key)
+	}
+
+	users := make([]*example.User, len(keys))
+	errors := make([]error, len(keys))
+	for i, thunk := range thunks {
+		users[i], errors[i] = thunk()
+	}
+	return users, errors
+}
+
+// UserLoaderTx binds a loader to a database transaction. What is loaded or primed through the tx is cached in the
+// tx only, Commit copies it into the loader and Rollback discards it, so uncommitted data never reaches a shared
+// cache. After Commit or Rollback the tx loads through the loader itself.
+type UserLoaderTx struct {
+	loader *UserLoader
+
+	// caches what was loaded or primed through the tx, nil once the tx is done
+	tx      *UserLoader
+	mu      sync.Mutex
+	primed  map[string]struct{}
+	cleared map[string]struct{}
+}
+
+// Tx starts binding l to a transaction, fetch reads through the transaction so it sees its uncommitted writes.
+// Batches are collected with the wait and max batch of l.
+func (l *UserLoader) Tx(fetch func(keys []string) ([]*example.User, []error)) *UserLoaderTx {
+	l.mu.Lock()
+	config := UserLoaderConfig{Fetch: fetch, Wait: l.wait, MaxBatch: l.maxBatch}
+	l.mu.Unlock()
+
+	return &UserLoaderTx{
+		loader:  l,
+		tx:      NewUserLoader(config),
+		primed:  map[string]struct{}{},
+		cleared: map[string]struct{}{},
+	}
+}
+
+// Load a User by key through the transaction
+func (t *UserLoaderTx) Load(key string) (*example.User, error) {
+	return t.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a User loaded through the
+// transaction
+func (t *UserLoaderTx) LoadThunk(key string) func() (*example.User, error) {
+	return t.current().LoadThunk(key)
+}
+
+// LoadAll loads many keys at once through the transaction
+func (t *UserLoaderTx) LoadAll(keys []string) ([]*example.User, []error) {
+	return t.current().LoadAll(keys)
+}
+
+// Prime caches a value written in the transaction, it replaces whatever the tx cached for key before. The
+// loader's cache only gets it on Commit.
+func (t *UserLoaderTx) Prime(key string, value *example.User) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil {
+		t.loader.Clear(key)
+		t.loader.Prime(key, value)
+		return
+	}
+	t.tx.Clear(key)
+	t.tx.Prime(key, value)
+	t.primed[key] = struct{}{}
+	delete(t.cleared, key)
+}
+
+// Clear the value at key, eg. after deleting it in the transaction. The loader's cache is cleared on Commit.
+func (t *UserLoaderTx) Clear(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tx == nil {
+		t.loader.Clear(key)
+		return
+	}
+	t.tx.Clear(key)
+	t.cleared[key] = struct{}{}
+	delete(t.primed, key)
+}
+
+// Commit copies what the tx cached into the loader, call it once the transaction committed. Primed values
+// replace the ones the loader has, loaded ones only fill in keys it doesn't.
+func (t *UserLoaderTx) Commit() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tx == nil {
+		return
+	}
+
+	for key := range t.cleared {
+		t.loader.Clear(key)
+	}
+
+	t.tx.mu.Lock()
+	keys := make([]string, 0, len(t.tx.meta))
+	for key := range t.tx.meta {
+		keys = append(keys, key)
+	}
+	t.tx.mu.Unlock()
+
+	for _, key := range keys {
+		value, ok := t.tx.cache.Get(key)
+		if !ok {
+			continue
+		}
+		if _, primed := t.primed[key]; primed {
+			t.loader.Clear(key)
+		}
+		t.loader.Prime(key, value)
+	}
+	t.tx = nil
+}
+
+// Rollback discards everything the tx cached, call it once the transaction rolled back
+func (t *UserLoaderTx) Rollback() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tx = nil
+}
+
+// current is the loader loads go through, the tx one until the tx is done
+func (t *UserLoaderTx) current() *UserLoader {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.tx == nil {
+		return t.loader
+	}
+	return t.tx
+}
+
+// UserLoaderEntryMeta describes a cached value
+type UserLoaderEntryMeta struct {
+	// CachedAt is when the value was written to the cache
+	CachedAt time.Time
+
+	// Expires is when the value expires because of TTL or UserLoaderWithTTL, zero when the cache decides
+	Expires time.Time
+
+	// Hits is how many loads have been served from the cache since
+	Hits int
+
+	// the results of LoadThen, they go together with the value
+	derived map[*UserLoaderDerivation]interface{}
+
+	// the checksum of the value when it was cached, only kept when detectMutations is set
+	checksum uint64
+}
+
+// Entry returns the cached value for key, without fetching it, along with what the loader knows about the entry.
+// The meta is zero for values this loader didn't write itself, eg. ones that were put in a shared cache by others.
+func (l *UserLoader) Entry(key string) (*example.User, UserLoaderEntryMeta, bool) {
+	value, ok := l.cache.Get(key)
+	if !ok {
+		return value, UserLoaderEntryMeta{}, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var meta UserLoaderEntryMeta
+	if m, ok := l.meta[key]; ok {
+		meta = *m
+	}
+	return value, meta, true
+}
+
+// UserLoaderDerivation is a computation derived from a User, eg. an expensive transform of it. Loads
+// through LoadThen remember its result alongside the cached value.
+type UserLoaderDerivation struct {
+	derive func(value *example.User) (interface{}, error)
+}
+
+// NewUserLoaderDerivation creates a derivation, it is meant to be created once and reused for every load
+func NewUserLoaderDerivation(derive func(value *example.User) (interface{}, error)) *UserLoaderDerivation {
+	return &UserLoaderDerivation{derive: derive}
+}
+
+// LoadThen loads a User by key and returns what derivation derives from it. The result is cached
+// alongside the value, so it is derived once per cached value rather than on every load, and is dropped together
+// with it when the key is cleared, expires or is primed. Failed derivations are not cached.
+func (l *UserLoader) LoadThen(key string, derivation *UserLoaderDerivation) (interface{}, error) {
+	value, err := l.Load(key)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	meta := l.meta[key]
+	if meta != nil {
+		if result, ok := meta.derived[derivation]; ok {
+			l.mu.Unlock()
+			return result, nil
+		}
+	}
+	l.mu.Unlock()
+
+	result, err := derivation.derive(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// the value may have been replaced while deriving, the result only belongs with the one it was derived from
+	l.mu.Lock()
+	if meta != nil && l.meta[key] == meta {
+		if meta.derived == nil {
+			meta.derived = map[*UserLoaderDerivation]interface{}{}
+		}
+		meta.derived[derivation] = result
+	}
+	l.mu.Unlock()
+	return result, nil
+}
+
+// InvalidateAndReload clears key, fetches it again and caches the fresh value, which it returns. It is meant to be
+// called after a mutation to get read-your-writes behaviour.
+func (l *UserLoader) InvalidateAndReload(key string) (*example.User, error) {
+	l.Clear(key)
+
+	value, err := l.Load(key)
+	if err != nil {
+		return value, err
+	}
+
+	// a batch that was already in flight before the clear may have cached an older value in the meantime
+	l.mu.Lock()
+	l.unsafeSet(key, value, 0)
+	l.mu.Unlock()
+	l.flushWrites()
+
+	return value, nil
+}
+
+// LoadAll fetches many keys at once. It will be broken into appropriate sized
+// sub batches depending on how the loader is configured
+func (l *UserLoader) LoadAll(keys []string) ([]*example.User, []error) {
+	l.mu.Lock()
+	collapse := l.collapseLoadAll
+	l.mu.Unlock()
+	if collapse {
+		return l.collapsedLoadAll(keys)
+	}
+	return l.loadAll(keys)
+}
+
+func (l *UserLoader) loadAll(keys []string) ([]*example.User, []error) {
+	distinct, index := l.distinct(keys)
+	results := make([]func() (*example.User, error), len(distinct))
+
+	for i, key := range distinct {
+		results[i], _ = l.loadThunk(key, len(distinct)-i, true)
+	}
+
+	users := make([]*example.User, len(keys))
+	errors := make([]error, len(keys))
+	for i := range keys {
+		users[i], errors[i] = results[index[i]]()
+	}
+	return users, errors
+}
+
+// distinct returns keys without its duplicates, in the order they first appear, and the position in it of every key.
+// Duplicates would otherwise take up room in a batch, or end up fetched again in the next one. They still count as
+// loads for HotKeys.
+func (l *UserLoader) distinct(keys []string) ([]string, []int) {
+	distinct := make([]string, 0, len(keys))
+	index := make([]int, len(keys))
+	seen := make(map[string]int, len(keys))
+	for i, key := range keys {
+		pos, ok := seen[key]
+		if !ok {
+			pos = len(distinct)
+			seen[key] = pos
+			distinct = append(distinct, key)
+		} else {
+			l.hotKeys.record(key)
+		}
+		index[i] = pos
+	}
+	return distinct, index
+}
+
+type userLoaderCollapsed struct {
+	done   chan struct{}
+	values []*example.User
+	errors []error
+}
+
+// collapsedLoadAll shares the result of a LoadAll of the same keys that is still running, or runs one itself
+func (l *UserLoader) collapsedLoadAll(keys []string) ([]*example.User, []error) {
+	id := fmt.Sprintf("%#v", keys)
+
+	l.mu.Lock()
+	if c, ok := l.collapsing[id]; ok {
+		l.stats.Collapsed++
+		l.mu.Unlock()
+
+		<-c.done
+		values := make([]*example.User, len(c.values))
+		copy(values, c.values)
+		errors := make([]error, len(c.errors))
+		copy(errors, c.errors)
+		return values, errors
+	}
+	c := &userLoaderCollapsed{done: make(chan struct{})}
+	if l.collapsing == nil {
+		l.collapsing = map[string]*userLoaderCollapsed{}
+	}
+	l.collapsing[id] = c
+	l.mu.Unlock()
+
+	defer func() {
+		// the waiters get a panic of the LoadAll they share, eg. in LogSample, as an error instead of waiting forever
+		r := recover()
+		if r != nil {
+			err := &UserLoaderPanicError{Value: r, Stack: debug.Stack()}
+			c.values = make([]*example.User, len(keys))
+			c.errors = make([]error, len(keys))
+			for i := range c.errors {
+				c.errors[i] = err
+			}
+		}
+
+		l.mu.Lock()
+		delete(l.collapsing, id)
+		l.mu.Unlock()
+		close(c.done)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	values, errors := l.loadAll(keys)
+	c.values = make([]*example.User, len(values))
+	copy(c.values, values)
+	c.errors = make([]error, len(errors))
+	copy(c.errors, errors)
+	return values, errors
+}
+
+// LoadAllThunk returns a function that when called will block waiting for a Users.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *UserLoader) LoadAllThunk(keys []string) func() ([]*example.User, []error) {
+	distinct, index := l.distinct(keys)
+	results := make([]func() (*example.User, error), len(distinct))
+	for i, key := range distinct {
+		results[i], _ = l.loadThunk(key, len(distinct)-i, true)
+	}
+	return func() ([]*example.User, []error) {
+		users := make([]*example.User, len(keys))
+		errors := make([]error, len(keys))
+		for i := range keys {
+			users[i], errors[i] = results[index[i]]()
+		}
+		return users, errors
+	}
+}
+
+// LoadAllPartial loads many keys like LoadAll, but only waits until ctx is done. Keys that haven't returned by
+// then get ctx.Err() (eg. context.DeadlineExceeded) as their error and everything else is returned as it was loaded,
+// for best effort fan outs that would rather have some results in time than all of them late.
+func (l *UserLoader) LoadAllPartial(ctx context.Context, keys []string) ([]*example.User, []error) {
+	distinct, index := l.distinct(keys)
+	thunks := make([]func() (*example.User, error), len(distinct))
+	releases := make([]func(), len(distinct))
+	dones := make([]<-chan struct{}, len(distinct))
+	for i, key := range distinct {
+		thunks[i], releases[i], dones[i] = l.load(ctx, key, len(distinct)-i, true)
+	}
+
+	users := make([]*example.User, len(keys))
+	errors := make([]error, len(keys))
+	for i := range keys {
+		d := index[i]
+		select {
+		case <-dones[d]:
+		default:
+			select {
+			case <-dones[d]:
+			case <-ctx.Done():
+				releases[d]()
+				errors[i] = ctx.Err()
+				continue
+			}
+		}
+		users[i], errors[i] = thunks[d]()
+	}
+	return users, errors
+}
+
+// LoadAllParallel loads many keys like LoadAll, but fails fast like an errgroup: the first key to fail cancels the
+// loads still waiting and its error is returned, wrapped with the key. The thunks are resolved on up to parallel
+// goroutines, 0 = one per key. It returns ctx.Err() if ctx is done before every key has loaded.
+func (l *UserLoader) LoadAllParallel(ctx context.Context, keys []string, parallel int) ([]*example.User, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	distinct, index := l.distinct(keys)
+	thunks := make([]func() (*example.User, error), len(distinct))
+	for i, key := range distinct {
+		thunks[i] = l.LoadThunkContext(ctx, key)
+	}
+
+	// repeated keys share a thunk, so there is never more to resolve than there are distinct keys
+	if parallel <= 0 || parallel > len(distinct) {
+		parallel = len(distinct)
+	}
+	values := make([]*example.User, len(distinct))
+	var failed sync.Once
+	var firstErr error
+	next := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(parallel)
+	for w := 0; w < parallel; w++ {
+		go func() {
+			defer wg.Done()
+			for d := range next {
+				value, err := thunks[d]()
+				if err != nil {
+					failed.Do(func() {
+						firstErr = fmt.Errorf("UserLoader: loading %s: %w", userLoaderKeyString(distinct[d]), err)
+						cancel()
+					})
+					continue
+				}
+				values[d] = value
+			}
+		}()
+	}
+
+feed:
+	for d := range distinct {
+		select {
+		case next <- d:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	users := make([]*example.User, len(keys))
+	for i, d := range index {
+		users[i] = values[d]
+	}
+	return users, nil
+}
+
+// UserLoaderLoadSample describes a load picked by LogSampleRate
+type UserLoaderLoadSample struct {
+	Key string
+
+	// Hit is set when the value came from the cache
+	Hit bool
+
+	// Latency is the time from the load until its value was available
+	Latency time.Duration
+	Err     error
+
+	// BatchID is the ID of the batch the value was fetched in, see UserLoaderBatchID
+	BatchID string
+}
+
+// sampleLog returns logSample when this load should be sampled, it must be called with the loader locked
+func (l *UserLoader) sampleLog() func(sample UserLoaderLoadSample) {
+	if l.logSample == nil || l.logSampleRate <= 0 || rand.Float64() >= l.logSampleRate {
+		return nil
+	}
+	return l.logSample
+}
+
+// UserLoaderResult is the result of loading one of the keys passed to LoadAllStream
+type UserLoaderResult struct {
+	// Index is the position of Key in the keys passed to LoadAllStream
+	Index int
+	Key   string
+	Value *example.User
+	Err   error
+}
+
+// LoadAllStream loads many keys like LoadAll, but sends the results on the returned channel as soon as the batch
+// they are in returns, so a caller can start on them before the last batch is done. Cached keys are sent right away, the
+// channel is closed after the last result.
+func (l *UserLoader) LoadAllStream(keys []string) <-chan UserLoaderResult {
+	results := make(chan UserLoaderResult, len(keys))
+	distinct, index := l.distinct(keys)
+	thunks := make([]func() (*example.User, error), len(distinct))
+	dones := make([]<-chan struct{}, len(distinct))
+	for i, key := range distinct {
+		thunks[i], _, dones[i] = l.load(context.Background(), key, len(distinct)-i, true)
+	}
+
+	// group the keys by the batch they wait on, so there is one goroutine per batch rather than per key
+	var batches []<-chan struct{}
+	waiting := map[<-chan struct{}][]int{}
+	for i := range keys {
+		done := dones[index[i]]
+		if _, ok := waiting[done]; !ok {
+			batches = append(batches, done)
+		}
+		waiting[done] = append(waiting[done], i)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(batches))
+	for _, done := range batches {
+		go func(done <-chan struct{}, indexes []int) {
+			defer wg.Done()
+			<-done
+			for _, i := range indexes {
+				value, err := thunks[index[i]]()
+				results <- UserLoaderResult{Index: i, Key: keys[i], Value: value, Err: err}
+			}
+		}(done, waiting[done])
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	return results
+}
+
+// Prime the cache with the provided key and value. If the key already exists, no change is made
+// and false is returned. The cache is checked before the loader is locked, so a value cached by a load
+// in between may be replaced.
+// (To forcefully prime the cache, use ForcePrime.)
+func (l *UserLoader) Prime(key string, value *example.User, opts ...UserLoaderPrimeOption) bool {
+	var o userLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if _, found := l.cache.Get(key); found {
+		return false
+	}
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unsafePrime(key, value, o.ttl)
+	return true
+}
+
+// PrimeMany primes the cache with each of keys and the value at the same position in values, eg. the results of
+// a list query, in one go. Like Prime keys that are already cached are left alone. It returns how many values were
+// cached and panics if there aren't as many values as keys.
+func (l *UserLoader) PrimeMany(keys []string, values []*example.User, opts ...UserLoaderPrimeOption) int {
+	if len(keys) != len(values) {
+		panic(fmt.Sprintf("UserLoader: PrimeMany got %d keys and %d values", len(keys), len(values)))
+	}
+
+	var o userLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	missing := make([]int, 0, len(keys))
+	for i, key := range keys {
+		if _, found := l.cache.Get(key); !found {
+			missing = append(missing, i)
+		}
+	}
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, i := range missing {
+		l.unsafePrime(keys[i], values[i], o.ttl)
+	}
+	return len(missing)
+}
+
+// PrimeMap primes the cache with every key and value in values like PrimeMany
+func (l *UserLoader) PrimeMap(values map[string]*example.User, opts ...UserLoaderPrimeOption) int {
+	var o userLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	missing := make([]string, 0, len(values))
+	for key := range values {
+		if _, found := l.cache.Get(key); !found {
+			missing = append(missing, key)
+		}
+	}
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range missing {
+		l.unsafePrime(key, values[key], o.ttl)
+	}
+	return len(missing)
+}
+
+// PrimeIfNewer primes the cache like Prime, but also replaces a cached value when value has a higher Version,
+// so an out of order response can't regress the cache. It returns whether value was cached. Without a Version
+// configured it only primes missing keys.
+func (l *UserLoader) PrimeIfNewer(key string, value *example.User, opts ...UserLoaderPrimeOption) bool {
+	var o userLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	defer l.flushWrites()
+	cached, found := l.lockCached(key)
+	defer l.mu.Unlock()
+
+	if found && (l.version == nil || l.version(value) <= l.version(cached)) {
+		return false
+	}
+	l.unsafePrime(key, value, o.ttl)
+	return true
+}
+
+// lockCached reads the cached value of key and locks the loader, making sure nothing wrote key in between so a
+// check of the value is atomic with the write that follows. The cache itself is read with the loader unlocked.
+func (l *UserLoader) lockCached(key string) (*example.User, bool) {
+	for {
+		l.mu.Lock()
+		meta, queued := l.meta[key], l.unsafeQueued(key)
+		l.mu.Unlock()
+		if queued {
+			l.flushWrites()
+			continue
+		}
+
+		cached, found := l.cache.Get(key)
+		l.mu.Lock()
+		if l.meta[key] == meta && !l.unsafeQueued(key) {
+			return cached, found
+		}
+		l.mu.Unlock()
+	}
+}
+
+// unsafeQueued reports whether a write to key is waiting for flushWrites, it must be called with the loader locked
+func (l *UserLoader) unsafeQueued(key string) bool {
+	for _, w := range l.writes {
+		if w.all || w.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ForcePrime replaces the cached value at key with value, eg. to write through after a mutation. Unlike a Clear
+// followed by a Prime no load can cache another value in between, and batches whose fetch is in flight won't
+// overwrite it. With a Version configured a value older than the cached one is ignored, so an out of order response
+// can't regress the cache. It returns whether value was cached.
+func (l *UserLoader) ForcePrime(key string, value *example.User, opts ...UserLoaderPrimeOption) bool {
+	var o userLoaderPrimeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	defer l.flushWrites()
+	cached, found := l.lockCached(key)
+	defer l.mu.Unlock()
+
+	if found && l.version != nil && l.version(value) < l.version(cached) {
+		return false
+	}
+	l.unsafeMarkStale(key)
+	l.unsafePrime(key, value, o.ttl)
+	return true
+}
+
+type userLoaderStaged struct {
+	// what was cached before the first staged prime, if anything
+	previous *example.User
+	had      bool
+
+	// the entry of the latest staged prime, it is only rolled back while it is still cached
+	meta *UserLoaderEntryMeta
+}
+
+// PrimePending stages value for key, eg. for an optimistic mutation. Loads see it right away, like with ForcePrime,
+// and CommitPrime or RollbackPrime keep it or restore what was cached before once the write succeeds or fails.
+func (l *UserLoader) PrimePending(key string, value *example.User) {
+	previous, had := l.cache.Get(key)
+
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	staged := l.staged[key]
+	if staged == nil {
+		staged = &userLoaderStaged{previous: previous, had: had}
+		if l.staged == nil {
+			l.staged = map[string]*userLoaderStaged{}
+		}
+		l.staged[key] = staged
+	}
+	l.unsafeMarkStale(key)
+	l.unsafePrime(key, value, 0)
+	staged.meta = l.meta[key]
+}
+
+// CommitPrime keeps the value PrimePending staged for key, it returns false if nothing was staged
+func (l *UserLoader) CommitPrime(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.staged[key]
+	delete(l.staged, key)
+	return ok
+}
+
+// RollbackPrime restores what was cached for key before PrimePending staged a value, it returns false if nothing
+// was staged. Keys that were cleared or primed again since are left alone, they no longer hold the staged value.
+func (l *UserLoader) RollbackPrime(key string) bool {
+	defer l.flushWrites()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	staged, ok := l.staged[key]
+	if !ok {
+		return false
+	}
+	delete(l.staged, key)
+	if meta := l.meta[key]; meta == nil || meta != staged.meta {
+		return true
+	}
+
+	l.unsafeMarkStale(key)
+	if staged.had {
+		l.unsafeSet(key, staged.previous, 0)
+	} else {
+		delete(l.meta, key)
+		l.unindex(key)
+		l.writes = append(l.writes, userLoaderCacheWrite{key: key, clear: true})
+	}
+	return true
+}
+
+// unsafePrime caches a copy of value, it must be called with the loader locked. The key is no longer
+// known to be absent once it has a value.
+func (l *UserLoader) unsafePrime(key string, value *example.User, ttl time.Duration) {
+	delete(l.deleted, key)
+	// nil is cached as is, like fetch returning it for a key it didn't find, eg. when a Tx commits one
+	if value == nil {
+		l.unsafeSet(key, value, ttl)
+		return
+	}
+	// make a copy when writing to the cache, its easy to pass a pointer in from a loop var
+	// and end up with the whole cache pointing to the same value.
+	cpy := *value
+	l.unsafeSet(key, &cpy, ttl)
+}
+
+// UserLoaderPrimeOption changes how a single Prime call stores its value
+type UserLoaderPrimeOption func(*userLoaderPrimeOptions)
+
+type userLoaderPrimeOptions struct {
+	ttl time.Duration
+}
+
+// UserLoaderWithTTL expires the primed value after ttl, regardless of how long the cache keeps other values.
+// Caches that don't implement UserLoaderTTLCache have the value expired by the loader on its next load.
+func UserLoaderWithTTL(ttl time.Duration) UserLoaderPrimeOption {
+	return func(o *userLoaderPrimeOptions) {
+		o.ttl = ttl
+	}
+}
+
+// Clear the value at key from the cache, if it exists
+func (l *UserLoader) Clear(key string) {
+	l.ClearMany(key)
+}
+
+// ClearMany clears the values at keys from the cache, eg. after a bulk write. Batches whose fetch is in flight
+// still return the keys to their callers, but don't cache them, they may have been read before the write.
+func (l *UserLoader) ClearMany(keys ...string) {
+	l.mu.Lock()
+	for _, key := range keys {
+		delete(l.meta, key)
+		delete(l.fetchCounts, key)
+		delete(l.deleted, key)
+		delete(l.errored, key)
+		l.unindex(key)
+		l.writes = append(l.writes, userLoaderCacheWrite{key: key, clear: true})
+	}
+	l.unsafeMarkStale(keys...)
+	l.mu.Unlock()
+	l.flushWrites()
+}
+
+// unsafeMarkStale keeps batches whose fetch is in flight from caching keys, it must be called with the loader locked
+func (l *UserLoader) unsafeMarkStale(keys ...string) {
+	for b := range l.inflight {
+		if !b.fetching {
+			continue
+		}
+		for _, key := range keys {
+			if pos, ok := b.position(key); ok {
+				if b.stale == nil {
+					b.stale = map[int]bool{}
+				}
+				b.stale[pos] = true
+			}
+		}
+	}
+}
+
+// ClearAll clears every value from the cache. Caches that don't implement UserLoaderClearableCache only have
+// the keys this loader cached cleared. Like ClearMany, batches whose fetch is in flight don't cache their keys.
+func (l *UserLoader) ClearAll() {
+	l.mu.Lock()
+	if _, ok := l.cache.(UserLoaderClearableCache); ok {
+		l.writes = append(l.writes, userLoaderCacheWrite{clear: true, all: true})
+	} else {
+		for key := range l.meta {
+			l.writes = append(l.writes, userLoaderCacheWrite{key: key, clear: true})
+		}
+	}
+	l.meta = nil
+	l.fetchCounts = nil
+	l.deleted = nil
+	l.errored = nil
+	l.index = nil
+	l.terms = nil
+	for b := range l.inflight {
+		if b.fetching {
+			b.staleAll = true
+		}
+	}
+	l.mu.Unlock()
+	l.flushWrites()
+}
+
+// ClearIndexed clears every value cached under term by IndexBy, returning how many were cleared
+func (l *UserLoader) ClearIndexed(term string) int {
+	l.mu.Lock()
+	keys := make([]string, 0, len(l.index[term]))
+	for key := range l.index[term] {
+		keys = append(keys, key)
+	}
+	l.mu.Unlock()
+
+	l.ClearMany(keys...)
+	return len(keys)
+}
+
+// unindex removes key from the index, it must be called with the loader locked
+func (l *UserLoader) unindex(key string) {
+	for _, term := range l.terms[key] {
+		delete(l.index[term], key)
+		if len(l.index[term]) == 0 {
+			delete(l.index, term)
+		}
+	}
+	delete(l.terms, key)
+}
+
+// ClearFunc clears every key this loader has cached that match returns true for, eg. everything for a tenant.
+// Keys written to a shared cache by other loaders aren't known to this one and are left alone.
+func (l *UserLoader) ClearFunc(match func(key string) bool) {
+	l.mu.Lock()
+	keys := make([]string, 0, len(l.meta))
+	for key := range l.meta {
+		if match(key) {
+			keys = append(keys, key)
+		}
+	}
+	l.mu.Unlock()
+
+	l.ClearMany(keys...)
+}
+
+// ClearPrefix clears every key this loader has cached that starts with prefix
+func (l *UserLoader) ClearPrefix(prefix string) {
+	l.ClearFunc(func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+func (l *UserLoader) unsafeSet(key string, value *example.User, ttl time.Duration) {
+	if l.cache == nil {
+		l.cache = NewUserLoaderMapCache()
+	}
+	if l.meta == nil {
+		l.meta = map[string]*UserLoaderEntryMeta{}
+	}
+
+	delete(l.errored, key)
+	if ttl == 0 {
+		ttl = l.ttl
+	}
+	l.writes = append(l.writes, userLoaderCacheWrite{key: key, value: value, ttl: ttl})
+	if l.indexBy != nil {
+		l.unindex(key)
+		terms := l.indexBy(value)
+		if len(terms) > 0 {
+			if l.index == nil {
+				l.index = map[string]map[string]struct{}{}
+				l.terms = map[string][]string{}
+			}
+			for _, term := range terms {
+				if l.index[term] == nil {
+					l.index[term] = map[string]struct{}{}
+				}
+				l.index[term][key] = struct{}{}
+			}
+			l.terms[key] = terms
+		}
+	}
+	meta := &UserLoaderEntryMeta{CachedAt: time.Now()}
+	if ttl > 0 {
+		meta.Expires = meta.CachedAt.Add(ttl)
+	}
+	if l.detectMutations {
+		meta.checksum = userLoaderChecksum(value)
+	}
+	l.meta[key] = meta
+}
+
+// userLoaderCacheWrite is a write to the cache made with the loader locked, see flushWrites
+type userLoaderCacheWrite struct {
+	key   string
+	value *example.User
+	ttl   time.Duration
+
+	// clear the key instead of setting it, or every key when all is set
+	clear bool
+	all   bool
+}
+
+// flushWrites applies the cache writes queued with the loader locked, in the order they were made. Keeping the
+// cache calls out of the lock means a cache doing network I/O, eg. redis, only blocks the caller that wrote to it.
+// It must be called with the loader unlocked.
+func (l *UserLoader) flushWrites() {
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	l.mu.Lock()
+	writes := l.writes
+	l.writes = nil
+	cache := l.cache
+	l.mu.Unlock()
+
+	for _, w := range writes {
+		switch {
+		case w.all:
+			cache.(UserLoaderClearableCache).Clear()
+		case w.clear:
+			cache.ClearKey(w.key)
+		default:
+			if ttlCache, ok := cache.(UserLoaderTTLCache); ok && w.ttl > 0 {
+				ttlCache.SetWithTTL(w.key, w.value, w.ttl)
+			} else {
+				cache.Set(w.key, w.value)
+			}
+		}
+	}
+}
+
+// evicted forgets what the loader knows about a key the cache dropped by itself
+func (l *UserLoader) evicted(key string) {
+	l.mu.Lock()
+	delete(l.meta, key)
+	delete(l.fetchCounts, key)
+	delete(l.deleted, key)
+	l.unindex(key)
+	l.mu.Unlock()
+}
+
+// expire clears key once its TTL has passed when the cache can't expire it by itself, reporting whether it did
+func (l *UserLoader) expire(key string) bool {
+	if _, ok := l.cache.(UserLoaderTTLCache); ok {
+		return false
+	}
+
+	l.mu.Lock()
+	meta, ok := l.meta[key]
+	expired := ok && !meta.Expires.IsZero() && time.Now().After(meta.Expires)
+	if expired {
+		delete(l.meta, key)
+		l.writes = append(l.writes, userLoaderCacheWrite{key: key, clear: true})
+	}
+	l.mu.Unlock()
+
+	if expired {
+		l.flushWrites()
+	}
+	return expired
+}
+
+// batchLimit returns the number of keys at which batch will be sent, it must be called with the loader locked
+func (l *UserLoader) batchLimit(batch *userLoaderBatch, remaining int) int {
+	if l.maxBatch == 0 || l.maxBatchOverflow == 0 {
+		return l.maxBatch
+	}
+	if len(batch.keys)+remaining <= l.maxBatch+l.maxBatchOverflow {
+		return l.maxBatch + l.maxBatchOverflow
+	}
+	return l.maxBatch
+}
+
+// keyIndex will return the location of the key in the batch, if its not found
+// it will add the key to the batch. Once the batch holds limit keys it is detached from the loader
+// and full is returned, the caller must then end it after unlocking the loader.
+func (b *userLoaderBatch) keyIndex(l *UserLoader, key string, limit int) (pos int, full bool) {
+	if i, ok := b.position(key); ok {
+		return i, false
+	}
+
+	pos = len(b.keys)
+	b.keys = append(b.keys, key)
+	b.claims = append(b.claims, 0)
+	// scanning is faster for small batches, large ones switch to a map so adding keys doesn't go quadratic
+	if b.index != nil {
+		b.index[key] = pos
+	} else if len(b.keys) > userLoaderIndexAfter {
+		b.index = make(map[string]int, 2*len(b.keys))
+		for i, k := range b.keys {
+			b.index[k] = i
+		}
+	}
+	if l.pending == nil {
+		l.pending = map[string]int{}
+	}
+	l.pending[key]++
+	l.pendingKeys++
+	if l.targetBatch > 0 {
+		l.arrivals.record(l.wait)
+	}
+	if pos == 0 {
+		go b.startTimer(l, l.batchWait())
+	}
+
+	if limit != 0 && pos >= limit-1 {
+		if !b.closing {
+			b.closing = true
+			delete(l.batches, b.partition)
+			l.queued++
+			full = true
+		}
+	}
+
+	return pos, full
+}
+
+// has reports whether key is in the batch
+func (b *userLoaderBatch) has(key string) bool {
+	_, ok := b.position(key)
+	return ok
+}
+
+// position returns the location of the key in the batch, if it is in it
+func (b *userLoaderBatch) position(key string) (int, bool) {
+	if b.index != nil {
+		i, ok := b.index[key]
+		return i, ok
+	}
+	for i, existingKey := range b.keys {
+		if key == existingKey {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// userLoaderIndexAfter is how many keys a batch holds before it is indexed with a map
+const userLoaderIndexAfter = 32
+
+// watch gives up the batch for a waiter once ctx is done, abandoning it when that was the last waiter
+func (b *userLoaderBatch) watch(l *UserLoader, ctx context.Context) {
+	select {
+	case <-b.done:
+		return
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b.contexts--
+	if b.contexts == 0 && !b.detached && !b.abandoned {
+		b.abandoned = true
+		if b.cancel != nil {
+			b.cancel()
+		}
+	}
+}
+
+// skipAbandoned finishes the batch without fetching it when every waiter has given up on it, reporting whether
+// it did. The slot the batch holds, if any, is given back.
+func (b *userLoaderBatch) skipAbandoned(l *UserLoader, slots chan struct{}) bool {
+	l.mu.Lock()
+	if !b.abandoned {
+		l.mu.Unlock()
+		return false
+	}
+	l.queued--
+	b.error = []error{context.Canceled}
+	for _, key := range b.keys {
+		if l.pending[key]--; l.pending[key] <= 0 {
+			delete(l.pending, key)
+		}
+	}
+	l.pendingKeys -= len(b.keys)
+	l.stats.Abandoned++
+	delete(l.inflight, b)
+	l.mu.Unlock()
+
+	if slots != nil {
+		<-slots
+	}
+	close(b.done)
+	return true
+}
+
+// userLoaderArrivals keeps a moving average of the time between keys
+type userLoaderArrivals struct {
+	last     time.Time
+	interval time.Duration
+}
+
+// record a key arriving now, gaps longer than max count as max so a loader that was idle adapts right away
+func (a *userLoaderArrivals) record(max time.Duration) {
+	now := time.Now()
+	if a.last.IsZero() {
+		a.last, a.interval = now, max
+		return
+	}
+	gap := now.Sub(a.last)
+	if gap > max {
+		gap = max
+	}
+	a.last = now
+	a.interval += (gap - a.interval) / 5
+}
+
+// batchWait is how long a new batch waits, it must be called with the loader locked
+func (l *UserLoader) batchWait() time.Duration {
+	if l.targetBatch == 0 || l.arrivals.last.IsZero() {
+		return l.wait
+	}
+	wait := l.arrivals.interval * time.Duration(l.targetBatch)
+	if wait < l.minWait {
+		wait = l.minWait
+	}
+	if wait > l.wait {
+		wait = l.wait
+	}
+	return wait
+}
+
+// CurrentWait is how long a batch started now would wait, with TargetBatch it follows the rate keys arrive at
+func (l *UserLoader) CurrentWait() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.batchWait()
+}
+
+func (b *userLoaderBatch) startTimer(l *UserLoader, wait time.Duration) {
+	time.Sleep(wait)
+	l.mu.Lock()
+
+	// we must have hit a batch limit and are already finalizing this batch
+	if b.closing {
+		l.mu.Unlock()
+		return
+	}
+
+	b.closing = true
+	delete(l.batches, b.partition)
+	l.queued++
+	pool := l.pool
+	if b.parent != nil {
+		pool = nil
+	}
+	l.mu.Unlock()
+
+	if pool != nil {
+		pool.Go(func() { b.end(l) })
+	} else {
+		b.end(l)
+	}
+}
+
+func (b *userLoaderBatch) end(l *UserLoader) {
+	if b.skipAbandoned(l, nil) {
+		return
+	}
+
+	l.mu.Lock()
+	config := l.config()
+	callers, origin := b.callers, b.origin
+	var slots chan struct{}
+	if b.parent == nil {
+		slots = l.concurrencySlots()
+	}
+	l.mu.Unlock()
+
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+		default:
+			if config.OnBackpressure != nil {
+				config.OnBackpressure(l.QueueDepth())
+			}
+			slots <- struct{}{}
+		}
+		// waiting for the slot may have taken long enough for every waiter to give up
+		if b.skipAbandoned(l, slots) {
+			return
+		}
+	}
+
+	b.id = userLoaderNewBatchID()
+	ctx := context.WithValue(context.Background(), userLoaderFetchKey{}, &userLoaderFetch{loader: l, batch: b, parent: b.parent})
+	if config.PropagateContext != nil && origin != nil {
+		ctx = config.PropagateContext(origin, ctx)
+	}
+	var endTrace func(errors int)
+	if config.Tracer != nil {
+		ctx, endTrace = config.Tracer.StartBatch(ctx, callers, len(b.keys))
+	}
+
+	l.mu.Lock()
+	l.queued--
+	b.fetching = true
+	fetchContext := config.contextFetch()
+	if fetchContext != nil {
+		ctx, b.cancel = context.WithCancel(ctx)
+		defer b.cancel()
+		if b.abandoned {
+			b.cancel()
+		}
+	}
+	l.mu.Unlock()
+
+	var locks *userLoaderKeyLocks
+	if config.KeyLocker != nil {
+		locks = &userLoaderKeyLocks{locker: config.KeyLocker}
+		defer locks.release()
+	}
+	fetch := config.Fetch
+	fetchWith := func(ctx context.Context) func(keys []string) ([]*example.User, []error) {
+		fetch := fetch
+		if fetchContext != nil {
+			fetch = func(keys []string) ([]*example.User, []error) {
+				return fetchContext(ctx, keys)
+			}
+		}
+		// a hedged fetch runs on goroutines of its own, so panics are recovered right where fetch is called
+		fetch = userLoaderRecovered(fetch)
+		if locks != nil {
+			fetch = l.lockedFetch(config, locks, fetch)
+		}
+		return fetch
+	}
+	if config.Hedge {
+		config.Fetch = l.hedgedFetch(ctx, fetchWith)
+	} else {
+		config.Fetch = fetchWith(ctx)
+	}
+	if config.Breaker != nil {
+		config.Fetch = userLoaderBrokenFetch(config.Breaker, config.Fetch)
+	}
+	if config.MaxRetries > 0 {
+		config.Fetch = l.retriedFetch(ctx, config, config.Fetch)
+	}
+	var untrack func()
+	if config.DetectDeadlocks {
+		untrack = l.trackFetch(b)
+	}
+	start := time.Now()
+	data, errs := b.fetch(config)
+	latency := time.Since(start)
+	if untrack != nil {
+		untrack()
+	}
+	if slots != nil {
+		<-slots
+	}
+	b.transform(config, data, errs)
+	data, errs, deleted := b.markDeleted(config, data, errs)
+	errs = b.validate(config, data, errs)
+	errs = b.markMissing(config, data, errs)
+	oversized := b.measure(config, data, errs)
+
+	l.mu.Lock()
+	b.data, b.error, b.oversized = data, errs, oversized
+	if locks != nil && !l.loadAllNoCache {
+		// other processes wait for the cache to be filled, so the keys are cached before they are unlocked
+		for pos, key := range b.keys {
+			if b.errorAt(pos) == nil && !oversized[pos] && !b.staleAll && !b.stale[pos] {
+				l.unsafeSet(key, data[pos], 0)
+			}
+		}
+	}
+	if config.CacheDeleted {
+		for _, pos := range deleted {
+			l.unsafeAbsent(b.keys[pos])
+		}
+	}
+	if config.ErrorTTL > 0 {
+		l.cacheErrors(config, b)
+	}
+	for pos, claims := range b.claims {
+		if claims == 0 {
+			b.release(pos)
+		}
+	}
+	for _, key := range b.keys {
+		if l.pending[key]--; l.pending[key] <= 0 {
+			delete(l.pending, key)
+		}
+	}
+	l.pendingKeys -= len(b.keys)
+	if b.abandoned && b.cancel != nil {
+		// the fetch was cancelled once every waiter gave up, it says nothing about how long the backend takes
+		l.stats.Abandoned++
+	} else {
+		l.latencies.record(latency)
+		l.stats.Batches++
+		l.stats.Keys += len(b.keys)
+		l.window.record(0, 0, 1)
+	}
+	l.countPatterns(b)
+	failed := l.countErrors(b)
+	duplicates := l.countFetches(b)
+	delete(l.inflight, b)
+	l.mu.Unlock()
+
+	if locks != nil {
+		l.flushWrites()
+		locks.release()
+	}
+	close(b.done)
+
+	if endTrace != nil {
+		endTrace(failed)
+	}
+	if config.Metrics != nil {
+		traceID := ""
+		if tracer, ok := config.Tracer.(UserLoaderTraceIDs); ok {
+			traceID = tracer.TraceID(ctx)
+		}
+		if metrics, ok := config.Metrics.(UserLoaderExemplarMetrics); ok && traceID != "" {
+			metrics.BatchExemplar(len(b.keys), latency, failed, traceID)
+		} else {
+			config.Metrics.Batch(len(b.keys), latency, failed)
+		}
+	}
+
+	for _, key := range duplicates {
+		config.OnDuplicateFetch(key, l.fetchCount(key))
+	}
+}
+
+// ErrUserLoaderReentrant is returned by loads from within a fetch of keys that fetch is already loading, instead of
+// waiting for the fetch to return and so for themselves. Only loads passed the ctx of FetchContext are checked.
+var ErrUserLoaderReentrant = errors.New("UserLoader: loaded a key from within its own fetch")
+
+// UserLoaderPanicError is returned for every key of a batch whose fetch panicked. The loader stays usable, the keys
+// are fetched again by their next load.
+type UserLoaderPanicError struct {
+	// Value is what fetch panicked with
+	Value interface{}
+
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (e *UserLoaderPanicError) Error() string {
+	return fmt.Sprintf("UserLoader: fetch panicked: %v", e.Value)
+}
+
+// userLoaderRecovered wraps fetch so a panic fails the batch with a UserLoaderPanicError instead of crashing
+// the process
+func userLoaderRecovered(fetch func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	return func(keys []string) (data []*example.User, errs []error) {
+		defer func() {
+			if r := recover(); r != nil {
+				data, errs = nil, []error{&UserLoaderPanicError{Value: r, Stack: debug.Stack()}}
+			}
+		}()
+		return fetch(keys)
+	}
+}
+
+// ErrUserLoaderDeadlock is returned by loads from within Fetch when DetectDeadlocks is set, see there
+var ErrUserLoaderDeadlock = errors.New("UserLoader: load from within fetch could deadlock")
+
+// trackFetch remembers that the calling goroutine fetches b, until the returned func is called
+func (l *UserLoader) trackFetch(b *userLoaderBatch) func() {
+	id := userLoaderGoroutineID()
+	l.mu.Lock()
+	if l.fetchers == nil {
+		l.fetchers = map[uint64]*userLoaderBatch{}
+	}
+	l.fetchers[id] = b
+	l.mu.Unlock()
+	return func() {
+		l.mu.Lock()
+		delete(l.fetchers, id)
+		l.mu.Unlock()
+	}
+}
+
+// userLoaderGoroutineID parses the id of the calling goroutine out of its stack trace. Go doesn't expose it
+// otherwise, which is why DetectDeadlocks is for development only.
+func userLoaderGoroutineID() uint64 {
+	var buf [64]byte
+	trace := buf[:runtime.Stack(buf[:], false)]
+	trace = bytes.TrimPrefix(trace, []byte("goroutine "))
+	if i := bytes.IndexByte(trace, ' '); i >= 0 {
+		trace = trace[:i]
+	}
+	id, _ := strconv.ParseUint(string(trace), 10, 64)
+	return id
+}
+
+// userLoaderFetchKey is the context key of the userLoaderFetch a FetchContext runs in
+type userLoaderFetchKey struct{}
+
+// userLoaderFetch is a batch that is being fetched, linked to the fetch that loaded it if there is one
+type userLoaderFetch struct {
+	loader *UserLoader
+	batch  *userLoaderBatch
+	parent *userLoaderFetch
+}
+
+// UserLoaderContextKeys returns a PropagateContext that copies the values of keys, eg. the context keys of a tenant
+// middleware, into the ctx of the fetch
+func UserLoaderContextKeys(keys ...interface{}) func(from, to context.Context) context.Context {
+	return func(from, to context.Context) context.Context {
+		for _, key := range keys {
+			if value := from.Value(key); value != nil {
+				to = context.WithValue(to, key, value)
+			}
+		}
+		return to
+	}
+}
+
+// UserLoaderBatchID returns the ID of the batch whose FetchContext or Tracer was passed ctx, or "" for any other
+// ctx. Every batch gets a random ID that stays the same across its retries and hedged fetches, so backends can use
+// it as an idempotency key and logs can correlate the events of a batch.
+func UserLoaderBatchID(ctx context.Context) string {
+	if f, ok := ctx.Value(userLoaderFetchKey{}).(*userLoaderFetch); ok {
+		return f.batch.id
+	}
+	return ""
+}
+
+// userLoaderNewBatchID returns 16 random bytes in hex, like a trace ID
+func userLoaderNewBatchID() string {
+	var id [16]byte
+	if _, err := crand.Read(id[:]); err != nil {
+		// not secure, but still unique enough to correlate logs
+		rand.Read(id[:])
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// has reports whether f or a fetch it was loaded from is fetching key for l. It must be called with the loader
+// locked.
+func (f *userLoaderFetch) has(l *UserLoader, key string) bool {
+	for ; f != nil; f = f.parent {
+		if f.loader != l {
+			continue
+		}
+		for _, k := range f.batch.keys {
+			if k == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// concurrencySlots returns the channel limiting the batches fetching at once, nil when there is no limit. It must
+// be called with the loader locked.
+func (l *UserLoader) concurrencySlots() chan struct{} {
+	if l.maxConcurrentBatches == 0 {
+		return nil
+	}
+	// batches that are already fetching give their token back to the old channel
+	if cap(l.slots) != l.maxConcurrentBatches {
+		l.slots = make(chan struct{}, l.maxConcurrentBatches)
+	}
+	return l.slots
+}
+
+// QueueDepth is how many batches are waiting to be fetched, because MaxConcurrentBatches batches are already
+// fetching or the Pool has no free worker. A growing queue means fetches can't keep up with the loads.
+func (l *UserLoader) QueueDepth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// errorAt returns the error for the key at pos
+func (b *userLoaderBatch) errorAt(pos int) error {
+	// its convenient to be able to return a single error for everything
+	if len(b.error) == 1 {
+		return b.error[0]
+	} else if pos < len(b.error) {
+		return b.error[pos]
+	}
+	return nil
+}
+
+func (b *userLoaderBatch) fetch(config UserLoaderConfig) ([]*example.User, []error) {
+	if config.SortKeys == nil {
+		return b.checkedFetch(config, b.keys)
+	}
+
+	sorted := make([]string, len(b.keys))
+	copy(sorted, b.keys)
+	config.SortKeys(sorted)
+
+	data, errs := b.checkedFetch(config, sorted)
+	return b.unsort(sorted, data, errs)
+}
+
+// checkedFetch calls fetch and replaces results that don't line up with the keys with an error
+func (b *userLoaderBatch) checkedFetch(config UserLoaderConfig, keys []string) ([]*example.User, []error) {
+	data, errs := config.Fetch(keys)
+	if config.Strict {
+		validErrs := len(errs) == 0 || len(errs) == 1 || len(errs) == len(keys)
+		// a single error fails the whole batch, so there doesn't need to be any data alongside it
+		validData := len(data) == len(keys) || (len(errs) == 1 && len(data) == 0)
+		if validErrs && validData {
+			return data, errs
+		}
+	} else if userLoaderComplete(len(keys), data, errs, config.MissingPolicy != UserLoaderMissingAsFetched) {
+		return data, errs
+	}
+
+	err := &UserLoaderResultLengthError{Keys: len(keys), Values: len(data), Errors: len(errs)}
+	if config.OnResultLengthError != nil {
+		config.OnResultLengthError(keys, err)
+	}
+	return nil, []error{err}
+}
+
+// userLoaderComplete reports whether every key got a value or an error, or only whether the results line up
+// with the keys when missing is set and the MissingPolicy says what keys without one return. The loader can't
+// tell what a missing result was meant to be otherwise, caching a zero value for it would hide the bug in fetch.
+func userLoaderComplete(keys int, data []*example.User, errs []error, missing bool) bool {
+	if len(errs) == 1 && errs[0] != nil {
+		return true
+	}
+	if len(data) > keys || (len(errs) > 1 && len(errs) != keys) {
+		return false
+	}
+	if missing {
+		return true
+	}
+	for pos := len(data); pos < keys; pos++ {
+		if pos >= len(errs) || errs[pos] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// markDeleted replaces soft deleted values with ErrUserLoaderNotFound, returning the positions it replaced
+func (b *userLoaderBatch) markDeleted(config UserLoaderConfig, data []*example.User, errs []error) ([]*example.User, []error, []int) {
+	// a single error fails every key anyway
+	if config.IsDeleted == nil || (len(errs) == 1 && errs[0] != nil) {
+		return data, errs, nil
+	}
+
+	var deleted []int
+	for pos := range data {
+		if pos < len(errs) && errs[pos] != nil {
+			continue
+		}
+		if !config.IsDeleted(data[pos]) {
+			continue
+		}
+
+		if len(errs) < len(data) {
+			expanded := make([]error, len(data))
+			copy(expanded, errs)
+			errs = expanded
+		}
+		var zero *example.User
+		data[pos] = zero
+		errs[pos] = ErrUserLoaderNotFound
+		deleted = append(deleted, pos)
+	}
+	return data, errs, deleted
+}
+
+// transform applies Transform to the values that were fetched without an error
+func (b *userLoaderBatch) transform(config UserLoaderConfig, data []*example.User, errs []error) {
+	// a single error fails every key anyway
+	if config.Transform == nil || (len(errs) == 1 && errs[0] != nil) {
+		return
+	}
+
+	for pos := range data {
+		if pos >= len(b.keys) || (pos < len(errs) && errs[pos] != nil) {
+			continue
+		}
+		data[pos] = config.Transform(b.keys[pos], data[pos])
+	}
+}
+
+// validate replaces the errors of values that fail ValidateValue
+func (b *userLoaderBatch) validate(config UserLoaderConfig, data []*example.User, errs []error) []error {
+	// a single error fails every key anyway
+	if config.ValidateValue == nil || (len(errs) == 1 && errs[0] != nil) {
+		return errs
+	}
+
+	for pos := range data {
+		if pos >= len(b.keys) || (pos < len(errs) && errs[pos] != nil) {
+			continue
+		}
+		err := config.ValidateValue(b.keys[pos], data[pos])
+		if err == nil {
+			continue
+		}
+
+		if len(errs) < len(b.keys) {
+			expanded := make([]error, len(b.keys))
+			copy(expanded, errs)
+			errs = expanded
+		}
+		var zero *example.User
+		data[pos] = zero
+		errs[pos] = err
+	}
+	return errs
+}
+
+// measure returns the positions of values too large to cache under MaxValueBytes
+func (b *userLoaderBatch) measure(config UserLoaderConfig, data []*example.User, errs []error) map[int]bool {
+	if config.MaxValueBytes == 0 || config.ValueSize == nil || (len(errs) == 1 && errs[0] != nil) {
+		return nil
+	}
+
+	var oversized map[int]bool
+	for pos := range data {
+		if pos < len(errs) && errs[pos] != nil {
+			continue
+		}
+		if config.ValueSize(data[pos]) <= config.MaxValueBytes {
+			continue
+		}
+		if oversized == nil {
+			oversized = map[int]bool{}
+		}
+		oversized[pos] = true
+	}
+	return oversized
+}
+
+// markMissing applies the MissingPolicy to the keys Fetch didn't find
+func (b *userLoaderBatch) markMissing(config UserLoaderConfig, data []*example.User, errs []error) []error {
+	switch config.MissingPolicy {
+	case UserLoaderMissingZero:
+		for pos, err := range errs {
+			if errors.Is(err, ErrUserLoaderNotFound) {
+				errs[pos] = nil
+			}
+		}
+
+	case UserLoaderMissingError:
+		// a single error fails every key anyway
+		if len(errs) == 1 && errs[0] != nil {
+			return errs
+		}
+		for pos := range b.keys {
+			if pos < len(errs) && errs[pos] != nil {
+				continue
+			}
+			if pos < len(data) && data[pos] != nil {
+				continue
+			}
+
+			if len(errs) < len(b.keys) {
+				expanded := make([]error, len(b.keys))
+				copy(expanded, errs)
+				errs = expanded
+			}
+			errs[pos] = ErrUserLoaderNotFound
+		}
+	}
+	return errs
+}
+
+// unclaim is called once a thunk has read its result or was released, when no thunks are left waiting on pos
+// the result is dropped so it can be garbage collected even if other thunks keep the batch alive.
+func (b *userLoaderBatch) unclaim(l *UserLoader, pos int) {
+	l.mu.Lock()
+	b.claims[pos]--
+	if b.claims[pos] == 0 {
+		b.release(pos)
+	}
+	l.mu.Unlock()
+}
+
+func (b *userLoaderBatch) release(pos int) {
+	if pos < len(b.data) {
+		var zero *example.User
+		b.data[pos] = zero
+	}
+}
+
+// unsort maps results fetched for the sorted keys back to the positions the thunks are waiting on
+func (b *userLoaderBatch) unsort(sorted []string, data []*example.User, errs []error) ([]*example.User, []error) {
+	index := make(map[string]int, len(sorted))
+	for i, key := range sorted {
+		index[key] = i
+	}
+
+	unsortedData := make([]*example.User, len(b.keys))
+	var unsortedErrs []error
+	if len(errs) > 1 {
+		unsortedErrs = make([]error, len(b.keys))
+	} else {
+		unsortedErrs = errs
+	}
+
+	for i, key := range b.keys {
+		pos, ok := index[key]
+		if !ok {
+			continue
+		}
+		if pos < len(data) {
+			unsortedData[i] = data[pos]
+		}
+		if len(errs) > 1 && pos < len(errs) {
+			unsortedErrs[i] = errs[pos]
+		}
+	}
+
+	return unsortedData, unsortedErrs
+}
+
+// UserLoaderTracer traces the batches of a loader, see UserLoaderOTel
+type UserLoaderTracer interface {
+	// StartBatch is called before a batch is fetched with the contexts of the callers waiting on it. The ctx it
+	// returns is passed to FetchContext, and end is called with the number of failed keys once the fetch returned.
+	StartBatch(ctx context.Context, callers []context.Context, keys int) (batchCtx context.Context, end func(errors int))
+}
+
+// UserLoaderTraceIDs is implemented by Tracers that can tell the ID of the trace the batchCtx StartBatch returned
+// belongs to, eg. UserLoaderOTel. It is passed to UserLoaderExemplarMetrics.
+type UserLoaderTraceIDs interface {
+	// TraceID returns the ID of the trace of batchCtx, or "" if there is none
+	TraceID(batchCtx context.Context) string
+}
+
+// UserLoaderExemplarMetrics is implemented by Metrics that can link a batch to its trace, eg. UserLoaderPrometheus
+// with an exemplar. BatchExemplar is called instead of Batch when the Tracer implements UserLoaderTraceIDs.
+type UserLoaderExemplarMetrics interface {
+	BatchExemplar(size int, latency time.Duration, errors int, traceID string)
+}
+
+// UserLoaderMetrics is told what the loader does, eg. to export it to a metrics system. It is called outside of
+// the loader's lock.
+type UserLoaderMetrics interface {
+	// Batch is called after every fetch with the number of keys it was sent, how long it took and how many keys
+	// failed
+	Batch(size int, latency time.Duration, errors int)
+
+	// Hit and Miss are called for every load that is served from the cache or not
+	Hit()
+	Miss()
+}
+
+// UserLoaderAggregator is a UserLoaderMetrics that adds everything up in memory, for services that don't use a
+// metrics library. Unlike Stats it can be shared by every loader, eg. the ones created for each request, and read
+// with Snapshot.
+type UserLoaderAggregator struct {
+	mu       sync.Mutex
+	snapshot UserLoaderMetricsSnapshot
+}
+
+// UserLoaderMetricsSnapshot is what a UserLoaderAggregator added up
+type UserLoaderMetricsSnapshot struct {
+	// Batches is the number of fetches, Keys the number of keys they were sent and Errors the number that failed
+	Batches int64
+	Keys    int64
+	Errors  int64
+
+	Hits   int64
+	Misses int64
+
+	// BatchSize has the number of keys of each batch, and FetchLatency how long each fetch took in nanoseconds
+	BatchSize    UserLoaderHistogram
+	FetchLatency UserLoaderHistogram
+}
+
+// Batch implements UserLoaderMetrics
+func (a *UserLoaderAggregator) Batch(size int, latency time.Duration, errors int) {
+	a.mu.Lock()
+	a.snapshot.Batches++
+	a.snapshot.Keys += int64(size)
+	a.snapshot.Errors += int64(errors)
+	a.snapshot.BatchSize.Record(int64(size))
+	a.snapshot.FetchLatency.Record(int64(latency))
+	a.mu.Unlock()
+}
+
+// Hit implements UserLoaderMetrics
+func (a *UserLoaderAggregator) Hit() {
+	a.mu.Lock()
+	a.snapshot.Hits++
+	a.mu.Unlock()
+}
+
+// Miss implements UserLoaderMetrics
+func (a *UserLoaderAggregator) Miss() {
+	a.mu.Lock()
+	a.snapshot.Misses++
+	a.mu.Unlock()
+}
+
+// Snapshot returns a copy of everything added up so far
+func (a *UserLoaderAggregator) Snapshot() UserLoaderMetricsSnapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshot
+}
+
+// userLoaderSubBuckets is how many buckets each power of two is split into, values are recorded to within
+// 1/userLoaderSubBuckets of their size
+const userLoaderSubBuckets = 8
+
+// UserLoaderHistogram counts non negative values into log-linear buckets, like an HDR histogram with a precision
+// of 12.5%. Its zero value is empty and ready to use, it isn't safe for concurrent use.
+type UserLoaderHistogram struct {
+	Count int64
+	Sum   int64
+	Min   int64
+	Max   int64
+
+	buckets [64 * userLoaderSubBuckets]int64
+}
+
+// Record adds v, negative values are recorded as 0
+func (h *UserLoaderHistogram) Record(v int64) {
+	if v < 0 {
+		v = 0
+	}
+	if h.Count == 0 || v < h.Min {
+		h.Min = v
+	}
+	if v > h.Max {
+		h.Max = v
+	}
+	h.Count++
+	h.Sum += v
+	h.buckets[userLoaderBucketOf(v)]++
+}
+
+// Mean is the average of the recorded values
+func (h *UserLoaderHistogram) Mean() float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	return float64(h.Sum) / float64(h.Count)
+}
+
+// Quantile estimates the value that the fraction q, from 0 to 1, of the recorded values is at or below, eg. 0.99
+// for the p99
+func (h *UserLoaderHistogram) Quantile(q float64) int64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.Count)))
+	// the extremes are known exactly
+	if rank <= 1 {
+		return h.Min
+	} else if rank >= h.Count {
+		return h.Max
+	}
+	var seen int64
+	for i, n := range h.buckets {
+		seen += n
+		if n > 0 && seen >= rank {
+			lower, upper := userLoaderBucketBounds(i)
+			v := lower + (upper-lower)/2
+			if v < h.Min {
+				v = h.Min
+			}
+			if v > h.Max {
+				v = h.Max
+			}
+			return v
+		}
+	}
+	return h.Max
+}
+
+// userLoaderBucketOf returns the bucket v is counted in: values below userLoaderSubBuckets get one
+// each, larger ones share a bucket with the values that agree in their top 4 bits
+func userLoaderBucketOf(v int64) int {
+	if v < userLoaderSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(uint64(v)) - 1
+	sub := int(v>>uint(exp-3)) - userLoaderSubBuckets
+	return (exp-2)*userLoaderSubBuckets + sub
+}
+
+// userLoaderBucketBounds is the range of values counted in bucket i, upper is exclusive
+func userLoaderBucketBounds(i int) (lower int64, upper int64) {
+	if i < userLoaderSubBuckets {
+		return int64(i), int64(i) + 1
+	}
+	exp := i/userLoaderSubBuckets + 2
+	sub := int64(i%userLoaderSubBuckets + userLoaderSubBuckets)
+	return sub << uint(exp-3), (sub + 1) << uint(exp-3)
+}
+
+// UserLoaderStats is a snapshot of what a loader has done since it was created
+type UserLoaderStats struct {
+	// Batches is the number of fetches and Keys the number of keys they were sent
+	Batches int
+	Keys    int
+
+	Hits   int
+	Misses int
+
+	// Errors is the number of keys that failed, see ErrorCounts for a breakdown
+	Errors int
+
+	// Collapsed is the number of LoadAlls that shared the result of an identical one, see CollapseLoadAll
+	Collapsed int
+
+	// Abandoned is the number of batches that weren't fetched, or had the ctx of FetchContext cancelled, because
+	// every caller waiting on them gave up first. They don't count as Batches.
+	Abandoned int
+
+	// Retries is the number of times failed keys were fetched again, see MaxRetries
+	Retries int
+
+	// Overloaded is the number of loads that failed with a UserLoaderOverloadError, see MaxPendingKeys
+	Overloaded int
+
+	AvgBatchSize float64
+
+	// FetchP50 and FetchP99 are taken over the most recent fetches
+	FetchP50 time.Duration
+	FetchP99 time.Duration
+}
+
+// Stats returns a snapshot of the loader's counters, eg. to expose as metrics
+func (l *UserLoader) Stats() UserLoaderStats {
+	l.mu.Lock()
+	stats := l.stats
+	for _, count := range l.errorCounts {
+		stats.Errors += count
+	}
+	l.mu.Unlock()
+
+	if stats.Batches > 0 {
+		stats.AvgBatchSize = float64(stats.Keys) / float64(stats.Batches)
+	}
+	stats.FetchP50, _ = l.latencies.percentile(50, 1)
+	stats.FetchP99, _ = l.latencies.percentile(99, 1)
+	return stats
+}
+
+// UserLoaderWindowStats holds the counters observed over a rolling window
+type UserLoaderWindowStats struct {
+	Hits    int
+	Misses  int
+	Batches int
+}
+
+// WindowStats returns the hits, misses and batches seen over the last window (eg. 1m or 5m),
+// rounded to the second. The window is capped at the StatsWindow the loader was configured with.
+func (l *UserLoader) WindowStats(window time.Duration) UserLoaderWindowStats {
+	if l.window == nil {
+		return UserLoaderWindowStats{}
+	}
+	return l.window.sum(window)
+}
+
+// userLoaderStatsWindow is a ring of one second buckets
+type userLoaderStatsWindow struct {
+	mu      sync.Mutex
+	buckets []userLoaderStatsBucket
+}
+
+type userLoaderStatsBucket struct {
+	second int64
+	stats  UserLoaderWindowStats
+}
+
+func newuserLoaderStatsWindow(size time.Duration) *userLoaderStatsWindow {
+	seconds := int(size / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return &userLoaderStatsWindow{buckets: make([]userLoaderStatsBucket, seconds)}
+}
+
+func (w *userLoaderStatsWindow) record(hits, misses, batches int) {
+	if w == nil {
+		return
+	}
+	now := time.Now().Unix()
+
+	w.mu.Lock()
+	b := &w.buckets[now%int64(len(w.buckets))]
+	if b.second != now {
+		*b = userLoaderStatsBucket{second: now}
+	}
+	b.stats.Hits += hits
+	b.stats.Misses += misses
+	b.stats.Batches += batches
+	w.mu.Unlock()
+}
+
+func (w *userLoaderStatsWindow) sum(window time.Duration) UserLoaderWindowStats {
+	seconds := int64(window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	if seconds > int64(len(w.buckets)) {
+		seconds = int64(len(w.buckets))
+	}
+	now := time.Now().Unix()
+
+	var total UserLoaderWindowStats
+	w.mu.Lock()
+	for _, b := range w.buckets {
+		if b.second > now-seconds && b.second <= now {
+			total.Hits += b.stats.Hits
+			total.Misses += b.stats.Misses
+			total.Batches += b.stats.Batches
+		}
+	}
+	w.mu.Unlock()
+	return total
+}
+
+// UserLoaderErrorClass is the category a failed key is counted in by ErrorCounts
+type UserLoaderErrorClass string
+
+const (
+	UserLoaderErrorNotFound UserLoaderErrorClass = "not_found"
+	UserLoaderErrorTimeout  UserLoaderErrorClass = "timeout"
+	UserLoaderErrorBackend  UserLoaderErrorClass = "backend"
+	UserLoaderErrorOther    UserLoaderErrorClass = "other"
+)
+
+// ErrorCounts returns how many fetched keys have failed so far, by error class
+func (l *UserLoader) ErrorCounts() map[UserLoaderErrorClass]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counts := make(map[UserLoaderErrorClass]int, len(l.errorCounts))
+	for class, count := range l.errorCounts {
+		counts[class] = count
+	}
+	return counts
+}
+
+// UserLoaderPatternStats holds the counters of the keys of one KeyPattern
+type UserLoaderPatternStats struct {
+	Loads  int
+	Hits   int
+	Misses int
+
+	// Coalesced is the number of loads that joined a key already waiting in a batch, rather than adding it
+	Coalesced int
+
+	// Batches is the number of fetches with keys of the pattern and Keys the number of them they were sent
+	Batches int
+	Keys    int
+}
+
+// PatternStats returns a snapshot of the counters of every KeyPattern seen so far
+func (l *UserLoader) PatternStats() map[string]UserLoaderPatternStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	stats := make(map[string]UserLoaderPatternStats, len(l.patternStats))
+	for pattern, counters := range l.patternStats {
+		stats[pattern] = *counters
+	}
+	return stats
+}
+
+// patternOf returns the counters of the pattern of key, or nil when there is no KeyPattern. It must be called with
+// the loader locked.
+func (l *UserLoader) patternOf(key string) *UserLoaderPatternStats {
+	if l.keyPattern == nil {
+		return nil
+	}
+	pattern := l.keyPattern(key)
+	stats := l.patternStats[pattern]
+	if stats == nil {
+		if l.patternStats == nil {
+			l.patternStats = map[string]*UserLoaderPatternStats{}
+		}
+		stats = &UserLoaderPatternStats{}
+		l.patternStats[pattern] = stats
+	}
+	return stats
+}
+
+// countPatterns must be called with the loader locked
+func (l *UserLoader) countPatterns(b *userLoaderBatch) {
+	if l.keyPattern == nil {
+		return
+	}
+
+	batched := map[*UserLoaderPatternStats]bool{}
+	for _, key := range b.keys {
+		stats := l.patternOf(key)
+		stats.Keys++
+		if !batched[stats] {
+			batched[stats] = true
+			stats.Batches++
+		}
+	}
+}
+
+// UserLoaderKeyPattern names the keys Regexp matches, for UserLoaderKeyPatterns
+type UserLoaderKeyPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// UserLoaderKeyPatterns returns a KeyPattern that groups keys under the name of the first pattern matching them,
+// keys that match none are grouped as "other"
+func UserLoaderKeyPatterns(patterns ...UserLoaderKeyPattern) func(key string) string {
+	return func(key string) string {
+		text := userLoaderKeyString(key)
+		for _, pattern := range patterns {
+			if pattern.Regexp.MatchString(text) {
+				return pattern.Name
+			}
+		}
+		return "other"
+	}
+}
+
+// countErrors must be called with the loader locked, it returns how many keys of the batch failed
+func (l *UserLoader) countErrors(b *userLoaderBatch) int {
+	if len(b.error) == 0 {
+		return 0
+	}
+
+	var failed int
+	for pos, key := range b.keys {
+		err := b.errorAt(pos)
+		if err == nil {
+			continue
+		}
+		failed++
+
+		class := UserLoaderErrorOther
+		if l.classifyError != nil {
+			class = l.classifyError(key, err)
+		}
+
+		if l.errorCounts == nil {
+			l.errorCounts = map[UserLoaderErrorClass]int{}
+		}
+		l.errorCounts[class]++
+	}
+	return failed
+}
+
+// countFetches must be called with the loader locked, it returns the keys that have been fetched before
+func (l *UserLoader) countFetches(b *userLoaderBatch) []string {
+	if l.onDuplicateFetch == nil {
+		return nil
+	}
+	if l.fetchCounts == nil {
+		l.fetchCounts = map[string]int{}
+	}
+
+	var duplicates []string
+	for pos, key := range b.keys {
+		if b.errorAt(pos) != nil {
+			continue
+		}
+		l.fetchCounts[key]++
+		if l.fetchCounts[key] > 1 {
+			duplicates = append(duplicates, key)
+		}
+	}
+	return duplicates
+}
+
+func (l *UserLoader) fetchCount(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fetchCounts[key]
+}
+
+// UserLoaderKeyLocker hands out a lock per key that is shared by every process using the same cache, eg. with
+// SET NX in redis
+type UserLoaderKeyLocker interface {
+	// TryLock takes the lock for key without waiting, ok is false when another process holds it
+	TryLock(key string) (unlock func(), ok bool)
+}
+
+// UserLoaderDefaultKeyLockWait is how long a load waits for another process to fill the cache when KeyLockWait is 0
+const UserLoaderDefaultKeyLockWait = 100 * time.Millisecond
+
+// userLoaderKeyLocks holds the locks lockedFetch took for a batch, until end has cached its values
+type userLoaderKeyLocks struct {
+	locker UserLoaderKeyLocker
+	mu     sync.Mutex
+	held   map[string]func()
+}
+
+// lock takes the lock for key, a key that is already held, eg. by an earlier attempt of a retried fetch, is
+// locked again right away
+func (k *userLoaderKeyLocks) lock(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.held[key]; ok {
+		return true
+	}
+	unlock, ok := k.locker.TryLock(key)
+	if !ok {
+		return false
+	}
+	if k.held == nil {
+		k.held = map[string]func(){}
+	}
+	k.held[key] = unlock
+	return true
+}
+
+// release unlocks every key that is held, it is safe to call more than once
+func (k *userLoaderKeyLocks) release() {
+	k.mu.Lock()
+	held := k.held
+	k.held = nil
+	k.mu.Unlock()
+
+	for _, unlock := range held {
+		unlock()
+	}
+}
+
+// lockedFetch wraps fetch so it only fetches the keys this process could lock in locks, and waits for the cache
+// to be filled with the rest
+func (l *UserLoader) lockedFetch(config UserLoaderConfig, locks *userLoaderKeyLocks, fetch func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	wait := config.KeyLockWait
+	if wait == 0 {
+		wait = UserLoaderDefaultKeyLockWait
+	}
+
+	return func(keys []string) ([]*example.User, []error) {
+		data := make([]*example.User, len(keys))
+		errs := make([]error, len(keys))
+		fetchInto := func(positions []int) {
+			batch := make([]string, len(positions))
+			for i, pos := range positions {
+				batch[i] = keys[pos]
+			}
+			values, valueErrs := fetch(batch)
+			for i, pos := range positions {
+				if i < len(values) {
+					data[pos] = values[i]
+				}
+				if len(valueErrs) == 1 {
+					errs[pos] = valueErrs[0]
+				} else if i < len(valueErrs) {
+					errs[pos] = valueErrs[i]
+				}
+			}
+		}
+
+		var mine, theirs []int
+		for pos, key := range keys {
+			if locks.lock(key) {
+				mine = append(mine, pos)
+			} else {
+				theirs = append(theirs, pos)
+			}
+		}
+		if len(mine) > 0 {
+			fetchInto(mine)
+		}
+
+		deadline := time.Now().Add(wait)
+		for len(theirs) > 0 && time.Now().Before(deadline) {
+			time.Sleep(wait / 10)
+			waiting := theirs[:0]
+			for _, pos := range theirs {
+				if value, ok := config.Cache.Get(keys[pos]); ok {
+					data[pos] = value
+				} else {
+					waiting = append(waiting, pos)
+				}
+			}
+			theirs = waiting
+		}
+		// the other process didn't come through in time
+		if len(theirs) > 0 {
+			fetchInto(theirs)
+		}
+		return data, errs
+	}
+}
+
+// hedgedFetch returns a fetch that gets a second call when it is slower than the p99 of recent fetches, the first
+// to return wins. Both calls fetch with a child of ctx from fetchWith, it is cancelled once the winner returns.
+func (l *UserLoader) hedgedFetch(ctx context.Context, fetchWith func(ctx context.Context) func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	type result struct {
+		data []*example.User
+		errs []error
+	}
+
+	return func(keys []string) ([]*example.User, []error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		fetch := fetchWith(ctx)
+
+		results := make(chan result, 2)
+		call := func() {
+			data, errs := fetch(keys)
+			results <- result{data, errs}
+		}
+
+		delay, ok := l.latencies.percentile(99, 20)
+		if !ok {
+			call()
+			r := <-results
+			return r.data, r.errs
+		}
+
+		go call()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case r := <-results:
+			return r.data, r.errs
+		case <-timer.C:
+		}
+
+		go call()
+		r := <-results
+		return r.data, r.errs
+	}
+}
+
+// UserLoaderDefaultBackoff waits 10ms before the first retry, doubling with every attempt up to a second
+func UserLoaderDefaultBackoff(attempt int) time.Duration {
+	return UserLoaderExponentialBackoff(10*time.Millisecond, time.Second, 0)(attempt)
+}
+
+// UserLoaderExponentialBackoff returns a Backoff that waits initial before the first retry, doubling with every
+// attempt up to max. Every wait is shortened by a random fraction of up to jitter, from 0 to 1, so clients that
+// failed together don't retry in lockstep.
+func UserLoaderExponentialBackoff(initial, max time.Duration, jitter float64) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		wait := initial
+		for i := 1; i < attempt && wait < max; i++ {
+			wait *= 2
+		}
+		if wait > max {
+			wait = max
+		}
+		if jitter > 0 {
+			wait -= time.Duration(rand.Float64() * jitter * float64(wait))
+		}
+		return wait
+	}
+}
+
+// UserLoaderRetry calls op until it succeeds, fails with an error retryable doesn't accept or has been retried
+// maxRetries times, waiting backoff(attempt) before every retry, and returns its last error. It is the retry loop
+// MaxRetries uses, for Fetch implementations that retry calls of their own. A nil backoff is
+// UserLoaderDefaultBackoff and a nil retryable retries the same errors as a nil Retryable. Once ctx is done it
+// stops waiting and returns ctx.Err().
+func UserLoaderRetry(ctx context.Context, maxRetries int, backoff func(attempt int) time.Duration, retryable func(err error) bool, op func() error) error {
+	if backoff == nil {
+		backoff = UserLoaderDefaultBackoff
+	}
+
+	err := op()
+	for attempt := 1; attempt <= maxRetries && userLoaderRetryable(retryable, err); attempt++ {
+		if !userLoaderSleep(ctx, backoff(attempt)) {
+			return ctx.Err()
+		}
+		err = op()
+	}
+	return err
+}
+
+// userLoaderRetryable reports whether err is worth retrying, retryable nil = every error but the ones
+// wrapping ErrUserLoaderNotFound or ErrUserLoaderCircuitOpen
+func userLoaderRetryable(retryable func(err error) bool, err error) bool {
+	if err == nil {
+		return false
+	}
+	if retryable != nil {
+		return retryable(err)
+	}
+	return !errors.Is(err, ErrUserLoaderNotFound) && !errors.Is(err, ErrUserLoaderCircuitOpen)
+}
+
+// userLoaderSleep waits for d, it returns false when ctx was done first
+func userLoaderSleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// retriedFetch fetches the keys that failed with a retryable error again, up to config.MaxRetries times. It gives up
+// early once ctx is done, ie. every caller of a FetchContext batch gave up.
+func (l *UserLoader) retriedFetch(ctx context.Context, config UserLoaderConfig, fetch func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	backoff := config.Backoff
+	if backoff == nil {
+		backoff = UserLoaderDefaultBackoff
+	}
+
+	return func(keys []string) ([]*example.User, []error) {
+		data, errs := fetch(keys)
+		for attempt := 1; attempt <= config.MaxRetries; attempt++ {
+			retry := userLoaderRetries(config, keys, data, errs)
+			if len(retry) == 0 {
+				break
+			}
+
+			if !userLoaderSleep(ctx, backoff(attempt)) {
+				return data, errs
+			}
+
+			l.mu.Lock()
+			l.stats.Retries++
+			l.mu.Unlock()
+
+			if len(retry) == len(keys) {
+				data, errs = fetch(keys)
+				continue
+			}
+
+			retryKeys := make([]string, len(retry))
+			for i, pos := range retry {
+				retryKeys[i] = keys[pos]
+			}
+			retried, retriedErrs := fetch(retryKeys)
+			// results that don't line up with the keys can't be merged, the keys keep their earlier errors
+			if len(retried) > len(retryKeys) || (len(retriedErrs) > 1 && len(retriedErrs) != len(retryKeys)) {
+				continue
+			}
+
+			if len(data) < len(keys) {
+				data = append(data, make([]*example.User, len(keys)-len(data))...)
+			}
+			for i, pos := range retry {
+				var err error
+				if len(retriedErrs) == 1 {
+					err = retriedErrs[0]
+				} else if i < len(retriedErrs) {
+					err = retriedErrs[i]
+				}
+				if i < len(retried) {
+					data[pos] = retried[i]
+				}
+				errs[pos] = err
+			}
+		}
+		return data, errs
+	}
+}
+
+// userLoaderRetries returns the positions of the keys that failed with a retryable error. A single error
+// fails every key, and results that don't line up with the keys are left for checkedFetch to reject.
+func userLoaderRetries(config UserLoaderConfig, keys []string, data []*example.User, errs []error) []int {
+	retryable := func(err error) bool {
+		return userLoaderRetryable(config.Retryable, err)
+	}
+
+	if len(errs) == 1 && len(keys) != 1 {
+		if !retryable(errs[0]) {
+			return nil
+		}
+		retry := make([]int, len(keys))
+		for pos := range retry {
+			retry[pos] = pos
+		}
+		return retry
+	}
+	if len(errs) != len(keys) || len(data) > len(keys) {
+		return nil
+	}
+
+	var retry []int
+	for pos, err := range errs {
+		if retryable(err) {
+			retry = append(retry, pos)
+		}
+	}
+	return retry
+}
+
+// userLoaderLatencies keeps the durations of the most recent fetches
+type userLoaderLatencies struct {
+	mu      sync.Mutex
+	samples [128]time.Duration
+	n       int
+}
+
+func (la *userLoaderLatencies) record(d time.Duration) {
+	la.mu.Lock()
+	defer la.mu.Unlock()
+	la.samples[la.n%len(la.samples)] = d
+	la.n++
+}
+
+// percentile returns the pth percentile of the recent fetches, ok is false until there are at least minSamples
+func (la *userLoaderLatencies) percentile(p int, minSamples int) (d time.Duration, ok bool) {
+	la.mu.Lock()
+	n := la.n
+	if n > len(la.samples) {
+		n = len(la.samples)
+	}
+	if n == 0 || n < minSamples {
+		la.mu.Unlock()
+		return 0, false
+	}
+	samples := make([]time.Duration, n)
+	copy(samples, la.samples[:n])
+	la.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[n*p/100], true
+}
+
+// UserLoaderResultLengthError is returned for every key of a batch when Fetch returns a number of values or errors
+// that doesn't line up with the keys it was given, see Strict
+type UserLoaderResultLengthError struct {
+	Keys   int
+	Values int
+	Errors int
+}
+
+func (e *UserLoaderResultLengthError) Error() string {
+	return fmt.Sprintf("UserLoader: fetch returned %d values and %d errors for %d keys", e.Values, e.Errors, e.Keys)
+}
+
+// UserLoaderOverloadError is returned for loads that would take the loader over MaxPendingKeys, errors.Is matches
+// it with ErrUserLoaderOverloaded
+type UserLoaderOverloadError struct {
+	Pending        int
+	MaxPendingKeys int
+}
+
+func (e *UserLoaderOverloadError) Error() string {
+	return fmt.Sprintf("UserLoader: overloaded, %d keys are pending (MaxPendingKeys is %d)", e.Pending, e.MaxPendingKeys)
+}
+
+func (e *UserLoaderOverloadError) Is(target error) bool {
+	return target == ErrUserLoaderOverloaded
+}
+
+// ErrUserLoaderOverloaded matches every UserLoaderOverloadError, for callers that only need to know the load was shed
+var ErrUserLoaderOverloaded = errors.New("UserLoader: overloaded")
+
+// UserLoaderKeyCount is an approximate number of times a key was loaded
+type UserLoaderKeyCount struct {
+	Key   string
+	Count uint64
+}
+
+// HotKeys returns up to n of the most loaded keys, hottest first. Counts come from a count-min sketch so they
+// can overestimate, but never underestimate, how often a key was loaded. This is intended to be exposed on
+// debug or admin endpoints to find entities worth dedicated caching.
+func (l *UserLoader) HotKeys(n int) []UserLoaderKeyCount {
+	if l.hotKeys == nil {
+		return nil
+	}
+	return l.hotKeys.top(n)
+}
+
+const (
+	userLoaderSketchDepth = 4
+	userLoaderSketchWidth = 2048
+)
+
+type userLoaderHotKeys struct {
+	mu     sync.Mutex
+	size   int
+	sketch [userLoaderSketchDepth][userLoaderSketchWidth]uint64
+	counts map[string]uint64
+}
+
+func newuserLoaderHotKeys(size int) *userLoaderHotKeys {
+	return &userLoaderHotKeys{
+		size:   size,
+		counts: make(map[string]uint64, size),
+	}
+}
+
+func (h *userLoaderHotKeys) record(key string) {
+	if h == nil {
+		return
+	}
+	hash := fnv.New64a()
+	fmt.Fprint(hash, key)
+	sum := hash.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	estimate := ^uint64(0)
+	for i := range h.sketch {
+		cell := &h.sketch[i][(h1+uint32(i)*h2)%userLoaderSketchWidth]
+		*cell++
+		if *cell < estimate {
+			estimate = *cell
+		}
+	}
+
+	if _, ok := h.counts[key]; ok || len(h.counts) < h.size {
+		h.counts[key] = estimate
+		return
+	}
+
+	var coldest string
+	coldestCount := ^uint64(0)
+	for k, count := range h.counts {
+		if count < coldestCount {
+			coldest, coldestCount = k, count
+		}
+	}
+	if estimate > coldestCount {
+		delete(h.counts, coldest)
+		h.counts[key] = estimate
+	}
+}
+
+func (h *userLoaderHotKeys) top(n int) []UserLoaderKeyCount {
+	h.mu.Lock()
+	keys := make([]UserLoaderKeyCount, 0, len(h.counts))
+	for key, count := range h.counts {
+		keys = append(keys, UserLoaderKeyCount{Key: key, Count: count})
+	}
+	h.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Count > keys[j].Count
+	})
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// ErrUserLoaderNotFound is returned for keys whose value is missing, eg. because IsDeleted matched it
+var ErrUserLoaderNotFound = errors.New("UserLoader: not found")
+
+// UserLoaderMissingPolicy decides what loads of keys that Fetch didn't find return
+type UserLoaderMissingPolicy int
+
+const (
+	// UserLoaderMissingAsFetched returns whatever Fetch returned for the key
+	UserLoaderMissingAsFetched UserLoaderMissingPolicy = iota
+
+	// UserLoaderMissingZero returns the zero value without an error, eg. for nullable graphql fields. Fetch
+	// errors wrapping ErrUserLoaderNotFound are dropped and the zero value is cached.
+	UserLoaderMissingZero
+
+	// UserLoaderMissingError fails keys that Fetch returned no value for (or a nil value) with ErrUserLoaderNotFound
+	UserLoaderMissingError
+)
+
+// UserLoaderClosedPolicy decides what happens to loads after Close
+type UserLoaderClosedPolicy int
+
+const (
+	// UserLoaderClosedError fails loads with ErrUserLoaderClosed
+	UserLoaderClosedError UserLoaderClosedPolicy = iota
+
+	// UserLoaderClosedPanic panics on load, to catch loaders that are used after shutdown during development
+	UserLoaderClosedPanic
+
+	// UserLoaderClosedFetch calls Fetch for every load on its own, without batching or caching
+	UserLoaderClosedFetch
+)
+
+// ErrUserLoaderOwnerDone is what loads panic with when they happen after the Owner of the loader is done
+var ErrUserLoaderOwnerDone = errors.New("UserLoader: used after the request that owns it finished")
+
+// checkOwner panics once the owner is done, it must be called with the loader locked and unlocks it before panicking
+func (l *UserLoader) checkOwner(key string) {
+	if l.owner == nil || l.owner.Err() == nil {
+		return
+	}
+	l.mu.Unlock()
+	panic(fmt.Errorf("%w: loading %s", ErrUserLoaderOwnerDone, userLoaderKeyString(key)))
+}
+
+// ErrUserLoaderMutated is what loads panic with when DetectMutations catches a cached value that was changed in place
+var ErrUserLoaderMutated = errors.New("UserLoader: cached value was changed in place")
+
+// CheckMutations returns the keys whose cached values were changed in place since they were cached or last
+// checked, without calling OnMutation, eg. to check every value at the end of a test. DetectMutations must be set.
+func (l *UserLoader) CheckMutations() []string {
+	l.mu.Lock()
+	metas := make(map[string]*UserLoaderEntryMeta, len(l.meta))
+	if l.detectMutations {
+		for key, meta := range l.meta {
+			metas[key] = meta
+		}
+	}
+	l.mu.Unlock()
+
+	values := make(map[string]*example.User, len(metas))
+	for key := range metas {
+		if value, ok := l.cache.Get(key); ok {
+			values[key] = value
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var mutated []string
+	for key, value := range values {
+		if meta := metas[key]; l.meta[key] == meta && meta.mutated(value) {
+			mutated = append(mutated, key)
+		}
+	}
+	return mutated
+}
+
+// mutated reports whether value no longer matches its checksum, updating it so a change is only reported once
+func (m *UserLoaderEntryMeta) mutated(value *example.User) bool {
+	checksum := userLoaderChecksum(value)
+	if checksum == m.checksum {
+		return false
+	}
+	m.checksum = checksum
+	return true
+}
+
+// userLoaderChecksum hashes value as encoding/json encodes it, values json can't encode all hash the same
+func userLoaderChecksum(value *example.User) uint64 {
+	h := fnv.New64a()
+	if err := json.NewEncoder(h).Encode(value); err != nil {
+		return 0
+	}
+	return h.Sum64()
+}
+
+// ErrUserLoaderClosed is returned for loads after Close when the ClosedPolicy is UserLoaderClosedError
+var ErrUserLoaderClosed = errors.New("UserLoader: loader is closed")
+
+// Close stops the loader from batching any new loads, from now on they are handled according to the ClosedPolicy.
+// Batches still collecting keys are fetched right away, like with Flush, and Close waits for every pending batch
+// to return, or for ctx to be done.
+func (l *UserLoader) Close(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+
+	l.Flush()
+	return l.WaitForPending(ctx)
+}
+
+// WaitForPending blocks until every batch that is currently scheduled, either still collecting keys or already
+// fetching, has returned, or until ctx is done. Batches started in the meantime aren't waited for. Frameworks can use
+// this as a barrier between execution phases, or before serializing a response.
+func (l *UserLoader) WaitForPending(ctx context.Context) error {
+	l.mu.Lock()
+	pending := make([]chan struct{}, 0, len(l.inflight))
+	for b := range l.inflight {
+		pending = append(pending, b.done)
+	}
+	l.mu.Unlock()
+
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (l *UserLoader) closedThunk(config UserLoaderConfig, key string) (func() (*example.User, error), func()) {
+	switch config.ClosedPolicy {
+	case UserLoaderClosedPanic:
+		panic(ErrUserLoaderClosed)
+
+	case UserLoaderClosedFetch:
+		if fetchContext := config.contextFetch(); fetchContext != nil {
+			config.Fetch = func(keys []string) ([]*example.User, []error) {
+				return fetchContext(context.Background(), keys)
+			}
+		}
+		config.Fetch = userLoaderRecovered(config.Fetch)
+		return func() (*example.User, error) {
+			b := &userLoaderBatch{keys: []string{key}}
+			b.data, b.error = b.checkedFetch(config, b.keys)
+
+			var data *example.User
+			if len(b.data) > 0 {
+				data = b.data[0]
+			}
+			return data, b.errorAt(0)
+		}, func() {}
+
+	default:
+		return func() (*example.User, error) {
+			var zero *example.User
+			return zero, ErrUserLoaderClosed
+		}, func() {}
+	}
+}
+
+// UserLoaderPool runs fetches, it is satisfied by UserLoaderWorkerPool or any other goroutine pool
+type UserLoaderPool interface {
+	// Go runs task, it may block until there is capacity to do so
+	Go(task func())
+}
+
+// UserLoaderWorkerPool runs tasks on a fixed number of goroutines. It can be shared by many loaders, even of
+// different types, to bound the number of fetches running at once. A Fetch must not wait on another loader using
+// the same pool, or it can end up waiting for itself.
+type UserLoaderWorkerPool struct {
+	tasks chan func()
+}
+
+// NewUserLoaderWorkerPool starts a pool of workers goroutines
+func NewUserLoaderWorkerPool(workers int) *UserLoaderWorkerPool {
+	p := &UserLoaderWorkerPool{tasks: make(chan func())}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Go blocks until a worker is free to run task
+func (p *UserLoaderWorkerPool) Go(task func()) {
+	p.tasks <- task
+}
+
+// Stop the workers once they are done with their current task, Go must not be called afterwards
+func (p *UserLoaderWorkerPool) Stop() {
+	close(p.tasks)
+}
+
+func (p *UserLoaderWorkerPool) work() {
+	for task := range p.tasks {
+		task()
+	}
+}
+
+// UserLoaderBreaker is a circuit breaker guarding Fetch, eg. UserLoaderCircuitBreaker or an adapter to another library
+type UserLoaderBreaker interface {
+	// Allow reports whether a batch may be fetched now
+	Allow() bool
+
+	// Done is told whether a fetch that Allow let through failed
+	Done(failed bool)
+}
+
+// ErrUserLoaderCircuitOpen is returned for the keys of batches the Breaker didn't allow to be fetched
+var ErrUserLoaderCircuitOpen = errors.New("UserLoader: circuit open")
+
+// userLoaderBrokenFetch wraps fetch so it is only called when breaker allows it. A fetch failed when it
+// failed the whole batch, either with a single error or with an error for every key. Keys that weren't found,
+// including the ones the MissingPolicy fails, say nothing about the health of the backend and don't count.
+func userLoaderBrokenFetch(breaker UserLoaderBreaker, fetch func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	return func(keys []string) ([]*example.User, []error) {
+		if !breaker.Allow() {
+			return nil, []error{ErrUserLoaderCircuitOpen}
+		}
+
+		data, errs := fetch(keys)
+		failed := len(errs) == 1 && errs[0] != nil && !errors.Is(errs[0], ErrUserLoaderNotFound)
+		if len(errs) == len(keys) && len(keys) > 0 {
+			failed = true
+			for _, err := range errs {
+				if err == nil || errors.Is(err, ErrUserLoaderNotFound) {
+					failed = false
+					break
+				}
+			}
+		}
+		breaker.Done(failed)
+		return data, errs
+	}
+}
+
+// UserLoaderCircuitBreakerConfig tunes a UserLoaderCircuitBreaker, zero fields take the defaults
+type UserLoaderCircuitBreakerConfig struct {
+	// FailureRatio is the fraction of failed fetches in a window that opens the circuit, 0 = 0.5
+	FailureRatio float64
+
+	// MinFetches is how many fetches a window needs before it can open the circuit, 0 = 10
+	MinFetches int
+
+	// Window is how long fetches are counted for before the counts start over, 0 = 10 seconds
+	Window time.Duration
+
+	// Cooldown is how long the circuit stays open before a single fetch is let through to probe the backend.
+	// The circuit closes again when it succeeds, and stays open for another Cooldown when it fails. 0 = 5 seconds.
+	Cooldown time.Duration
+}
+
+// UserLoaderCircuitBreaker opens once too many fetches of a window fail, so a failing backend isn't kept busy with
+// requests that are bound to fail. It can be shared by loaders fetching from the same backend.
+type UserLoaderCircuitBreaker struct {
+	config UserLoaderCircuitBreakerConfig
+
+	mu       sync.Mutex
+	started  time.Time
+	fetches  int
+	failures int
+	open     bool
+	opened   time.Time
+	probing  bool
+}
+
+// NewUserLoaderCircuitBreaker creates a closed circuit breaker
+func NewUserLoaderCircuitBreaker(config UserLoaderCircuitBreakerConfig) *UserLoaderCircuitBreaker {
+	if config.FailureRatio == 0 {
+		config.FailureRatio = 0.5
+	}
+	if config.MinFetches == 0 {
+		config.MinFetches = 10
+	}
+	if config.Window == 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = 5 * time.Second
+	}
+	return &UserLoaderCircuitBreaker{config: config, started: time.Now()}
+}
+
+// Allow lets every fetch through while the circuit is closed, and a single probe once an open one cooled down
+func (c *UserLoaderCircuitBreaker) Allow() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.open {
+		if c.probing || now.Sub(c.opened) < c.config.Cooldown {
+			return false
+		}
+		c.probing = true
+		return true
+	}
+	if now.Sub(c.started) > c.config.Window {
+		c.started, c.fetches, c.failures = now, 0, 0
+	}
+	return true
+}
+
+// Done counts the outcome of a fetch
+func (c *UserLoaderCircuitBreaker) Done(failed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.open {
+		// fetches that were let through before the circuit opened don't count, only the probe does
+		if !c.probing {
+			return
+		}
+		c.probing = false
+		if failed {
+			c.opened = time.Now()
+		} else {
+			c.open = false
+			c.started, c.fetches, c.failures = time.Now(), 0, 0
+		}
+		return
+	}
+
+	c.fetches++
+	if failed {
+		c.failures++
+	}
+	if c.fetches >= c.config.MinFetches && float64(c.failures) >= c.config.FailureRatio*float64(c.fetches) {
+		c.open = true
+		c.opened = time.Now()
+	}
+}
+
+// Open reports whether the circuit is open, ie. fetches are short-circuited
+func (c *UserLoaderCircuitBreaker) Open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// UserLoaderChunk splits keys into chunks of up to n keys, eg. for a backend that caps the size of an IN clause
+// below MaxBatch, and calls fetch for every chunk at once. The results are merged back in the order of keys: a
+// single error for a chunk fails each of its keys, keys a chunk returned no value for get the zero value, and a
+// chunk with more results than keys fails with a UserLoaderResultLengthError. A panic in fetch fails its chunk with
+// a UserLoaderPanicError. With n <= 0 all keys are fetched as a single chunk, merged the same way.
+func UserLoaderChunk(keys []string, n int, fetch func(keys []string) ([]*example.User, []error)) ([]*example.User, []error) {
+	if n <= 0 || n > len(keys) {
+		n = len(keys)
+	}
+
+	fetch = userLoaderRecovered(fetch)
+	data := make([]*example.User, len(keys))
+	errs := make([]error, len(keys))
+	var failed int32
+	var wg sync.WaitGroup
+	for start := 0; start < len(keys); start += n {
+		end := start + n
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			chunk := keys[start:end]
+			chunkData, chunkErrs := fetch(chunk)
+			if len(chunkData) > len(chunk) || (len(chunkErrs) > 1 && len(chunkErrs) != len(chunk)) {
+				chunkData, chunkErrs = nil, []error{&UserLoaderResultLengthError{Keys: len(chunk), Values: len(chunkData), Errors: len(chunkErrs)}}
+			}
+
+			copy(data[start:end], chunkData)
+			for i := range chunk {
+				var err error
+				if len(chunkErrs) == 1 {
+					err = chunkErrs[0]
+				} else if i < len(chunkErrs) {
+					err = chunkErrs[i]
+				}
+				if err != nil {
+					errs[start+i] = err
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	if failed == 0 {
+		return data, nil
+	}
+	return data, errs
+}
+
+// userLoaderRecording is one recorded batch, errors are kept as their messages along with the sentinel
+// they wrapped, if any
+type userLoaderRecording struct {
+	Keys      []string        `json:"keys"`
+	Values    []*example.User `json:"values"`
+	Errors    []string        `json:"errors,omitempty"`
+	Sentinels []string        `json:"sentinels,omitempty"`
+}
+
+// userLoaderSentinels are the errors Record tags recorded errors with, so errors.Is still matches them
+// once they are replayed
+var userLoaderSentinels = []struct {
+	tag string
+	err error
+}{
+	{"not_found", ErrUserLoaderNotFound},
+	{"circuit_open", ErrUserLoaderCircuitOpen},
+	{"injected", ErrUserLoaderInjected},
+	{"overloaded", ErrUserLoaderOverloaded},
+	{"closed", ErrUserLoaderClosed},
+}
+
+// userLoaderReplayedError is a recorded error that wrapped one of userLoaderSentinels
+type userLoaderReplayedError struct {
+	msg      string
+	sentinel error
+}
+
+func (e *userLoaderReplayedError) Error() string {
+	return e.msg
+}
+
+func (e *userLoaderReplayedError) Unwrap() error {
+	return e.sentinel
+}
+
+// userLoaderReplayed turns a recorded error back into an error, unknown errors only keep their message
+func userLoaderReplayed(msg, tag string) error {
+	for _, sentinel := range userLoaderSentinels {
+		if sentinel.tag != tag {
+			continue
+		}
+		if msg == sentinel.err.Error() {
+			return sentinel.err
+		}
+		return &userLoaderReplayedError{msg: msg, sentinel: sentinel.err}
+	}
+	return errors.New(msg)
+}
+
+// UserLoaderRecord wraps fetch so every batch and its results are written to w as a line of json, eg. to a golden
+// file that UserLoaderReplay serves in tests later. Values must survive a round trip through encoding/json.
+func UserLoaderRecord(w io.Writer, fetch func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return func(keys []string) ([]*example.User, []error) {
+		data, errs := fetch(keys)
+
+		rec := userLoaderRecording{Keys: keys, Values: data}
+		tagged := false
+		for _, err := range errs {
+			msg, tag := "", ""
+			if err != nil {
+				msg = err.Error()
+				for _, sentinel := range userLoaderSentinels {
+					if errors.Is(err, sentinel.err) {
+						tag, tagged = sentinel.tag, true
+						break
+					}
+				}
+			}
+			rec.Errors = append(rec.Errors, msg)
+			rec.Sentinels = append(rec.Sentinels, tag)
+		}
+		if !tagged {
+			rec.Sentinels = nil
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(rec); err != nil {
+			return nil, []error{fmt.Errorf("UserLoader: recording batch: %w", err)}
+		}
+		return data, errs
+	}
+}
+
+// ErrUserLoaderNotRecorded is returned by a replayed fetch for keys that weren't recorded
+var ErrUserLoaderNotRecorded = errors.New("UserLoader: key wasn't recorded")
+
+// UserLoaderReplay reads batches written by UserLoaderRecord and returns a fetch that serves them. Results are looked
+// up per key, so batches don't have to come together the same way they did while recording.
+func UserLoaderReplay(r io.Reader) (func(keys []string) ([]*example.User, []error), error) {
+	type result struct {
+		value *example.User
+		err   error
+	}
+	results := map[string]result{}
+
+	dec := json.NewDecoder(r)
+	for {
+		var rec userLoaderRecording
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("UserLoader: reading recording: %w", err)
+		}
+
+		for pos, key := range rec.Keys {
+			var res result
+			if pos < len(rec.Values) {
+				res.value = rec.Values[pos]
+			}
+			// a single error was returned for the whole batch
+			at := pos
+			if len(rec.Errors) == 1 {
+				at = 0
+			}
+			if at < len(rec.Errors) && rec.Errors[at] != "" {
+				tag := ""
+				if at < len(rec.Sentinels) {
+					tag = rec.Sentinels[at]
+				}
+				res.err = userLoaderReplayed(rec.Errors[at], tag)
+			}
+			results[key] = res
+		}
+	}
+
+	return func(keys []string) ([]*example.User, []error) {
+		data := make([]*example.User, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			res, ok := results[key]
+			if !ok {
+				errs[i] = fmt.Errorf("%w: %s", ErrUserLoaderNotRecorded, userLoaderKeyString(key))
+				continue
+			}
+			data[i], errs[i] = res.value, res.err
+		}
+		return data, errs
+	}, nil
+}
+
+// ErrUserLoaderInjected is the error of keys and batches failed by a UserLoaderChaos
+var ErrUserLoaderInjected = errors.New("UserLoader: injected failure")
+
+// UserLoaderChaosConfig is the degradation a UserLoaderChaos injects, the zero value injects nothing
+type UserLoaderChaosConfig struct {
+	// Latency is added to every batch
+	Latency time.Duration
+
+	// KeyErrorRate is the fraction of keys, from 0 to 1, that fail with ErrUserLoaderInjected
+	KeyErrorRate float64
+
+	// BatchErrorRate is the fraction of batches, from 0 to 1, that fail as a whole without calling fetch
+	BatchErrorRate float64
+}
+
+// UserLoaderChaos injects latency and failures into a fetch, to see how resolvers cope with a degraded loader
+// (eg. in staging). Its config can be changed at any time, so it can be switched on and off at runtime.
+type UserLoaderChaos struct {
+	mu     sync.Mutex
+	config UserLoaderChaosConfig
+}
+
+// Set replaces the degradation that is injected from the next batch on
+func (c *UserLoaderChaos) Set(config UserLoaderChaosConfig) {
+	c.mu.Lock()
+	c.config = config
+	c.mu.Unlock()
+}
+
+// Wrap returns fetch with the degradation of c injected
+func (c *UserLoaderChaos) Wrap(fetch func(keys []string) ([]*example.User, []error)) func(keys []string) ([]*example.User, []error) {
+	return func(keys []string) ([]*example.User, []error) {
+		c.mu.Lock()
+		config := c.config
+		c.mu.Unlock()
+
+		if config.Latency > 0 {
+			time.Sleep(config.Latency)
+		}
+		if config.BatchErrorRate > 0 && rand.Float64() < config.BatchErrorRate {
+			return nil, []error{ErrUserLoaderInjected}
+		}
+
+		data, errs := fetch(keys)
+		if config.KeyErrorRate <= 0 || (len(errs) == 1 && errs[0] != nil) {
+			return data, errs
+		}
+
+		injected := make([]error, len(keys))
+		copy(injected, errs)
+		for pos := range keys {
+			if rand.Float64() < config.KeyErrorRate {
+				injected[pos] = ErrUserLoaderInjected
+			}
+		}
+		return data, injected
+	}
+}
+
+// userLoaderExport is one cache entry as written by Export
+type userLoaderExport struct {
+	Key     string        `json:"key"`
+	Value   *example.User `json:"value"`
+	Expires time.Time     `json:"expires,omitempty"`
+
+	// TTL is what was left of the TTL, Import uses it rather than Expires so clocks of the two instances
+	// needn't agree
+	TTL time.Duration `json:"ttl,omitempty"`
+}
+
+// Export writes the entries this loader has cached to w as lines of json, eg. to warm up the instance replacing
+// this one in a blue/green deploy with Import. It returns how many entries were written.
+func (l *UserLoader) Export(w io.Writer) (int, error) {
+	l.mu.Lock()
+	entries := make([]userLoaderExport, 0, len(l.meta))
+	for key, meta := range l.meta {
+		entry := userLoaderExport{Key: key, Expires: meta.Expires}
+		if !meta.Expires.IsZero() {
+			if entry.TTL = time.Until(meta.Expires); entry.TTL <= 0 {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	l.mu.Unlock()
+
+	enc := json.NewEncoder(w)
+	written := 0
+	for _, entry := range entries {
+		value, ok := l.cache.Get(entry.Key)
+		if !ok {
+			continue
+		}
+		entry.Value = value
+		if err := enc.Encode(entry); err != nil {
+			return written, fmt.Errorf("UserLoader: exporting %s: %w", userLoaderKeyString(entry.Key), err)
+		}
+		written++
+	}
+	return written, nil
+}
+
+// Import primes the cache with the entries written by Export, keeping what is left of their TTL. Keys that are
+// already cached are left alone. It returns how many entries were cached.
+func (l *UserLoader) Import(r io.Reader) (int, error) {
+	l.mu.Lock()
+	missingError := l.missingPolicy == UserLoaderMissingError
+	l.mu.Unlock()
+
+	dec := json.NewDecoder(r)
+	imported := 0
+	for {
+		var entry userLoaderExport
+		if err := dec.Decode(&entry); err == io.EOF {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("UserLoader: importing: %w", err)
+		}
+
+		var opts []UserLoaderPrimeOption
+		if !entry.Expires.IsZero() {
+			ttl := entry.TTL
+			if ttl == 0 {
+				// written before exports had a ttl
+				ttl = time.Until(entry.Expires)
+			}
+			if ttl <= 0 {
+				continue
+			}
+			opts = append(opts, UserLoaderWithTTL(ttl))
+		}
+		// a null value is a key fetch didn't find, it is only cached when a fetched nil would be
+		if entry.Value == nil && missingError {
+			continue
+		}
+		if l.Prime(entry.Key, entry.Value, opts...) {
+			imported++
+		}
+	}
+}
+
+// UserLoaderOptional is a value that may legitimately be absent. Absent keys have Found false and no error,
+// so "not found" is told apart from both a failed fetch and a found zero value.
+type UserLoaderOptional struct {
+	Value *example.User
+	Found bool
+}
+
+// LoadOptional loads key like Load, but reports keys that are not found, ie. that fail with
+// ErrUserLoaderNotFound, as absent instead of failing. Absence is cached like a value: until the key is
+// cleared or evicted, or the TTL of the loader passed.
+// With UserLoaderMissingZero missing keys load as found zero values, so return ErrUserLoaderNotFound from Fetch
+// or use UserLoaderMissingError to see them as absent.
+func (l *UserLoader) LoadOptional(key string) (UserLoaderOptional, error) {
+	value, err := l.Load(key)
+	return l.optional(key, value, err)
+}
+
+// LoadAllOptional loads many keys like LoadAll, reporting the ones that are not found as absent
+func (l *UserLoader) LoadAllOptional(keys []string) ([]UserLoaderOptional, []error) {
+	values, errs := l.LoadAll(keys)
+	optionals := make([]UserLoaderOptional, len(keys))
+	for i, key := range keys {
+		optionals[i], errs[i] = l.optional(key, values[i], errs[i])
+	}
+	return optionals, errs
+}
+
+// PrimeOptional primes the cache with value, or with its absence when it isn't found. If the key is already
+// cached or known to be absent no change is made and false is returned.
+func (l *UserLoader) PrimeOptional(key string, value UserLoaderOptional) bool {
+	if value.Found {
+		return l.Prime(key, value.Value)
+	}
+
+	if _, found := l.cache.Get(key); found {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.unsafeIsAbsent(key) {
+		return false
+	}
+	l.unsafeAbsent(key)
+	return true
+}
+
+func (l *UserLoader) optional(key string, value *example.User, err error) (UserLoaderOptional, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if errors.Is(err, ErrUserLoaderNotFound) {
+		l.unsafeAbsent(key)
+		return UserLoaderOptional{}, nil
+	}
+	if err != nil {
+		return UserLoaderOptional{}, err
+	}
+	// with UserLoaderMissingZero keys known to be absent load as zero values
+	if l.unsafeIsAbsent(key) {
+		return UserLoaderOptional{}, nil
+	}
+	return UserLoaderOptional{Value: value, Found: true}, nil
+}
+
+type userLoaderCachedError struct {
+	err     error
+	expires time.Time
+}
+
+// cacheErrors caches the errors of a batch that config.CacheError accepts for config.ErrorTTL, keys cleared while
+// the batch was fetched aren't cached. It must be called with the loader locked.
+func (l *UserLoader) cacheErrors(config UserLoaderConfig, b *userLoaderBatch) {
+	expires := time.Now().Add(config.ErrorTTL)
+	for pos, key := range b.keys {
+		err := b.errorAt(pos)
+		if err == nil || b.staleAll || b.stale[pos] {
+			continue
+		}
+		if config.CacheError != nil && !config.CacheError(key, err) {
+			continue
+		}
+		if config.CacheError == nil && !errors.Is(err, ErrUserLoaderNotFound) {
+			continue
+		}
+		if l.errored == nil {
+			l.errored = map[string]userLoaderCachedError{}
+		}
+		l.errored[key] = userLoaderCachedError{err: err, expires: expires}
+	}
+}
+
+// unsafeAbsent remembers that key has no value, loads of it fail with ErrUserLoaderNotFound until it is cleared
+// or the TTL of the loader passed. It must be called with the loader locked.
+func (l *UserLoader) unsafeAbsent(key string) {
+	if l.deleted == nil {
+		l.deleted = map[string]time.Time{}
+	}
+	var expires time.Time
+	if l.ttl > 0 {
+		expires = time.Now().Add(l.ttl)
+	}
+	l.deleted[key] = expires
+}
+
+// unsafeIsAbsent reports whether key is known to be absent, forgetting it once it expired. It must be called with
+// the loader locked.
+func (l *UserLoader) unsafeIsAbsent(key string) bool {
+	expires, ok := l.deleted[key]
+	if ok && !expires.IsZero() && time.Now().After(expires) {
+		delete(l.deleted, key)
+		return false
+	}
+	return ok
+}
+
+// UserLoaderName names the loader, eg. in metrics labels and logs. Use it instead of a string literal so a
+// typo can't split a dashboard in two.
+const UserLoaderName = "UserLoader"
+
+type userLoaderContextKey struct{}
+
+// WithUserLoader returns a copy of ctx that carries l, eg. for a middleware that creates loaders per request
+func WithUserLoader(ctx context.Context, l *UserLoader) context.Context {
+	return context.WithValue(ctx, userLoaderContextKey{}, l)
+}
+
+// UserLoaderFromContext returns the loader WithUserLoader put in ctx, or nil if there is none
+func UserLoaderFromContext(ctx context.Context) *UserLoader {
+	l, _ := ctx.Value(userLoaderContextKey{}).(*UserLoader)
+	return l
+}
+
+// UserLoaderConn is what FetchConn queries through, *sql.DB, *sql.Conn and *sql.Tx all implement it
+type UserLoaderConn interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// UserLoaderDBConn is an Acquire that checks out a connection from db for every batch
+func UserLoaderDBConn(db *sql.DB) func(ctx context.Context, fetch func(conn UserLoaderConn)) error {
+	return func(ctx context.Context, fetch func(conn UserLoaderConn)) error {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		fetch(conn)
+		return nil
+	}
+}
+
+// UserLoaderTxConn is an Acquire that runs every batch in tx, eg. for a loader created for a request inside the
+// request's transaction. Batches running at the same time share tx, so its driver has to allow that.
+func UserLoaderTxConn(tx *sql.Tx) func(ctx context.Context, fetch func(conn UserLoaderConn)) error {
+	return func(ctx context.Context, fetch func(conn UserLoaderConn)) error {
+		fetch(tx)
+		return nil
+	}
+}
+
+// contextFetch is the fetch taking a ctx that is configured, FetchConn wrapped in Acquire or FetchContext. It
+// is nil when only Fetch is.
+func (c UserLoaderConfig) contextFetch() func(ctx context.Context, keys []string) ([]*example.User, []error) {
+	if c.FetchConn == nil {
+		return c.FetchContext
+	}
+	fetchConn, acquire := c.FetchConn, c.Acquire
+	return func(ctx context.Context, keys []string) ([]*example.User, []error) {
+		var data []*example.User
+		var errs []error
+		err := acquire(ctx, func(conn UserLoaderConn) {
+			data, errs = fetchConn(ctx, conn, keys)
+		})
+		if err != nil {
+			return nil, []error{err}
+		}
+		return data, errs
+	}
+}
+
+// userLoaderKeyString is how keys are written out, eg. in external caches and errors
+func userLoaderKeyString(key string) string {
+	return key
 }
@@ -0,0 +1,15 @@
+//go:generate ../../dataloaden -internal-prefix dl -iter -spill -redis -prometheus -otel UserLoader string *github.com/tribunadigital/dataloaden/example.User
+
+package embed
+
+import "github.com/tribunadigital/dataloaden/example"
+
+// cache and batch would collide with the fields of the same names without -internal-prefix
+
+func (l *UserLoader) cache(user *example.User) {
+	l.Prime(user.ID, user)
+}
+
+func (l *UserLoader) batch(ids ...string) ([]*example.User, []error) {
+	return l.LoadAll(ids)
+}
@@ -0,0 +1,32 @@
+package embed
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tribunadigital/dataloaden/example"
+)
+
+func TestInternalPrefix(t *testing.T) {
+	var fetched []string
+	dl := NewUserLoader(UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			fetched = append(fetched, keys...)
+			users := make([]*example.User, len(keys))
+			for i, key := range keys {
+				users[i] = &example.User{ID: key}
+			}
+			return users, nil
+		},
+	})
+
+	dl.cache(&example.User{ID: "U1", Name: "primed"})
+	users, errs := dl.batch("U1", "U2")
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, "primed", users[0].Name)
+	require.Equal(t, "U2", users[1].ID)
+	require.Equal(t, []string{"U2"}, fetched)
+}
@@ -0,0 +1,58 @@
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package embed
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// UserLoaderOTel is a UserLoaderTracer that starts an OpenTelemetry span for every batch. The span is linked to the
+// spans of the callers waiting on the batch, as a batch has many parents.
+type UserLoaderOTel struct {
+	dlTracer trace.Tracer
+}
+
+// NewUserLoaderOTel creates a UserLoaderOTel that starts its spans with a tracer from tp
+func NewUserLoaderOTel(tp trace.TracerProvider) *UserLoaderOTel {
+	return &UserLoaderOTel{dlTracer: tp.Tracer("github.com/tribunadigital/dataloaden")}
+}
+
+// StartBatch starts a "UserLoader.fetch" span with the number of keys, it is ended with the number of failed keys
+func (o *UserLoaderOTel) StartBatch(ctx context.Context, callers []context.Context, keys int) (context.Context, func(errors int)) {
+	var links []trace.Link
+	seen := map[trace.SpanID]bool{}
+	for _, caller := range callers {
+		sc := trace.SpanContextFromContext(caller)
+		if !sc.IsValid() || seen[sc.SpanID()] {
+			continue
+		}
+		seen[sc.SpanID()] = true
+		links = append(links, trace.Link{SpanContext: sc})
+	}
+
+	ctx, span := o.dlTracer.Start(ctx, UserLoaderName+".fetch",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("dataloader.name", UserLoaderName),
+			attribute.Int("dataloader.keys", keys),
+			attribute.String("dataloader.batch_id", UserLoaderBatchID(ctx)),
+		),
+	)
+	return ctx, func(errors int) {
+		span.SetAttributes(attribute.Int("dataloader.errors", errors))
+		span.End()
+	}
+}
+
+// TraceID returns the ID of the trace of the batch span in ctx, so metrics can point at it
+func (o *UserLoaderOTel) TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
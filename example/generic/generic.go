@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -generic UserLoader string *github.com/tribunadigital/dataloaden/example.User
+
+package generic
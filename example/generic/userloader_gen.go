@@ -0,0 +1,34 @@
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+//go:build go1.18
+
+package generic
+
+import (
+	"github.com/tribunadigital/dataloaden/pkg/dataloader"
+
+	"github.com/tribunadigital/dataloaden/example"
+)
+
+// UserLoaderCache can be used to cache results. A default map based
+// implementation is used by default.
+type UserLoaderCache = dataloader.Cache[string, *example.User]
+
+// UserLoaderMapCache is a UserLoaderCache backed by a go map
+type UserLoaderMapCache = dataloader.MapCache[string, *example.User]
+
+// NewUserLoaderMapCache creates an empty UserLoaderMapCache
+func NewUserLoaderMapCache() *UserLoaderMapCache {
+	return dataloader.NewMapCache[string, *example.User]()
+}
+
+// UserLoaderConfig captures the config to create a new UserLoader
+type UserLoaderConfig = dataloader.Config[string, *example.User]
+
+// UserLoader batches and caches requests
+type UserLoader = dataloader.Loader[string, *example.User]
+
+// NewUserLoader creates a new UserLoader given a fetch, wait, and maxBatch
+func NewUserLoader(config UserLoaderConfig) *UserLoader {
+	return dataloader.New(config)
+}
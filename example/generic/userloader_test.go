@@ -0,0 +1,85 @@
+//go:build go1.18
+
+package generic_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/generic"
+)
+
+func TestGenericUserLoader(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := generic.NewUserLoader(generic.UserLoaderConfig{
+		Wait:     10 * time.Millisecond,
+		MaxBatch: 5,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+
+			users := make([]*example.User, len(keys))
+			errs := make([]error, len(keys))
+			for i, key := range keys {
+				if key == "E1" {
+					errs[i] = errors.New("user not found")
+				} else {
+					users[i] = &example.User{ID: key, Name: "user " + key}
+				}
+			}
+			return users, errs
+		},
+	})
+
+	t.Run("batches and caches", func(t *testing.T) {
+		users, errs := dl.LoadAll([]string{"U1", "U2", "E1"})
+		require.Equal(t, "user U1", users[0].Name)
+		require.Equal(t, "user U2", users[1].Name)
+		require.NoError(t, errs[0])
+		require.EqualError(t, errs[2], "user not found")
+
+		u, err := dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name)
+
+		mu.Lock()
+		require.Equal(t, [][]string{{"U1", "U2", "E1"}}, fetches)
+		mu.Unlock()
+	})
+
+	t.Run("respects max batch", func(t *testing.T) {
+		thunk := dl.LoadAllThunk([]string{"B1", "B2", "B3", "B4", "B5", "B6"})
+		_, errs := thunk()
+		require.Equal(t, make([]error, 6), errs)
+
+		mu.Lock()
+		require.Len(t, fetches, 3)
+		require.Len(t, fetches[1], 5)
+		mu.Unlock()
+	})
+
+	t.Run("prime and clear", func(t *testing.T) {
+		require.True(t, dl.Prime("P1", &example.User{ID: "P1", Name: "primed"}))
+		require.False(t, dl.Prime("P1", &example.User{ID: "P1", Name: "again"}))
+
+		u, err := dl.Load("P1")
+		require.NoError(t, err)
+		require.Equal(t, "primed", u.Name)
+
+		dl.ForcePrime("P1", &example.User{ID: "P1", Name: "forced"})
+		u, err = dl.Load("P1")
+		require.NoError(t, err)
+		require.Equal(t, "forced", u.Name)
+
+		dl.Clear("P1")
+		u, err = dl.Load("P1")
+		require.NoError(t, err)
+		require.Equal(t, "user P1", u.Name)
+	})
+}
@@ -0,0 +1,49 @@
+//go:build go1.23
+
+package example_test
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+)
+
+func TestUserLoaderLoadSeq(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:     time.Millisecond,
+		MaxBatch: 2,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+
+	var names []string
+	for u, err := range dl.LoadSeq(slices.Values([]string{"U1", "U2", "U3", "E1", "U4"})) {
+		if err != nil {
+			names = append(names, "error")
+			continue
+		}
+		names = append(names, u.Name)
+	}
+	require.Equal(t, []string{"user U1", "user U2", "user U3", "error", "user U4"}, names)
+
+	mu.Lock()
+	require.Equal(t, [][]string{{"U1", "U2"}, {"U3", "E1"}, {"U4"}}, fetches)
+	mu.Unlock()
+
+	t.Run("stopping early", func(t *testing.T) {
+		for u := range dl.LoadSeq(slices.Values([]string{"U1", "U5"})) {
+			require.Equal(t, "user U1", u.Name)
+			break
+		}
+	})
+}
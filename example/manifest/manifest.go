@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -config dataloaden.yml
+
+package manifest
@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -prometheus UserLoader string *github.com/tribunadigital/dataloaden/example.User
+
+package metrics
@@ -0,0 +1,112 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/metrics"
+)
+
+func TestPrometheus(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	newLoader := func() *metrics.UserLoader {
+		m, err := metrics.NewUserLoaderPrometheus(reg)
+		require.NoError(t, err)
+
+		return metrics.NewUserLoader(metrics.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				users := make([]*example.User, len(keys))
+				errs := make([]error, len(keys))
+				for i, key := range keys {
+					if key == "E1" {
+						errs[i] = fmt.Errorf("user not found")
+					} else {
+						users[i] = &example.User{ID: key}
+					}
+				}
+				return users, errs
+			},
+			Metrics: m,
+		})
+	}
+
+	dl := newLoader()
+	dl.LoadAll([]string{"U1", "U2", "E1"})
+	dl.Load("U1")
+
+	// a loader created for the next request shares the metrics
+	newLoader().Load("U3")
+
+	values := map[string]float64{}
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			require.Equal(t, "UserLoader", metric.Label[0].GetValue())
+			switch {
+			case metric.Counter != nil:
+				values[family.GetName()] = metric.Counter.GetValue()
+			case metric.Histogram != nil:
+				values[family.GetName()] = metric.Histogram.GetSampleSum()
+			}
+		}
+	}
+
+	require.Equal(t, float64(2), values["dataloader_batches_total"])
+	require.Equal(t, float64(4), values["dataloader_batch_size"])
+	require.Equal(t, float64(1), values["dataloader_errors_total"])
+	require.Equal(t, float64(1), values["dataloader_cache_hits_total"])
+	require.Equal(t, float64(4), values["dataloader_cache_misses_total"])
+	require.Contains(t, values, "dataloader_fetch_duration_seconds")
+}
+
+type traceIDTracer struct{}
+
+type traceIDKey struct{}
+
+func (traceIDTracer) StartBatch(ctx context.Context, callers []context.Context, keys int) (context.Context, func(errors int)) {
+	return context.WithValue(ctx, traceIDKey{}, "4bf92f3577b34da6a3ce929d0e0e4736"), func(errors int) {}
+}
+
+func (traceIDTracer) TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+func TestPrometheusExemplars(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := metrics.NewUserLoaderPrometheus(reg)
+	require.NoError(t, err)
+
+	dl := metrics.NewUserLoader(metrics.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			return make([]*example.User, len(keys)), nil
+		},
+		Metrics: m,
+		Tracer:  traceIDTracer{},
+	})
+	dl.Load("U1")
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	var exemplars []string
+	for _, family := range families {
+		if family.GetName() != "dataloader_fetch_duration_seconds" {
+			continue
+		}
+		for _, bucket := range family.Metric[0].Histogram.Bucket {
+			if exemplar := bucket.GetExemplar(); exemplar != nil {
+				exemplars = append(exemplars, exemplar.Label[0].GetName()+"="+exemplar.Label[0].GetValue())
+			}
+		}
+	}
+	require.Equal(t, []string{"trace_id=4bf92f3577b34da6a3ce929d0e0e4736"}, exemplars)
+}
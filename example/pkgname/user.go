@@ -1,3 +1,3 @@
 package differentpkg
 
-//go:generate ../../dataloaden UserLoader string *github.com/tribunadigital/dataloaden/example.User
+//go:generate ../../dataloaden UserLoader string *github.com/tribunadigital/dataloaden/example.User UserSliceLoader int []*github.com/tribunadigital/dataloaden/example.User
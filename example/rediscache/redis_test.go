@@ -0,0 +1,76 @@
+package rediscache_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/rediscache"
+)
+
+func TestRedisCache(t *testing.T) {
+	s, err := miniredis.Run()
+	require.NoError(t, err)
+	defer s.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	var fetches int32
+	fetch := func(keys []string) ([]*example.User, []error) {
+		atomic.AddInt32(&fetches, 1)
+		users := make([]*example.User, len(keys))
+		for i, key := range keys {
+			users[i] = &example.User{ID: key, Name: "user " + key}
+		}
+		return users, make([]error, len(keys))
+	}
+
+	// two replicas of a service sharing the cache
+	replica1 := rediscache.NewUserLoader(rediscache.UserLoaderWriteThroughRedis(fetch, client))
+	replica2 := rediscache.NewUserLoader(rediscache.UserLoaderWriteThroughRedis(fetch, client))
+
+	t.Run("values are shared between loaders", func(t *testing.T) {
+		u, err := replica1.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name)
+
+		u, err = replica2.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name)
+		require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	})
+
+	t.Run("keys are namespaced and expire", func(t *testing.T) {
+		require.True(t, s.Exists("UserLoader:U1"))
+		require.Equal(t, 10*time.Minute, s.TTL("UserLoader:U1"))
+	})
+
+	t.Run("clearing removes the key from redis", func(t *testing.T) {
+		replica2.Clear("U1")
+		require.False(t, s.Exists("UserLoader:U1"))
+	})
+
+	t.Run("options", func(t *testing.T) {
+		var errs []error
+		c := rediscache.NewUserLoaderRedisCache(client,
+			rediscache.UserLoaderRedisNamespace("users"),
+			rediscache.UserLoaderRedisOnError(func(err error) { errs = append(errs, err) }),
+		)
+
+		c.Set("U2", &example.User{ID: "U2"})
+		require.True(t, s.Exists("users:U2"))
+		u, ok := c.Get("U2")
+		require.True(t, ok)
+		require.Equal(t, "U2", u.ID)
+
+		require.NoError(t, s.Set("users:U3", "not json"))
+		_, ok = c.Get("U3")
+		require.False(t, ok)
+		require.Len(t, errs, 1)
+	})
+}
@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -redis UserLoader string *github.com/tribunadigital/dataloaden/example.User
+
+package rediscache
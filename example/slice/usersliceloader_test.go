@@ -205,3 +205,39 @@ func TestUserLoader(t *testing.T) {
 		require.Equal(t, "user 6", users2[0][0].Name)
 	})
 }
+
+func TestUserLoaderDedup(t *testing.T) {
+	var transformed, measured []int
+	dl := slice.NewUserSliceLoader(slice.UserSliceLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([][]example.User, []error) {
+			users := make([][]example.User, len(keys))
+			for i, key := range keys {
+				users[i] = []example.User{
+					{ID: key, Name: "user " + key},
+					{ID: key + "b", Name: "other " + key},
+					{ID: key, Name: "user " + key},
+				}
+			}
+			return users, nil
+		},
+		Dedup: func(a, b example.User) bool {
+			return a.ID == b.ID
+		},
+		Transform: func(key string, value []example.User) []example.User {
+			transformed = append(transformed, len(value))
+			return value
+		},
+		MaxValueBytes: 1000,
+		ValueSize: func(value []example.User) int {
+			measured = append(measured, len(value))
+			return len(value)
+		},
+	})
+
+	users, err := dl.Load("1")
+	require.NoError(t, err)
+	require.Equal(t, []example.User{{ID: "1", Name: "user 1"}, {ID: "1b", Name: "other 1"}}, users)
+	require.Equal(t, []int{2}, transformed, "duplicates are removed before the value is transformed")
+	require.Equal(t, []int{2}, measured, "duplicates are removed before the value is measured")
+}
@@ -0,0 +1,128 @@
+package spill_test
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/spill"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestSpillCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	conf := spill.UserLoaderSpillCacheConfig{
+		Path:       filepath.Join(dir, "users.db"),
+		MaxEntries: 1,
+		OnError: func(err error) {
+			t.Error(err)
+		},
+	}
+
+	c, err := spill.NewUserLoaderSpillCache(conf)
+	require.NoError(t, err)
+
+	c.Set("U1", &example.User{ID: "U1", Name: "user U1"})
+	c.Set("U2", &example.User{ID: "U2", Name: "user U2"})
+	c.SetWithTTL("U3", &example.User{ID: "U3", Name: "user U3"}, time.Millisecond)
+
+	t.Run("cold entries are read back from disk", func(t *testing.T) {
+		u, ok := c.Get("U1")
+		require.True(t, ok)
+		require.Equal(t, "user U1", u.Name)
+	})
+
+	t.Run("expired entries are dropped", func(t *testing.T) {
+		time.Sleep(5 * time.Millisecond)
+		_, ok := c.Get("U3")
+		require.False(t, ok)
+	})
+
+	t.Run("entries spilled by the same cache expire by the monotonic clock", func(t *testing.T) {
+		c.SetWithTTL("M1", &example.User{ID: "M1"}, 20*time.Millisecond)
+		c.Set("M2", &example.User{ID: "M2"})
+
+		_, ok := c.Get("M1")
+		require.True(t, ok, "read back from disk")
+		time.Sleep(30 * time.Millisecond)
+		c.Set("M3", &example.User{ID: "M3"})
+		_, ok = c.Get("M1")
+		require.False(t, ok)
+	})
+
+	t.Run("cleared entries are removed from disk", func(t *testing.T) {
+		c.ClearKey("U2")
+		_, ok := c.Get("U2")
+		require.False(t, ok)
+	})
+
+	t.Run("clear drops entries from memory and disk", func(t *testing.T) {
+		c.Set("C1", &example.User{ID: "C1"})
+		c.Set("C2", &example.User{ID: "C2"})
+		c.Clear()
+
+		_, ok := c.Get("C1")
+		require.False(t, ok)
+		_, ok = c.Get("C2")
+		require.False(t, ok)
+
+		c.Set("U1", &example.User{ID: "U1", Name: "user U1"})
+	})
+
+	t.Run("entries survive a restart", func(t *testing.T) {
+		require.NoError(t, c.Close())
+
+		c, err = spill.NewUserLoaderSpillCache(conf)
+		require.NoError(t, err)
+		defer c.Close()
+
+		dl := spill.NewUserLoader(spill.UserLoaderConfig{
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				t.Fatalf("unexpected fetch for %v", keys)
+				return nil, nil
+			},
+			Cache: c,
+		})
+
+		u, err := dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name)
+	})
+
+	t.Run("a wall clock that jumped back doesn't keep entries longer", func(t *testing.T) {
+		require.NoError(t, c.Close())
+
+		// written with 20ms left, by a clock that was a year behind
+		var buf bytes.Buffer
+		require.NoError(t, gob.NewEncoder(&buf).Encode(struct {
+			Value   *example.User
+			Expires time.Time
+			TTL     time.Duration
+		}{&example.User{ID: "J1"}, time.Now().AddDate(1, 0, 0), 20 * time.Millisecond}))
+		db, err := bolt.Open(conf.Path, 0600, nil)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("UserLoader")).Put([]byte("J1"), buf.Bytes())
+		}))
+		require.NoError(t, db.Close())
+
+		c, err = spill.NewUserLoaderSpillCache(conf)
+		require.NoError(t, err)
+		defer c.Close()
+
+		_, ok := c.Get("J1")
+		require.True(t, ok)
+		time.Sleep(30 * time.Millisecond)
+		_, ok = c.Get("J1")
+		require.False(t, ok)
+	})
+}
@@ -0,0 +1,3 @@
+package spill
+
+//go:generate ../../dataloaden -spill UserLoader string *github.com/tribunadigital/dataloaden/example.User
@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -split UserLoader string *github.com/tribunadigital/dataloaden/example.User
+
+package split
@@ -0,0 +1,16 @@
+//go:generate ../../dataloaden -redis -schema UserLoader github.com/tribunadigital/dataloaden/example/textkey.ID *github.com/tribunadigital/dataloaden/example.User
+
+package textkey
+
+import "strconv"
+
+// ID identifies a user within a tenant
+type ID struct {
+	Tenant string
+	Num    int
+}
+
+// MarshalText writes the ID as tenant/num
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.Tenant + "/" + strconv.Itoa(id.Num)), nil
+}
@@ -0,0 +1,28 @@
+package textkey_test
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/textkey"
+)
+
+func TestTextMarshalerKeys(t *testing.T) {
+	s, err := miniredis.Run()
+	require.NoError(t, err)
+	defer s.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	defer client.Close()
+
+	c := textkey.NewUserLoaderRedisCache(client)
+	c.Set(textkey.ID{Tenant: "acme", Num: 1}, &example.User{ID: "U1"})
+	require.True(t, s.Exists("UserLoader:acme/1"))
+
+	u, ok := c.Get(textkey.ID{Tenant: "acme", Num: 1})
+	require.True(t, ok)
+	require.Equal(t, "U1", u.ID)
+}
@@ -0,0 +1,78 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/oteltest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tribunadigital/dataloaden/example"
+	"github.com/tribunadigital/dataloaden/example/tracing"
+)
+
+func TestOTel(t *testing.T) {
+	recorder := new(oteltest.SpanRecorder)
+	tp := oteltest.NewTracerProvider(oteltest.WithSpanRecorder(recorder))
+
+	var fetchSpan trace.SpanContext
+	dl := tracing.NewUserLoader(tracing.UserLoaderConfig{
+		Wait: 10 * time.Millisecond,
+		FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+			fetchSpan = trace.SpanContextFromContext(ctx)
+			users := make([]*example.User, len(keys))
+			errs := make([]error, len(keys))
+			for i, key := range keys {
+				if key == "E1" {
+					errs[i] = fmt.Errorf("user not found")
+				} else {
+					users[i] = &example.User{ID: key}
+				}
+			}
+			return users, errs
+		},
+		Tracer: tracing.NewUserLoaderOTel(tp),
+	})
+
+	var parents []trace.SpanContext
+	var wg sync.WaitGroup
+	for _, key := range []string{"U1", "U2", "E1"} {
+		ctx, span := tp.Tracer("test").Start(context.Background(), "resolve "+key)
+		parents = append(parents, span.SpanContext())
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			defer span.End()
+			_, _ = dl.LoadContext(ctx, key)
+		}(key)
+	}
+	wg.Wait()
+
+	var batch *oteltest.Span
+	for _, span := range recorder.Completed() {
+		if span.Name() == "UserLoader.fetch" {
+			require.Nil(t, batch, "expected a single batch")
+			batch = span
+		}
+	}
+	require.NotNil(t, batch)
+
+	require.Equal(t, batch.SpanContext(), fetchSpan, "FetchContext should get the batch span")
+	require.Equal(t, attribute.IntValue(3), batch.Attributes()["dataloader.keys"])
+	require.Equal(t, attribute.IntValue(1), batch.Attributes()["dataloader.errors"])
+
+	var linked []trace.SpanContext
+	for _, link := range batch.Links() {
+		linked = append(linked, link.SpanContext)
+	}
+	require.ElementsMatch(t, parents, linked)
+
+	otel := tracing.NewUserLoaderOTel(tp)
+	require.Equal(t, batch.SpanContext().TraceID().String(), otel.TraceID(trace.ContextWithSpanContext(context.Background(), fetchSpan)))
+	require.Equal(t, "", otel.TraceID(context.Background()))
+}
@@ -0,0 +1,3 @@
+//go:generate ../../dataloaden -otel UserLoader string *github.com/tribunadigital/dataloaden/example.User
+
+package tracing
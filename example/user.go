@@ -1,4 +1,4 @@
-//go:generate ../dataloaden UserLoader string *github.com/tribunadigital/dataloaden/example.User
+//go:generate ../dataloaden -view -iter -fetcher UserRepo.GetByIDs UserLoader string *github.com/tribunadigital/dataloaden/example.User
 
 package example
 
@@ -12,6 +12,11 @@ type User struct {
 	Name string
 }
 
+// UserRepo is where users are stored
+type UserRepo interface {
+	GetByIDs(ids []string) ([]*User, []error)
+}
+
 // NewLoader will collect user requests for 2 milliseconds and send them as a single batch to the fetch func
 // normally fetch would be a database call.
 func NewLoader() *UserLoader {
@@ -1,9 +1,16 @@
 package example_test
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -195,3 +202,3309 @@ func TestUserLoader(t *testing.T) {
 		require.Equal(t, "user U6", users2[0].Name)
 	})
 }
+
+func fetchUsers(keys []string) ([]*example.User, []error) {
+	users := make([]*example.User, len(keys))
+	errors := make([]error, len(keys))
+
+	for i, key := range keys {
+		if strings.HasPrefix(key, "E") {
+			errors[i] = fmt.Errorf("user not found")
+		} else {
+			users[i] = &example.User{ID: key, Name: "user " + key}
+		}
+	}
+	return users, errors
+}
+
+func TestUserLoaderWindowStats(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:        time.Millisecond,
+		Fetch:       fetchUsers,
+		StatsWindow: 5 * time.Minute,
+	})
+
+	dl.LoadAll([]string{"U1", "U2"})
+	dl.Load("U1")
+
+	stats := dl.WindowStats(time.Minute)
+	require.Equal(t, example.UserLoaderWindowStats{Hits: 1, Misses: 2, Batches: 1}, stats)
+	require.Equal(t, stats, dl.WindowStats(5*time.Minute))
+
+	t.Run("disabled by default", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Fetch: fetchUsers})
+		dl.Load("U1")
+		require.Equal(t, example.UserLoaderWindowStats{}, dl.WindowStats(time.Minute))
+	})
+
+	t.Run("cancelled fetches aren't counted as batches", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+				<-ctx.Done()
+				return nil, []error{ctx.Err()}
+			},
+			StatsWindow: time.Minute,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk := dl.LoadThunkContext(ctx, "U1")
+		time.Sleep(5 * time.Millisecond)
+		cancel()
+		_, err := thunk()
+		require.Equal(t, context.Canceled, err)
+
+		require.Eventually(t, func() bool { return dl.Stats().Abandoned == 1 }, time.Second, time.Millisecond)
+		require.Equal(t, 0, dl.WindowStats(time.Minute).Batches)
+	})
+}
+
+func TestUserLoaderLoadFresh(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+	})
+
+	dl.Prime("U1", &example.User{ID: "U1", Name: "Primed user"})
+
+	u, err := dl.LoadFresh("U1", time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, "Primed user", u.Name)
+
+	time.Sleep(5 * time.Millisecond)
+
+	u, err = dl.LoadFresh("U1", time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, "user U1", u.Name)
+
+	u, err = dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "user U1", u.Name)
+}
+
+func TestUserLoaderSortKeys(t *testing.T) {
+	var fetched []string
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			fetched = keys
+			return fetchUsers(keys)
+		},
+		SortKeys: sort.Strings,
+	})
+
+	u, err := dl.LoadAll([]string{"U3", "E1", "U1", "U2"})
+	require.Equal(t, []string{"E1", "U1", "U2", "U3"}, fetched)
+	require.Equal(t, "U3", u[0].ID)
+	require.Error(t, err[1])
+	require.Equal(t, "U1", u[2].ID)
+	require.Equal(t, "U2", u[3].ID)
+}
+
+func TestUserLoaderReleaseThunk(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+
+	abandoned, release := dl.LoadThunkWithRelease("U1")
+	thunk := dl.LoadThunk("U2")
+	release()
+
+	u, err := thunk()
+	require.NoError(t, err)
+	require.Equal(t, "U2", u.ID)
+
+	// released results are not cached, so calling the thunk anyway loads it again
+	u, err = abandoned()
+	require.NoError(t, err)
+	require.Equal(t, "U1", u.ID)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, [][]string{{"U1", "U2"}, {"U1"}}, fetches)
+}
+
+func TestUserLoaderErrorCounts(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			users, errs := fetchUsers(keys)
+			for i, key := range keys {
+				if strings.HasPrefix(key, "T") {
+					errs[i] = fmt.Errorf("timeout")
+				}
+			}
+			return users, errs
+		},
+		ClassifyError: func(key string, err error) example.UserLoaderErrorClass {
+			if err.Error() == "user not found" {
+				return example.UserLoaderErrorNotFound
+			}
+			return example.UserLoaderErrorTimeout
+		},
+	})
+
+	dl.LoadAll([]string{"U1", "E1", "E2", "T1"})
+
+	require.Equal(t, map[example.UserLoaderErrorClass]int{
+		example.UserLoaderErrorNotFound: 2,
+		example.UserLoaderErrorTimeout:  1,
+	}, dl.ErrorCounts())
+}
+
+func TestUserLoaderDuplicateFetch(t *testing.T) {
+	var mu sync.Mutex
+	duplicates := map[string]int{}
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+		OnDuplicateFetch: func(key string, fetches int) {
+			mu.Lock()
+			duplicates[key] = fetches
+			mu.Unlock()
+		},
+	})
+
+	// both thunks are created before either is awaited, so the result of the first batch isn't cached yet
+	thunk1 := dl.LoadThunk("U1")
+	time.Sleep(5 * time.Millisecond)
+	thunk2 := dl.LoadThunk("U1")
+	thunk1()
+	thunk2()
+
+	dl.Clear("U1")
+	dl.Load("U1")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[string]int{"U1": 2}, duplicates)
+}
+
+func TestUserLoaderLoadAllDuplicates(t *testing.T) {
+	var mu sync.Mutex
+	var fetches [][]string
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:     time.Millisecond,
+		MaxBatch: 2,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+
+	users, errs := dl.LoadAll([]string{"U1", "U2", "U1", "U3", "U2"})
+	for _, err := range errs {
+		require.NoError(t, err)
+	}
+	var ids []string
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	require.Equal(t, []string{"U1", "U2", "U1", "U3", "U2"}, ids)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.ElementsMatch(t, [][]string{{"U1", "U2"}, {"U3"}}, fetches)
+}
+
+func TestUserLoaderPrimeTTL(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+	})
+
+	dl.Prime("U1", &example.User{ID: "U1", Name: "Primed user"}, example.UserLoaderWithTTL(5*time.Millisecond))
+	dl.Prime("U2", &example.User{ID: "U2", Name: "Primed user"})
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "Primed user", u.Name)
+
+	time.Sleep(10 * time.Millisecond)
+
+	u, err = dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "user U1", u.Name)
+
+	u, err = dl.Load("U2")
+	require.NoError(t, err)
+	require.Equal(t, "Primed user", u.Name)
+}
+
+func TestUserLoaderIsPending(t *testing.T) {
+	fetching := make(chan struct{})
+	proceed := make(chan struct{})
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			close(fetching)
+			<-proceed
+			return fetchUsers(keys)
+		},
+	})
+
+	require.False(t, dl.IsPending("U1"))
+
+	thunk := dl.LoadThunk("U1")
+	require.True(t, dl.IsPending("U1"))
+
+	<-fetching
+	require.True(t, dl.IsPending("U1"))
+	require.False(t, dl.IsPending("U2"))
+
+	close(proceed)
+	_, err := thunk()
+	require.NoError(t, err)
+	require.False(t, dl.IsPending("U1"))
+}
+
+func TestUserLoaderStrict(t *testing.T) {
+	var reported *example.UserLoaderResultLengthError
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			users, errs := fetchUsers(keys)
+			return users[1:], errs
+		},
+		Strict: true,
+		OnResultLengthError: func(keys []string, err *example.UserLoaderResultLengthError) {
+			reported = err
+		},
+	})
+
+	_, errs := dl.LoadAll([]string{"U1", "U2"})
+	for _, err := range errs {
+		var lengthErr *example.UserLoaderResultLengthError
+		require.True(t, errors.As(err, &lengthErr))
+		require.Equal(t, &example.UserLoaderResultLengthError{Keys: 2, Values: 1, Errors: 2}, lengthErr)
+	}
+	require.Equal(t, errs[0], reported)
+	require.EqualError(t, errs[0], "UserLoader: fetch returned 1 values and 2 errors for 2 keys")
+
+	t.Run("a single error is allowed without data", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				return nil, []error{fmt.Errorf("db down")}
+			},
+			Strict: true,
+		})
+
+		_, errs := dl.LoadAll([]string{"U1", "U2"})
+		require.EqualError(t, errs[0], "db down")
+		require.EqualError(t, errs[1], "db down")
+	})
+
+	t.Run("short results fail without strict", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				users, _ := fetchUsers(keys)
+				return users[:1], nil
+			},
+		})
+
+		_, errs := dl.LoadAll([]string{"U1", "U2"})
+		require.EqualError(t, errs[0], "UserLoader: fetch returned 1 values and 0 errors for 2 keys")
+		require.EqualError(t, errs[1], "UserLoader: fetch returned 1 values and 0 errors for 2 keys")
+
+		user, err := dl.Load("U1")
+		require.NoError(t, err, "the failed batch must not be cached")
+		require.Equal(t, "U1", user.ID)
+	})
+
+	t.Run("short results with an error for the rest are fine", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				users, _ := fetchUsers(keys)
+				return users[:1], []error{nil, fmt.Errorf("user %s not found", keys[1])}
+			},
+		})
+
+		users, errs := dl.LoadAll([]string{"U1", "U2"})
+		require.NoError(t, errs[0])
+		require.Equal(t, "U1", users[0].ID)
+		require.EqualError(t, errs[1], "user U2 not found")
+	})
+
+	t.Run("a MissingPolicy applies to short results without strict", func(t *testing.T) {
+		extra := false
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				users, _ := fetchUsers(keys)
+				if extra {
+					return append(users, &example.User{ID: "U9"}), nil
+				}
+				return users[:1], nil
+			},
+			MissingPolicy: example.UserLoaderMissingError,
+		})
+
+		users, errs := dl.LoadAll([]string{"U1", "U2"})
+		require.NoError(t, errs[0])
+		require.Equal(t, "U1", users[0].ID)
+		require.True(t, errors.Is(errs[1], example.ErrUserLoaderNotFound))
+
+		extra = true
+		_, errs = dl.LoadAll([]string{"U3", "U4"})
+		var lengthErr *example.UserLoaderResultLengthError
+		require.True(t, errors.As(errs[0], &lengthErr), "more values than keys still fail the batch")
+	})
+}
+
+func TestUserLoaderMaxBatchOverflow(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:             time.Millisecond,
+		MaxBatch:         4,
+		MaxBatchOverflow: 1,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+
+	dl.LoadAll([]string{"U1", "U2", "U3", "U4", "U5"})
+	dl.LoadAll([]string{"U6", "U7", "U8", "U9", "U10", "U11"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, [][]string{
+		{"U1", "U2", "U3", "U4", "U5"},
+		{"U6", "U7", "U8", "U9"},
+		{"U10", "U11"},
+	}, fetches)
+}
+
+func TestUserLoaderHotKeys(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:    time.Millisecond,
+		Fetch:   fetchUsers,
+		HotKeys: 2,
+	})
+
+	for i := 0; i < 5; i++ {
+		dl.Load("U1")
+	}
+	for i := 0; i < 3; i++ {
+		dl.LoadAll([]string{"U2", "U3"})
+	}
+	dl.Load("U2")
+
+	require.Equal(t, []example.UserLoaderKeyCount{{Key: "U1", Count: 5}, {Key: "U2", Count: 4}}, dl.HotKeys(3))
+	require.Equal(t, []example.UserLoaderKeyCount{{Key: "U1", Count: 5}}, dl.HotKeys(1))
+}
+
+func TestUserLoaderValidate(t *testing.T) {
+	_, err := example.NewUserLoaderValidated(example.UserLoaderConfig{})
+	require.EqualError(t, err, "UserLoader: Fetch, FetchContext or FetchConn is required")
+
+	_, err = example.NewUserLoaderValidated(example.UserLoaderConfig{Fetch: fetchUsers, MaxBatch: -1})
+	require.EqualError(t, err, "UserLoader: MaxBatch must not be negative, got -1 (use 0 for no limit)")
+
+	_, err = example.NewUserLoaderValidated(example.UserLoaderConfig{Fetch: fetchUsers, MaxBatchOverflow: 2})
+	require.EqualError(t, err, "UserLoader: MaxBatchOverflow needs a MaxBatch to overflow")
+
+	dl, err := example.NewUserLoaderValidated(example.UserLoaderConfig{Fetch: fetchUsers})
+	require.NoError(t, err)
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "U1", u.ID)
+}
+
+func TestUserLoaderPresets(t *testing.T) {
+	conf := example.UserLoaderPerRequest(fetchUsers)
+	require.NoError(t, conf.Validate())
+	require.Equal(t, 100, conf.MaxBatch)
+
+	conf = example.UserLoaderLongLived(fetchUsers)
+	require.NoError(t, conf.Validate())
+	require.IsType(t, &example.UserLoaderGoCache{}, conf.Cache)
+
+	u, err := example.NewUserLoader(conf).Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "U1", u.ID)
+
+	conf = example.UserLoaderLongLivedLRU(fetchUsers, 10)
+	require.NoError(t, conf.Validate())
+	require.IsType(t, &example.UserLoaderLRUCache{}, conf.Cache)
+}
+
+func TestUserLoaderLRUCache(t *testing.T) {
+	cache := example.NewUserLoaderLRUCache(2)
+	dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers, Cache: cache})
+
+	dl.LoadAll([]string{"U1", "U2"})
+	dl.Load("U1")
+	dl.Prime("U3", &example.User{ID: "U3"})
+
+	t.Run("evicts the least recently used value", func(t *testing.T) {
+		require.Equal(t, 2, cache.Len())
+		_, ok := cache.Get("U2")
+		require.False(t, ok)
+		_, ok = cache.Get("U1")
+		require.True(t, ok)
+	})
+
+	t.Run("clears", func(t *testing.T) {
+		dl.Clear("U1")
+		require.Equal(t, 1, cache.Len())
+		dl.ClearAll()
+		require.Equal(t, 0, cache.Len())
+	})
+
+	t.Run("is safe under concurrent loads", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("U%d", i%5)
+				dl.Load(key)
+				dl.Prime(key, &example.User{ID: key})
+				if i%3 == 0 {
+					dl.Clear(key)
+				}
+			}(i)
+		}
+		wg.Wait()
+		require.True(t, cache.Len() <= 2)
+	})
+
+	t.Run("forgets evicted keys", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderLongLivedLRU(fetchUsers, 3))
+		for i := 0; i < 10; i++ {
+			_, err := dl.Load(fmt.Sprintf("U%d", i))
+			require.NoError(t, err)
+		}
+
+		known := 0
+		dl.ClearFunc(func(key string) bool {
+			known++
+			return false
+		})
+		require.Equal(t, 3, known)
+	})
+}
+
+func TestUserLoaderApply(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+	})
+
+	var fetched []string
+	dl.Apply(func(config *example.UserLoaderConfig) {
+		config.SortKeys = sort.Strings
+		config.Fetch = func(keys []string) ([]*example.User, []error) {
+			fetched = keys
+			return fetchUsers(keys)
+		}
+	})
+
+	u, err := dl.LoadAll([]string{"U2", "U1"})
+	require.Equal(t, []string{"U1", "U2"}, fetched)
+	require.NoError(t, err[0])
+	require.Equal(t, "U2", u[0].ID)
+}
+
+func TestUserLoaderIsDeleted(t *testing.T) {
+	var fetches int
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches++
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		IsDeleted: func(user *example.User) bool {
+			return strings.HasPrefix(user.ID, "D")
+		},
+		CacheDeleted: true,
+	})
+
+	u, errs := dl.LoadAll([]string{"U1", "D1"})
+	require.NoError(t, errs[0])
+	require.Equal(t, "U1", u[0].ID)
+	require.Nil(t, u[1])
+	require.True(t, errors.Is(errs[1], example.ErrUserLoaderNotFound))
+
+	_, err := dl.Load("D1")
+	require.True(t, errors.Is(err, example.ErrUserLoaderNotFound))
+	require.Equal(t, 1, fetches)
+
+	dl.Clear("D1")
+	_, err = dl.Load("D1")
+	require.True(t, errors.Is(err, example.ErrUserLoaderNotFound))
+	require.Equal(t, 2, fetches)
+}
+
+func TestUserLoaderInvalidateAndReload(t *testing.T) {
+	name := "before"
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			users := make([]*example.User, len(keys))
+			for i, key := range keys {
+				users[i] = &example.User{ID: key, Name: name}
+			}
+			return users, nil
+		},
+	})
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "before", u.Name)
+
+	name = "after"
+
+	u, err = dl.InvalidateAndReload("U1")
+	require.NoError(t, err)
+	require.Equal(t, "after", u.Name)
+
+	u, err = dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "after", u.Name)
+}
+
+func TestUserLoaderClearPrefix(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		SortKeys: sort.Strings,
+	})
+
+	dl.LoadAll([]string{"acme:U1", "acme:U2", "initech:U1"})
+	dl.ClearPrefix("acme:")
+	dl.LoadAll([]string{"acme:U1", "acme:U2", "initech:U1"})
+
+	dl.ClearFunc(func(key string) bool {
+		return strings.HasSuffix(key, "U1")
+	})
+	dl.LoadAll([]string{"acme:U1", "acme:U2", "initech:U1"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, [][]string{
+		{"acme:U1", "acme:U2", "initech:U1"},
+		{"acme:U1", "acme:U2"},
+		{"acme:U1", "initech:U1"},
+	}, fetches)
+}
+
+func TestUserLoaderEntry(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+	})
+
+	_, _, ok := dl.Entry("U1")
+	require.False(t, ok)
+
+	before := time.Now()
+	dl.Load("U1")
+	dl.Load("U1")
+	dl.Load("U1")
+
+	u, meta, ok := dl.Entry("U1")
+	require.True(t, ok)
+	require.Equal(t, "U1", u.ID)
+	require.Equal(t, 2, meta.Hits)
+	require.False(t, meta.CachedAt.Before(before))
+	require.True(t, meta.Expires.IsZero())
+
+	dl.Prime("U2", &example.User{ID: "U2"}, example.UserLoaderWithTTL(time.Minute))
+	_, meta, ok = dl.Entry("U2")
+	require.True(t, ok)
+	require.Equal(t, time.Minute, meta.Expires.Sub(meta.CachedAt))
+}
+
+func TestUserLoaderClosedPolicy(t *testing.T) {
+	newLoader := func(policy example.UserLoaderClosedPolicy) *example.UserLoader {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:         time.Millisecond,
+			Fetch:        fetchUsers,
+			ClosedPolicy: policy,
+		})
+		dl.Prime("U1", &example.User{ID: "U1", Name: "Primed user"})
+		return dl
+	}
+
+	t.Run("close waits for pending batches", func(t *testing.T) {
+		dl := newLoader(example.UserLoaderClosedError)
+		thunk := dl.LoadThunk("U2")
+
+		require.NoError(t, dl.Close(context.Background()))
+		require.False(t, dl.IsPending("U2"))
+
+		u, err := thunk()
+		require.NoError(t, err)
+		require.Equal(t, "U2", u.ID)
+	})
+
+	t.Run("close dispatches batches without waiting out Wait", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Hour, Fetch: fetchUsers})
+		thunk := dl.LoadThunk("U2")
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, dl.Close(ctx))
+
+		u, err := thunk()
+		require.NoError(t, err)
+		require.Equal(t, "U2", u.ID)
+	})
+
+	t.Run("close gives up once ctx is done", func(t *testing.T) {
+		proceed := make(chan struct{})
+		defer close(proceed)
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				<-proceed
+				return fetchUsers(keys)
+			},
+		})
+		dl.LoadThunk("U2")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.Equal(t, context.DeadlineExceeded, dl.Close(ctx))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		dl := newLoader(example.UserLoaderClosedError)
+		require.NoError(t, dl.Close(context.Background()))
+
+		_, err := dl.Load("U1")
+		require.True(t, errors.Is(err, example.ErrUserLoaderClosed))
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		dl := newLoader(example.UserLoaderClosedPanic)
+		require.NoError(t, dl.Close(context.Background()))
+
+		require.Panics(t, func() {
+			dl.Load("U1")
+		})
+	})
+
+	t.Run("fetch", func(t *testing.T) {
+		dl := newLoader(example.UserLoaderClosedFetch)
+		require.NoError(t, dl.Close(context.Background()))
+
+		u, err := dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name)
+
+		_, err = dl.Load("E1")
+		require.Error(t, err)
+	})
+}
+
+func TestUserLoaderWorkerPool(t *testing.T) {
+	pool := example.NewUserLoaderWorkerPool(1)
+	defer pool.Stop()
+
+	var running, maxRunning int
+	var mu sync.Mutex
+	fetch := func(keys []string) ([]*example.User, []error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(2 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return fetchUsers(keys)
+	}
+
+	loaders := []*example.UserLoader{
+		example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, MaxBatch: 1, Fetch: fetch, Pool: pool}),
+		example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, MaxBatch: 1, Fetch: fetch, Pool: pool}),
+	}
+
+	var wg sync.WaitGroup
+	for _, dl := range loaders {
+		wg.Add(1)
+		go func(dl *example.UserLoader) {
+			defer wg.Done()
+			u, errs := dl.LoadAll([]string{"U1", "U2", "U3"})
+			for i, err := range errs {
+				require.NoError(t, err)
+				require.NotNil(t, u[i])
+			}
+		}(dl)
+	}
+	wg.Wait()
+
+	require.Equal(t, 1, maxRunning)
+}
+
+func TestUserLoaderBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	var running, maxRunning int
+	var mu sync.Mutex
+	var depths []int
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:                 time.Millisecond,
+		MaxBatch:             1,
+		MaxConcurrentBatches: 1,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+
+			<-release
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		OnBackpressure: func(queued int) {
+			mu.Lock()
+			depths = append(depths, queued)
+			mu.Unlock()
+		},
+	})
+
+	thunk := dl.LoadAllThunk([]string{"U1", "U2", "U3"})
+	require.Eventually(t, func() bool { return dl.QueueDepth() == 2 }, time.Second, time.Millisecond)
+
+	close(release)
+	u, errs := thunk()
+	for i, err := range errs {
+		require.NoError(t, err)
+		require.NotNil(t, u[i])
+	}
+	require.Equal(t, 0, dl.QueueDepth())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 1, maxRunning)
+	// the batches behind the first one queue
+	require.Len(t, depths, 2)
+	for _, depth := range depths {
+		require.True(t, depth >= 1 && depth <= 2, "depth %d", depth)
+	}
+}
+
+func TestUserLoaderReentrant(t *testing.T) {
+	var dl *example.UserLoader
+	var selfErr error
+	dl = example.NewUserLoader(example.UserLoaderConfig{
+		Wait:                 time.Millisecond,
+		MaxConcurrentBatches: 1,
+		FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+			users, errs := fetchUsers(keys)
+			for i, key := range keys {
+				if key != "U1" {
+					continue
+				}
+				// loading another key from within fetch works even though this fetch holds the only slot
+				friend, err := dl.LoadContext(ctx, "U2")
+				require.NoError(t, err)
+				users[i].Name = "friend of " + friend.ID
+
+				// loading its own key fails instead of waiting for itself
+				_, selfErr = dl.LoadContext(ctx, "U1")
+			}
+			return users, errs
+		},
+	})
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "friend of U2", u.Name)
+	require.True(t, errors.Is(selfErr, example.ErrUserLoaderReentrant))
+}
+
+func TestUserLoaderDetectDeadlocks(t *testing.T) {
+	var dl *example.UserLoader
+	var friendErr error
+	dl = example.NewUserLoader(example.UserLoaderConfig{
+		Wait:                 time.Millisecond,
+		MaxConcurrentBatches: 1,
+		DetectDeadlocks:      true,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			// this would wait for the slot this fetch is holding
+			_, friendErr = dl.Load("U2")
+			return fetchUsers(keys)
+		},
+	})
+
+	_, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.True(t, errors.Is(friendErr, example.ErrUserLoaderDeadlock))
+	require.Contains(t, friendErr.Error(), "loaded U2 from within the fetch of 1 keys")
+
+	// loads outside of fetch aren't affected
+	u, err := dl.Load("U2")
+	require.NoError(t, err)
+	require.Equal(t, "U2", u.ID)
+}
+
+func TestUserLoaderFetchPanic(t *testing.T) {
+	var fail bool
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			if fail {
+				panic("boom")
+			}
+			return fetchUsers(keys)
+		},
+	})
+
+	fail = true
+	_, errs := dl.LoadAll([]string{"U1", "U2"})
+	require.Len(t, errs, 2)
+	for _, err := range errs {
+		var panicErr *example.UserLoaderPanicError
+		require.True(t, errors.As(err, &panicErr))
+		require.Equal(t, "boom", panicErr.Value)
+		require.Contains(t, string(panicErr.Stack), "TestUserLoaderFetchPanic")
+	}
+
+	// the loader keeps working and didn't cache the failure
+	fail = false
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "U1", u.ID)
+}
+
+func TestUserLoaderAggregator(t *testing.T) {
+	metrics := &example.UserLoaderAggregator{}
+	for i := 0; i < 2; i++ {
+		// a loader per request, sharing the aggregator
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers, Metrics: metrics})
+		dl.LoadAll([]string{"U1", "U2", "E1"})
+		dl.Load("U1")
+	}
+
+	snapshot := metrics.Snapshot()
+	require.Equal(t, int64(2), snapshot.Batches)
+	require.Equal(t, int64(6), snapshot.Keys)
+	require.Equal(t, int64(2), snapshot.Errors)
+	require.Equal(t, int64(2), snapshot.Hits)
+	require.Equal(t, int64(6), snapshot.Misses)
+	require.Equal(t, int64(2), snapshot.BatchSize.Count)
+	require.Equal(t, int64(3), snapshot.BatchSize.Quantile(0.5))
+	require.Equal(t, int64(2), snapshot.FetchLatency.Count)
+
+	t.Run("histogram", func(t *testing.T) {
+		var h example.UserLoaderHistogram
+		for v := int64(1); v <= 1000; v++ {
+			h.Record(v)
+		}
+		require.Equal(t, int64(1), h.Min)
+		require.Equal(t, int64(1000), h.Max)
+		require.Equal(t, 500.5, h.Mean())
+		require.InEpsilon(t, 500, h.Quantile(0.5), 0.125)
+		require.InEpsilon(t, 990, h.Quantile(0.99), 0.125)
+		require.Equal(t, int64(1000), h.Quantile(1))
+	})
+}
+
+func TestUserLoaderWaitForPending(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  5 * time.Millisecond,
+		Fetch: fetchUsers,
+	})
+
+	dl.LoadThunk("U1")
+	require.True(t, dl.IsPending("U1"))
+	require.NoError(t, dl.WaitForPending(context.Background()))
+	require.False(t, dl.IsPending("U1"))
+
+	t.Run("gives up when the context is done", func(t *testing.T) {
+		dl.LoadThunk("U2")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		require.Equal(t, context.Canceled, dl.WaitForPending(ctx))
+	})
+}
+
+func TestUserLoaderMissingPolicy(t *testing.T) {
+	// keys starting with M are missing, N are reported as not found by fetch
+	fetch := func(keys []string) ([]*example.User, []error) {
+		users := make([]*example.User, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			switch {
+			case strings.HasPrefix(key, "M"):
+			case strings.HasPrefix(key, "N"):
+				errs[i] = fmt.Errorf("fetching %s: %w", key, example.ErrUserLoaderNotFound)
+			default:
+				users[i] = &example.User{ID: key, Name: "user " + key}
+			}
+		}
+		return users, errs
+	}
+
+	t.Run("as fetched", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetch})
+
+		users, errs := dl.LoadAll([]string{"U1", "M1", "N1"})
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		require.Nil(t, users[1])
+		require.True(t, errors.Is(errs[2], example.ErrUserLoaderNotFound))
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:          time.Millisecond,
+			Fetch:         fetch,
+			MissingPolicy: example.UserLoaderMissingZero,
+		})
+
+		users, errs := dl.LoadAll([]string{"U1", "M1", "N1"})
+		require.Equal(t, []error{nil, nil, nil}, errs)
+		require.Equal(t, "user U1", users[0].Name)
+		require.Nil(t, users[1])
+		require.Nil(t, users[2])
+	})
+
+	t.Run("error", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:          time.Millisecond,
+			Fetch:         fetch,
+			MissingPolicy: example.UserLoaderMissingError,
+		})
+
+		users, errs := dl.LoadAll([]string{"U1", "M1", "N1"})
+		require.NoError(t, errs[0])
+		require.Equal(t, "user U1", users[0].Name)
+		require.Equal(t, example.ErrUserLoaderNotFound, errs[1])
+		require.True(t, errors.Is(errs[2], example.ErrUserLoaderNotFound))
+	})
+
+	t.Run("error for short results", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				return []*example.User{{ID: keys[0]}}, nil
+			},
+			MissingPolicy: example.UserLoaderMissingError,
+		})
+
+		_, errs := dl.LoadAll([]string{"U1", "U2"})
+		require.NoError(t, errs[0])
+		require.Equal(t, example.ErrUserLoaderNotFound, errs[1])
+	})
+}
+
+type userRepo struct {
+	calls int
+}
+
+func (r *userRepo) GetByIDs(ids []string) ([]*example.User, []error) {
+	r.calls++
+	return fetchUsers(ids)
+}
+
+func TestUserLoaderFromUserRepo(t *testing.T) {
+	repo := &userRepo{}
+	dl := example.NewUserLoaderFromUserRepo(repo, example.UserLoaderConfig{Wait: time.Millisecond})
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "user U1", u.Name)
+	require.Equal(t, 1, repo.calls)
+}
+
+func TestUserLoaderSetFetch(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "user U1", u.Name)
+
+	dl.SetFetch(func(keys []string) ([]*example.User, []error) {
+		return nil, []error{fmt.Errorf("replica down")}
+	})
+
+	u, err = dl.Load("U1")
+	require.NoError(t, err, "cached values survive the swap")
+	require.Equal(t, "user U1", u.Name)
+
+	_, err = dl.Load("U2")
+	require.EqualError(t, err, "replica down")
+
+	t.Run("replaces FetchContext", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+				return fetchUsers(keys)
+			},
+		})
+		dl.SetFetch(func(keys []string) ([]*example.User, []error) {
+			return nil, []error{fmt.Errorf("replica down")}
+		})
+
+		_, err := dl.Load("U1")
+		require.EqualError(t, err, "replica down")
+	})
+}
+
+func TestUserLoaderBatchKey(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: 5 * time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		// keys look like tenant/id
+		BatchKey: func(key string) string {
+			return strings.SplitN(key, "/", 2)[0]
+		},
+	})
+
+	_, errs := dl.LoadAll([]string{"a/1", "b/1", "a/2", "b/2", "c/1"})
+	require.Equal(t, []error{nil, nil, nil, nil, nil}, errs)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, keys := range fetches {
+		sort.Strings(keys)
+	}
+	sort.Slice(fetches, func(i, j int) bool { return fetches[i][0] < fetches[j][0] })
+	require.Equal(t, [][]string{{"a/1", "a/2"}, {"b/1", "b/2"}, {"c/1"}}, fetches)
+}
+
+func TestUserLoaderHedge(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	slow := false
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Hedge: true,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			calls++
+			stall := slow && calls%2 == 1
+			mu.Unlock()
+			if stall {
+				time.Sleep(time.Second)
+			}
+			return fetchUsers(keys)
+		},
+	})
+
+	for i := 0; i < 20; i++ {
+		_, err := dl.Load(fmt.Sprintf("U%d", i))
+		require.NoError(t, err)
+	}
+	mu.Lock()
+	require.Equal(t, 20, calls, "nothing is hedged until there are enough samples")
+	slow = true
+	calls = 0
+	mu.Unlock()
+
+	start := time.Now()
+	u, err := dl.Load("slow")
+	require.NoError(t, err)
+	require.Equal(t, "user slow", u.Name)
+	require.True(t, time.Since(start) < 500*time.Millisecond, "the hedged fetch returned first")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, 2, calls)
+}
+
+func TestUserLoaderHedgeCancel(t *testing.T) {
+	var calls int32
+	slow := int32(0)
+	cancelled := make(chan struct{})
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Hedge: true,
+		FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+			if atomic.AddInt32(&calls, 1)%2 == 1 && atomic.LoadInt32(&slow) == 1 {
+				select {
+				case <-ctx.Done():
+					close(cancelled)
+				case <-time.After(time.Second):
+				}
+			}
+			return fetchUsers(keys)
+		},
+	})
+
+	for i := 0; i < 20; i++ {
+		_, err := dl.Load(fmt.Sprintf("U%d", i))
+		require.NoError(t, err)
+	}
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&slow, 1)
+
+	u, err := dl.Load("slow")
+	require.NoError(t, err)
+	require.Equal(t, "user slow", u.Name)
+
+	select {
+	case <-cancelled:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the slower fetch wasn't cancelled")
+	}
+}
+
+func TestUserLoaderPatternStats(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  5 * time.Millisecond,
+		Fetch: fetchUsers,
+		KeyPattern: example.UserLoaderKeyPatterns(
+			example.UserLoaderKeyPattern{Name: "users", Regexp: regexp.MustCompile(`^U`)},
+			example.UserLoaderKeyPattern{Name: "errors", Regexp: regexp.MustCompile(`^E`)},
+		),
+	})
+
+	thunks := []func() (*example.User, error){
+		dl.LoadThunk("U1"), dl.LoadThunk("U1"), dl.LoadThunk("U2"), dl.LoadThunk("E1"), dl.LoadThunk("X1"),
+	}
+	for _, thunk := range thunks {
+		thunk()
+	}
+	dl.Load("U1")
+
+	require.Equal(t, map[string]example.UserLoaderPatternStats{
+		"users":  {Loads: 4, Hits: 1, Misses: 3, Coalesced: 1, Batches: 1, Keys: 2},
+		"errors": {Loads: 1, Misses: 1, Batches: 1, Keys: 1},
+		"other":  {Loads: 1, Misses: 1, Batches: 1, Keys: 1},
+	}, dl.PatternStats())
+}
+
+func TestUserLoaderStats(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+	require.Equal(t, example.UserLoaderStats{}, dl.Stats())
+
+	dl.LoadAll([]string{"U1", "U2", "E1"})
+	dl.Load("U1")
+	dl.Load("U3")
+
+	stats := dl.Stats()
+	require.Equal(t, 2, stats.Batches)
+	require.Equal(t, 4, stats.Keys)
+	require.Equal(t, 1, stats.Hits)
+	require.Equal(t, 4, stats.Misses)
+	require.Equal(t, 1, stats.Errors)
+	require.Equal(t, 2.0, stats.AvgBatchSize)
+	require.True(t, stats.FetchP50 > 0)
+	require.True(t, stats.FetchP99 >= stats.FetchP50)
+}
+
+func TestUserLoaderLoadAllNoCache(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		LoadAllNoCache: true,
+	})
+
+	_, err := dl.Load("U1")
+	require.NoError(t, err)
+
+	users, errs := dl.LoadAll([]string{"U1", "U2"})
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, "user U2", users[1].Name)
+
+	_, err = dl.Load("U2")
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, [][]string{{"U1"}, {"U2"}, {"U2"}}, fetches)
+}
+
+func TestUserLoaderLoadAllStream(t *testing.T) {
+	release := make(chan struct{})
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:     time.Millisecond,
+		MaxBatch: 2,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			if keys[0] == "U3" {
+				<-release
+			}
+			return fetchUsers(keys)
+		},
+	})
+	dl.Prime("U0", &example.User{ID: "U0", Name: "user U0"})
+
+	results := dl.LoadAllStream([]string{"U0", "U1", "E1", "U3", "U4"})
+
+	// the cached key and the first batch arrive while the second batch is still blocked
+	var got []string
+	for i := 0; i < 3; i++ {
+		r := <-results
+		got = append(got, r.Key)
+		if r.Key == "E1" {
+			require.Error(t, r.Err)
+			require.Equal(t, 2, r.Index)
+		} else {
+			require.NoError(t, r.Err)
+			require.Equal(t, "user "+r.Key, r.Value.Name)
+		}
+	}
+	sort.Strings(got)
+	require.Equal(t, []string{"E1", "U0", "U1"}, got)
+
+	close(release)
+	got = nil
+	for r := range results {
+		got = append(got, r.Key)
+	}
+	require.Equal(t, []string{"U3", "U4"}, got)
+}
+
+func TestUserLoaderLoadAllPartial(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:     time.Millisecond,
+		MaxBatch: 2,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			if keys[0] == "U3" {
+				<-release
+			}
+			return fetchUsers(keys)
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	users, errs := dl.LoadAllPartial(ctx, []string{"U1", "E1", "U3", "U4"})
+
+	require.Equal(t, "user U1", users[0].Name)
+	require.NoError(t, errs[0])
+	require.EqualError(t, errs[1], "user not found")
+	require.Nil(t, users[2])
+	require.Equal(t, context.DeadlineExceeded, errs[2])
+	require.Equal(t, context.DeadlineExceeded, errs[3])
+}
+
+func TestUserLoaderLoadAllParallel(t *testing.T) {
+	t.Run("values are returned in order", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, MaxBatch: 2, Fetch: fetchUsers})
+
+		users, err := dl.LoadAllParallel(context.Background(), []string{"U1", "U2", "U3", "U1"}, 2)
+		require.NoError(t, err)
+		require.Len(t, users, 4)
+		for i, id := range []string{"U1", "U2", "U3", "U1"} {
+			require.Equal(t, id, users[i].ID)
+		}
+	})
+
+	t.Run("the first error cancels the rest", func(t *testing.T) {
+		cancelled := make(chan error, 1)
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:     time.Millisecond,
+			MaxBatch: 1,
+			FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+				if keys[0] == "S1" {
+					<-ctx.Done()
+					cancelled <- ctx.Err()
+					return nil, []error{ctx.Err()}
+				}
+				return fetchUsers(keys)
+			},
+		})
+
+		users, err := dl.LoadAllParallel(context.Background(), []string{"S1", "E1"}, 2)
+		require.Nil(t, users)
+		require.EqualError(t, err, "UserLoader: loading E1: user not found")
+		require.Equal(t, context.Canceled, <-cancelled)
+	})
+
+	t.Run("gives up when ctx is done", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				<-release
+				return fetchUsers(keys)
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := dl.LoadAllParallel(ctx, []string{"U1", "U2"}, 1)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+
+	t.Run("repeated keys get one goroutine", func(t *testing.T) {
+		fetching := make(chan struct{})
+		release := make(chan struct{})
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				close(fetching)
+				<-release
+				return fetchUsers(keys)
+			},
+		})
+
+		keys := make([]string, 1000)
+		for i := range keys {
+			keys[i] = "U1"
+		}
+		before := runtime.NumGoroutine()
+		done := make(chan struct{})
+		var users []*example.User
+		var err error
+		go func() {
+			users, err = dl.LoadAllParallel(context.Background(), keys, 0)
+			close(done)
+		}()
+
+		<-fetching
+		require.Less(t, runtime.NumGoroutine()-before, 100)
+		close(release)
+		<-done
+		require.NoError(t, err)
+		require.Len(t, users, 1000)
+		for _, u := range users {
+			require.Equal(t, "U1", u.ID)
+		}
+	})
+}
+
+func TestUserLoaderValidateValue(t *testing.T) {
+	var fetches int
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			fetches++
+			users, errs := fetchUsers(keys)
+			for _, u := range users {
+				if u != nil && u.ID == "U2" {
+					u.ID = ""
+				}
+			}
+			return users, errs
+		},
+		ValidateValue: func(key string, user *example.User) error {
+			if user.ID != key {
+				return fmt.Errorf("user %s: got id %q", key, user.ID)
+			}
+			return nil
+		},
+	})
+
+	_, errs := dl.LoadAll([]string{"U1", "U2", "E1"})
+	require.NoError(t, errs[0])
+	require.EqualError(t, errs[1], `user U2: got id ""`)
+	require.EqualError(t, errs[2], "user not found")
+
+	_, err := dl.Load("U2")
+	require.Error(t, err)
+	require.Equal(t, 2, fetches, "invalid values aren't cached")
+}
+
+func TestUserLoaderTransform(t *testing.T) {
+	var calls int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+		Transform: func(key string, user *example.User) *example.User {
+			atomic.AddInt32(&calls, 1)
+			return &example.User{ID: user.ID, Name: strings.ToUpper(user.Name)}
+		},
+	})
+
+	users, errs := dl.LoadAll([]string{"U1", "E1"})
+	require.NoError(t, errs[0])
+	require.Equal(t, "USER U1", users[0].Name)
+	require.Error(t, errs[1])
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "USER U1", u.Name)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestUserLoaderPrimeIfNewer(t *testing.T) {
+	// the version of a user is the number after the v in its name
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+		Version: func(user *example.User) int64 {
+			var version int64
+			fmt.Sscanf(user.Name[strings.LastIndex(user.Name, "v"):], "v%d", &version)
+			return version
+		},
+	})
+
+	require.True(t, dl.PrimeIfNewer("U1", &example.User{ID: "U1", Name: "v2"}))
+	require.False(t, dl.PrimeIfNewer("U1", &example.User{ID: "U1", Name: "v1"}), "older values are ignored")
+	require.False(t, dl.PrimeIfNewer("U1", &example.User{ID: "U1", Name: "v2"}))
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "v2", u.Name)
+
+	require.True(t, dl.PrimeIfNewer("U1", &example.User{ID: "U1", Name: "v3"}))
+	u, err = dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "v3", u.Name)
+
+	t.Run("is safe while the config changes", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				dl.PrimeIfNewer("U2", &example.User{ID: "U2", Name: fmt.Sprintf("v%d", i)})
+			}(i)
+			go func() {
+				defer wg.Done()
+				dl.Apply(func(config *example.UserLoaderConfig) { config.Wait = time.Millisecond })
+			}()
+		}
+		wg.Wait()
+
+		u, err := dl.Load("U2")
+		require.NoError(t, err)
+		require.Equal(t, "v9", u.Name, "the newest version wins whatever order the primes ran in")
+	})
+}
+
+func TestUserLoaderPrimeMany(t *testing.T) {
+	var fetches int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			return fetchUsers(keys)
+		},
+	})
+	dl.Prime("U1", &example.User{ID: "U1", Name: "primed before"})
+
+	listed := []*example.User{{ID: "U1", Name: "listed"}, {ID: "U2", Name: "listed"}}
+	require.Equal(t, 1, dl.PrimeMany([]string{"U1", "U2"}, listed))
+	require.Equal(t, 1, dl.PrimeMap(map[string]*example.User{"U2": {ID: "U2"}, "U3": {ID: "U3", Name: "mapped"}}))
+
+	users, errs := dl.LoadAll([]string{"U1", "U2", "U3"})
+	require.Equal(t, []error{nil, nil, nil}, errs)
+	require.Equal(t, "primed before", users[0].Name)
+	require.Equal(t, "listed", users[1].Name)
+	require.Equal(t, "mapped", users[2].Name)
+	require.Equal(t, int32(0), atomic.LoadInt32(&fetches))
+
+	listed[1].Name = "changed"
+	u, _ := dl.Load("U2")
+	require.Equal(t, "listed", u.Name, "values are copied")
+
+	require.PanicsWithValue(t, "UserLoader: PrimeMany got 2 keys and 1 values", func() {
+		dl.PrimeMany([]string{"U4", "U5"}, listed[:1])
+	})
+}
+
+func TestUserLoaderPrimePending(t *testing.T) {
+	renamed := &example.User{ID: "U1", Name: "renamed"}
+
+	t.Run("commit keeps the staged value", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+		dl.Load("U1")
+
+		dl.PrimePending("U1", renamed)
+		u, _ := dl.Load("U1")
+		require.Equal(t, "renamed", u.Name)
+
+		require.True(t, dl.CommitPrime("U1"))
+		require.False(t, dl.RollbackPrime("U1"))
+		u, _ = dl.Load("U1")
+		require.Equal(t, "renamed", u.Name)
+	})
+
+	t.Run("rollback restores the cached value", func(t *testing.T) {
+		var fetches int32
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				atomic.AddInt32(&fetches, 1)
+				return fetchUsers(keys)
+			},
+		})
+		dl.Load("U1")
+
+		dl.PrimePending("U1", renamed)
+		dl.PrimePending("U1", &example.User{ID: "U1", Name: "renamed twice"})
+		require.True(t, dl.RollbackPrime("U1"))
+		u, _ := dl.Load("U1")
+		require.Equal(t, "user U1", u.Name)
+		require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	})
+
+	t.Run("rollback of an uncached key clears it", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+
+		dl.PrimePending("U1", renamed)
+		require.True(t, dl.RollbackPrime("U1"))
+		_, _, found := dl.Entry("U1")
+		require.False(t, found)
+		u, _ := dl.Load("U1")
+		require.Equal(t, "user U1", u.Name)
+	})
+
+	t.Run("rollback leaves values primed since", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+		dl.Load("U1")
+
+		dl.PrimePending("U1", renamed)
+		dl.ForcePrime("U1", &example.User{ID: "U1", Name: "written elsewhere"})
+		require.True(t, dl.RollbackPrime("U1"))
+		u, _ := dl.Load("U1")
+		require.Equal(t, "written elsewhere", u.Name)
+	})
+}
+
+func TestUserLoaderLoadThen(t *testing.T) {
+	var derives int32
+	upper := example.NewUserLoaderDerivation(func(user *example.User) (interface{}, error) {
+		atomic.AddInt32(&derives, 1)
+		if user.ID == "U0" {
+			return nil, errors.New("can't derive U0")
+		}
+		return strings.ToUpper(user.Name), nil
+	})
+
+	t.Run("derives once per cached value", func(t *testing.T) {
+		atomic.StoreInt32(&derives, 0)
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+
+		for i := 0; i < 3; i++ {
+			name, err := dl.LoadThen("U1", upper)
+			require.NoError(t, err)
+			require.Equal(t, "USER U1", name)
+		}
+		require.Equal(t, int32(1), atomic.LoadInt32(&derives))
+	})
+
+	t.Run("is invalidated with the value", func(t *testing.T) {
+		atomic.StoreInt32(&derives, 0)
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+		dl.LoadThen("U1", upper)
+
+		dl.Clear("U1")
+		dl.LoadThen("U1", upper)
+		require.Equal(t, int32(2), atomic.LoadInt32(&derives))
+
+		dl.ForcePrime("U1", &example.User{ID: "U1", Name: "renamed"})
+		name, err := dl.LoadThen("U1", upper)
+		require.NoError(t, err)
+		require.Equal(t, "RENAMED", name)
+		require.Equal(t, int32(3), atomic.LoadInt32(&derives))
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		atomic.StoreInt32(&derives, 0)
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+
+		_, err := dl.LoadThen("E1", upper)
+		require.Error(t, err)
+		require.Equal(t, int32(0), atomic.LoadInt32(&derives), "values that failed to load aren't derived")
+
+		dl.Prime("U0", &example.User{ID: "U0"})
+		_, err = dl.LoadThen("U0", upper)
+		require.EqualError(t, err, "can't derive U0")
+		_, err = dl.LoadThen("U0", upper)
+		require.EqualError(t, err, "can't derive U0")
+		require.Equal(t, int32(2), atomic.LoadInt32(&derives), "failed derivations aren't cached")
+	})
+}
+
+func TestUserLoaderForcePrime(t *testing.T) {
+	t.Run("replaces cached values", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+		dl.Load("U1")
+
+		require.True(t, dl.ForcePrime("U1", &example.User{ID: "U1", Name: "renamed"}))
+		u, err := dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "renamed", u.Name)
+	})
+
+	t.Run("older versions are ignored", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:    time.Millisecond,
+			Fetch:   fetchUsers,
+			Version: func(user *example.User) int64 { return int64(len(user.Name)) },
+		})
+
+		require.True(t, dl.ForcePrime("U1", &example.User{ID: "U1", Name: "bb"}))
+		require.True(t, dl.ForcePrime("U1", &example.User{ID: "U1", Name: "cc"}), "the same version replaces it")
+		require.False(t, dl.ForcePrime("U1", &example.User{ID: "U1", Name: "a"}))
+		u, err := dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "cc", u.Name)
+
+		// the check and the write are atomic, so of racing writes the longest name wins
+		var wg sync.WaitGroup
+		for i := 1; i <= 10; i++ {
+			wg.Add(2)
+			go func(i int) {
+				defer wg.Done()
+				dl.ForcePrime("U2", &example.User{ID: "U2", Name: strings.Repeat("x", i)})
+			}(i)
+			go func() {
+				defer wg.Done()
+				dl.Apply(func(config *example.UserLoaderConfig) { config.Wait = time.Millisecond })
+			}()
+		}
+		wg.Wait()
+		u, err = dl.Load("U2")
+		require.NoError(t, err)
+		require.Equal(t, strings.Repeat("x", 10), u.Name)
+	})
+
+	t.Run("in flight batches don't overwrite it", func(t *testing.T) {
+		started, proceed := make(chan struct{}), make(chan struct{})
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				close(started)
+				<-proceed
+				return fetchUsers(keys)
+			},
+		})
+
+		thunk := dl.LoadThunk("U1")
+		<-started
+		dl.ForcePrime("U1", &example.User{ID: "U1", Name: "written"})
+		close(proceed)
+		u, err := thunk()
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name, "the batch still returns what it fetched")
+
+		u, err = dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "written", u.Name)
+	})
+}
+
+func TestUserLoaderSession(t *testing.T) {
+	var fetches int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			return fetchUsers(keys)
+		},
+	})
+	dl.Prime("U1", &example.User{ID: "U1", Name: "stale"})
+	dl.Prime("U2", &example.User{ID: "U2", Name: "stale"})
+
+	session := dl.Session()
+	session.Wrote("U1")
+
+	users, errs := session.LoadAll([]string{"U1", "U2"})
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, "user U1", users[0].Name)
+	require.Equal(t, "stale", users[1].Name, "keys that weren't written still come from the cache")
+
+	_, err := session.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches), "written keys are fetched on every load")
+
+	u, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "stale", u.Name, "the shared cache isn't touched by the session")
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	other := dl.Session()
+	u, err = other.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "stale", u.Name, "other sessions still read the shared cache")
+}
+
+func TestUserLoaderMaxValueBytes(t *testing.T) {
+	var fetches int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			return fetchUsers(keys)
+		},
+		MaxValueBytes: 10,
+		ValueSize: func(user *example.User) int {
+			return len(user.ID) + len(user.Name)
+		},
+	})
+
+	users, errs := dl.LoadAll([]string{"U1", "Uhuge"})
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, "user Uhuge", users[1].Name, "oversized values are still returned")
+
+	_, err := dl.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	_, err = dl.Load("Uhuge")
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches), "oversized values aren't cached")
+
+	require.Error(t, example.UserLoaderConfig{Fetch: fetchUsers, MaxValueBytes: 10}.Validate())
+}
+
+func TestUserLoaderSecondary(t *testing.T) {
+	var mu sync.Mutex
+	var byID, bySlug [][]string
+	ids := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			byID = append(byID, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+	// slugs look like user-U1
+	slugs := example.NewUserLoaderSecondary(ids, example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			bySlug = append(bySlug, keys)
+			mu.Unlock()
+			trimmed := make([]string, len(keys))
+			for i, key := range keys {
+				trimmed[i] = strings.TrimPrefix(key, "user-")
+			}
+			return fetchUsers(trimmed)
+		},
+	}, func(user *example.User) string {
+		return user.ID
+	})
+
+	u, err := slugs.Load("user-U1")
+	require.NoError(t, err)
+	require.Equal(t, "U1", u.ID)
+
+	u, err = ids.Load("U1")
+	require.NoError(t, err)
+	require.Equal(t, "user U1", u.Name)
+
+	_, err = slugs.Load("user-U1")
+	require.NoError(t, err)
+
+	ids.Clear("U1")
+	_, err = slugs.Load("user-U1")
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Empty(t, byID, "values loaded by slug are cached by id")
+	require.Equal(t, [][]string{{"user-U1"}, {"user-U1"}}, bySlug)
+}
+
+func TestUserLoaderClearIndexed(t *testing.T) {
+	var fetches int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			return fetchUsers(keys)
+		},
+		// users with an even number are in org a, the rest in org b
+		IndexBy: func(user *example.User) []string {
+			if (user.ID[len(user.ID)-1]-'0')%2 == 0 {
+				return []string{"org:a"}
+			}
+			return []string{"org:b"}
+		},
+	})
+
+	_, errs := dl.LoadAll([]string{"U1", "U2", "U3", "U4"})
+	require.Equal(t, []error{nil, nil, nil, nil}, errs)
+
+	require.Equal(t, 2, dl.ClearIndexed("org:a"))
+	require.Equal(t, 0, dl.ClearIndexed("org:a"))
+
+	_, errs = dl.LoadAll([]string{"U1", "U2", "U3", "U4"})
+	require.Equal(t, []error{nil, nil, nil, nil}, errs)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	dl.Clear("U1")
+	require.Equal(t, 1, dl.ClearIndexed("org:b"))
+}
+
+func TestUserLoaderRecordReplay(t *testing.T) {
+	var golden bytes.Buffer
+	recording := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: example.UserLoaderRecord(&golden, func(keys []string) ([]*example.User, []error) {
+			users, errs := fetchUsers(keys)
+			for i, key := range keys {
+				if strings.HasPrefix(key, "M") {
+					errs[i] = fmt.Errorf("%w: %s", example.ErrUserLoaderNotFound, key)
+				}
+			}
+			return users, errs
+		}),
+	})
+	_, errs := recording.LoadAll([]string{"U1", "E1", "M1"})
+	require.Error(t, errs[1])
+	_, err := recording.Load("U2")
+	require.NoError(t, err)
+
+	fetch, err := example.UserLoaderReplay(&golden)
+	require.NoError(t, err)
+	replaying := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetch,
+	})
+
+	users, errs := replaying.LoadAll([]string{"U2", "U1", "E1", "U3", "M1"})
+	require.Equal(t, "user U2", users[0].Name)
+	require.Equal(t, "user U1", users[1].Name)
+	require.EqualError(t, errs[2], "user not found")
+	require.False(t, errors.Is(errs[2], example.ErrUserLoaderNotFound))
+	require.True(t, errors.Is(errs[3], example.ErrUserLoaderNotRecorded))
+	require.EqualError(t, errs[4], "UserLoader: not found: M1")
+	require.True(t, errors.Is(errs[4], example.ErrUserLoaderNotFound), "sentinels survive the replay")
+}
+
+func TestUserLoaderChaos(t *testing.T) {
+	var chaos example.UserLoaderChaos
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: chaos.Wrap(fetchUsers),
+	})
+
+	_, err := dl.Load("U1")
+	require.NoError(t, err)
+
+	chaos.Set(example.UserLoaderChaosConfig{KeyErrorRate: 1})
+	_, errs := dl.LoadAll([]string{"U1", "U2"})
+	require.NoError(t, errs[0], "cached keys aren't fetched")
+	require.Equal(t, example.ErrUserLoaderInjected, errs[1])
+
+	chaos.Set(example.UserLoaderChaosConfig{BatchErrorRate: 1, Latency: 5 * time.Millisecond})
+	start := time.Now()
+	_, errs = dl.LoadAll([]string{"U3", "U4"})
+	require.Equal(t, []error{example.ErrUserLoaderInjected, example.ErrUserLoaderInjected}, errs)
+	require.True(t, time.Since(start) >= 5*time.Millisecond)
+
+	chaos.Set(example.UserLoaderChaosConfig{})
+	_, err = dl.Load("U5")
+	require.NoError(t, err)
+}
+
+func TestUserLoaderExportImport(t *testing.T) {
+	running := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+	running.LoadAll([]string{"U1", "U2", "E1"})
+	running.Prime("U3", &example.User{ID: "U3", Name: "short lived"}, example.UserLoaderWithTTL(time.Hour))
+
+	var transport bytes.Buffer
+	n, err := running.Export(&transport)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	var fetches int32
+	starting := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			return fetchUsers(keys)
+		},
+	})
+	n, err = starting.Import(&transport)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+
+	users, errs := starting.LoadAll([]string{"U1", "U2", "U3"})
+	require.Equal(t, []error{nil, nil, nil}, errs)
+	require.Equal(t, "user U2", users[1].Name)
+	require.Equal(t, int32(0), atomic.LoadInt32(&fetches))
+
+	_, meta, ok := starting.Entry("U3")
+	require.True(t, ok)
+	require.False(t, meta.Expires.IsZero(), "the remaining ttl is kept")
+
+	t.Run("the clocks of the instances needn't agree", func(t *testing.T) {
+		// the exporting instance's clock was an hour ahead, by this one's the entry expired already
+		expires := time.Now().Add(-time.Minute).Format(time.RFC3339Nano)
+		line := `{"key":"U4","value":{"ID":"U4","Name":"user U4"},"expires":"` + expires + `","ttl":3600000000000}`
+
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+		n, err := dl.Import(strings.NewReader(line + "\n"))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		_, meta, ok := dl.Entry("U4")
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now().Add(time.Hour), meta.Expires, time.Minute)
+	})
+
+	t.Run("keys fetch didn't find", func(t *testing.T) {
+		fetchNil := func(keys []string) ([]*example.User, []error) {
+			return make([]*example.User, len(keys)), nil
+		}
+		running := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchNil})
+		running.Load("N1")
+
+		var transport bytes.Buffer
+		n, err := running.Export(&transport)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		exported := transport.String()
+
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchNil})
+		n, err = dl.Import(&transport)
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		u, _, ok := dl.Entry("N1")
+		require.True(t, ok)
+		require.Nil(t, u)
+
+		// with MissingError a fetched nil isn't cached either
+		dl = example.NewUserLoader(example.UserLoaderConfig{
+			Wait:          time.Millisecond,
+			Fetch:         fetchNil,
+			MissingPolicy: example.UserLoaderMissingError,
+		})
+		n, err = dl.Import(strings.NewReader(exported))
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+		_, err = dl.Load("N1")
+		require.True(t, errors.Is(err, example.ErrUserLoaderNotFound))
+	})
+}
+
+func TestUserLoaderLogSample(t *testing.T) {
+	var mu sync.Mutex
+	var samples []example.UserLoaderLoadSample
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:          time.Millisecond,
+		Fetch:         fetchUsers,
+		LogSampleRate: 1,
+		LogSample: func(sample example.UserLoaderLoadSample) {
+			mu.Lock()
+			samples = append(samples, sample)
+			mu.Unlock()
+		},
+	})
+
+	dl.Load("U1")
+	dl.Load("U1")
+	dl.Load("E1")
+
+	mu.Lock()
+	require.Len(t, samples, 3)
+	require.False(t, samples[0].Hit)
+	require.True(t, samples[0].Latency >= time.Millisecond)
+	require.True(t, samples[1].Hit)
+	require.Error(t, samples[2].Err)
+	mu.Unlock()
+
+	dl.Apply(func(config *example.UserLoaderConfig) { config.LogSampleRate = 0 })
+	dl.Load("U2")
+	mu.Lock()
+	require.Len(t, samples, 3)
+	mu.Unlock()
+}
+
+func TestUserLoaderLoadContext(t *testing.T) {
+	t.Run("gives up when ctx is done", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				<-release
+				return fetchUsers(keys)
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := dl.LoadContext(ctx, "U1")
+		require.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("fetch is cancelled once every waiter is gone", func(t *testing.T) {
+		cancelled := make(chan error, 1)
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: 5 * time.Millisecond,
+			FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+				<-ctx.Done()
+				cancelled <- ctx.Err()
+				return nil, []error{ctx.Err()}
+			},
+		})
+
+		ctx1, cancel1 := context.WithCancel(context.Background())
+		ctx2, cancel2 := context.WithCancel(context.Background())
+		thunk1 := dl.LoadThunkContext(ctx1, "U1")
+		thunk2 := dl.LoadThunkContext(ctx2, "U2")
+		time.Sleep(10 * time.Millisecond)
+
+		cancel1()
+		_, err := thunk1()
+		require.Equal(t, context.Canceled, err)
+		select {
+		case <-cancelled:
+			t.Fatal("fetch was cancelled while U2 was still waiting")
+		case <-time.After(5 * time.Millisecond):
+		}
+
+		cancel2()
+		_, err = thunk2()
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, context.Canceled, <-cancelled)
+
+		require.Eventually(t, func() bool { return dl.Stats().Abandoned == 1 }, time.Second, time.Millisecond)
+		require.Equal(t, 0, dl.Stats().Batches, "a cancelled fetch isn't counted as a batch")
+	})
+
+	t.Run("loads without a ctx keep the fetch alive", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: 5 * time.Millisecond,
+			FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+				time.Sleep(10 * time.Millisecond)
+				if ctx.Err() != nil {
+					return nil, []error{ctx.Err()}
+				}
+				return fetchUsers(keys)
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk1 := dl.LoadThunkContext(ctx, "U1")
+		thunk2 := dl.LoadThunk("U2")
+		cancel()
+
+		_, err := thunk1()
+		require.Equal(t, context.Canceled, err)
+		u, err := thunk2()
+		require.NoError(t, err)
+		require.Equal(t, "user U2", u.Name)
+	})
+
+	t.Run("batches every waiter gave up on before dispatch aren't fetched", func(t *testing.T) {
+		var fetches int32
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: 10 * time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				atomic.AddInt32(&fetches, 1)
+				return fetchUsers(keys)
+			},
+			StatsWindow: time.Minute,
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk := dl.LoadThunkContext(ctx, "U1")
+		cancel()
+		_, err := thunk()
+		require.Equal(t, context.Canceled, err)
+
+		time.Sleep(20 * time.Millisecond)
+		require.Equal(t, int32(0), atomic.LoadInt32(&fetches))
+		require.Equal(t, 1, dl.Stats().Abandoned)
+		require.Equal(t, 0, dl.WindowStats(time.Minute).Batches)
+		require.False(t, dl.IsPending("U1"))
+	})
+
+	t.Run("a waiter joining an abandoned batch gets it fetched", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: 10 * time.Millisecond, Fetch: fetchUsers})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk1 := dl.LoadThunkContext(ctx, "U1")
+		cancel()
+		_, err := thunk1()
+		require.Equal(t, context.Canceled, err)
+
+		u, err := dl.LoadContext(context.Background(), "U2")
+		require.NoError(t, err)
+		require.Equal(t, "user U2", u.Name)
+		require.Equal(t, 0, dl.Stats().Abandoned)
+	})
+
+	t.Run("a thunk called again after giving up keeps its ctx", func(t *testing.T) {
+		var fetches int32
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: 5 * time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				atomic.AddInt32(&fetches, 1)
+				return fetchUsers(keys)
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk1 := dl.LoadThunkContext(ctx, "U1")
+		thunk2 := dl.LoadThunk("U2")
+		cancel()
+		_, err := thunk1()
+		require.Equal(t, context.Canceled, err)
+
+		_, err = thunk2()
+		require.NoError(t, err)
+		_, err = thunk1()
+		require.Equal(t, context.Canceled, err)
+		require.Equal(t, int32(1), atomic.LoadInt32(&fetches), "not loaded again for a caller that gave up")
+	})
+}
+
+func TestUserLoaderOwner(t *testing.T) {
+	request, finish := context.WithCancel(context.Background())
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:  time.Millisecond,
+		Fetch: fetchUsers,
+		Owner: request,
+	})
+
+	_, err := dl.Load("U1")
+	require.NoError(t, err)
+
+	finish()
+	for _, key := range []string{"U1", "U2"} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				require.True(t, errors.Is(err, example.ErrUserLoaderOwnerDone), "loading %s after the request", key)
+			}()
+			dl.Load(key)
+		}()
+	}
+}
+
+type keyLocker struct {
+	mu     sync.Mutex
+	locked map[string]bool
+
+	// unlocking is called with every key that is unlocked, before it is
+	unlocking func(key string)
+}
+
+func (l *keyLocker) TryLock(key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locked[key] {
+		return nil, false
+	}
+	l.locked[key] = true
+	return func() {
+		l.mu.Lock()
+		if l.unlocking != nil {
+			l.unlocking(key)
+		}
+		delete(l.locked, key)
+		l.mu.Unlock()
+	}, true
+}
+
+func TestUserLoaderKeyLocker(t *testing.T) {
+	// two processes sharing a cache and a lock
+	shared := example.NewUserLoaderMapCache()
+	locker := &keyLocker{locked: map[string]bool{}}
+
+	var mu sync.Mutex
+	var fetched []string
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetched = append(fetched, keys...)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		Cache:       shared,
+		KeyLocker:   locker,
+		KeyLockWait: 50 * time.Millisecond,
+	})
+
+	// the other process is busy fetching U2 and will cache it shortly
+	unlock, _ := locker.TryLock("U2")
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		shared.Set("U2", &example.User{ID: "U2", Name: "from the other process"})
+		unlock()
+	}()
+
+	users, errs := dl.LoadAll([]string{"U1", "U2"})
+	require.Equal(t, []error{nil, nil}, errs)
+	require.Equal(t, "user U1", users[0].Name)
+	require.Equal(t, "from the other process", users[1].Name)
+
+	// nobody fills U3 in, so it is fetched after waiting
+	locker.TryLock("U3")
+	u, err := dl.Load("U3")
+	require.NoError(t, err)
+	require.Equal(t, "user U3", u.Name)
+
+	mu.Lock()
+	require.Equal(t, []string{"U1", "U3"}, fetched)
+	mu.Unlock()
+
+	// the other process stops waiting once U4 is unlocked, so it must be cached by then
+	cached := map[string]bool{}
+	locker.unlocking = func(key string) {
+		_, cached[key] = shared.Get(key)
+	}
+	thunk := dl.LoadThunk("U4")
+	_, err = thunk()
+	require.NoError(t, err)
+	locker.mu.Lock()
+	defer locker.mu.Unlock()
+	require.Equal(t, map[string]bool{"U4": true}, cached)
+}
+
+func TestUserLoaderOptional(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetched = append(fetched, keys...)
+			mu.Unlock()
+
+			users, errs := fetchUsers(keys)
+			for i, key := range keys {
+				if strings.HasPrefix(key, "M") {
+					users[i] = nil
+				}
+			}
+			return users, errs
+		},
+		MissingPolicy: example.UserLoaderMissingError,
+	})
+
+	t.Run("tells absent keys from errors", func(t *testing.T) {
+		users, errs := dl.LoadAllOptional([]string{"U1", "M1", "E1"})
+		require.NoError(t, errs[0])
+		require.True(t, users[0].Found)
+		require.Equal(t, "user U1", users[0].Value.Name)
+
+		require.NoError(t, errs[1])
+		require.False(t, users[1].Found)
+
+		require.EqualError(t, errs[2], "user not found")
+	})
+
+	t.Run("caches absence", func(t *testing.T) {
+		u, err := dl.LoadOptional("M1")
+		require.NoError(t, err)
+		require.False(t, u.Found)
+
+		_, err = dl.Load("M1")
+		require.True(t, errors.Is(err, example.ErrUserLoaderNotFound))
+
+		mu.Lock()
+		require.Equal(t, []string{"U1", "M1", "E1"}, fetched)
+		mu.Unlock()
+
+		dl.Clear("M1")
+		u, err = dl.LoadOptional("M1")
+		require.NoError(t, err)
+		require.False(t, u.Found)
+
+		mu.Lock()
+		require.Equal(t, []string{"U1", "M1", "E1", "M1"}, fetched)
+		mu.Unlock()
+	})
+
+	t.Run("primes absence", func(t *testing.T) {
+		require.True(t, dl.PrimeOptional("P1", example.UserLoaderOptional{}))
+		require.False(t, dl.PrimeOptional("P1", example.UserLoaderOptional{}))
+		require.True(t, dl.PrimeOptional("P2", example.UserLoaderOptional{Value: &example.User{ID: "P2"}, Found: true}))
+
+		users, errs := dl.LoadAllOptional([]string{"P1", "P2"})
+		require.Equal(t, []error{nil, nil}, errs)
+		require.False(t, users[0].Found)
+		require.True(t, users[1].Found)
+		require.Equal(t, "P2", users[1].Value.ID)
+	})
+
+	t.Run("primes over absence", func(t *testing.T) {
+		u, err := dl.LoadOptional("M2")
+		require.NoError(t, err)
+		require.False(t, u.Found)
+
+		require.True(t, dl.Prime("M2", &example.User{ID: "M2"}))
+		u, err = dl.LoadOptional("M2")
+		require.NoError(t, err)
+		require.True(t, u.Found)
+		require.Equal(t, "M2", u.Value.ID)
+
+		dl.LoadOptional("M3")
+		require.Equal(t, 1, dl.PrimeMap(map[string]*example.User{"M3": {ID: "M3"}}))
+		u, err = dl.LoadOptional("M3")
+		require.NoError(t, err)
+		require.True(t, u.Found)
+	})
+
+	t.Run("absence expires with the TTL", func(t *testing.T) {
+		var fetches int32
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				atomic.AddInt32(&fetches, 1)
+				return make([]*example.User, len(keys)), nil
+			},
+			MissingPolicy: example.UserLoaderMissingError,
+			TTL:           20 * time.Millisecond,
+		})
+
+		u, err := dl.LoadOptional("M1")
+		require.NoError(t, err)
+		require.False(t, u.Found)
+		dl.LoadOptional("M1")
+		require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+		time.Sleep(30 * time.Millisecond)
+		u, err = dl.LoadOptional("M1")
+		require.NoError(t, err)
+		require.False(t, u.Found)
+		require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	})
+}
+
+type keyCache struct {
+	mu   sync.Mutex
+	data map[string]*example.User
+}
+
+func (c *keyCache) Get(key string) (*example.User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	u, ok := c.data[key]
+	return u, ok
+}
+
+func (c *keyCache) Set(key string, value *example.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data[key] = value
+}
+
+func (c *keyCache) ClearKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.data, key)
+}
+
+func TestUserLoaderClearAll(t *testing.T) {
+	t.Run("clearable caches are cleared", func(t *testing.T) {
+		cache := example.NewUserLoaderMapCache()
+		cache.Set("other", &example.User{ID: "other"})
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers, Cache: cache})
+		dl.LoadAll([]string{"U1", "U2"})
+
+		dl.ClearAll()
+		for _, key := range []string{"U1", "U2", "other"} {
+			_, ok := cache.Get(key)
+			require.False(t, ok, key)
+		}
+	})
+
+	t.Run("other caches have known keys cleared", func(t *testing.T) {
+		cache := &keyCache{data: map[string]*example.User{"other": {ID: "other"}}}
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers, Cache: cache})
+		dl.LoadAll([]string{"U1", "U2"})
+
+		dl.ClearAll()
+		require.Equal(t, map[string]*example.User{"other": {ID: "other"}}, cache.data)
+	})
+
+	t.Run("in flight batches aren't cached", func(t *testing.T) {
+		cache := example.NewUserLoaderMapCache()
+		started, proceed := make(chan struct{}), make(chan struct{})
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:  time.Millisecond,
+			Cache: cache,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				close(started)
+				<-proceed
+				return fetchUsers(keys)
+			},
+		})
+
+		thunk := dl.LoadAllThunk([]string{"U1", "U2"})
+		<-started
+		dl.ClearAll()
+		close(proceed)
+
+		users, _ := thunk()
+		require.Equal(t, "U1", users[0].ID)
+		_, ok := cache.Get("U1")
+		require.False(t, ok)
+	})
+}
+
+func TestUserLoaderClearMany(t *testing.T) {
+	var mu sync.Mutex
+	var fetches [][]string
+	started, proceed := make(chan struct{}, 1), make(chan struct{}, 1)
+	proceed <- struct{}{}
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			started <- struct{}{}
+			<-proceed
+			return fetchUsers(keys)
+		},
+	})
+
+	dl.LoadAll([]string{"U1", "U2", "U3"})
+	<-started
+	dl.ClearMany("U1", "U2")
+
+	// U1 is cleared while its fetch is in flight, so it is fetched again even though the batch returned it
+	thunk := dl.LoadAllThunk([]string{"U1", "U4"})
+	<-started
+	dl.ClearMany("U1")
+	proceed <- struct{}{}
+	users, errs := thunk()
+	require.NoError(t, errs[0])
+	require.Equal(t, "U1", users[0].ID)
+
+	proceed <- struct{}{}
+	dl.LoadAll([]string{"U1", "U2", "U3", "U4"})
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, [][]string{{"U1", "U2", "U3"}, {"U1", "U4"}, {"U1", "U2"}}, fetches)
+}
+
+func TestUserLoaderNoCache(t *testing.T) {
+	var fetches int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			return fetchUsers(keys)
+		},
+		Cache: example.UserLoaderNoCache{},
+	})
+
+	users, _ := dl.LoadAll([]string{"U1", "U1"})
+	require.Equal(t, "user U1", users[1].Name)
+	dl.Load("U1")
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestUserLoaderContext(t *testing.T) {
+	require.Equal(t, "UserLoader", example.UserLoaderName)
+	require.Nil(t, example.UserLoaderFromContext(context.Background()))
+
+	dl := example.NewUserLoader(example.UserLoaderConfig{Fetch: fetchUsers})
+	ctx := example.WithUserLoader(context.Background(), dl)
+	require.True(t, dl == example.UserLoaderFromContext(ctx))
+}
+
+type fakeConn struct {
+	example.UserLoaderConn
+	id int
+}
+
+func TestUserLoaderFetchConn(t *testing.T) {
+	var mu sync.Mutex
+	var acquired int
+	var fail bool
+	var conns []int
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:     time.Millisecond,
+		MaxBatch: 2,
+		Acquire: func(ctx context.Context, fetch func(conn example.UserLoaderConn)) error {
+			mu.Lock()
+			acquired++
+			id, fail := acquired, fail
+			mu.Unlock()
+			if fail {
+				return errors.New("no connections left")
+			}
+			fetch(&fakeConn{id: id})
+			return nil
+		},
+		FetchConn: func(ctx context.Context, conn example.UserLoaderConn, keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			conns = append(conns, conn.(*fakeConn).id)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+
+	users, errs := dl.LoadAll([]string{"U1", "U2", "U3"})
+	require.Equal(t, []error{nil, nil, nil}, errs)
+	require.Equal(t, "user U3", users[2].Name)
+
+	// each batch ran on a connection of its own
+	mu.Lock()
+	sort.Ints(conns)
+	require.Equal(t, []int{1, 2}, conns)
+	fail = true
+	mu.Unlock()
+
+	_, err := dl.Load("U4")
+	require.EqualError(t, err, "no connections left")
+
+	t.Run("needs an acquire", func(t *testing.T) {
+		err := example.UserLoaderConfig{FetchConn: func(ctx context.Context, conn example.UserLoaderConn, keys []string) ([]*example.User, []error) {
+			return nil, nil
+		}}.Validate()
+		require.EqualError(t, err, "UserLoader: FetchConn needs an Acquire to get connections from")
+	})
+}
+
+func TestUserLoaderTTL(t *testing.T) {
+	caches := map[string]example.UserLoaderCache{
+		"ttl cache":   example.NewUserLoaderMapCache(),
+		"plain cache": &keyCache{data: map[string]*example.User{}},
+	}
+	for name, cache := range caches {
+		t.Run(name, func(t *testing.T) {
+			var fetches int32
+			dl := example.NewUserLoader(example.UserLoaderConfig{
+				Wait: time.Millisecond,
+				Fetch: func(keys []string) ([]*example.User, []error) {
+					atomic.AddInt32(&fetches, 1)
+					return fetchUsers(keys)
+				},
+				Cache: cache,
+				TTL:   20 * time.Millisecond,
+			})
+
+			dl.Load("U1")
+			dl.Load("U1")
+			require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+			time.Sleep(30 * time.Millisecond)
+			u, err := dl.Load("U1")
+			require.NoError(t, err)
+			require.Equal(t, "user U1", u.Name)
+			require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+		})
+	}
+}
+
+func TestUserLoaderFlush(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Hour,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		BatchKey: func(key string) string { return key[:1] },
+	})
+
+	dl.Flush()
+	thunks := []func() (*example.User, error){dl.LoadThunk("U1"), dl.LoadThunk("U2"), dl.LoadThunk("E1")}
+	dl.Flush()
+	for _, thunk := range thunks {
+		thunk()
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(fetches, func(i, j int) bool { return fetches[i][0] < fetches[j][0] })
+	require.Equal(t, [][]string{{"E1"}, {"U1", "U2"}}, fetches)
+	require.False(t, dl.IsPending("U1"))
+}
+
+func TestUserLoaderErrorTTL(t *testing.T) {
+	fetched := map[string]int{}
+	var mu sync.Mutex
+	fetch := func(keys []string) ([]*example.User, []error) {
+		mu.Lock()
+		for _, key := range keys {
+			fetched[key]++
+		}
+		mu.Unlock()
+		users, errs := fetchUsers(keys)
+		for i, key := range keys {
+			if strings.HasPrefix(key, "M") {
+				errs[i] = fmt.Errorf("user %s: %w", key, example.ErrUserLoaderNotFound)
+			}
+		}
+		return users, errs
+	}
+	fetches := func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetched[key]
+	}
+
+	t.Run("not found errors are cached until they expire", func(t *testing.T) {
+		fetched = map[string]int{}
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetch, ErrorTTL: 20 * time.Millisecond})
+
+		_, err := dl.Load("M1")
+		require.ErrorIs(t, err, example.ErrUserLoaderNotFound)
+		_, err = dl.Load("M1")
+		require.EqualError(t, err, "user M1: "+example.ErrUserLoaderNotFound.Error())
+		dl.Load("E1")
+		dl.Load("E1")
+		require.Equal(t, 1, fetches("M1"))
+		require.Equal(t, 2, fetches("E1"), "other errors aren't cached")
+
+		time.Sleep(30 * time.Millisecond)
+		dl.Load("M1")
+		require.Equal(t, 2, fetches("M1"))
+	})
+
+	t.Run("clearing or priming drops them", func(t *testing.T) {
+		fetched = map[string]int{}
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetch, ErrorTTL: time.Minute})
+
+		dl.Load("M1")
+		dl.Clear("M1")
+		dl.Load("M1")
+		require.Equal(t, 2, fetches("M1"))
+
+		require.True(t, dl.Prime("M1", &example.User{ID: "M1", Name: "created"}))
+		u, err := dl.Load("M1")
+		require.NoError(t, err)
+		require.Equal(t, "created", u.Name)
+	})
+
+	t.Run("CacheError picks the errors", func(t *testing.T) {
+		fetched = map[string]int{}
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:       time.Millisecond,
+			Fetch:      fetch,
+			ErrorTTL:   time.Minute,
+			CacheError: func(key string, err error) bool { return strings.HasPrefix(key, "E") },
+		})
+
+		dl.Load("E1")
+		dl.Load("E1")
+		dl.Load("M1")
+		dl.Load("M1")
+		require.Equal(t, 1, fetches("E1"))
+		require.Equal(t, 2, fetches("M1"))
+	})
+}
+
+func TestUserLoaderRetries(t *testing.T) {
+	flaky := errors.New("connection reset")
+	noBackoff := func(attempt int) time.Duration { return 0 }
+
+	t.Run("failed keys are fetched again", func(t *testing.T) {
+		var fetches [][]string
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				fetches = append(fetches, keys)
+				users, errs := fetchUsers(keys)
+				for i, key := range keys {
+					if key == "U2" && len(fetches) < 3 {
+						users[i], errs[i] = nil, flaky
+					}
+				}
+				return users, errs
+			},
+			MaxRetries: 3,
+			Backoff:    noBackoff,
+		})
+
+		users, errs := dl.LoadAll([]string{"U1", "U2", "E1"})
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		require.Equal(t, "user U2", users[1].Name)
+		require.Error(t, errs[2])
+		require.Equal(t, [][]string{{"U1", "U2", "E1"}, {"U2", "E1"}, {"U2", "E1"}, {"E1"}}, fetches)
+		require.Equal(t, 3, dl.Stats().Retries)
+	})
+
+	t.Run("gives up after MaxRetries", func(t *testing.T) {
+		var fetches, attempts []int
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				fetches = append(fetches, len(keys))
+				return nil, []error{flaky}
+			},
+			MaxRetries: 2,
+			Backoff: func(attempt int) time.Duration {
+				attempts = append(attempts, attempt)
+				return 0
+			},
+		})
+
+		_, err := dl.Load("U1")
+		require.Equal(t, flaky, err)
+		require.Equal(t, []int{1, 1, 1}, fetches)
+		require.Equal(t, []int{1, 2}, attempts)
+	})
+
+	t.Run("Retryable picks the errors", func(t *testing.T) {
+		var fetches int
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait: time.Millisecond,
+			Fetch: func(keys []string) ([]*example.User, []error) {
+				fetches++
+				return nil, []error{example.ErrUserLoaderNotFound}
+			},
+			MaxRetries: 2,
+			Backoff:    noBackoff,
+			Retryable:  func(err error) bool { return err == flaky },
+		})
+
+		_, err := dl.Load("U1")
+		require.Error(t, err)
+		require.Equal(t, 1, fetches)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := example.NewUserLoaderValidated(example.UserLoaderConfig{Fetch: fetchUsers, MaxRetries: -1})
+		require.EqualError(t, err, "UserLoader: MaxRetries must not be negative, got -1")
+	})
+}
+
+func TestUserLoaderMaxPendingKeys(t *testing.T) {
+	proceed := make(chan struct{})
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:     time.Hour,
+		MaxBatch: 2,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			<-proceed
+			return fetchUsers(keys)
+		},
+		MaxPendingKeys: 3,
+	})
+
+	thunks := []func() (*example.User, error){dl.LoadThunk("U1"), dl.LoadThunk("U2"), dl.LoadThunk("U3")}
+	_, err := dl.Load("U4")
+	var overload *example.UserLoaderOverloadError
+	require.True(t, errors.As(err, &overload))
+	require.Equal(t, 3, overload.Pending)
+	require.True(t, errors.Is(err, example.ErrUserLoaderOverloaded))
+
+	// U3 is still collecting keys, so joining it doesn't add another pending key
+	thunks = append(thunks, dl.LoadThunk("U3"))
+	dl.Flush()
+	close(proceed)
+	for _, thunk := range thunks {
+		_, err := thunk()
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, dl.Stats().Overloaded)
+
+	thunk := dl.LoadThunk("U4")
+	dl.Flush()
+	u, err := thunk()
+	require.NoError(t, err)
+	require.Equal(t, "user U4", u.Name)
+}
+
+func TestUserLoaderBreaker(t *testing.T) {
+	var fetches int32
+	breaker := example.NewUserLoaderCircuitBreaker(example.UserLoaderCircuitBreakerConfig{
+		FailureRatio: 0.6,
+		MinFetches:   2,
+		Cooldown:     20 * time.Millisecond,
+	})
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			if strings.HasPrefix(keys[0], "E") {
+				return nil, []error{errors.New("backend down")}
+			}
+			return fetchUsers(keys)
+		},
+		Breaker: breaker,
+	})
+
+	dl.Load("U1")
+	dl.Load("E1")
+	require.False(t, breaker.Open(), "only half of the fetches failed")
+	dl.Load("E2")
+	require.True(t, breaker.Open())
+
+	_, err := dl.Load("U2")
+	require.True(t, errors.Is(err, example.ErrUserLoaderCircuitOpen))
+	require.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = dl.Load("E3")
+	require.Error(t, err)
+	require.True(t, breaker.Open(), "a failed probe keeps it open")
+
+	time.Sleep(30 * time.Millisecond)
+	u, err := dl.Load("U2")
+	require.NoError(t, err)
+	require.Equal(t, "user U2", u.Name)
+	require.False(t, breaker.Open())
+	require.Equal(t, int32(5), atomic.LoadInt32(&fetches))
+}
+
+func TestUserLoaderBreakerNotFound(t *testing.T) {
+	breaker := example.NewUserLoaderCircuitBreaker(example.UserLoaderCircuitBreakerConfig{MinFetches: 2})
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			errs := make([]error, len(keys))
+			for i := range keys {
+				errs[i] = fmt.Errorf("%w: %s", example.ErrUserLoaderNotFound, keys[i])
+			}
+			return make([]*example.User, len(keys)), errs
+		},
+		MissingPolicy: example.UserLoaderMissingError,
+		Breaker:       breaker,
+	})
+
+	for i := 0; i < 5; i++ {
+		_, err := dl.Load(fmt.Sprintf("M%d", i))
+		require.True(t, errors.Is(err, example.ErrUserLoaderNotFound))
+	}
+	_, errs := dl.LoadAll([]string{"M10", "M11"})
+	require.True(t, errors.Is(errs[1], example.ErrUserLoaderNotFound))
+	require.False(t, breaker.Open(), "keys that weren't found aren't failures")
+}
+
+type tenantKey struct{}
+
+func TestUserLoaderPropagateContext(t *testing.T) {
+	var mu sync.Mutex
+	var tenants []interface{}
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: 5 * time.Millisecond,
+		FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			tenants = append(tenants, ctx.Value(tenantKey{}))
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+		PropagateContext: example.UserLoaderContextKeys(tenantKey{}),
+	})
+
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+	other := context.WithValue(context.Background(), tenantKey{}, "other")
+	thunk1 := dl.LoadThunk("U1")
+	thunk2 := dl.LoadThunkContext(acme, "U2")
+	thunk3 := dl.LoadThunkContext(other, "U3")
+	for _, thunk := range []func() (*example.User, error){thunk1, thunk2, thunk3} {
+		_, err := thunk()
+		require.NoError(t, err)
+	}
+
+	_, err := dl.Load("U4")
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []interface{}{"acme", nil}, tenants, "the first caller with a ctx wins")
+}
+
+func TestUserLoaderBatchID(t *testing.T) {
+	var ids []string
+	var mu sync.Mutex
+	var samples []example.UserLoaderLoadSample
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		FetchContext: func(ctx context.Context, keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			ids = append(ids, example.UserLoaderBatchID(ctx))
+			attempt := len(ids)
+			mu.Unlock()
+			if attempt == 1 {
+				return nil, []error{errors.New("connection reset")}
+			}
+			return fetchUsers(keys)
+		},
+		MaxRetries:    1,
+		Backoff:       func(attempt int) time.Duration { return 0 },
+		LogSampleRate: 1,
+		LogSample: func(sample example.UserLoaderLoadSample) {
+			mu.Lock()
+			samples = append(samples, sample)
+			mu.Unlock()
+		},
+	})
+
+	_, err := dl.Load("U1")
+	require.NoError(t, err)
+	dl.Load("U2")
+	require.Equal(t, "", example.UserLoaderBatchID(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, ids, 3)
+	require.Len(t, ids[0], 32)
+	require.Equal(t, ids[0], ids[1], "retries keep the ID of their batch")
+	require.NotEqual(t, ids[0], ids[2])
+	require.Equal(t, ids[0], samples[0].BatchID)
+	require.Equal(t, ids[2], samples[1].BatchID)
+}
+
+func TestUserLoaderRetryHelpers(t *testing.T) {
+	t.Run("exponential backoff", func(t *testing.T) {
+		backoff := example.UserLoaderExponentialBackoff(10*time.Millisecond, 50*time.Millisecond, 0)
+		var waits []time.Duration
+		for attempt := 1; attempt <= 5; attempt++ {
+			waits = append(waits, backoff(attempt))
+		}
+		require.Equal(t, []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 50 * time.Millisecond}, waits)
+		require.Equal(t, time.Second, example.UserLoaderDefaultBackoff(100))
+
+		jittered := example.UserLoaderExponentialBackoff(time.Second, time.Second, 0.5)
+		for i := 0; i < 10; i++ {
+			wait := jittered(1)
+			require.True(t, wait > 500*time.Millisecond && wait <= time.Second, wait)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		flaky := errors.New("connection reset")
+		noBackoff := func(attempt int) time.Duration { return 0 }
+
+		var calls int
+		err := example.UserLoaderRetry(context.Background(), 3, noBackoff, nil, func() error {
+			calls++
+			if calls < 3 {
+				return flaky
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, calls)
+
+		calls = 0
+		err = example.UserLoaderRetry(context.Background(), 3, noBackoff, nil, func() error {
+			calls++
+			return example.ErrUserLoaderNotFound
+		})
+		require.Equal(t, example.ErrUserLoaderNotFound, err)
+		require.Equal(t, 1, calls, "not found errors aren't retried")
+
+		calls = 0
+		err = example.UserLoaderRetry(context.Background(), 2, noBackoff, nil, func() error {
+			calls++
+			return flaky
+		})
+		require.Equal(t, flaky, err)
+		require.Equal(t, 3, calls)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err = example.UserLoaderRetry(ctx, 3, func(attempt int) time.Duration { return time.Hour }, nil, func() error {
+			return flaky
+		})
+		require.Equal(t, context.DeadlineExceeded, err)
+	})
+}
+
+func TestUserLoaderTargetBatch(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait:        50 * time.Millisecond,
+		MinWait:     time.Millisecond,
+		TargetBatch: 10,
+		Fetch:       fetchUsers,
+		BatchKey:    func(key string) string { return key[:1] },
+	})
+	require.Equal(t, 50*time.Millisecond, dl.CurrentWait(), "without traffic batches wait the full Wait")
+
+	// a burst of keys arriving all at once makes the batches started after it wait as little as they may
+	for i := 0; i < 100; i++ {
+		dl.LoadThunk(fmt.Sprintf("U%d", i))
+	}
+	require.Equal(t, time.Millisecond, dl.CurrentWait())
+	start := time.Now()
+	dl.Load("V1")
+	require.Less(t, int64(time.Since(start)), int64(40*time.Millisecond))
+
+	// while keys trickle in the wait grows back
+	for i := 0; i < 20; i++ {
+		time.Sleep(5 * time.Millisecond)
+		dl.LoadThunk(fmt.Sprintf("T%d", i))
+	}
+	require.Equal(t, 50*time.Millisecond, dl.CurrentWait())
+
+	_, err := example.NewUserLoaderValidated(example.UserLoaderConfig{Fetch: fetchUsers, Wait: time.Millisecond, MinWait: time.Second, TargetBatch: 10})
+	require.EqualError(t, err, "UserLoader: MinWait must be between 0 and Wait (1ms), got 1s")
+}
+
+func TestUserLoaderChunk(t *testing.T) {
+	var chunks [][]string
+	var mu sync.Mutex
+	fetch := func(keys []string) ([]*example.User, []error) {
+		mu.Lock()
+		chunks = append(chunks, keys)
+		mu.Unlock()
+		switch keys[0] {
+		case "U5":
+			return nil, []error{errors.New("chunk failed")}
+		case "U7":
+			panic("boom")
+		}
+		return fetchUsers(keys)
+	}
+
+	keys := []string{"U1", "E1", "U3", "U4", "U5", "U6", "U7"}
+	users, errs := example.UserLoaderChunk(keys, 2, fetch)
+	require.Len(t, users, len(keys))
+	require.Len(t, errs, len(keys))
+	require.Equal(t, "user U1", users[0].Name)
+	require.Error(t, errs[1])
+	require.Equal(t, "user U4", users[3].Name)
+	require.NoError(t, errs[3])
+	require.EqualError(t, errs[4], "chunk failed")
+	require.EqualError(t, errs[5], "chunk failed")
+	var panicErr *example.UserLoaderPanicError
+	require.True(t, errors.As(errs[6], &panicErr))
+
+	mu.Lock()
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i][0] < chunks[j][0] })
+	require.Equal(t, [][]string{{"U1", "E1"}, {"U3", "U4"}, {"U5", "U6"}, {"U7"}}, chunks)
+	mu.Unlock()
+
+	users, errs = example.UserLoaderChunk([]string{"U1", "U2", "U3"}, 2, fetchUsers)
+	require.Nil(t, errs)
+	require.Equal(t, "user U3", users[2].Name)
+
+	t.Run("a single chunk is checked the same way", func(t *testing.T) {
+		_, errs := example.UserLoaderChunk([]string{"U7"}, 2, fetch)
+		require.True(t, errors.As(errs[0], &panicErr))
+
+		users, errs := example.UserLoaderChunk([]string{"U1", "U2"}, 0, func(keys []string) ([]*example.User, []error) {
+			return fetchUsers(append(keys, "U3"))
+		})
+		var lengthErr *example.UserLoaderResultLengthError
+		require.True(t, errors.As(errs[1], &lengthErr))
+		require.Len(t, users, 2)
+
+		users, errs = example.UserLoaderChunk([]string{"U1"}, 5, fetchUsers)
+		require.Nil(t, errs)
+		require.Equal(t, "user U1", users[0].Name)
+
+		users, errs = example.UserLoaderChunk(nil, 5, fetchUsers)
+		require.Nil(t, errs)
+		require.Empty(t, users)
+	})
+}
+
+func TestUserLoaderDetectMutations(t *testing.T) {
+	t.Run("loads panic", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers, DetectMutations: true})
+
+		u, _ := dl.Load("U1")
+		dl.Load("U1")
+		u.Name = "changed in place"
+		require.PanicsWithError(t, "UserLoader: cached value was changed in place: loading U1", func() {
+			dl.Load("U1")
+		})
+		require.NotPanics(t, func() { dl.Load("U1") }, "a change is only reported once")
+	})
+
+	t.Run("OnMutation", func(t *testing.T) {
+		var mutated []string
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:            time.Millisecond,
+			Fetch:           fetchUsers,
+			DetectMutations: true,
+			OnMutation: func(key string, value *example.User) {
+				mutated = append(mutated, key+" "+value.Name)
+			},
+		})
+
+		u, _ := dl.Load("U1")
+		u.Name = "changed in place"
+		dl.Load("U1")
+		require.Equal(t, []string{"U1 changed in place"}, mutated)
+	})
+
+	t.Run("CheckMutations", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers, DetectMutations: true})
+		users, _ := dl.LoadAll([]string{"U1", "U2"})
+		require.Empty(t, dl.CheckMutations())
+
+		users[1].Name = "changed in place"
+		require.Equal(t, []string{"U2"}, dl.CheckMutations())
+		require.Empty(t, dl.CheckMutations())
+
+		primed := &example.User{ID: "U3"}
+		dl.Prime("U3", primed)
+		primed.Name = "changed after priming"
+		require.Empty(t, dl.CheckMutations(), "priming caches a copy")
+	})
+}
+
+func TestUserLoaderTx(t *testing.T) {
+	dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+	dl.Prime("U2", &example.User{ID: "U2", Name: "committed"})
+
+	// reads through the transaction see its uncommitted writes
+	txFetch := func(keys []string) ([]*example.User, []error) {
+		users, errs := fetchUsers(keys)
+		for _, u := range users {
+			if u != nil {
+				u.Name = "uncommitted " + u.ID
+			}
+		}
+		return users, errs
+	}
+
+	t.Run("rollback discards what the tx cached", func(t *testing.T) {
+		tx := dl.Tx(txFetch)
+		u, err := tx.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "uncommitted U1", u.Name)
+		tx.Prime("U2", &example.User{ID: "U2", Name: "renamed"})
+
+		_, _, ok := dl.Entry("U1")
+		require.False(t, ok)
+		tx.Rollback()
+
+		u, err = dl.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, "user U1", u.Name)
+		u, err = dl.Load("U2")
+		require.NoError(t, err)
+		require.Equal(t, "committed", u.Name)
+		dl.Clear("U1")
+	})
+
+	t.Run("commit copies what the tx cached", func(t *testing.T) {
+		tx := dl.Tx(txFetch)
+		users, errs := tx.LoadAll([]string{"U1", "U2"})
+		require.Equal(t, []error{nil, nil}, errs)
+		require.Equal(t, "uncommitted U2", users[1].Name)
+		tx.Prime("U3", &example.User{ID: "U3", Name: "created"})
+		tx.Prime("U2", &example.User{ID: "U2", Name: "renamed"})
+		tx.Commit()
+
+		users, errs = dl.LoadAll([]string{"U1", "U2", "U3"})
+		require.Equal(t, []error{nil, nil, nil}, errs)
+		require.Equal(t, "uncommitted U1", users[0].Name)
+		require.Equal(t, "renamed", users[1].Name)
+		require.Equal(t, "created", users[2].Name)
+	})
+
+	t.Run("commit copies keys the tx didn't find", func(t *testing.T) {
+		tx := dl.Tx(func(keys []string) ([]*example.User, []error) {
+			return make([]*example.User, len(keys)), nil
+		})
+		u, err := tx.Load("N1")
+		require.NoError(t, err)
+		require.Nil(t, u)
+		tx.Commit()
+
+		u, _, ok := dl.Entry("N1")
+		require.True(t, ok)
+		require.Nil(t, u)
+	})
+}
+
+func TestUserLoaderCollapseLoadAll(t *testing.T) {
+	var fetches int32
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			atomic.AddInt32(&fetches, 1)
+			time.Sleep(20 * time.Millisecond)
+			return fetchUsers(keys)
+		},
+		LoadAllNoCache:  true,
+		CollapseLoadAll: true,
+	})
+
+	var wg sync.WaitGroup
+	results := make([][]*example.User, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the first LoadAll is already being fetched when the others start
+			time.Sleep(time.Duration(i) * 5 * time.Millisecond)
+			keys := []string{"U1", "U2"}
+			if i == 2 {
+				keys = []string{"U2", "U1"}
+			}
+			results[i], _ = dl.LoadAll(keys)
+		}(i)
+	}
+	wg.Wait()
+
+	require.Equal(t, "user U2", results[1][1].Name)
+	require.Equal(t, "user U2", results[2][0].Name)
+	require.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	require.Equal(t, 1, dl.Stats().Collapsed)
+
+	t.Run("waiters fail when the first LoadAll panics", func(t *testing.T) {
+		dl := example.NewUserLoader(example.UserLoaderConfig{
+			Wait:            20 * time.Millisecond,
+			Fetch:           fetchUsers,
+			CollapseLoadAll: true,
+			LogSampleRate:   1,
+			LogSample: func(sample example.UserLoaderLoadSample) {
+				panic("logger broke")
+			},
+		})
+
+		panicked := make(chan interface{})
+		go func() {
+			defer func() { panicked <- recover() }()
+			dl.LoadAll([]string{"U1"})
+		}()
+		time.Sleep(5 * time.Millisecond)
+
+		done := make(chan []error)
+		go func() {
+			_, errs := dl.LoadAll([]string{"U1"})
+			done <- errs
+		}()
+
+		require.Equal(t, "logger broke", <-panicked)
+		select {
+		case errs := <-done:
+			var panicErr *example.UserLoaderPanicError
+			require.True(t, errors.As(errs[0], &panicErr))
+		case <-time.After(time.Second):
+			t.Fatal("the waiting LoadAll hangs")
+		}
+	})
+}
@@ -0,0 +1,68 @@
+//go:build go1.18
+
+package example_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tribunadigital/dataloaden/example"
+)
+
+func TestUserLoaderView(t *testing.T) {
+	var fetches [][]string
+	var mu sync.Mutex
+	dl := example.NewUserLoader(example.UserLoaderConfig{
+		Wait: time.Millisecond,
+		Fetch: func(keys []string) ([]*example.User, []error) {
+			mu.Lock()
+			fetches = append(fetches, keys)
+			mu.Unlock()
+			return fetchUsers(keys)
+		},
+	})
+	names := example.NewUserLoaderView(dl, func(user *example.User) string {
+		return user.Name
+	})
+
+	userThunk := dl.LoadThunk("U1")
+	nameThunk := names.LoadThunk("U1")
+
+	u, err := userThunk()
+	require.NoError(t, err)
+	require.Equal(t, "U1", u.ID)
+
+	name, err := nameThunk()
+	require.NoError(t, err)
+	require.Equal(t, "user U1", name)
+
+	all, errs := names.LoadAll([]string{"U1", "E1"})
+	require.Equal(t, "user U1", all[0])
+	require.Equal(t, "", all[1])
+	require.Error(t, errs[1])
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, [][]string{{"U1"}, {"E1"}}, fetches)
+}
+
+func TestUserLoaderMemo(t *testing.T) {
+	var derives int
+	dl := example.NewUserLoader(example.UserLoaderConfig{Wait: time.Millisecond, Fetch: fetchUsers})
+	lengths := example.NewUserLoaderMemo(dl, func(user *example.User) (int, error) {
+		derives++
+		return len(user.Name), nil
+	})
+
+	for i := 0; i < 2; i++ {
+		n, err := lengths.Load("U1")
+		require.NoError(t, err)
+		require.Equal(t, 7, n)
+	}
+	require.Equal(t, 1, derives)
+
+	_, err := lengths.Load("E1")
+	require.Error(t, err)
+}
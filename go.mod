@@ -3,10 +3,18 @@ module github.com/tribunadigital/dataloaden
 go 1.14
 
 require (
-	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/99designs/gqlgen v0.13.0
+	github.com/alicebob/miniredis/v2 v2.17.0
+	github.com/go-redis/redis/v8 v8.11.4
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/pkg/errors v0.9.1
-	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/stretchr/testify v1.2.1
-	golang.org/x/tools v0.0.0-20200304193943-95d2e580d8eb
+	github.com/prometheus/client_golang v1.12.2
+	github.com/stretchr/testify v1.7.0
+	github.com/vektah/gqlparser/v2 v2.1.0
+	go.etcd.io/bbolt v1.3.6
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/oteltest v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
+	golang.org/x/tools v0.0.0-20201224043029-2b0845dc783e
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,288 @@
+//go:build go1.18
+
+// Package dataloader is the runtime behind dataloaden -generic. Instead of generating a loader per key/value pair it
+// provides one generic Loader, the generated code is only a few aliases over it.
+package dataloader
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Cache can be used to cache results. A map based implementation is used by default.
+type Cache[K comparable, V any] interface {
+	Get(key K) (V, bool)
+	Set(key K, value V)
+	ClearKey(key K)
+}
+
+// MapCache is a Cache backed by a go map
+type MapCache[K comparable, V any] struct {
+	data map[K]V
+	mu   sync.Mutex
+}
+
+// NewMapCache creates an empty MapCache
+func NewMapCache[K comparable, V any]() *MapCache[K, V] {
+	return &MapCache[K, V]{data: map[K]V{}}
+}
+
+func (c *MapCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	r, ok := c.data[key]
+	c.mu.Unlock()
+	return r, ok
+}
+
+func (c *MapCache[K, V]) Set(key K, value V) {
+	c.mu.Lock()
+	c.data[key] = value
+	c.mu.Unlock()
+}
+
+func (c *MapCache[K, V]) ClearKey(key K) {
+	c.mu.Lock()
+	delete(c.data, key)
+	c.mu.Unlock()
+}
+
+// Config captures the config to create a new Loader
+type Config[K comparable, V any] struct {
+	// Fetch is a method that provides the data for the loader
+	Fetch func(keys []K) ([]V, []error)
+
+	// Wait is how long wait before sending a batch
+	Wait time.Duration
+
+	// MaxBatch will limit the maximum number of keys to send in one batch, 0 = not limit
+	MaxBatch int
+
+	// Cache is the datastructure used to cache fetched data
+	Cache Cache[K, V]
+}
+
+// New creates a new Loader given a fetch, wait, and maxBatch
+func New[K comparable, V any](config Config[K, V]) *Loader[K, V] {
+	l := &Loader[K, V]{
+		fetch:    config.Fetch,
+		wait:     config.Wait,
+		maxBatch: config.MaxBatch,
+		cache:    config.Cache,
+	}
+	if l.cache == nil {
+		l.cache = NewMapCache[K, V]()
+	}
+	return l
+}
+
+// Loader batches and caches requests
+type Loader[K comparable, V any] struct {
+	// this method provides the data for the loader
+	fetch func(keys []K) ([]V, []error)
+
+	// how long to done before sending a batch
+	wait time.Duration
+
+	// this will limit the maximum number of keys to send in one batch, 0 = no limit
+	maxBatch int
+
+	// INTERNAL
+
+	cache Cache[K, V]
+
+	// the current batch. keys will continue to be collected until timeout is hit,
+	// then everything will be sent to the fetch method and out to the listeners
+	batch *batch[K, V]
+
+	// mutex to prevent races
+	mu sync.Mutex
+}
+
+type batch[K comparable, V any] struct {
+	keys    []K
+	data    []V
+	error   []error
+	closing bool
+	done    chan struct{}
+}
+
+// Load a value by key, batching and caching will be applied automatically
+func (l *Loader[K, V]) Load(key K) (V, error) {
+	return l.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a value.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *Loader[K, V]) LoadThunk(key K) func() (V, error) {
+	if it, ok := l.cache.Get(key); ok {
+		return func() (V, error) {
+			return it, nil
+		}
+	}
+	l.mu.Lock()
+	if l.batch == nil {
+		l.batch = &batch[K, V]{done: make(chan struct{})}
+	}
+	b := l.batch
+	pos := b.keyIndex(l, key)
+	l.mu.Unlock()
+
+	return func() (V, error) {
+		<-b.done
+
+		var data V
+		if pos < len(b.data) {
+			data = b.data[pos]
+		}
+
+		var err error
+		// its convenient to be able to return a single error for everything
+		if len(b.error) == 1 {
+			err = b.error[0]
+		} else if b.error != nil {
+			err = b.error[pos]
+		}
+
+		if err == nil {
+			l.cache.Set(key, data)
+		}
+
+		return data, err
+	}
+}
+
+// LoadAll fetches many keys at once. It will be broken into appropriate sized
+// sub batches depending on how the loader is configured
+func (l *Loader[K, V]) LoadAll(keys []K) ([]V, []error) {
+	return l.LoadAllThunk(keys)()
+}
+
+// LoadAllThunk returns a function that when called will block waiting for the values.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *Loader[K, V]) LoadAllThunk(keys []K) func() ([]V, []error) {
+	// each distinct key is loaded once, so a duplicate can't end up in the next batch when the first fills up
+	thunks := map[K]func() (V, error){}
+	results := make([]func() (V, error), len(keys))
+	for i, key := range keys {
+		if thunks[key] == nil {
+			thunks[key] = l.LoadThunk(key)
+		}
+		results[i] = thunks[key]
+	}
+	return func() ([]V, []error) {
+		values := make([]V, len(keys))
+		errors := make([]error, len(keys))
+		for i, thunk := range results {
+			values[i], errors[i] = thunk()
+		}
+		return values, errors
+	}
+}
+
+// Prime the cache with the provided key and value. If the key already exists, no change is made
+// and false is returned.
+// (To forcefully prime the cache, use ForcePrime.)
+//
+// Unlike the generated loaders the value is stored as is, pointers and slices are not copied.
+func (l *Loader[K, V]) Prime(key K, value V) bool {
+	var found bool
+	if _, found = l.cache.Get(key); !found {
+		l.cache.Set(key, value)
+	}
+	return !found
+}
+
+// ForcePrime replaces the cached value at key with value, eg. to write through after a mutation
+func (l *Loader[K, V]) ForcePrime(key K, value V) {
+	l.cache.Set(key, value)
+}
+
+// Clear the value at key from the cache, if it exists
+func (l *Loader[K, V]) Clear(key K) {
+	l.cache.ClearKey(key)
+}
+
+// keyIndex will return the location of the key in the batch, if its not found
+// it will add the key to the batch
+func (b *batch[K, V]) keyIndex(l *Loader[K, V], key K) int {
+	for i, existingKey := range b.keys {
+		if key == existingKey {
+			return i
+		}
+	}
+
+	pos := len(b.keys)
+	b.keys = append(b.keys, key)
+	if pos == 0 {
+		go b.startTimer(l)
+	}
+
+	if l.maxBatch != 0 && pos >= l.maxBatch-1 {
+		if !b.closing {
+			b.closing = true
+			l.batch = nil
+			go b.end(l)
+		}
+	}
+
+	return pos
+}
+
+func (b *batch[K, V]) startTimer(l *Loader[K, V]) {
+	time.Sleep(l.wait)
+	l.mu.Lock()
+
+	// we must have hit a batch limit and are already finalizing this batch
+	if b.closing {
+		l.mu.Unlock()
+		return
+	}
+
+	l.batch = nil
+	l.mu.Unlock()
+
+	b.end(l)
+}
+
+func (b *batch[K, V]) end(l *Loader[K, V]) {
+	defer close(b.done)
+	defer func() {
+		if r := recover(); r != nil {
+			b.data, b.error = nil, []error{&PanicError{Value: r, Stack: debug.Stack()}}
+		}
+	}()
+	b.data, b.error = l.fetch(b.keys)
+	if len(b.data) > len(b.keys) || (len(b.error) > 1 && len(b.error) != len(b.keys)) {
+		err := &ResultLengthError{Keys: len(b.keys), Values: len(b.data), Errors: len(b.error)}
+		b.data, b.error = nil, []error{err}
+	}
+}
+
+// ResultLengthError is returned for every key of a batch when Fetch returns more values than keys, or a number of
+// errors other than none, one or one per key
+type ResultLengthError struct {
+	Keys   int
+	Values int
+	Errors int
+}
+
+func (e *ResultLengthError) Error() string {
+	return fmt.Sprintf("dataloader: fetch returned %d values and %d errors for %d keys", e.Values, e.Errors, e.Keys)
+}
+
+// PanicError is returned for every key of a batch whose Fetch panicked
+type PanicError struct {
+	// Value is what Fetch panicked with
+	Value interface{}
+
+	// Stack is the stack trace of the panic
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("dataloader: fetch panicked: %v", e.Value)
+}
@@ -3,10 +3,17 @@ package generator
 import (
 	"bytes"
 	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"text/template"
 	"unicode"
 
 	"github.com/pkg/errors"
@@ -17,8 +24,16 @@ import (
 type templateData struct {
 	Package string
 	Name    string
+
+	// ImportPath is the import path of the package the loader is generated into
+	ImportPath string
+
+	Fetcher *fetcher
 	KeyType *goType
 	ValType *goType
+
+	// Internals, when set, renames the unexported fields and methods of the generated code
+	Internals *internals
 }
 
 type goType struct {
@@ -26,6 +41,11 @@ type goType struct {
 	ImportPath string
 	ImportName string
 	Name       string
+
+	// TextMarshaler and Stringer are set when the type implements encoding.TextMarshaler or fmt.Stringer, keys
+	// are turned into strings with those instead of with fmt
+	TextMarshaler bool
+	Stringer      bool
 }
 
 func (t *goType) String() string {
@@ -44,6 +64,33 @@ func (t *goType) IsSlice() bool {
 	return strings.HasPrefix(t.Modifiers, "[]")
 }
 
+// Elem is the element type of a slice type, eg. []*User gives *User
+func (t *goType) Elem() string {
+	return strings.TrimPrefix(t.String(), "[]")
+}
+
+// IsNumber reports whether the type is one of go's built in numeric types, loaders of these are treated as aggregates
+func (t *goType) IsNumber() bool {
+	if t.Modifiers != "" || t.ImportPath != "" {
+		return false
+	}
+
+	return t.IsInteger() || t.Name == "float32" || t.Name == "float64"
+}
+
+// IsInteger reports whether the type is one of go's built in integer types, keys of these get cheaper hashing
+func (t *goType) IsInteger() bool {
+	if t.Modifiers != "" || t.ImportPath != "" {
+		return false
+	}
+
+	switch t.Name {
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return true
+	}
+	return false
+}
+
 var partsRe = regexp.MustCompile(`^([\[\]\*]*)(.*?)(\.\w*)?$`)
 
 func parseType(str string) (*goType, error) {
@@ -78,16 +125,243 @@ func parseType(str string) (*goType, error) {
 	return t, nil
 }
 
+// detectMethods sets TextMarshaler and Stringer by type checking the package of the type. Types that can't be
+// checked are left as they are, keys of them fall back to fmt.
+func (t *goType) detectMethods() {
+	if t.ImportPath == "" || (t.Modifiers != "" && t.Modifiers != "*") {
+		return
+	}
+
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import(t.ImportPath)
+	if err != nil {
+		return
+	}
+	obj := pkg.Scope().Lookup(t.Name)
+	if obj == nil {
+		return
+	}
+
+	typ := obj.Type()
+	if t.Modifiers == "*" {
+		typ = types.NewPointer(typ)
+	}
+	t.TextMarshaler = types.Implements(typ, textMarshaler)
+	t.Stringer = types.Implements(typ, stringer)
+}
+
+var (
+	textMarshaler = newInterface("MarshalText", types.NewSlice(types.Typ[types.Byte]), types.Universe.Lookup("error").Type())
+	stringer      = newInterface("String", types.Typ[types.String])
+)
+
+// newInterface makes an interface with a single method that takes no arguments and returns results
+func newInterface(method string, results ...types.Type) *types.Interface {
+	vars := make([]*types.Var, len(results))
+	for i, result := range results {
+		vars[i] = types.NewVar(0, nil, "", result)
+	}
+	sig := types.NewSignature(nil, nil, types.NewTuple(vars...), false)
+
+	iface := types.NewInterfaceType([]*types.Func{types.NewFunc(0, nil, method, sig)}, nil)
+	iface.Complete()
+	return iface
+}
+
+// Options toggles the optional parts of the generated code
+type Options struct {
+	// Spill also generates a cache that spills cold entries to a bbolt file, into <name>_spill_gen.go
+	Spill bool `yaml:"spill"`
+
+	// Redis also generates a cache kept in redis, into <name>_redis_gen.go
+	Redis bool `yaml:"redis"`
+
+	// Prometheus also generates a <Name>Metrics that exports to prometheus, into <name>_prometheus_gen.go
+	Prometheus bool `yaml:"prometheus"`
+
+	// OTel also generates a <Name>Tracer that traces batches with OpenTelemetry, into <name>_otel_gen.go
+	OTel bool `yaml:"otel"`
+
+	// View also generates a generic view that projects the loaded values, into <name>_view_gen.go. It needs go1.18.
+	View bool `yaml:"view"`
+
+	// Iter also generates iterator based loads, into <name>_iter_gen.go. It needs go1.23.
+	Iter bool `yaml:"iter"`
+
+	// Split writes the exported API of the loader into <name>_gen.go and the internals into <name>_impl_gen.go,
+	// so diffs of the API stay small when the internals change
+	Split bool `yaml:"split"`
+
+	// InternalPrefix is prepended to the unexported fields and methods of the generated types, eg. fetch becomes
+	// dlFetch for dl, so code in the same package can add its own fields and methods to a loader or embed it
+	// without colliding. Loaders with a prefix can't be created with a struct literal.
+	InternalPrefix string `yaml:"internal_prefix"`
+
+	// Schema also writes a JSON Schema of the key and value of the loader, into <name>_schema_gen.json
+	Schema bool `yaml:"schema"`
+
+	// Generic generates a few aliases over the generic runtime in pkg/dataloader instead of a whole loader. It
+	// needs go1.18 and can't be combined with the other options.
+	Generic bool `yaml:"generic"`
+
+	// Fetcher names an interface method in the package that fetches the values, eg. UserRepo.GetByIDs. The loader
+	// then gets a constructor that accepts the interface.
+	Fetcher string `yaml:"fetcher"`
+}
+
+// fetcher is an interface method that fetches the values of a loader
+type fetcher struct {
+	Type   string
+	Method string
+}
+
+func parseFetcher(str string) (*fetcher, error) {
+	parts := strings.Split(str, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("fetcher %s: expected Interface.Method", str)
+	}
+	return &fetcher{Type: parts[0], Method: parts[1]}, nil
+}
+
 func Generate(name string, keyType string, valueType string, wd string) error {
+	return GenerateWithOptions(name, keyType, valueType, wd, Options{})
+}
+
+func GenerateWithOptions(name string, keyType string, valueType string, wd string, opts Options) error {
 	data, err := getData(name, keyType, valueType, wd)
 	if err != nil {
 		return err
 	}
 
-	filename := strings.ToLower(data.Name) + "_gen.go"
+	if opts.Fetcher != "" {
+		data.Fetcher, err = parseFetcher(opts.Fetcher)
+		if err != nil {
+			return err
+		}
+	}
 
-	if err := writeTemplate(filepath.Join(wd, filename), data); err != nil {
-		return err
+	filename := strings.ToLower(data.Name)
+
+	if opts.Generic {
+		if opts.Spill || opts.Redis || opts.Prometheus || opts.OTel || opts.View || opts.Iter || opts.Split || opts.InternalPrefix != "" || opts.Fetcher != "" {
+			return fmt.Errorf("generic loaders can't be combined with other options")
+		}
+		if err := writeTemplate(genericTpl, filepath.Join(wd, filename+"_gen.go"), data); err != nil {
+			return err
+		}
+		// the types are checked with the loader in place, so packages referring to it compile
+		if opts.Schema {
+			return writeSchema(filepath.Join(wd, filename+"_schema_gen.json"), data)
+		}
+		return nil
+	}
+
+	if opts.InternalPrefix != "" {
+		data.Internals, err = newInternals(opts.InternalPrefix, tpl, data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Split {
+		if err := writeSplitTemplate(tpl, filepath.Join(wd, filename+"_gen.go"), filepath.Join(wd, filename+"_impl_gen.go"), data); err != nil {
+			return err
+		}
+	} else {
+		if err := writeTemplate(tpl, filepath.Join(wd, filename+"_gen.go"), data); err != nil {
+			return err
+		}
+		// the internals of an earlier split would now be declared twice
+		if err := removeGenerated(filepath.Join(wd, filename+"_impl_gen.go")); err != nil {
+			return err
+		}
+	}
+
+	if opts.Spill {
+		if err := writeTemplate(spillTpl, filepath.Join(wd, filename+"_spill_gen.go"), data); err != nil {
+			return err
+		}
+	}
+
+	if opts.Redis {
+		if err := writeTemplate(redisTpl, filepath.Join(wd, filename+"_redis_gen.go"), data); err != nil {
+			return err
+		}
+	}
+
+	if opts.Prometheus {
+		if err := writeTemplate(prometheusTpl, filepath.Join(wd, filename+"_prometheus_gen.go"), data); err != nil {
+			return err
+		}
+	}
+
+	if opts.OTel {
+		if err := writeTemplate(otelTpl, filepath.Join(wd, filename+"_otel_gen.go"), data); err != nil {
+			return err
+		}
+	}
+
+	if opts.View {
+		if err := writeTemplate(viewTpl, filepath.Join(wd, filename+"_view_gen.go"), data); err != nil {
+			return err
+		}
+	}
+
+	if opts.Iter {
+		if err := writeTemplate(iterTpl, filepath.Join(wd, filename+"_iter_gen.go"), data); err != nil {
+			return err
+		}
+	}
+
+	if opts.Schema {
+		if err := writeSchema(filepath.Join(wd, filename+"_schema_gen.json"), data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type bundleData struct {
+	Package string
+	Loaders []bundleLoader
+}
+
+type bundleLoader struct {
+	Field string
+	Name  string
+}
+
+// GenerateBundle writes a Loaders struct holding one of each of the named loaders into loaders_gen.go, with
+// middleware that creates it for every request. The loaders must be generated into the same package, wd may not
+// have any go files yet.
+func GenerateBundle(wd string, names []string) error {
+	data := bundleData{Package: filepath.Base(wd)}
+	if genPkg := getPackage(wd); genPkg != nil && genPkg.Name != "" {
+		data.Package = genPkg.Name
+	}
+
+	for _, name := range names {
+		// UserLoader is For(ctx).User
+		field := strings.TrimSuffix(name, "Loader")
+		if field == "" {
+			field = name
+		}
+		data.Loaders = append(data.Loaders, bundleLoader{Field: field, Name: name})
+	}
+
+	var buf bytes.Buffer
+	if err := bundleTpl.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "generating code")
+	}
+
+	path := filepath.Join(wd, "loaders_gen.go")
+	src, err := imports.Process(path, buf.Bytes(), nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to gofmt")
+	}
+
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		return errors.Wrap(err, "writing output")
 	}
 
 	return nil
@@ -104,10 +378,12 @@ func getData(name string, keyType string, valueType string, wd string) (template
 	var err error
 	data.Name = name
 	data.Package = genPkg.Name
+	data.ImportPath = genPkg.PkgPath
 	data.KeyType, err = parseType(keyType)
 	if err != nil {
 		return templateData{}, fmt.Errorf("key type: %s", err.Error())
 	}
+	data.KeyType.detectMethods()
 	data.ValType, err = parseType(valueType)
 	if err != nil {
 		return templateData{}, fmt.Errorf("key type: %s", err.Error())
@@ -138,24 +414,168 @@ func getPackage(dir string) *packages.Package {
 	return p[0]
 }
 
-func writeTemplate(filepath string, data templateData) error {
+func writeTemplate(tpl *template.Template, filepath string, data templateData) error {
+	src, err := render(tpl, filepath, data)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath, src, 0644); err != nil {
+		return errors.Wrap(err, "writing output")
+	}
+
+	return nil
+}
+
+// writeSplitTemplate writes the exported declarations to apiPath and the rest to implPath
+func writeSplitTemplate(tpl *template.Template, apiPath string, implPath string, data templateData) error {
+	src, err := render(tpl, apiPath, data)
+	if err != nil {
+		return err
+	}
+
+	api, impl, err := splitExported(src)
+	if err != nil {
+		return errors.Wrap(err, "splitting code")
+	}
+
+	for path, src := range map[string][]byte{apiPath: api, implPath: impl} {
+		src, err := imports.Process(path, src, nil)
+		if err != nil {
+			return errors.Wrap(err, "unable to gofmt")
+		}
+		if err := ioutil.WriteFile(path, src, 0644); err != nil {
+			return errors.Wrap(err, "writing output")
+		}
+	}
+
+	return nil
+}
+
+// render executes tpl and formats the code, adding the imports it needs
+func render(tpl *template.Template, filepath string, data templateData) ([]byte, error) {
 	var buf bytes.Buffer
 	if err := tpl.Execute(&buf, data); err != nil {
-		return errors.Wrap(err, "generating code")
+		return nil, errors.Wrap(err, "generating code")
 	}
 
 	src, err := imports.Process(filepath, buf.Bytes(), nil)
 	if err != nil {
-		return errors.Wrap(err, "unable to gofmt")
+		return nil, errors.Wrap(err, "unable to gofmt")
 	}
 
-	if err := ioutil.WriteFile(filepath, src, 0644); err != nil {
-		return errors.Wrap(err, "writing output")
+	if data.Internals != nil {
+		src, err = data.Internals.rename(src)
+		if err != nil {
+			return nil, errors.Wrap(err, "renaming internals")
+		}
 	}
+	return src, nil
+}
+
+// splitExported splits formatted source into its exported declarations, ie. exported types, constants, variables,
+// functions and methods, and everything else. Both parts keep every import, goimports drops the unused ones.
+func splitExported(src []byte) (api []byte, impl []byte, err error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// everything up to the first declaration after the imports is the header: the generated comment, the package
+	// clause and the imports
+	var header []byte
+	var apiBuf, implBuf bytes.Buffer
+	for _, decl := range f.Decls {
+		if gen, ok := decl.(*ast.GenDecl); ok && gen.Tok == token.IMPORT {
+			header = src[:fset.Position(gen.End()).Offset]
+			continue
+		}
+
+		start := decl.Pos()
+		if doc := declDoc(decl); doc != nil {
+			start = doc.Pos()
+		}
+		text := src[fset.Position(start).Offset:fset.Position(decl.End()).Offset]
 
+		out := &implBuf
+		if isExported(decl) {
+			out = &apiBuf
+		}
+		out.WriteString("\n\n")
+		out.Write(text)
+	}
+	if header == nil {
+		header = src[:fset.Position(f.Name.End()).Offset]
+	}
+
+	api = append(append(append([]byte{}, header...), apiBuf.Bytes()...), '\n')
+	impl = append(append(append([]byte{}, header...), implBuf.Bytes()...), '\n')
+	return api, impl, nil
+}
+
+func declDoc(decl ast.Decl) *ast.CommentGroup {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		return decl.Doc
+	case *ast.GenDecl:
+		return decl.Doc
+	}
 	return nil
 }
 
+// isExported reports whether decl declares anything exported. Methods count when both the method and its
+// receiver type are exported.
+func isExported(decl ast.Decl) bool {
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		if decl.Recv != nil && !ast.IsExported(receiverName(decl.Recv.List[0].Type)) {
+			return false
+		}
+		return decl.Name.IsExported()
+	case *ast.GenDecl:
+		for _, spec := range decl.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				if spec.Name.IsExported() {
+					return true
+				}
+			case *ast.ValueSpec:
+				for _, name := range spec.Names {
+					if name.IsExported() {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
+
+// removeGenerated removes the generated file at path, if there is one
+func removeGenerated(path string) error {
+	src, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(src, []byte("// Code generated by github.com/tribunadigital/dataloaden")) {
+		return fmt.Errorf("%s exists but wasn't generated by dataloaden", path)
+	}
+	return os.Remove(path)
+}
+
 func lcFirst(s string) string {
 	r := []rune(s)
 	r[0] = unicode.ToLower(r[0])
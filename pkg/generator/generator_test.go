@@ -1,6 +1,7 @@
 package generator
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -16,6 +17,184 @@ func TestParseType(t *testing.T) {
 	}, parse("github.com/tribunadigital/dataloaden/pkg/generator/testdata/mismatch.Foo"))
 }
 
+func TestDetectMethods(t *testing.T) {
+	id := detected("github.com/tribunadigital/dataloaden/pkg/generator/testdata/textkey.ID")
+	require.True(t, id.TextMarshaler)
+	require.False(t, id.Stringer)
+
+	require.False(t, detected("github.com/tribunadigital/dataloaden/pkg/generator/testdata/textkey.Name").Stringer)
+	require.True(t, detected("*github.com/tribunadigital/dataloaden/pkg/generator/testdata/textkey.Name").Stringer)
+	require.True(t, detected("time.Time").TextMarshaler)
+	require.False(t, detected("string").TextMarshaler)
+}
+
+func TestIsNumber(t *testing.T) {
+	require.True(t, parse("int").IsNumber())
+	require.True(t, parse("float64").IsNumber())
+	require.False(t, parse("*int").IsNumber())
+	require.False(t, parse("string").IsNumber())
+	require.False(t, parse("time.Duration").IsNumber())
+}
+
+func TestIsInteger(t *testing.T) {
+	require.True(t, parse("int").IsInteger())
+	require.True(t, parse("uint64").IsInteger())
+	require.False(t, parse("float64").IsInteger())
+	require.False(t, parse("*int").IsInteger())
+}
+
+func TestElem(t *testing.T) {
+	require.Equal(t, "*User", parse("[]*User").Elem())
+	require.Equal(t, "[]string", parse("[][]string").Elem())
+}
+
+func TestParseFetcher(t *testing.T) {
+	f, err := parseFetcher("UserRepo.GetByIDs")
+	require.NoError(t, err)
+	require.Equal(t, &fetcher{Type: "UserRepo", Method: "GetByIDs"}, f)
+
+	_, err = parseFetcher("GetByIDs")
+	require.Error(t, err)
+}
+
+func TestReadManifest(t *testing.T) {
+	m, err := ReadManifest("testdata/manifest/valid.yml")
+	require.NoError(t, err)
+	require.Equal(t, []ManifestLoader{
+		{
+			Name:      "UserLoader",
+			KeyType:   "string",
+			ValueType: "*github.com/tribunadigital/dataloaden/example.User",
+			Package:   "../textkey",
+			Options:   Options{Prometheus: true, Fetcher: "UserRepo.GetByIDs"},
+		},
+		{Name: "PostLoader", KeyType: "int", ValueType: "[]string"},
+	}, m.Loaders)
+
+	_, err = ReadManifest("testdata/manifest/typo.yml")
+	require.EqualError(t, err, "parsing testdata/manifest/typo.yml: yaml: unmarshal errors:\n  line 6: field promethus not found in type generator.Options")
+
+	_, err = ReadManifest("testdata/manifest/missing.yml")
+	require.EqualError(t, err, "testdata/manifest/missing.yml: loader 1: name, key and value are required")
+}
+
+func TestSplitExported(t *testing.T) {
+	api, impl, err := splitExported([]byte(`package p
+
+import "fmt"
+
+// Loader loads
+type Loader struct{ batch *batch }
+
+// Load loads
+func (l *Loader) Load() { l.load() }
+
+func (l *Loader) load() { fmt.Println() }
+
+type batch struct{}
+
+func (b *batch) End() {}
+`))
+	require.NoError(t, err)
+	require.Equal(t, `package p
+
+import "fmt"
+
+// Loader loads
+type Loader struct{ batch *batch }
+
+// Load loads
+func (l *Loader) Load() { l.load() }
+`, string(api))
+	require.Equal(t, `package p
+
+import "fmt"
+
+func (l *Loader) load() { fmt.Println() }
+
+type batch struct{}
+
+func (b *batch) End() {}
+`, string(impl))
+}
+
+func TestInternalsRename(t *testing.T) {
+	i := &internals{prefix: "dl", names: map[string]bool{}}
+	src, err := i.rename([]byte(`package p
+
+type Loader struct{ cache map[string]int }
+
+func newLoader() *Loader { return &Loader{cache: map[string]int{}} }
+
+func (l *Loader) get(cache string) int { return l.cache[cache] }
+`))
+	require.NoError(t, err)
+	require.Equal(t, `package p
+
+type Loader struct{ dlCache map[string]int }
+
+func newLoader() *Loader { return &Loader{dlCache: map[string]int{}} }
+
+func (l *Loader) dlGet(cache string) int { return l.dlCache[cache] }
+`, string(src))
+
+	_, err = newInternals("DL", tpl, templateData{})
+	require.Error(t, err)
+}
+
+func TestLoaderSchema(t *testing.T) {
+	schema, err := loaderSchema(templateData{
+		Name:       "EmployeeLoader",
+		ImportPath: "github.com/tribunadigital/dataloaden/pkg/generator/testdata/schema",
+		KeyType:    parse("int"),
+		ValType:    &goType{Modifiers: "[]*", Name: "Employee"},
+	})
+	require.NoError(t, err)
+
+	src, err := json.Marshal(schema)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$comment": "Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.",
+		"title": "EmployeeLoader",
+		"description": "EmployeeLoader loads []*Employee by int",
+		"type": "object",
+		"properties": {
+			"key": {"type": "integer", "x-go-type": "int"},
+			"value": {
+				"type": "array",
+				"items": {"$ref": "#/$defs/schema.Employee"},
+				"x-go-type": "[]*github.com/tribunadigital/dataloaden/pkg/generator/testdata/schema.Employee"
+			}
+		},
+		"required": ["key", "value"],
+		"x-go-package": "github.com/tribunadigital/dataloaden/pkg/generator/testdata/schema",
+		"$defs": {
+			"schema.Employee": {
+				"type": "object",
+				"properties": {
+					"id": {"type": "integer"},
+					"created": {"type": "string", "format": "date-time"},
+					"name": {"type": "string"},
+					"avatar": {"type": "string", "contentEncoding": "base64"},
+					"salary": {"type": "string"},
+					"tags": {"type": "object", "additionalProperties": {"type": "string"}},
+					"manager": {"$ref": "#/$defs/schema.Employee"},
+					"Position": {"type": "array", "items": {"type": "number"}, "minItems": 2, "maxItems": 2}
+				},
+				"required": ["id", "created", "name", "salary", "tags", "Position"],
+				"x-go-type": "github.com/tribunadigital/dataloaden/pkg/generator/testdata/schema.Employee"
+			}
+		}
+	}`, string(src))
+}
+
+func detected(s string) *goType {
+	t := parse(s)
+	t.detectMethods()
+	return t
+}
+
 func parse(s string) *goType {
 	t, err := parseType(s)
 	if err != nil {
@@ -0,0 +1,99 @@
+package generator
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Manifest lists many loaders to generate at once, it is read from a dataloaden.yml that looks like
+//
+//	loaders:
+//	  - name: UserLoader
+//	    key: string
+//	    value: "*github.com/my/package.User"
+//	    package: ./graph
+//	    options:
+//	      prometheus: true
+//	bundle: true
+type Manifest struct {
+	Loaders []ManifestLoader `yaml:"loaders"`
+
+	// Bundle also generates a Loaders struct into every package, holding one of each loader generated into it
+	Bundle bool `yaml:"bundle"`
+}
+
+// ManifestLoader is one loader of a Manifest
+type ManifestLoader struct {
+	Name      string `yaml:"name"`
+	KeyType   string `yaml:"key"`
+	ValueType string `yaml:"value"`
+
+	// Package is the directory of the package the loader is generated into, relative to the manifest. It defaults
+	// to the directory of the manifest.
+	Package string `yaml:"package"`
+
+	Options Options `yaml:"options"`
+}
+
+// ReadManifest reads and checks the manifest at path, unknown fields are an error so typos don't go unnoticed
+func ReadManifest(path string) (*Manifest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading manifest")
+	}
+
+	var m Manifest
+	if err := yaml.UnmarshalStrict(b, &m); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+
+	seen := map[string]bool{}
+	for i, l := range m.Loaders {
+		if l.Name == "" || l.KeyType == "" || l.ValueType == "" {
+			return nil, fmt.Errorf("%s: loader %d: name, key and value are required", path, i+1)
+		}
+		dir := filepath.Join(filepath.Dir(path), l.Package)
+		if seen[dir+"/"+l.Name] {
+			return nil, fmt.Errorf("%s: %s is listed twice for %s", path, l.Name, dir)
+		}
+		seen[dir+"/"+l.Name] = true
+	}
+	return &m, nil
+}
+
+// GenerateManifest generates every loader listed in the manifest at path
+func GenerateManifest(path string) error {
+	m, err := ReadManifest(path)
+	if err != nil {
+		return err
+	}
+
+	var dirs []string
+	bundles := map[string][]string{}
+	for _, l := range m.Loaders {
+		dir, err := filepath.Abs(filepath.Join(filepath.Dir(path), l.Package))
+		if err != nil {
+			return err
+		}
+		if err := GenerateWithOptions(l.Name, l.KeyType, l.ValueType, dir, l.Options); err != nil {
+			return errors.Wrap(err, l.Name)
+		}
+		if _, ok := bundles[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		bundles[dir] = append(bundles[dir], l.Name)
+	}
+
+	if m.Bundle {
+		for _, dir := range dirs {
+			if err := GenerateBundle(dir, bundles[dir]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
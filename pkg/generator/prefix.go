@@ -0,0 +1,125 @@
+package generator
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"text/template"
+	"unicode"
+)
+
+// internals renames the unexported fields and methods of the generated types, so user code in the same package
+// can add fields and methods like cache or batch to a loader, or embed it, without them colliding
+type internals struct {
+	prefix string
+	names  map[string]bool
+}
+
+var prefixRe = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+
+// newInternals collects the names to rename from the code tpl generates. Every file of the loader is renamed
+// with them, as the optional files reach into the loader too.
+func newInternals(prefix string, tpl *template.Template, data templateData) (*internals, error) {
+	if !prefixRe.MatchString(prefix) {
+		return nil, fmt.Errorf("internal prefix %s: expected a lower case identifier, eg. dl", prefix)
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	i := &internals{prefix: prefix, names: map[string]bool{}}
+	i.collect(f)
+	return i, nil
+}
+
+// collect adds the unexported fields, interface methods and methods declared in f
+func (i *internals) collect(f *ast.File) {
+	add := func(fields *ast.FieldList) {
+		for _, field := range fields.List {
+			for _, name := range field.Names {
+				if !name.IsExported() && name.Name != "_" {
+					i.names[name.Name] = true
+				}
+			}
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.StructType:
+			add(n.Fields)
+		case *ast.InterfaceType:
+			add(n.Methods)
+		case *ast.FuncDecl:
+			if n.Recv != nil && !n.Name.IsExported() {
+				i.names[n.Name.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+// rename prefixes the internal names in src: their declarations, selectors and the keys of struct literals
+func (i *internals) rename(src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	i.collect(f)
+
+	renameIdent := func(ident *ast.Ident) {
+		if i.names[ident.Name] {
+			ident.Name = i.prefix + string(unicode.ToUpper(rune(ident.Name[0]))) + ident.Name[1:]
+		}
+	}
+	renameFields := func(fields *ast.FieldList) {
+		for _, field := range fields.List {
+			for _, name := range field.Names {
+				renameIdent(name)
+			}
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.StructType:
+			renameFields(n.Fields)
+		case *ast.InterfaceType:
+			renameFields(n.Methods)
+		case *ast.FuncDecl:
+			if n.Recv != nil {
+				renameIdent(n.Name)
+			}
+		case *ast.SelectorExpr:
+			// a package can't export an unexported name, so this only ever selects from generated types
+			renameIdent(n.Sel)
+		case *ast.CompositeLit:
+			if _, isMap := n.Type.(*ast.MapType); isMap {
+				break
+			}
+			for _, elt := range n.Elts {
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						renameIdent(key)
+					}
+				}
+			}
+		}
+		return true
+	})
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, fset, f); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
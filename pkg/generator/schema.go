@@ -0,0 +1,248 @@
+package generator
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// jsonSchema is the subset of JSON Schema (draft 2020-12, which OpenAPI 3.1 also uses) needed to describe the json
+// encoding of go types
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Comment              string                 `json:"$comment,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	GoType               string                 `json:"x-go-type,omitempty"`
+	GoPackage            string                 `json:"x-go-package,omitempty"`
+	Defs                 map[string]*jsonSchema `json:"$defs,omitempty"`
+}
+
+// writeSchema writes a JSON Schema of the key and value of the loader to path, so service catalogs can document
+// which lookups a service performs
+func writeSchema(path string, data templateData) error {
+	schema, err := loaderSchema(data)
+	if err != nil {
+		return err
+	}
+
+	src, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "encoding schema")
+	}
+
+	if err := ioutil.WriteFile(path, append(src, '\n'), 0644); err != nil {
+		return errors.Wrap(err, "writing output")
+	}
+
+	return nil
+}
+
+// loaderSchema describes the json encoding of the key and value of the loader, named types it refers to are put
+// into $defs
+func loaderSchema(data templateData) (*jsonSchema, error) {
+	defs := schemaDefs{}
+
+	keyType, err := data.KeyType.resolve(data.ImportPath)
+	if err != nil {
+		return nil, fmt.Errorf("key type: %s", err.Error())
+	}
+	valType, err := data.ValType.resolve(data.ImportPath)
+	if err != nil {
+		return nil, fmt.Errorf("value type: %s", err.Error())
+	}
+
+	key := defs.of(keyType)
+	key.GoType = types.TypeString(keyType, nil)
+	value := defs.of(valType)
+	value.GoType = types.TypeString(valType, nil)
+
+	schema := &jsonSchema{
+		Schema:      "https://json-schema.org/draft/2020-12/schema",
+		Comment:     "Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.",
+		Title:       data.Name,
+		Description: fmt.Sprintf("%s loads %s by %s", data.Name, data.ValType.String(), data.KeyType.String()),
+		Type:        "object",
+		Properties:  map[string]*jsonSchema{"key": key, "value": value},
+		Required:    []string{"key", "value"},
+		GoPackage:   data.ImportPath,
+	}
+	if len(defs) > 0 {
+		schema.Defs = defs
+	}
+	return schema, nil
+}
+
+// resolve type checks the type, pkgPath is the package to look up names without an import path in
+func (t *goType) resolve(pkgPath string) (types.Type, error) {
+	var typ types.Type
+	if t.ImportPath == "" {
+		if obj, ok := types.Universe.Lookup(t.Name).(*types.TypeName); ok {
+			typ = obj.Type()
+		}
+	}
+	if typ == nil {
+		path := t.ImportPath
+		if path == "" {
+			path = pkgPath
+		}
+		pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import(path)
+		if err != nil {
+			return nil, err
+		}
+		obj, ok := pkg.Scope().Lookup(t.Name).(*types.TypeName)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a type in %s", t.Name, path)
+		}
+		typ = obj.Type()
+	}
+
+	// []*User is a slice of pointers, so the modifiers apply from the right
+	mods := t.Modifiers
+	for mods != "" {
+		if strings.HasSuffix(mods, "*") {
+			typ = types.NewPointer(typ)
+			mods = strings.TrimSuffix(mods, "*")
+		} else if strings.HasSuffix(mods, "[]") {
+			typ = types.NewSlice(typ)
+			mods = strings.TrimSuffix(mods, "[]")
+		} else {
+			return nil, fmt.Errorf("unsupported modifiers %s", t.Modifiers)
+		}
+	}
+	return typ, nil
+}
+
+// schemaDefs holds the schemas of the named struct types, by package and type name
+type schemaDefs map[string]*jsonSchema
+
+var jsonMarshaler = newInterface("MarshalJSON", types.NewSlice(types.Typ[types.Byte]), types.Universe.Lookup("error").Type())
+
+// of describes how encoding/json encodes typ. Types json can't encode, like funcs and channels, and types with
+// their own MarshalJSON are left open.
+func (d schemaDefs) of(typ types.Type) *jsonSchema {
+	if named, ok := typ.(*types.Named); ok {
+		obj := named.Obj()
+		switch {
+		case obj.Pkg() != nil && obj.Pkg().Path() == "time" && obj.Name() == "Time":
+			return &jsonSchema{Type: "string", Format: "date-time"}
+		case implements(typ, jsonMarshaler):
+			return &jsonSchema{}
+		case implements(typ, textMarshaler):
+			return &jsonSchema{Type: "string"}
+		}
+
+		st, ok := named.Underlying().(*types.Struct)
+		if !ok || obj.Pkg() == nil {
+			return d.of(named.Underlying())
+		}
+		name := obj.Pkg().Name() + "." + obj.Name()
+		if _, ok := d[name]; !ok {
+			// set before describing the fields, so types referring to themselves end
+			def := &jsonSchema{Type: "object", GoType: obj.Pkg().Path() + "." + obj.Name()}
+			d[name] = def
+			d.fields(st, def)
+		}
+		return &jsonSchema{Ref: "#/$defs/" + name}
+	}
+
+	switch typ := typ.(type) {
+	case *types.Basic:
+		switch {
+		case typ.Info()&types.IsBoolean != 0:
+			return &jsonSchema{Type: "boolean"}
+		case typ.Info()&types.IsInteger != 0:
+			return &jsonSchema{Type: "integer"}
+		case typ.Info()&types.IsFloat != 0:
+			return &jsonSchema{Type: "number"}
+		case typ.Info()&types.IsString != 0:
+			return &jsonSchema{Type: "string"}
+		}
+	case *types.Pointer:
+		return d.of(typ.Elem())
+	case *types.Slice:
+		if basic, ok := typ.Elem().(*types.Basic); ok && basic.Kind() == types.Byte {
+			return &jsonSchema{Type: "string", ContentEncoding: "base64"}
+		}
+		return &jsonSchema{Type: "array", Items: d.of(typ.Elem())}
+	case *types.Array:
+		n := int(typ.Len())
+		return &jsonSchema{Type: "array", Items: d.of(typ.Elem()), MinItems: &n, MaxItems: &n}
+	case *types.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: d.of(typ.Elem())}
+	case *types.Struct:
+		schema := &jsonSchema{Type: "object"}
+		d.fields(typ, schema)
+		return schema
+	}
+	return &jsonSchema{}
+}
+
+// fields adds the exported fields of st to schema as json names them. Fields of embedded structs without a name
+// of their own are promoted, like json does.
+func (d schemaDefs) fields(st *types.Struct, schema *jsonSchema) {
+	for i := 0; i < st.NumFields(); i++ {
+		field := st.Field(i)
+		name, opts := field.Name(), ""
+		if tag, ok := reflect.StructTag(st.Tag(i)).Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) == 2 {
+				opts = parts[1]
+			}
+		}
+
+		if field.Embedded() && name == field.Name() {
+			typ := field.Type()
+			if ptr, ok := typ.(*types.Pointer); ok {
+				typ = ptr.Elem()
+			}
+			if embedded, ok := typ.Underlying().(*types.Struct); ok {
+				d.fields(embedded, schema)
+				continue
+			}
+		}
+		if !field.Exported() {
+			continue
+		}
+
+		if schema.Properties == nil {
+			schema.Properties = map[string]*jsonSchema{}
+		}
+		if strings.Contains(","+opts+",", ",string,") {
+			schema.Properties[name] = &jsonSchema{Type: "string"}
+		} else {
+			schema.Properties[name] = d.of(field.Type())
+		}
+		if !strings.Contains(","+opts+",", ",omitempty,") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+}
+
+// implements reports whether typ or a pointer to it implements iface, json uses either
+func implements(typ types.Type, iface *types.Interface) bool {
+	return types.Implements(typ, iface) || types.Implements(types.NewPointer(typ), iface)
+}
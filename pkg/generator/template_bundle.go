@@ -0,0 +1,70 @@
+package generator
+
+import "text/template"
+
+var bundleTpl = template.Must(template.New("bundle").Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"net/http"
+)
+
+// Loaders holds one of each loader for a request. Create it with NewLoaders, or have Middleware create it for every
+// request and retrieve it with For.
+type Loaders struct {
+	{{- range .Loaders }}
+	{{.Field}} *{{.Name}}
+	{{- end }}
+}
+
+// LoadersConfig has the config of each loader in Loaders
+type LoadersConfig struct {
+	{{- range .Loaders }}
+	{{.Field}} {{.Name}}Config
+	{{- end }}
+
+	// Prepare, when set, adjusts a copy of the config for every request before Middleware creates its loaders, eg.
+	// to fetch with the credentials of the request
+	Prepare func(r *http.Request, config *LoadersConfig)
+}
+
+// NewLoaders creates a new Loaders given the config of each loader
+func NewLoaders(config LoadersConfig) *Loaders {
+	return &Loaders{
+		{{- range .Loaders }}
+		{{.Field}}: New{{.Name}}(config.{{.Field}}),
+		{{- end }}
+	}
+}
+
+// Middleware attaches new Loaders to the context of every request passed to next
+func (c LoadersConfig) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		config := c
+		if config.Prepare != nil {
+			config.Prepare(r, &config)
+		}
+		next.ServeHTTP(w, r.WithContext(WithLoaders(r.Context(), NewLoaders(config))))
+	})
+}
+
+type loadersContextKey struct{}
+
+// WithLoaders returns a copy of ctx that carries loaders, eg. for tests or work that doesn't go through Middleware
+func WithLoaders(ctx context.Context, loaders *Loaders) context.Context {
+	return context.WithValue(ctx, loadersContextKey{}, loaders)
+}
+
+// For returns the Loaders attached to ctx by Middleware or WithLoaders, it panics when there are none so a
+// missing middleware is caught by the first request
+func For(ctx context.Context) *Loaders {
+	loaders, ok := ctx.Value(loadersContextKey{}).(*Loaders)
+	if !ok {
+		panic("dataloaden: no Loaders in the context, is the Middleware missing?")
+	}
+	return loaders
+}
+`))
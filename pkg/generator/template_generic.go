@@ -0,0 +1,45 @@
+package generator
+
+import "text/template"
+
+var genericTpl = template.Must(template.New("generic").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+//go:build go1.18
+
+package {{.Package}}
+
+import (
+	"github.com/tribunadigital/dataloaden/pkg/dataloader"
+
+	{{if .KeyType.ImportPath}}"{{.KeyType.ImportPath}}"{{end}}
+	{{if .ValType.ImportPath}}"{{.ValType.ImportPath}}"{{end}}
+)
+
+// {{.Name}}Cache can be used to cache results. A default map based
+// implementation is used by default.
+type {{.Name}}Cache = dataloader.Cache[{{.KeyType.String}}, {{.ValType.String}}]
+
+// {{.Name}}MapCache is a {{.Name}}Cache backed by a go map
+type {{.Name}}MapCache = dataloader.MapCache[{{.KeyType.String}}, {{.ValType.String}}]
+
+// New{{.Name}}MapCache creates an empty {{.Name}}MapCache
+func New{{.Name}}MapCache() *{{.Name}}MapCache {
+	return dataloader.NewMapCache[{{.KeyType.String}}, {{.ValType.String}}]()
+}
+
+// {{.Name}}Config captures the config to create a new {{.Name}}
+type {{.Name}}Config = dataloader.Config[{{.KeyType.String}}, {{.ValType.String}}]
+
+// {{.Name}} batches and caches requests
+type {{.Name}} = dataloader.Loader[{{.KeyType.String}}, {{.ValType.String}}]
+
+// New{{.Name}} creates a new {{.Name}} given a fetch, wait, and maxBatch
+func New{{.Name}}(config {{.Name}}Config) *{{.Name}} {
+	return dataloader.New(config)
+}
+`))
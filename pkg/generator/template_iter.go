@@ -0,0 +1,64 @@
+package generator
+
+import "text/template"
+
+var iterTpl = template.Must(template.New("iter").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+//go:build go1.23
+
+package {{.Package}}
+
+import (
+	"iter"
+
+	{{if .KeyType.ImportPath}}"{{.KeyType.ImportPath}}"{{end}}
+	{{if .ValType.ImportPath}}"{{.ValType.ImportPath}}"{{end}}
+)
+
+// {{.Name|lcFirst}}SeqBatch is how many keys LoadSeq reads ahead when the loader has no MaxBatch
+const {{.Name|lcFirst}}SeqBatch = 100
+
+// LoadSeq loads the {{.ValType.Name}}s of keys, yielding them in the order of keys. Keys are read a batch ahead
+// (MaxBatch of them, or 100), so they are still fetched together without holding all of them in memory. Keys read
+// ahead of a stopped iteration are released.
+func (l *{{.Name}}) LoadSeq(keys iter.Seq[{{.KeyType.String}}]) iter.Seq2[{{.ValType.String}}, error] {
+	return func(yield func({{.ValType.String}}, error) bool) {
+		l.mu.Lock()
+		size := l.maxBatch
+		l.mu.Unlock()
+		if size == 0 {
+			size = {{.Name|lcFirst}}SeqBatch
+		}
+
+		var thunks []func() ({{.ValType.String}}, error)
+		var releases []func()
+		flush := func() bool {
+			for i, thunk := range thunks {
+				if !yield(thunk()) {
+					for _, release := range releases[i+1:] {
+						release()
+					}
+					return false
+				}
+			}
+			thunks, releases = thunks[:0], releases[:0]
+			return true
+		}
+
+		for key := range keys {
+			thunk, release := l.loadThunk(key, 1, true)
+			thunks = append(thunks, thunk)
+			releases = append(releases, release)
+			if len(thunks) == size && !flush() {
+				return
+			}
+		}
+		flush()
+	}
+}
+`))
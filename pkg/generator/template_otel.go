@@ -0,0 +1,68 @@
+package generator
+
+import "text/template"
+
+var otelTpl = template.Must(template.New("otel").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// {{.Name}}OTel is a {{.Name}}Tracer that starts an OpenTelemetry span for every batch. The span is linked to the
+// spans of the callers waiting on the batch, as a batch has many parents.
+type {{.Name}}OTel struct {
+	tracer trace.Tracer
+}
+
+// New{{.Name}}OTel creates a {{.Name}}OTel that starts its spans with a tracer from tp
+func New{{.Name}}OTel(tp trace.TracerProvider) *{{.Name}}OTel {
+	return &{{.Name}}OTel{tracer: tp.Tracer("github.com/tribunadigital/dataloaden")}
+}
+
+// StartBatch starts a "{{.Name}}.fetch" span with the number of keys, it is ended with the number of failed keys
+func (o *{{.Name}}OTel) StartBatch(ctx context.Context, callers []context.Context, keys int) (context.Context, func(errors int)) {
+	var links []trace.Link
+	seen := map[trace.SpanID]bool{}
+	for _, caller := range callers {
+		sc := trace.SpanContextFromContext(caller)
+		if !sc.IsValid() || seen[sc.SpanID()] {
+			continue
+		}
+		seen[sc.SpanID()] = true
+		links = append(links, trace.Link{SpanContext: sc})
+	}
+
+	ctx, span := o.tracer.Start(ctx, {{.Name}}Name+".fetch",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("dataloader.name", {{.Name}}Name),
+			attribute.Int("dataloader.keys", keys),
+			attribute.String("dataloader.batch_id", {{.Name}}BatchID(ctx)),
+		),
+	)
+	return ctx, func(errors int) {
+		span.SetAttributes(attribute.Int("dataloader.errors", errors))
+		span.End()
+	}
+}
+
+// TraceID returns the ID of the trace of the batch span in ctx, so metrics can point at it
+func (o *{{.Name}}OTel) TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+`))
@@ -0,0 +1,134 @@
+package generator
+
+import "text/template"
+
+var prometheusTpl = template.Must(template.New("prometheus").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// {{.Name}}Prometheus is a {{.Name}}Metrics that exports to prometheus. Every metric is labeled with
+// loader="{{.Name}}", so loaders of different types can share the names.
+type {{.Name}}Prometheus struct {
+	batches      prometheus.Counter
+	batchSize    prometheus.Observer
+	fetchLatency prometheus.Observer
+	errors       prometheus.Counter
+	hits         prometheus.Counter
+	misses       prometheus.Counter
+}
+
+// New{{.Name}}Prometheus registers the metrics of a {{.Name}} with reg. Metrics that are already registered, eg.
+// by a loader created for an earlier request, are shared.
+func New{{.Name}}Prometheus(reg prometheus.Registerer) (*{{.Name}}Prometheus, error) {
+	labels := prometheus.Labels{"loader": {{.Name}}Name}
+
+	var m {{.Name}}Prometheus
+	var err error
+	if m.batches, err = {{.Name|lcFirst}}RegisterCounter(reg, prometheus.CounterOpts{
+		Name:        "dataloader_batches_total",
+		Help:        "Number of batches sent to fetch.",
+		ConstLabels: labels,
+	}); err != nil {
+		return nil, err
+	}
+	if m.batchSize, err = {{.Name|lcFirst}}RegisterHistogram(reg, prometheus.HistogramOpts{
+		Name:        "dataloader_batch_size",
+		Help:        "Number of keys sent to fetch per batch.",
+		ConstLabels: labels,
+		Buckets:     prometheus.ExponentialBuckets(1, 2, 11),
+	}); err != nil {
+		return nil, err
+	}
+	if m.fetchLatency, err = {{.Name|lcFirst}}RegisterHistogram(reg, prometheus.HistogramOpts{
+		Name:        "dataloader_fetch_duration_seconds",
+		Help:        "How long fetches took.",
+		ConstLabels: labels,
+		Buckets:     prometheus.DefBuckets,
+	}); err != nil {
+		return nil, err
+	}
+	if m.errors, err = {{.Name|lcFirst}}RegisterCounter(reg, prometheus.CounterOpts{
+		Name:        "dataloader_errors_total",
+		Help:        "Number of keys that failed to fetch.",
+		ConstLabels: labels,
+	}); err != nil {
+		return nil, err
+	}
+	if m.hits, err = {{.Name|lcFirst}}RegisterCounter(reg, prometheus.CounterOpts{
+		Name:        "dataloader_cache_hits_total",
+		Help:        "Number of loads served from the cache.",
+		ConstLabels: labels,
+	}); err != nil {
+		return nil, err
+	}
+	if m.misses, err = {{.Name|lcFirst}}RegisterCounter(reg, prometheus.CounterOpts{
+		Name:        "dataloader_cache_misses_total",
+		Help:        "Number of loads that had to be fetched.",
+		ConstLabels: labels,
+	}); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func (m *{{.Name}}Prometheus) Batch(size int, latency time.Duration, errors int) {
+	m.BatchExemplar(size, latency, errors, "")
+}
+
+// BatchExemplar records a batch like Batch, attaching traceID to the fetch duration as an exemplar so a latency
+// spike leads to an example trace. It is called when the loader's Tracer implements {{.Name}}TraceIDs.
+func (m *{{.Name}}Prometheus) BatchExemplar(size int, latency time.Duration, errors int, traceID string) {
+	m.batches.Inc()
+	m.batchSize.Observe(float64(size))
+	if observer, ok := m.fetchLatency.(prometheus.ExemplarObserver); ok && traceID != "" {
+		observer.ObserveWithExemplar(latency.Seconds(), prometheus.Labels{"trace_id": traceID})
+	} else {
+		m.fetchLatency.Observe(latency.Seconds())
+	}
+	m.errors.Add(float64(errors))
+}
+
+func (m *{{.Name}}Prometheus) Hit() {
+	m.hits.Inc()
+}
+
+func (m *{{.Name}}Prometheus) Miss() {
+	m.misses.Inc()
+}
+
+func {{.Name|lcFirst}}RegisterCounter(reg prometheus.Registerer, opts prometheus.CounterOpts) (prometheus.Counter, error) {
+	counter := prometheus.NewCounter(opts)
+	if err := reg.Register(counter); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			return registered.ExistingCollector.(prometheus.Counter), nil
+		}
+		return nil, err
+	}
+	return counter, nil
+}
+
+func {{.Name|lcFirst}}RegisterHistogram(reg prometheus.Registerer, opts prometheus.HistogramOpts) (prometheus.Observer, error) {
+	histogram := prometheus.NewHistogram(opts)
+	if err := reg.Register(histogram); err != nil {
+		var registered prometheus.AlreadyRegisteredError
+		if errors.As(err, &registered) {
+			return registered.ExistingCollector.(prometheus.Histogram), nil
+		}
+		return nil, err
+	}
+	return histogram, nil
+}
+`))
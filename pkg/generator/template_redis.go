@@ -0,0 +1,184 @@
+package generator
+
+import "text/template"
+
+var redisTpl = template.Must(template.New("redis").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	{{if .KeyType.ImportPath}}"{{.KeyType.ImportPath}}"{{end}}
+	{{if .ValType.ImportPath}}"{{.ValType.ImportPath}}"{{end}}
+
+	"github.com/go-redis/redis/v8"
+)
+
+// {{.Name}}RedisCodec turns values into the bytes stored in redis and back
+type {{.Name}}RedisCodec interface {
+	Marshal(value {{.ValType.String}}) ([]byte, error)
+	Unmarshal(data []byte) ({{.ValType.String}}, error)
+}
+
+// {{.Name}}JSONCodec stores values as json, it is the codec used by default
+type {{.Name}}JSONCodec struct{}
+
+func ({{.Name}}JSONCodec) Marshal(value {{.ValType.String}}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func ({{.Name}}JSONCodec) Unmarshal(data []byte) ({{.ValType.String}}, error) {
+	var value {{.ValType.String}}
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// {{.Name}}RedisCache is a {{.Name}}Cache kept in redis, so every replica of a service shares the same warm cache
+type {{.Name}}RedisCache struct {
+	client    redis.UniversalClient
+	namespace string
+	codec     {{.Name}}RedisCodec
+	ttl       time.Duration
+	timeout   time.Duration
+	onError   func(err error)
+}
+
+// {{.Name}}RedisOption changes how a {{.Name}}RedisCache stores its values
+type {{.Name}}RedisOption func(c *{{.Name}}RedisCache)
+
+// {{.Name}}RedisNamespace prefixes every key with namespace and a colon, by default it is "{{.Name}}". Loaders
+// that share a namespace share their values.
+func {{.Name}}RedisNamespace(namespace string) {{.Name}}RedisOption {
+	return func(c *{{.Name}}RedisCache) {
+		c.namespace = namespace
+	}
+}
+
+// {{.Name}}RedisCodecOf stores values with codec instead of {{.Name}}JSONCodec
+func {{.Name}}RedisCodecOf(codec {{.Name}}RedisCodec) {{.Name}}RedisOption {
+	return func(c *{{.Name}}RedisCache) {
+		c.codec = codec
+	}
+}
+
+// {{.Name}}RedisTTL expires values after ttl, 0 = values are kept until redis evicts them
+func {{.Name}}RedisTTL(ttl time.Duration) {{.Name}}RedisOption {
+	return func(c *{{.Name}}RedisCache) {
+		c.ttl = ttl
+	}
+}
+
+// {{.Name}}RedisTimeout bounds every call to redis, by default it is {{.Name}}DefaultRedisTimeout
+func {{.Name}}RedisTimeout(timeout time.Duration) {{.Name}}RedisOption {
+	return func(c *{{.Name}}RedisCache) {
+		c.timeout = timeout
+	}
+}
+
+// {{.Name}}RedisOnError is called when redis can't be reached or a value can't be decoded, those entries are
+// treated as misses
+func {{.Name}}RedisOnError(onError func(err error)) {{.Name}}RedisOption {
+	return func(c *{{.Name}}RedisCache) {
+		c.onError = onError
+	}
+}
+
+// {{.Name}}DefaultRedisTimeout is how long a {{.Name}}RedisCache waits for redis unless told otherwise
+const {{.Name}}DefaultRedisTimeout = 100 * time.Millisecond
+
+// New{{.Name}}RedisCache creates a {{.Name}}RedisCache that stores values through client
+func New{{.Name}}RedisCache(client redis.UniversalClient, opts ...{{.Name}}RedisOption) *{{.Name}}RedisCache {
+	c := &{{.Name}}RedisCache{
+		client:    client,
+		namespace: "{{.Name}}",
+		codec:     {{.Name}}JSONCodec{},
+		timeout:   {{.Name}}DefaultRedisTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *{{.Name}}RedisCache) Get(key {{.KeyType.String}}) ({{.ValType.String}}, bool) {
+	var zero {{.ValType.String}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, c.key(key)).Bytes()
+	if err == redis.Nil {
+		return zero, false
+	}
+	if err != nil {
+		c.error(err)
+		return zero, false
+	}
+
+	value, err := c.codec.Unmarshal(data)
+	if err != nil {
+		c.error(fmt.Errorf("decoding %s: %w", c.key(key), err))
+		return zero, false
+	}
+	return value, true
+}
+
+func (c *{{.Name}}RedisCache) Set(key {{.KeyType.String}}, value {{.ValType.String}}) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores a value that expires after ttl instead of the cache wide TTL
+func (c *{{.Name}}RedisCache) SetWithTTL(key {{.KeyType.String}}, value {{.ValType.String}}, ttl time.Duration) {
+	data, err := c.codec.Marshal(value)
+	if err != nil {
+		c.error(fmt.Errorf("encoding %s: %w", c.key(key), err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if err := c.client.Set(ctx, c.key(key), data, ttl).Err(); err != nil {
+		c.error(err)
+	}
+}
+
+func (c *{{.Name}}RedisCache) ClearKey(key {{.KeyType.String}}) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	if err := c.client.Del(ctx, c.key(key)).Err(); err != nil {
+		c.error(err)
+	}
+}
+
+func (c *{{.Name}}RedisCache) key(key {{.KeyType.String}}) string {
+	return c.namespace + ":" + {{.Name|lcFirst}}KeyString(key)
+}
+
+func (c *{{.Name}}RedisCache) error(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// {{.Name}}WriteThroughRedis returns a config for loaders shared between the replicas of a service, fetched
+// values are written to redis and expire after 10 minutes.
+func {{.Name}}WriteThroughRedis(fetch func(keys []{{.KeyType.String}}) ([]{{.ValType.String}}, []error), client redis.UniversalClient) {{.Name}}Config {
+	return {{.Name}}Config{
+		Fetch:    fetch,
+		Wait:     2 * time.Millisecond,
+		MaxBatch: 100,
+		Cache:    New{{.Name}}RedisCache(client, {{.Name}}RedisTTL(10*time.Minute)),
+	}
+}
+`))
@@ -0,0 +1,315 @@
+package generator
+
+import "text/template"
+
+var spillTpl = template.Must(template.New("spill").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	{{if .KeyType.ImportPath}}"{{.KeyType.ImportPath}}"{{end}}
+	{{if .ValType.ImportPath}}"{{.ValType.ImportPath}}"{{end}}
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// {{.Name}}SpillCacheConfig captures the config to create a new {{.Name}}SpillCache
+type {{.Name}}SpillCacheConfig struct {
+	// Path is the bbolt file cold entries are spilled to, it is created if it doesn't exist
+	Path string
+
+	// MaxEntries is how many entries are kept in memory before the least recently used ones are spilled to disk,
+	// 0 = everything stays in memory until Close
+	MaxEntries int
+
+	// TTL is how long an entry stays valid, in memory or on disk, 0 = forever
+	TTL time.Duration
+
+	// OnError is called when the spill file can't be read or written, those entries are treated as misses
+	OnError func(err error)
+}
+
+// {{.Name}}SpillCache keeps the most recently used entries in memory and spills the rest to a local bbolt file,
+// so large working sets survive memory pressure. Entries still in memory are written out by Close, so a cache
+// opened on the same file again starts warm.
+type {{.Name}}SpillCache struct {
+	db         *bolt.DB
+	maxEntries int
+	ttl        time.Duration
+	onError    func(err error)
+
+	// when the cache was opened, records written since then expire by the monotonic clock rather than the wall clock
+	opened time.Time
+
+	mu      sync.Mutex
+	recent  *list.List
+	entries map[{{.KeyType.String}}]*list.Element
+}
+
+type {{.Name|lcFirst}}SpillEntry struct {
+	key     {{.KeyType.String}}
+	value   {{.ValType.String}}
+	expires time.Time
+}
+
+// {{.Name|lcFirst}}SpillRecord is how an entry is stored on disk
+type {{.Name|lcFirst}}SpillRecord struct {
+	Value   {{.ValType.String}}
+	Expires time.Time
+
+	// TTL is what was left of the entry's ttl when it was written, so a wall clock that jumped back since
+	// can't keep it around for longer
+	TTL time.Duration
+
+	// Opened identifies the opening of the cache that wrote the record and Written is how long after it that was
+	Opened  int64
+	Written time.Duration
+}
+
+var {{.Name|lcFirst}}SpillBucket = []byte("{{.Name}}")
+
+func New{{.Name}}SpillCache(conf {{.Name}}SpillCacheConfig) (*{{.Name}}SpillCache, error) {
+	db, err := bolt.Open(conf.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists({{.Name|lcFirst}}SpillBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &{{.Name}}SpillCache{
+		db:         db,
+		maxEntries: conf.MaxEntries,
+		ttl:        conf.TTL,
+		onError:    conf.OnError,
+		opened:     time.Now(),
+		recent:     list.New(),
+		entries:    map[{{.KeyType.String}}]*list.Element{},
+	}, nil
+}
+
+func (c *{{.Name}}SpillCache) Get(key {{.KeyType.String}}) ({{.ValType.String}}, bool) {
+	var zero {{.ValType.String}}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*{{.Name|lcFirst}}SpillEntry)
+		if {{.Name|lcFirst}}SpillExpired(entry.expires) {
+			c.recent.Remove(el)
+			delete(c.entries, key)
+			return zero, false
+		}
+		c.recent.MoveToFront(el)
+		return entry.value, true
+	}
+
+	record, ok := c.read(key)
+	if !ok {
+		return zero, false
+	}
+
+	// bring it back into memory, it will be spilled again once it goes cold
+	c.remove(key)
+	c.add(key, record.Value, record.Expires)
+	return record.Value, true
+}
+
+func (c *{{.Name}}SpillCache) Set(key {{.KeyType.String}}, value {{.ValType.String}}) {
+	c.SetWithTTL(key, value, c.ttl)
+}
+
+// SetWithTTL stores a value that expires after ttl instead of the cache wide TTL
+func (c *{{.Name}}SpillCache) SetWithTTL(key {{.KeyType.String}}, value {{.ValType.String}}, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*{{.Name|lcFirst}}SpillEntry)
+		entry.value = value
+		entry.expires = expires
+		c.recent.MoveToFront(el)
+		return
+	}
+	c.add(key, value, expires)
+}
+
+func (c *{{.Name}}SpillCache) ClearKey(key {{.KeyType.String}}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.recent.Remove(el)
+		delete(c.entries, key)
+	}
+	c.remove(key)
+}
+
+// Clear drops every entry, both from memory and from the spill file
+func (c *{{.Name}}SpillCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.recent.Init()
+	c.entries = map[{{.KeyType.String}}]*list.Element{}
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket({{.Name|lcFirst}}SpillBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket({{.Name|lcFirst}}SpillBucket)
+		return err
+	})
+	if err != nil {
+		c.error(err)
+	}
+}
+
+// Close writes every entry still held in memory to the spill file and closes it
+func (c *{{.Name}}SpillCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for el := c.recent.Front(); el != nil; el = el.Next() {
+		entry := el.Value.(*{{.Name|lcFirst}}SpillEntry)
+		c.write(entry)
+	}
+	c.recent.Init()
+	c.entries = map[{{.KeyType.String}}]*list.Element{}
+
+	return c.db.Close()
+}
+
+// add must be called with the cache locked
+func (c *{{.Name}}SpillCache) add(key {{.KeyType.String}}, value {{.ValType.String}}, expires time.Time) {
+	c.entries[key] = c.recent.PushFront(&{{.Name|lcFirst}}SpillEntry{key: key, value: value, expires: expires})
+
+	for c.maxEntries > 0 && c.recent.Len() > c.maxEntries {
+		el := c.recent.Back()
+		entry := el.Value.(*{{.Name|lcFirst}}SpillEntry)
+		c.recent.Remove(el)
+		delete(c.entries, entry.key)
+		c.write(entry)
+	}
+}
+
+func (c *{{.Name}}SpillCache) read(key {{.KeyType.String}}) ({{.Name|lcFirst}}SpillRecord, bool) {
+	var record {{.Name|lcFirst}}SpillRecord
+	var found bool
+
+	err := c.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket({{.Name|lcFirst}}SpillBucket).Get({{.Name|lcFirst}}SpillKey(key))
+		if raw == nil {
+			return nil
+		}
+		found = true
+		return gob.NewDecoder(bytes.NewReader(raw)).Decode(&record)
+	})
+	if err != nil {
+		c.error(err)
+		return record, false
+	}
+	record.Expires = c.expires(record)
+
+	if found && {{.Name|lcFirst}}SpillExpired(record.Expires) {
+		c.remove(key)
+		return record, false
+	}
+	return record, found
+}
+
+func (c *{{.Name}}SpillCache) write(entry *{{.Name|lcFirst}}SpillEntry) {
+	if {{.Name|lcFirst}}SpillExpired(entry.expires) {
+		return
+	}
+
+	record := {{.Name|lcFirst}}SpillRecord{
+		Value:   entry.value,
+		Expires: entry.expires,
+		Opened:  c.opened.UnixNano(),
+		Written: time.Since(c.opened),
+	}
+	if !entry.expires.IsZero() {
+		record.TTL = time.Until(entry.expires)
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(record); err != nil {
+		c.error(err)
+		return
+	}
+
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket({{.Name|lcFirst}}SpillBucket).Put({{.Name|lcFirst}}SpillKey(entry.key), buf.Bytes())
+	})
+	if err != nil {
+		c.error(err)
+	}
+}
+
+// expires returns when record expires by the monotonic clock. Records written before the cache was opened only
+// have the wall clock to go by, but don't outlive the TTL they had left when they were written.
+func (c *{{.Name}}SpillCache) expires(record {{.Name|lcFirst}}SpillRecord) time.Time {
+	if record.Expires.IsZero() {
+		return time.Time{}
+	}
+
+	var left time.Duration
+	if record.Opened == c.opened.UnixNano() {
+		left = record.TTL - (time.Since(c.opened) - record.Written)
+	} else {
+		left = time.Until(record.Expires)
+		if record.TTL > 0 && left > record.TTL {
+			left = record.TTL
+		}
+	}
+	return time.Now().Add(left)
+}
+
+func (c *{{.Name}}SpillCache) remove(key {{.KeyType.String}}) {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket({{.Name|lcFirst}}SpillBucket).Delete({{.Name|lcFirst}}SpillKey(key))
+	})
+	if err != nil {
+		c.error(err)
+	}
+}
+
+func (c *{{.Name}}SpillCache) error(err error) {
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+func {{.Name|lcFirst}}SpillKey(key {{.KeyType.String}}) []byte {
+	return []byte({{.Name|lcFirst}}KeyString(key))
+}
+
+func {{.Name|lcFirst}}SpillExpired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+`))
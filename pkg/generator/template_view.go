@@ -0,0 +1,88 @@
+package generator
+
+import "text/template"
+
+var viewTpl = template.Must(template.New("view").
+	Funcs(template.FuncMap{
+		"lcFirst": lcFirst,
+	}).
+	Parse(`
+// Code generated by github.com/tribunadigital/dataloaden, DO NOT EDIT.
+
+//go:build go1.18
+
+package {{.Package}}
+
+import (
+	{{if .KeyType.ImportPath}}"{{.KeyType.ImportPath}}"{{end}}
+	{{if .ValType.ImportPath}}"{{.ValType.ImportPath}}"{{end}}
+)
+
+// {{.Name}}View loads a projection of the values of a {{.Name}}, eg. just the name of a user. It goes through the
+// loader it was created from, so it shares its batches and cache instead of fetching again.
+type {{.Name}}View[R any] struct {
+	loader  *{{.Name}}
+	project func(value {{.ValType.String}}) R
+}
+
+// New{{.Name}}View derives a view of l that returns project(value) instead of the value itself
+func New{{.Name}}View[R any](l *{{.Name}}, project func(value {{.ValType.String}}) R) *{{.Name}}View[R] {
+	return &{{.Name}}View[R]{loader: l, project: project}
+}
+
+// Load the projection of a {{.ValType.Name}} by key, keys that fail to load give a zero R
+func (v *{{.Name}}View[R]) Load(key {{.KeyType.String}}) (R, error) {
+	return v.LoadThunk(key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for the projection of a {{.ValType.Name}}
+func (v *{{.Name}}View[R]) LoadThunk(key {{.KeyType.String}}) func() (R, error) {
+	thunk := v.loader.LoadThunk(key)
+	return func() (R, error) {
+		value, err := thunk()
+		if err != nil {
+			var zero R
+			return zero, err
+		}
+		return v.project(value), nil
+	}
+}
+
+// LoadAll loads the projections of many keys at once
+func (v *{{.Name}}View[R]) LoadAll(keys []{{.KeyType.String}}) ([]R, []error) {
+	values, errs := v.loader.LoadAll(keys)
+
+	results := make([]R, len(keys))
+	for i, value := range values {
+		if errs[i] == nil {
+			results[i] = v.project(value)
+		}
+	}
+	return results, errs
+}
+
+// {{.Name}}Memo loads an R derived from the values of a {{.Name}}, like a view, but derives it only once per
+// cached value with LoadThen. It suits derivations that are too expensive to repeat on every load.
+type {{.Name}}Memo[R any] struct {
+	loader     *{{.Name}}
+	derivation *{{.Name}}Derivation
+}
+
+// New{{.Name}}Memo derives a memo of l that returns the cached result of derive(value) instead of the value itself
+func New{{.Name}}Memo[R any](l *{{.Name}}, derive func(value {{.ValType.String}}) (R, error)) *{{.Name}}Memo[R] {
+	derivation := New{{.Name}}Derivation(func(value {{.ValType.String}}) (interface{}, error) {
+		return derive(value)
+	})
+	return &{{.Name}}Memo[R]{loader: l, derivation: derivation}
+}
+
+// Load the result derived from a {{.ValType.Name}} by key
+func (m *{{.Name}}Memo[R]) Load(key {{.KeyType.String}}) (R, error) {
+	result, err := m.loader.LoadThen(key, m.derivation)
+	if err != nil {
+		var zero R
+		return zero, err
+	}
+	return result.(R), nil
+}
+`))
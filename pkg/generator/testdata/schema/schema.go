@@ -0,0 +1,20 @@
+package schema
+
+import "time"
+
+type Base struct {
+	ID      int       `json:"id"`
+	Created time.Time `json:"created"`
+}
+
+type Employee struct {
+	Base
+	Name     string            `json:"name"`
+	Avatar   []byte            `json:"avatar,omitempty"`
+	Salary   int64             `json:"salary,string"`
+	Tags     map[string]string `json:"tags"`
+	Manager  *Employee         `json:"manager,omitempty"`
+	Position [2]float64
+	Secret   string `json:"-"`
+	internal int
+}
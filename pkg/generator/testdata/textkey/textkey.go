@@ -0,0 +1,18 @@
+package textkey
+
+import "strconv"
+
+type ID struct {
+	Tenant string
+	Num    int
+}
+
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.Tenant + "/" + strconv.Itoa(id.Num)), nil
+}
+
+type Name string
+
+func (n *Name) String() string {
+	return string(*n)
+}
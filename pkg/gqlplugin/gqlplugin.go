@@ -0,0 +1,158 @@
+// Package gqlplugin is a gqlgen plugin that generates a loader for every object a resolver returns, and a Loaders
+// bundle with middleware that creates them for every request. Add it when running gqlgen:
+//
+//	err := api.Generate(cfg, api.AddPlugin(gqlplugin.New("graph/loaders")))
+package gqlplugin
+
+import (
+	"fmt"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/99designs/gqlgen/codegen"
+	"github.com/99designs/gqlgen/plugin"
+	"github.com/pkg/errors"
+	"github.com/vektah/gqlparser/v2/ast"
+
+	"github.com/tribunadigital/dataloaden/pkg/generator"
+)
+
+// Plugin generates the loaders, see New
+type Plugin struct {
+	// Dir is the directory of the package the loaders are generated into, it is created when it doesn't exist
+	Dir string
+
+	// KeyField is the graphql field objects are loaded by, "id" by default. Objects without it don't get a loader.
+	KeyField string
+
+	// Options are passed on to every loader
+	Options generator.Options
+}
+
+var _ plugin.CodeGenerator = &Plugin{}
+
+// New creates a plugin that generates the loaders into dir
+func New(dir string) *Plugin {
+	return &Plugin{Dir: dir, KeyField: "id"}
+}
+
+func (p *Plugin) Name() string {
+	return "dataloaden"
+}
+
+// loader is a loader to generate, with its types in the form the generator takes them
+type loader struct {
+	Name      string
+	KeyType   string
+	ValueType string
+}
+
+func (p *Plugin) GenerateCode(data *codegen.Data) error {
+	loaders, err := p.loaders(data)
+	if err != nil {
+		return err
+	}
+	if len(loaders) == 0 {
+		return nil
+	}
+
+	dir, err := filepath.Abs(p.Dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// the bundle goes first, so the package exists before the loaders look it up
+	names := make([]string, len(loaders))
+	for i, l := range loaders {
+		names[i] = l.Name
+	}
+	if err := generator.GenerateBundle(dir, names); err != nil {
+		return err
+	}
+
+	for _, l := range loaders {
+		if err := generator.GenerateWithOptions(l.Name, l.KeyType, l.ValueType, dir, p.Options); err != nil {
+			return errors.Wrap(err, l.Name)
+		}
+	}
+	return nil
+}
+
+// loaders finds the objects returned by resolvers of other objects, they are the ones worth batching
+func (p *Plugin) loaders(data *codegen.Data) ([]loader, error) {
+	keyField := p.KeyField
+	if keyField == "" {
+		keyField = "id"
+	}
+
+	byName := map[string]loader{}
+	for _, o := range data.Objects {
+		if o.Root {
+			continue
+		}
+		for _, f := range o.Fields {
+			if !f.IsResolver || f.TypeReference == nil || f.TypeReference.Definition.Kind != ast.Object {
+				continue
+			}
+			target := data.Objects.ByName(f.TypeReference.Definition.Name)
+			if target == nil || target.Root {
+				continue
+			}
+			if _, ok := byName[target.Name]; ok {
+				continue
+			}
+
+			var keyType types.Type
+			for _, field := range target.Fields {
+				if field.Name == keyField {
+					keyType = field.TypeReference.GO
+				}
+			}
+			if keyType == nil {
+				continue
+			}
+
+			k, err := typeArg(keyType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s.%s", target.Name, keyField)
+			}
+			v, err := typeArg(types.NewPointer(target.Type))
+			if err != nil {
+				return nil, errors.Wrap(err, target.Name)
+			}
+			byName[target.Name] = loader{Name: target.Name + "Loader", KeyType: k, ValueType: v}
+		}
+	}
+
+	loaders := make([]loader, 0, len(byName))
+	for _, l := range byName {
+		loaders = append(loaders, l)
+	}
+	sort.Slice(loaders, func(i, j int) bool { return loaders[i].Name < loaders[j].Name })
+	return loaders, nil
+}
+
+// typeArg writes t the way the generator takes types, eg. *github.com/my/model.User
+func typeArg(t types.Type) (string, error) {
+	switch t := t.(type) {
+	case *types.Pointer:
+		elem, err := typeArg(t.Elem())
+		return "*" + elem, err
+	case *types.Slice:
+		elem, err := typeArg(t.Elem())
+		return "[]" + elem, err
+	case *types.Basic:
+		return t.Name(), nil
+	case *types.Named:
+		if t.Obj().Pkg() == nil {
+			return t.Obj().Name(), nil
+		}
+		return t.Obj().Pkg().Path() + "." + t.Obj().Name(), nil
+	}
+	return "", fmt.Errorf("%s can't be used by a loader", t)
+}
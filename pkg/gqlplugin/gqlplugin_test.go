@@ -0,0 +1,79 @@
+package gqlplugin
+
+import (
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/99designs/gqlgen/codegen"
+	"github.com/99designs/gqlgen/codegen/config"
+	"github.com/stretchr/testify/require"
+	"github.com/vektah/gqlparser/v2/ast"
+)
+
+const modelPath = "github.com/tribunadigital/dataloaden/pkg/gqlplugin/testdata/model"
+
+func TestLoaders(t *testing.T) {
+	loaders, err := New("loaders").loaders(testData())
+	require.NoError(t, err)
+	require.Equal(t, []loader{
+		{Name: "UserLoader", KeyType: "string", ValueType: "*" + modelPath + ".User"},
+	}, loaders)
+}
+
+func TestGenerateCode(t *testing.T) {
+	dir, err := ioutil.TempDir("testdata", "loaders")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, New(filepath.Join(dir, "loaders")).GenerateCode(testData()))
+
+	bundle, err := ioutil.ReadFile(filepath.Join(dir, "loaders", "loaders_gen.go"))
+	require.NoError(t, err)
+	require.Contains(t, string(bundle), "User *UserLoader")
+	require.FileExists(t, filepath.Join(dir, "loaders", "userloader_gen.go"))
+}
+
+// testData is what gqlgen builds for
+//
+//	type Query { post(id: Int!): Post }
+//	type Post { id: Int! title: String! author: User! }
+//	type User { id: ID! name: String! }
+//
+// when Post is bound to a model without an Author field
+func testData() *codegen.Data {
+	pkg := types.NewPackage(modelPath, "model")
+	named := func(name string) types.Type {
+		return types.NewNamed(types.NewTypeName(0, pkg, name, nil), types.NewStruct(nil, nil), nil)
+	}
+	object := func(name string, typ types.Type, root bool) *codegen.Object {
+		return &codegen.Object{Definition: &ast.Definition{Name: name, Kind: ast.Object}, Type: typ, Root: root}
+	}
+	field := func(o *codegen.Object, name string, kind ast.DefinitionKind, typeName string, goType types.Type, resolver bool) {
+		o.Fields = append(o.Fields, &codegen.Field{
+			FieldDefinition: &ast.FieldDefinition{Name: name},
+			TypeReference: &config.TypeReference{
+				Definition: &ast.Definition{Name: typeName, Kind: kind},
+				GO:         goType,
+			},
+			IsResolver: resolver,
+			Object:     o,
+		})
+	}
+
+	user := object("User", named("User"), false)
+	field(user, "id", ast.Scalar, "ID", types.Typ[types.String], false)
+	field(user, "name", ast.Scalar, "String", types.Typ[types.String], false)
+
+	post := object("Post", named("Post"), false)
+	field(post, "id", ast.Scalar, "Int", types.Typ[types.Int], false)
+	field(post, "title", ast.Scalar, "String", types.Typ[types.String], false)
+	field(post, "author", ast.Object, "User", types.NewPointer(user.Type), true)
+
+	query := object("Query", nil, true)
+	field(query, "post", ast.Object, "Post", types.NewPointer(post.Type), true)
+
+	return &codegen.Data{Objects: codegen.Objects{query, post, user}}
+}
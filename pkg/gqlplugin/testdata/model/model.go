@@ -0,0 +1,12 @@
+package model
+
+type User struct {
+	ID   string
+	Name string
+}
+
+type Post struct {
+	ID       int
+	Title    string
+	AuthorID string
+}